@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
@@ -27,6 +28,7 @@ import (
 var options struct {
 	broker.Options
 	RunBackgroundTasks   bool
+	InProcessTasks       bool
 	Port                 int
 	Insecure             bool
 	TLSCert              string
@@ -39,6 +41,7 @@ var options struct {
 
 func init() {
 	flag.BoolVar(&options.RunBackgroundTasks, "background-tasks", false, "use '--background-tasks' option to startup broker in worker mode that just processes queued tasks.")
+	flag.BoolVar(&options.InProcessTasks, "in-process-tasks", false, "use '--in-process-tasks' option to also process queued tasks in the same process as the broker api, this is intended for local development.")
 	flag.IntVar(&options.Port, "port", 8443, "use '--port' option to specify the port for broker to listen on")
 	flag.BoolVar(&options.Insecure, "insecure", true, "use --insecure to use HTTP vs HTTPS.")
 	flag.StringVar(&options.TLSCertFile, "tls-cert-file", "", "File containing the default x509 Certificate for HTTPS. (CA cert, if any, concatenated after server cert).")
@@ -59,7 +62,7 @@ func main() {
 			AppName:  "Database Broker",
 		})
 	}
-	if err := run(); err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+	if err := run(); err != nil && err != context.Canceled && err != context.DeadlineExceeded && err != http.ErrServerClosed {
 		glog.Fatalln(err)
 	}
 	glog.Infof("Exiting\n")
@@ -93,11 +96,21 @@ func runWithContext(ctx context.Context) error {
 		addr = ":" + os.Getenv("PORT")
 	}
 
-	businessLogic, err := broker.NewBusinessLogic(ctx, options.Options)
+	storage, namePrefix, err := broker.InitFromOptions(ctx, options.Options)
 	if err != nil {
 		glog.Errorln("Error starting provision logic")
 		return err
 	}
+	businessLogic := broker.NewBusinessLogicWithStorage(storage, namePrefix)
+	defer broker.CloseProviders()
+
+	if options.InProcessTasks {
+		go func() {
+			if err := broker.RunBackgroundTasksWithStorage(ctx, options.Options, namePrefix, storage); err != nil && err != context.Canceled {
+				glog.Errorf("Background tasks stopped: %s\n", err.Error())
+			}
+		}()
+	}
 
 	// Prom. metrics
 	reg := prom.NewRegistry()
@@ -176,17 +189,13 @@ func getKubernetesClient(kubeConfigPath string) (clientset.Interface, error) {
 }
 
 func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
-	term := make(chan os.Signal)
+	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
-	for {
-		select {
-		case <-term:
-			glog.Infof("Received SIGTERM, exiting gracefully...")
-			f()
-			os.Exit(0)
-		case <-ctx.Done():
-			os.Exit(0)
-		}
+	select {
+	case <-term:
+		glog.Infof("Received SIGTERM, exiting gracefully...")
+		f()
+	case <-ctx.Done():
 	}
 }
@@ -6,6 +6,7 @@ require (
 	cloud.google.com/go v0.32.0
 	github.com/aws/aws-sdk-go v1.15.69
 	github.com/beorn7/perks v0.0.0-20180321164747-3a771d992973
+	github.com/denisenkom/go-mssqldb v0.0.0-20191124224453-732737034ffd
 	github.com/ghodss/yaml v1.0.0
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/gogo/protobuf v1.1.1
@@ -40,7 +41,7 @@ require (
 	github.com/smartystreets/goconvey v1.6.4
 	github.com/spf13/pflag v1.0.3
 	github.com/stackimpact/stackimpact-go v2.3.10+incompatible
-	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	golang.org/x/crypto v0.0.0-20190325154230-a5d413f7728c
 	golang.org/x/net v0.0.0-20190311183353-d8887717615a
 	golang.org/x/oauth2 v0.0.0-20181106182150-f42d05182288
 	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a
@@ -69,7 +69,7 @@ func TestAwsClusterProvision(t *testing.T) {
 			var dbInstance *DbInstance = nil
 			t := time.NewTicker(time.Second * 30)
 			for i := 0; i < 30; i++ {
-				dbInstance, err = logic.GetInstanceById(instanceId)
+				dbInstance, err = logic.GetInstanceById(context.Background(), instanceId)
 				So(err, ShouldBeNil)
 				fmt.Printf(".")
 				if dbInstance.Ready == true && dbInstance.Status == "available" {
@@ -129,13 +129,13 @@ func TestAwsClusterProvision(t *testing.T) {
 			var c broker.RequestContext
 			_, err = logic.ActionRestart(instanceId, map[string]string{}, &c)
 			So(err, ShouldBeNil)
-			dbInstance, err := logic.GetInstanceById(instanceId)
+			dbInstance, err := logic.GetInstanceById(context.Background(), instanceId)
 			So(err, ShouldBeNil)
 			So(InProgress(dbInstance.Status), ShouldEqual, true)
 
 			t := time.NewTicker(time.Second * 30)
 			for i := 0; i < 30; i++ {
-				dbInstance, err = logic.GetInstanceById(instanceId)
+				dbInstance, err = logic.GetInstanceById(context.Background(), instanceId)
 				fmt.Printf(".")
 				if dbInstance.Ready == true && dbInstance.Status == "available" {
 					break;
@@ -183,7 +183,7 @@ func TestAwsClusterProvision(t *testing.T) {
 			So(err, ShouldBeNil)
 
 			for i := 0; i < 30; i++ {
-				dbInstance, err = logic.GetInstanceById(instanceId)
+				dbInstance, err = logic.GetInstanceById(context.Background(), instanceId)
 				So(err, ShouldBeNil)
 				fmt.Printf(".")
 				if dbInstance.Ready == true && dbInstance.Status == "available" {
@@ -74,7 +74,7 @@ func TestAwsProvision(t *testing.T) {
 			var dbInstance *DbInstance = nil
 			t := time.NewTicker(time.Second * 30)
 			for i := 0; i < 30; i++ {
-				dbInstance, err = logic.GetInstanceById(instanceId)
+				dbInstance, err = logic.GetInstanceById(context.Background(), instanceId)
 				fmt.Printf(".")
 				if dbInstance.Ready == true && dbInstance.Status == "available" {
 					break;
@@ -134,13 +134,13 @@ func TestAwsProvision(t *testing.T) {
 			var c broker.RequestContext
 			_, err = logic.ActionRestart(instanceId, map[string]string{}, &c)
 			So(err, ShouldBeNil)
-			dbInstance, err := logic.GetInstanceById(instanceId)
+			dbInstance, err := logic.GetInstanceById(context.Background(), instanceId)
 			So(err, ShouldBeNil)
 			So(InProgress(dbInstance.Status), ShouldEqual, true)
 
 			t := time.NewTicker(time.Second * 30)
 			for i := 0; i < 30; i++ {
-				dbInstance, err = logic.GetInstanceById(instanceId)
+				dbInstance, err = logic.GetInstanceById(context.Background(), instanceId)
 				fmt.Printf(".")
 				if dbInstance.Ready == true && dbInstance.Status == "available" {
 					break;
@@ -256,16 +256,16 @@ func TestAwsProvision(t *testing.T) {
 			_, err = logic.ActionRestoreBackup(instanceId, map[string]string{"backup":*backup.Id}, &c)
 			So(err, ShouldBeNil)
 
-			dbInstance, err = logic.GetInstanceById(instanceId)
+			dbInstance, err = logic.GetInstanceById(context.Background(), instanceId)
 			So(err, ShouldBeNil)
 			task, err := logic.storage.PopPendingTask()
 			So(err, ShouldBeNil)
 			So(task.Action, ShouldEqual, RestoreDbTask)
-			RestoreBackup(logic.storage, dbInstance, namePrefix, *backup.Id)
+			RestoreBackup(context.Background(), logic.storage, dbInstance, namePrefix, *backup.Id)
 			FinishedTask(logic.storage, task.Id, task.Retries, "", "finished")
 
 			for i := 0; i < 30; i++ {
-				dbInstance, err = logic.GetInstanceById(instanceId)
+				dbInstance, err = logic.GetInstanceById(context.Background(), instanceId)
 				So(err, ShouldBeNil)
 				fmt.Printf(".")
 				if dbInstance.Ready == true && dbInstance.Status == "available" {
@@ -296,7 +296,7 @@ func TestAwsProvision(t *testing.T) {
 
 			t := time.NewTicker(time.Second * 30)
 			for i := 0; i < 30; i++ {
-				dbInstance, err := logic.GetInstanceById(instanceId)
+				dbInstance, err := logic.GetInstanceById(context.Background(), instanceId)
 				fmt.Printf(".")
 				if err == nil && dbInstance.Ready == true && dbInstance.Status == "available" {
 					break;
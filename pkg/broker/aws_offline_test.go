@@ -0,0 +1,672 @@
+package broker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/rds"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFakeAWSInstanceProvider(awssvc *fakeRDS) *AWSInstanceProvider {
+	provider := NewAWSInstanceProviderWithClient("test", awssvc, "sg-test")
+	provider.pollInterval = 0
+	provider.instanceCache = NewInstanceCache(0)
+	return provider
+}
+
+func TestAwsInstanceProviderOffline(t *testing.T) {
+	ctx := context.Background()
+	plan := &ProviderPlan{
+		ID:                     "aws-test-plan",
+		Provider:               AWSInstance,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","Engine":"postgres","EngineVersion":"9.6.6","AllocatedStorage":5}`,
+	}
+
+	Convey("Given an aws instance provider backed by a fake rds", t, func() {
+		awssvc := newFakeRDS()
+		awssvc.upgradeTargets["9.5.4"] = []string{"9.5.10", "9.6.1"}
+		awssvc.upgradeTargets["9.6.1"] = []string{"9.6.6", "10.1"}
+		awssvc.upgradeTargets["9.6.6"] = []string{"10.1"}
+		awssvc.upgradeTargets["10.1"] = []string{"10.3", "10.4"}
+		awssvc.parameterGroups = []*rds.DBParameterGroup{
+			{DBParameterGroupName: aws.String("default.postgres9.6"), DBParameterGroupFamily: aws.String("postgres9.6")},
+			{DBParameterGroupName: aws.String("default.postgres10"), DBParameterGroupFamily: aws.String("postgres10")},
+		}
+		provider := newFakeAWSInstanceProvider(awssvc)
+
+		Convey("Ensure the upgrade plan walks through intermediate versions.", func() {
+			versions, err := provider.upgradePlan(ctx, &DbInstance{Engine: "postgres", EngineVersion: "9.5.4"}, "10.4")
+			So(err, ShouldBeNil)
+			So(versions, ShouldResemble, []string{"9.6.1", "10.1", "10.4"})
+
+			versions, err = provider.upgradePlan(ctx, &DbInstance{Engine: "postgres", EngineVersion: "9.6.1"}, "9.6.6")
+			So(err, ShouldBeNil)
+			So(versions, ShouldResemble, []string{"9.6.6"})
+
+			versions, err = provider.upgradePlan(ctx, &DbInstance{Engine: "postgres", EngineVersion: "10.4"}, "9.6")
+			So(err, ShouldBeNil)
+			So(len(versions), ShouldEqual, 0)
+
+			_, err = provider.upgradePlan(ctx, &DbInstance{Engine: "postgres", EngineVersion: "10.4"}, "11.1")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Unable to find a valid upgrade path from 10.4 to 11.1")
+		})
+
+		Convey("Ensure a provisioned database goes from creating to available.", func() {
+			dbInstance, err := provider.Provision(ctx, "instance-id", plan, "owner")
+			So(err, ShouldBeNil)
+			So(dbInstance.Status, ShouldEqual, "creating")
+			So(dbInstance.Ready, ShouldEqual, false)
+			So(dbInstance.Password, ShouldNotEqual, "")
+			So(strings.HasPrefix(dbInstance.Name, "test"), ShouldEqual, true)
+
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "creating")
+			fetched, err = provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "available")
+			So(fetched.Ready, ShouldEqual, true)
+			So(fetched.Endpoint, ShouldEqual, dbInstance.Name+".fake.us-west-2.rds.amazonaws.com:5432/"+dbInstance.Name)
+
+			Convey("Ensure it can be upgraded to a plan with a newer engine version.", func() {
+				dbInstance.Status = fetched.Status
+				dbInstance.Plan = plan
+				newPlan := &ProviderPlan{
+					ID:                     "aws-test-plan-2",
+					Provider:               AWSInstance,
+					Scheme:                 "postgres",
+					providerPrivateDetails: `{"DBInstanceClass":"db.t2.medium","Engine":"postgres","EngineVersion":"10.4","AllocatedStorage":2}`,
+				}
+				upgraded, err := provider.Modify(ctx, dbInstance, newPlan)
+				So(err, ShouldBeNil)
+				So(upgraded.EngineVersion, ShouldEqual, "10.4")
+				So(upgraded.Plan.ID, ShouldEqual, "aws-test-plan-2")
+				So(*awssvc.instances[dbInstance.Name].DBInstanceClass, ShouldEqual, "db.t2.medium")
+				So(*awssvc.instances[dbInstance.Name].AllocatedStorage, ShouldEqual, 5)
+				So(*awssvc.instances[dbInstance.Name].DBParameterGroups[0].DBParameterGroupName, ShouldEqual, "default.postgres10")
+			})
+
+			Convey("Ensure a backup can be restored by renaming and replacing the database.", func() {
+				dbInstance.Ready = true
+				dbInstance.Plan = plan
+				backup, err := provider.CreateBackup(ctx, dbInstance)
+				So(err, ShouldBeNil)
+				So(*backup.Status, ShouldEqual, "creating")
+				backup, err = provider.GetBackup(ctx, dbInstance, *backup.Id)
+				So(err, ShouldBeNil)
+				So(*backup.Status, ShouldEqual, "available")
+
+				So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+				So(awssvc.restoredFrom[dbInstance.Name], ShouldEqual, *backup.Id)
+				So(len(awssvc.instances), ShouldEqual, 1)
+				restored := awssvc.instances[dbInstance.Name]
+				So(restored, ShouldNotBeNil)
+				So(*restored.VpcSecurityGroups[0].VpcSecurityGroupId, ShouldEqual, "sg-test")
+
+				So(provider.RestoreBackup(ctx, dbInstance, "does-not-exist"), ShouldNotBeNil)
+			})
+
+			Convey("Ensure it can be restored to a point in time between its first automated backup and now.", func() {
+				dbInstance.Ready = true
+				dbInstance.Plan = plan
+				dbInstance.Id = "instance-id"
+				So(provider.Capabilities(plan).Has(PointInTimeCapability), ShouldEqual, true)
+				_, _, err := provider.RestorableTimes(ctx, dbInstance)
+				So(err, ShouldNotBeNil)
+
+				awssvc.snapshots["rds:"+dbInstance.Name+"-automated"] = &rds.DBSnapshot{
+					DBInstanceIdentifier: aws.String(dbInstance.Name),
+					DBSnapshotIdentifier: aws.String("rds:" + dbInstance.Name + "-automated"),
+					SnapshotCreateTime:   aws.Time(time.Now().Add(-time.Hour)),
+					SnapshotType:         aws.String("automated"),
+					Status:               aws.String("available"),
+				}
+				earliest, latest, err := provider.RestorableTimes(ctx, dbInstance)
+				So(err, ShouldBeNil)
+				So(earliest.Before(latest), ShouldEqual, true)
+				So(validateRestoreTime(ctx, provider, dbInstance, earliest.Add(-time.Minute)), ShouldNotBeNil)
+				So(validateRestoreTime(ctx, provider, dbInstance, latest.Add(time.Minute)), ShouldNotBeNil)
+				at := latest.Add(-time.Minute)
+				So(validateRestoreTime(ctx, provider, dbInstance, at), ShouldBeNil)
+
+				restored, err := provider.RestoreToTime(ctx, dbInstance, at)
+				So(err, ShouldBeNil)
+				So(restored.Name, ShouldEqual, dbInstance.Name)
+				So(restored.Id, ShouldEqual, "instance-id")
+				So(restored.Password, ShouldEqual, dbInstance.Password)
+				So(awssvc.restoredFrom[dbInstance.Name], ShouldStartWith, dbInstance.Name+"-restore-")
+				So(awssvc.restoredAt[dbInstance.Name], ShouldEqual, at)
+				So(len(awssvc.instances), ShouldEqual, 1)
+				So(*awssvc.instances[dbInstance.Name].VpcSecurityGroups[0].VpcSecurityGroupId, ShouldEqual, "sg-test")
+
+				noBackups := &ProviderPlan{ID: "aws-no-backups-plan", Provider: AWSInstance, Scheme: "postgres", providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","Engine":"postgres","BackupRetentionPeriod":0}`}
+				So(provider.Capabilities(noBackups).Has(PointInTimeCapability), ShouldEqual, false)
+			})
+
+			Convey("Ensure it can be forked as it is now or from a snapshot with the settings of another plan.", func() {
+				dbInstance.Ready = true
+				dbInstance.Plan = plan
+				larger := &ProviderPlan{ID: "aws-test-plan-large", Provider: AWSInstance, Scheme: "postgres", providerPrivateDetails: `{"DBInstanceClass":"db.t2.large","Engine":"postgres","EngineVersion":"9.6.6","AllocatedStorage":5}`}
+
+				fork, err := provider.Fork(ctx, "fork-id", larger, "other-owner", dbInstance, "")
+				So(err, ShouldBeNil)
+				So(fork.Name, ShouldNotEqual, dbInstance.Name)
+				So(fork.Username, ShouldEqual, dbInstance.Username)
+				So(fork.Password, ShouldNotEqual, dbInstance.Password)
+				So(awssvc.restoredFrom[fork.Name], ShouldEqual, dbInstance.Name)
+				So(*awssvc.instances[fork.Name].DBInstanceClass, ShouldEqual, "db.t2.large")
+				So(*awssvc.tags[fork.ProviderId][0].Value, ShouldEqual, "other-owner")
+
+				forked, err := provider.PerformFork(ctx, fork, dbInstance, "", "other-owner")
+				So(err, ShouldBeNil)
+				So(forked.Id, ShouldEqual, "fork-id")
+				So(forked.Endpoint, ShouldEqual, fork.Name+".fake.us-west-2.rds.amazonaws.com:5432/"+dbInstance.Name)
+				So(*awssvc.instances[fork.Name].VpcSecurityGroups[0].VpcSecurityGroupId, ShouldEqual, "sg-test")
+
+				_, err = provider.Fork(ctx, "fork-id-2", plan, "owner", dbInstance, LatestBackup)
+				So(err, ShouldNotBeNil)
+				backup, err := provider.CreateBackup(ctx, dbInstance)
+				So(err, ShouldBeNil)
+				_, err = provider.Fork(ctx, "fork-id-2", plan, "owner", dbInstance, "does-not-exist")
+				So(err, ShouldNotBeNil)
+				fork, err = provider.Fork(ctx, "fork-id-2", plan, "owner", dbInstance, LatestBackup)
+				So(err, ShouldBeNil)
+				So(awssvc.restoredFrom[fork.Name], ShouldEqual, *backup.Id)
+			})
+
+			Convey("Ensure replicas, tags, logs and restarts work.", func() {
+				dbInstance.Status = fetched.Status
+				dbInstance.Ready = true
+				dbInstance.ProviderId = fetched.ProviderId
+				dbInstance.Plan = plan
+				replica, err := provider.CreateReadReplica(ctx, dbInstance)
+				So(err, ShouldBeNil)
+				So(replica.Name, ShouldEqual, dbInstance.Name+"-ro")
+				replica, err = provider.GetReadReplica(ctx, dbInstance)
+				So(err, ShouldBeNil)
+				So(replica.Username, ShouldEqual, dbInstance.Username)
+				So(provider.DeleteReadReplica(ctx, dbInstance), ShouldBeNil)
+				_, err = provider.GetReadReplica(ctx, dbInstance)
+				So(err, ShouldNotBeNil)
+
+				So(provider.Tag(ctx, dbInstance, "App", "foo"), ShouldBeNil)
+				So(len(awssvc.tags[dbInstance.ProviderId]), ShouldEqual, 2)
+				So(provider.Untag(ctx, dbInstance, "App"), ShouldBeNil)
+				So(len(awssvc.tags[dbInstance.ProviderId]), ShouldEqual, 1)
+
+				logs, err := provider.ListLogs(ctx, dbInstance)
+				So(err, ShouldBeNil)
+				So(len(logs), ShouldEqual, 1)
+				data, err := provider.GetLogs(ctx, dbInstance, *logs[0].Name)
+				So(err, ShouldBeNil)
+				So(data, ShouldEqual, "LOG: ready\n")
+
+				So(provider.Restart(ctx, dbInstance), ShouldBeNil)
+				restarted, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+				So(err, ShouldBeNil)
+				So(restarted.Status, ShouldEqual, "rebooting")
+			})
+
+			Convey("Ensure deprovisioning removes the database and keeps a final snapshot.", func() {
+				So(provider.Deprovision(ctx, dbInstance, true), ShouldBeNil)
+				_, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+				So(err, ShouldNotBeNil)
+				So(awssvc.snapshots[dbInstance.Name+"-final"], ShouldNotBeNil)
+			})
+		})
+
+		Convey("Ensure a cancelled context stops the provider from waiting.", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := provider.UpgradeVersion(cancelled, &DbInstance{Name: "foo", Engine: "postgres", EngineVersion: "9.6.6"}, "10.1", &rds.CreateDBInstanceInput{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAwsInstanceProviderDisasterRecoveryOffline(t *testing.T) {
+	ctx := context.Background()
+	plan := &ProviderPlan{
+		ID:                     "aws-dr-plan",
+		Provider:               AWSInstance,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","Engine":"postgres","EngineVersion":"9.6.6","AllocatedStorage":5,"DisasterRecovery":{"Region":"us-east-1","SnapshotsKept":2,"ReadReplica":true,"VpcSecurityGroupIds":["sg-recovery"]}}`,
+	}
+	snapshotsOnly := &ProviderPlan{
+		ID:                     "aws-dr-snapshots-plan",
+		Provider:               AWSInstance,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","Engine":"postgres","EngineVersion":"9.6.6","AllocatedStorage":5,"DisasterRecovery":{"Region":"us-east-1"}}`,
+	}
+
+	Convey("Given an aws instance provider with a fake rds in its region and in a recovery region", t, func() {
+		primary := newFakeRDS()
+		recovery := newFakeRDS()
+		recovery.peer = primary
+		provider := newFakeAWSInstanceProvider(primary)
+		provider.region = "us-west-2"
+		provider.regions.clients["us-east-1"] = recovery
+
+		addSnapshot := func(dbInstance *DbInstance, id string, taken time.Time) {
+			primary.Lock()
+			defer primary.Unlock()
+			primary.snapshots[id] = &rds.DBSnapshot{
+				DBInstanceIdentifier: aws.String(dbInstance.Name),
+				DBSnapshotIdentifier: aws.String(id),
+				DBSnapshotArn:        fakeRDSArn("snapshot", id),
+				Engine:               aws.String("postgres"),
+				EngineVersion:        aws.String("9.6.6"),
+				MasterUsername:       aws.String(dbInstance.Username),
+				SnapshotCreateTime:   aws.Time(taken),
+				SnapshotType:         aws.String("automated"),
+				Status:               aws.String("available"),
+			}
+		}
+		provision := func(plan *ProviderPlan) *DbInstance {
+			dbInstance, err := provider.Provision(ctx, "instance-id", plan, "owner")
+			So(err, ShouldBeNil)
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			fetched.Id = dbInstance.Id
+			fetched.Username = dbInstance.Username
+			fetched.Password = dbInstance.Password
+			fetched.Status = "available"
+			fetched.Ready = true
+			return fetched
+		}
+
+		Convey("Ensure only plans with a recovery region can recover, and the settings are validated.", func() {
+			So(ValidateProviderPrivateDetails(AWSInstance, plan.providerPrivateDetails), ShouldBeNil)
+			So(ValidateProviderPrivateDetails(AWSInstance, `{"DisasterRecovery":{"Region":"us-east-1","Replicas":1}}`), ShouldNotBeNil)
+			So(provider.Capabilities(plan).Has(DisasterRecoveryCapability), ShouldEqual, true)
+			So(provider.Capabilities(&ProviderPlan{ID: "aws-plan", Provider: AWSInstance, providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro"}`}).Has(DisasterRecoveryCapability), ShouldEqual, false)
+
+			provider.region = "us-east-1"
+			_, err := provider.SyncRecovery(ctx, &DbInstance{Name: "test", Plan: plan})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure snapshots are copied to the recovery region, old copies are removed and a replica is kept there.", func() {
+			dbInstance := provision(plan)
+			output, err := provider.SyncRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(output, ShouldContainSubstring, "No snapshots")
+			replica := recovery.instances[dbInstance.Name+"-dr"]
+			So(replica, ShouldNotBeNil)
+			So(*replica.ReadReplicaSourceDBInstanceIdentifier, ShouldEqual, dbInstance.ProviderId)
+
+			now := time.Now().UTC().Truncate(time.Second)
+			addSnapshot(dbInstance, "rds:"+dbInstance.Name+"-1", now.Add(-time.Hour*3))
+			_, err = provider.SyncRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			first := dbInstance.Name + "-dr-" + now.Add(-time.Hour*3).Format("20060102150405")
+			So(recovery.snapshots[first], ShouldNotBeNil)
+			So(*recovery.snapshots[first].SourceRegion, ShouldEqual, "us-west-2")
+			output, err = provider.SyncRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(output, ShouldContainSubstring, "already copied")
+			So(len(recovery.snapshots), ShouldEqual, 1)
+
+			addSnapshot(dbInstance, "rds:"+dbInstance.Name+"-2", now.Add(-time.Hour*2))
+			_, err = provider.SyncRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			addSnapshot(dbInstance, "rds:"+dbInstance.Name+"-3", now.Add(-time.Hour))
+			_, err = provider.SyncRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(recovery.snapshots), ShouldEqual, 2)
+			So(recovery.snapshots[first], ShouldBeNil)
+
+			Convey("Ensure the replica is promoted in the recovery region in place of the database.", func() {
+				promoted, err := provider.PromoteRecovery(ctx, dbInstance)
+				So(err, ShouldBeNil)
+				So(promoted.Name, ShouldEqual, dbInstance.Name+"-dr")
+				So(promoted.Id, ShouldEqual, dbInstance.Id)
+				So(promoted.Password, ShouldEqual, dbInstance.Password)
+				So(promoted.Endpoint, ShouldEqual, dbInstance.Name+"-dr.fake.us-west-2.rds.amazonaws.com:5432/"+dbInstance.Name)
+				So(recovery.instances[promoted.Name].ReadReplicaSourceDBInstanceIdentifier, ShouldBeNil)
+				So(*recovery.instances[promoted.Name].VpcSecurityGroups[0].VpcSecurityGroupId, ShouldEqual, "sg-recovery")
+
+				// the promoted database is found (and managed) in the recovery region.
+				fetched, err := provider.GetInstance(ctx, promoted.Name, plan)
+				So(err, ShouldBeNil)
+				So(fetched.ProviderId, ShouldEqual, promoted.ProviderId)
+				So(provider.Restart(ctx, promoted), ShouldBeNil)
+				So(*recovery.instances[promoted.Name].DBInstanceStatus, ShouldEqual, "rebooting")
+
+				_, err = provider.PromoteRecovery(ctx, promoted)
+				So(err, ShouldNotBeNil)
+				output, err := provider.SyncRecovery(ctx, promoted)
+				So(err, ShouldBeNil)
+				So(output, ShouldContainSubstring, "promoted")
+				_, err = provider.Fork(ctx, "fork-id", plan, "owner", promoted, "")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Ensure deprovisioning removes the replica and copies in the recovery region.", func() {
+				So(provider.Deprovision(ctx, dbInstance, false), ShouldBeNil)
+				So(len(recovery.instances), ShouldEqual, 0)
+				So(len(recovery.snapshots), ShouldEqual, 0)
+			})
+		})
+
+		Convey("Ensure databases without a replica are promoted from their newest snapshot copy.", func() {
+			dbInstance := provision(snapshotsOnly)
+			_, err := provider.PromoteRecovery(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+
+			now := time.Now().UTC().Truncate(time.Second)
+			addSnapshot(dbInstance, "rds:"+dbInstance.Name+"-1", now.Add(-time.Hour*2))
+			_, err = provider.SyncRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			addSnapshot(dbInstance, "rds:"+dbInstance.Name+"-2", now.Add(-time.Hour))
+			_, err = provider.SyncRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(recovery.instances), ShouldEqual, 0)
+
+			promoted, err := provider.PromoteRecovery(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(promoted.Name, ShouldEqual, dbInstance.Name+"-dr")
+			So(recovery.restoredFrom[promoted.Name], ShouldEqual, dbInstance.Name+"-dr-"+now.Add(-time.Hour).Format("20060102150405"))
+			So(primary.instances[dbInstance.Name], ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAwsInstanceProviderIAMAuthOffline(t *testing.T) {
+	plan := &ProviderPlan{
+		ID:                     "aws-iam-plan",
+		Provider:               AWSInstance,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","Engine":"postgres","EngineVersion":"9.6.6","AllocatedStorage":5,"EnableIAMDatabaseAuthentication":true,"DisasterRecovery":{"Region":"us-east-1"}}`,
+	}
+
+	Convey("Given an aws instance provider on a plan with iam database authentication", t, func() {
+		provider := newFakeAWSInstanceProvider(newFakeRDS())
+		provider.region = "us-west-2"
+		dbInstance := &DbInstance{
+			Id:       "instance-id",
+			Name:     "testdb",
+			Plan:     plan,
+			Endpoint: "testdb.fake.us-west-2.rds.amazonaws.com:5432/testdb",
+			Engine:   "postgres",
+			Scheme:   "postgres",
+		}
+
+		Convey("Ensure only plans that enable iam authentication can bind with it.", func() {
+			So(provider.Capabilities(plan).Has(IAMAuthCapability), ShouldEqual, true)
+			So(provider.Capabilities(&ProviderPlan{ID: "aws-plan", Provider: AWSInstance, providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","EnableIAMDatabaseAuthentication":false}`}).Has(IAMAuthCapability), ShouldEqual, false)
+			So(provider.Capabilities(&ProviderPlan{ID: "aws-mysql-plan", Provider: AWSInstance, providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","Engine":"mysql","EnableIAMDatabaseAuthentication":true}`}).Has(IAMAuthCapability), ShouldEqual, false)
+			_, err := provider.CreateIAMUser(context.Background(), &DbInstance{Name: "testdb", Ready: true, Plan: &ProviderPlan{ID: "aws-plan", Provider: AWSInstance, providerPrivateDetails: `{}`}})
+			So(err, ShouldEqual, ErrFeatureNotAvailable)
+		})
+
+		Convey("Ensure the credentials of a binding point at the database and its region.", func() {
+			creds, err := provider.IAMCredentials(dbInstance, "iamuser")
+			So(err, ShouldBeNil)
+			So(creds, ShouldResemble, IAMCredentials{Host: "testdb.fake.us-west-2.rds.amazonaws.com", Port: "5432", Username: "iamuser", Database: "testdb", Region: "us-west-2"})
+
+			// databases promoted in their disaster recovery region are logged into there.
+			promoted := *dbInstance
+			promoted.Name = "testdb-dr"
+			promoted.Endpoint = "testdb-dr.fake.us-east-1.rds.amazonaws.com:5432/testdb"
+			creds, err = provider.IAMCredentials(&promoted, "iamuser")
+			So(err, ShouldBeNil)
+			So(creds.Region, ShouldEqual, "us-east-1")
+
+			_, err = provider.IAMCredentials(&DbInstance{Name: "testdb", Plan: plan, Endpoint: "testdb"}, "iamuser")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure tokens are signed for the user with the credentials of the broker.", func() {
+			_, err := provider.IAMAuthToken(context.Background(), dbInstance, "iamuser")
+			So(err, ShouldNotBeNil)
+
+			provider.credentials = credentials.NewStaticCredentials("AKID", "SECRET", "")
+			token, err := provider.IAMAuthToken(context.Background(), dbInstance, "iamuser")
+			So(err, ShouldBeNil)
+			So(token, ShouldStartWith, "testdb.fake.us-west-2.rds.amazonaws.com:5432?")
+			So(token, ShouldContainSubstring, "DBUser=iamuser")
+			So(token, ShouldContainSubstring, "us-west-2%2Frds-db")
+			So(token, ShouldContainSubstring, "X-Amz-Signature=")
+		})
+
+		Convey("Ensure the auth parameter of bindings is validated.", func() {
+			auth, err := bindingAuthParameter(map[string]interface{}{})
+			So(err, ShouldBeNil)
+			So(auth, ShouldEqual, "")
+			auth, err = bindingAuthParameter(map[string]interface{}{"auth": "password"})
+			So(err, ShouldBeNil)
+			So(auth, ShouldEqual, "")
+			auth, err = bindingAuthParameter(map[string]interface{}{"auth": "iam"})
+			So(err, ShouldBeNil)
+			So(auth, ShouldEqual, IAMBindingAuth)
+			_, err = bindingAuthParameter(map[string]interface{}{"auth": "kerberos"})
+			So(err, ShouldNotBeNil)
+			_, err = bindingAuthParameter(map[string]interface{}{"auth": true})
+			So(err, ShouldNotBeNil)
+
+			credentials := iamBindingCredentials(dbInstance, "binding-id", IAMCredentials{Host: "host", Port: "5432", Username: "iamuser", Database: "testdb", Region: "us-west-2"})
+			So(credentials["DATABASE_IAM_TOKEN_URL"], ShouldEqual, "/v2/service_instances/instance-id/actions/iam-token?binding=binding-id")
+			So(credentials["DATABASE_URL"], ShouldBeNil)
+		})
+	})
+}
+
+func TestAwsClusteredProviderOffline(t *testing.T) {
+	ctx := context.Background()
+	plan := &ProviderPlan{
+		ID:                     "aws-cluster-test-plan",
+		Provider:               AWSCluster,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"Instance":{"DBInstanceClass":"db.r4.large","Engine":"aurora-postgresql"},"Cluster":{"Engine":"aurora-postgresql","EngineVersion":"9.6.8"}}`,
+	}
+
+	Convey("Given an aws clustered provider backed by a fake rds", t, func() {
+		awssvc := newFakeRDS()
+		provider := NewAWSClusteredProviderWithClient("test", awssvc, "sg-test")
+		provider.pollInterval = 0
+		provider.awsInstanceProvider = newFakeAWSInstanceProvider(awssvc)
+
+		dbInstance, err := provider.Provision(ctx, "instance-id", plan, "owner")
+		So(err, ShouldBeNil)
+		So(dbInstance.Status, ShouldEqual, "creating")
+		So(awssvc.clusters[dbInstance.Name], ShouldNotBeNil)
+		So(len(awssvc.clusters[dbInstance.Name].DBClusterMembers), ShouldEqual, 1)
+		dbInstance.Plan = plan
+		dbInstance.Ready = true
+
+		Convey("Ensure a backup can be restored by renaming and replacing the cluster.", func() {
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			backups, err := provider.ListBackups(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldEqual, 1)
+
+			So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+			So(awssvc.restoredFrom[dbInstance.Name], ShouldEqual, *backup.Id)
+			So(len(awssvc.clusters), ShouldEqual, 1)
+			So(len(awssvc.instances), ShouldEqual, 1)
+			cluster := awssvc.clusters[dbInstance.Name]
+			So(cluster, ShouldNotBeNil)
+			So(len(cluster.DBClusterMembers), ShouldEqual, 1)
+			So(*cluster.DBClusterMembers[0].DBInstanceIdentifier, ShouldEqual, dbInstance.Name)
+			So(*cluster.VpcSecurityGroups[0].VpcSecurityGroupId, ShouldEqual, "sg-test")
+		})
+
+		Convey("Ensure the cluster keeps its name if the restore fails.", func() {
+			So(provider.RestoreBackup(ctx, dbInstance, "missing-snapshot"), ShouldNotBeNil)
+			So(len(awssvc.clusters), ShouldEqual, 1)
+			cluster := awssvc.clusters[dbInstance.Name]
+			So(cluster, ShouldNotBeNil)
+			So(len(cluster.DBClusterMembers), ShouldEqual, 1)
+			So(*cluster.DBClusterMembers[0].DBInstanceIdentifier, ShouldEqual, dbInstance.Name)
+			So(awssvc.instances[dbInstance.Name], ShouldNotBeNil)
+		})
+
+		Convey("Ensure the cluster can be restored to a point in time by renaming and replacing it.", func() {
+			dbInstance.Id = "instance-id"
+			So(provider.Capabilities(plan).Has(PointInTimeCapability), ShouldEqual, true)
+			awssvc.clusters[dbInstance.Name].EarliestRestorableTime = aws.Time(time.Now().Add(-time.Hour))
+			earliest, latest, err := provider.RestorableTimes(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(earliest.Before(latest), ShouldEqual, true)
+			So(validateRestoreTime(ctx, provider, dbInstance, earliest.Add(-time.Minute)), ShouldNotBeNil)
+			at := earliest.Add(time.Minute)
+			So(validateRestoreTime(ctx, provider, dbInstance, at), ShouldBeNil)
+
+			restored, err := provider.RestoreToTime(ctx, dbInstance, at)
+			So(err, ShouldBeNil)
+			So(restored.Id, ShouldEqual, "instance-id")
+			So(restored.Endpoint, ShouldEqual, dbInstance.Name+".cluster-fake.us-west-2.rds.amazonaws.com:5432/"+dbInstance.Name)
+			So(awssvc.restoredFrom[dbInstance.Name], ShouldStartWith, dbInstance.Name+"-restore-")
+			So(awssvc.restoredAt[dbInstance.Name], ShouldEqual, at)
+			So(len(awssvc.clusters), ShouldEqual, 1)
+			So(len(awssvc.instances), ShouldEqual, 1)
+			So(*awssvc.clusters[dbInstance.Name].VpcSecurityGroups[0].VpcSecurityGroupId, ShouldEqual, "sg-test")
+		})
+
+		Convey("Ensure the cluster can be forked as a clone or from a snapshot.", func() {
+			dbInstance.Id = "instance-id"
+			awssvc.clusters[dbInstance.Name].EarliestRestorableTime = aws.Time(time.Now().Add(-time.Hour))
+			fork, err := provider.Fork(ctx, "fork-id", plan, "owner", dbInstance, "")
+			So(err, ShouldBeNil)
+			So(fork.Name, ShouldNotEqual, dbInstance.Name)
+			So(fork.Username, ShouldEqual, dbInstance.Username)
+			So(awssvc.restoredFrom[fork.Name], ShouldEqual, dbInstance.Name)
+			So(len(awssvc.clusters[fork.Name].DBClusterMembers), ShouldEqual, 1)
+			So(*awssvc.clusters[fork.Name].VpcSecurityGroups[0].VpcSecurityGroupId, ShouldEqual, "sg-test")
+
+			fork.Plan = plan
+			fork.Status = "available"
+			fork.Ready = true
+			forked, err := provider.PerformFork(ctx, fork, dbInstance, "", "owner")
+			So(err, ShouldBeNil)
+			So(forked.Id, ShouldEqual, "fork-id")
+			So(forked.Endpoint, ShouldStartWith, fork.Name+".cluster-fake.us-west-2.rds.amazonaws.com:5432/")
+
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			fork, err = provider.Fork(ctx, "fork-id-2", plan, "owner", dbInstance, LatestBackup)
+			So(err, ShouldBeNil)
+			So(awssvc.restoredFrom[fork.Name], ShouldEqual, *backup.Id)
+			So(len(awssvc.clusters), ShouldEqual, 3)
+		})
+
+		Convey("Ensure deprovisioning removes the members before the cluster.", func() {
+			So(provider.Deprovision(ctx, dbInstance, false), ShouldBeNil)
+			So(len(awssvc.clusters), ShouldEqual, 0)
+			So(len(awssvc.instances), ShouldEqual, 0)
+		})
+
+		Convey("Ensure the cluster endpoints are given out rather than the writer instance.", func() {
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Endpoint, ShouldEqual, dbInstance.Name+".cluster-fake.us-west-2.rds.amazonaws.com:5432/"+dbInstance.Name)
+			replica, err := provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Name, ShouldEqual, dbInstance.Name+"-ro")
+			So(replica.Endpoint, ShouldEqual, dbInstance.Name+".cluster-ro-fake.us-west-2.rds.amazonaws.com:5432/"+dbInstance.Name)
+		})
+
+		Convey("Ensure readers can be scaled up and down behind the reader endpoint.", func() {
+			dbInstance.Status = "available"
+			So(provider.Capabilities(plan).Has(ReadersCapability), ShouldEqual, true)
+			So(provider.ScaleReaders(ctx, dbInstance, 2), ShouldBeNil)
+			So(len(awssvc.clusters[dbInstance.Name].DBClusterMembers), ShouldEqual, 3)
+			for _, member := range awssvc.clusters[dbInstance.Name].DBClusterMembers[1:] {
+				So(*member.DBInstanceIdentifier, ShouldStartWith, dbInstance.Name+"-ro-")
+				So(awssvc.instances[*member.DBInstanceIdentifier].MasterUsername, ShouldEqual, awssvc.clusters[dbInstance.Name].MasterUsername)
+			}
+			count, automatic, err := provider.Readers(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+			So(automatic, ShouldEqual, false)
+
+			replica, err := provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Status, ShouldEqual, "creating")
+			replica, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Status, ShouldEqual, "available")
+
+			So(provider.ScaleReaders(ctx, dbInstance, 16), ShouldNotBeNil)
+			So(provider.ScaleReaders(ctx, dbInstance, 0), ShouldBeNil)
+			So(len(awssvc.clusters[dbInstance.Name].DBClusterMembers), ShouldEqual, 1)
+
+			replica, err = provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Endpoint, ShouldContainSubstring, ".cluster-ro-fake.")
+			So(len(awssvc.clusters[dbInstance.Name].DBClusterMembers), ShouldEqual, 2)
+			So(provider.DeleteReadReplica(ctx, dbInstance), ShouldBeNil)
+			So(len(awssvc.clusters[dbInstance.Name].DBClusterMembers), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given an aws clustered provider with readers scaled automatically", t, func() {
+		awssvc := newFakeRDS()
+		autoscaling := newFakeAutoScaling()
+		provider := NewAWSClusteredProviderWithClient("test", awssvc, "sg-test")
+		provider.pollInterval = 0
+		provider.awsInstanceProvider = newFakeAWSInstanceProvider(awssvc)
+		autoScaledPlan := &ProviderPlan{
+			ID:                     "aws-cluster-auto-scaled-plan",
+			Provider:               AWSCluster,
+			Scheme:                 "postgres",
+			providerPrivateDetails: `{"Instance":{"DBInstanceClass":"db.r4.large","Engine":"aurora-postgresql"},"Cluster":{"Engine":"aurora-postgresql","EngineVersion":"9.6.8"},"Readers":1,"AutoScaling":{"MinCapacity":1,"MaxCapacity":4,"TargetValue":60}}`,
+		}
+
+		Convey("Ensure auto scaling has to be available.", func() {
+			_, err := provider.Provision(ctx, "instance-id", autoScaledPlan, "owner")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure the readers are created and registered with auto scaling.", func() {
+			provider.autoscaling = autoscaling
+			dbInstance, err := provider.Provision(ctx, "instance-id", autoScaledPlan, "owner")
+			So(err, ShouldBeNil)
+			dbInstance.Plan = autoScaledPlan
+			dbInstance.Status = "available"
+			So(len(awssvc.clusters[dbInstance.Name].DBClusterMembers), ShouldEqual, 2)
+			target := autoscaling.targets["cluster:"+dbInstance.Name]
+			So(target, ShouldNotBeNil)
+			So(*target.MinCapacity, ShouldEqual, 1)
+			So(*target.MaxCapacity, ShouldEqual, 4)
+			policy := autoscaling.policies["cluster:"+dbInstance.Name]
+			So(*policy.TargetTrackingScalingPolicyConfiguration.PredefinedMetricSpecification.PredefinedMetricType, ShouldEqual, "RDSReaderAverageCPUUtilization")
+
+			count, automatic, err := provider.Readers(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+			So(automatic, ShouldEqual, true)
+			So(provider.ScaleReaders(ctx, dbInstance, 3), ShouldNotBeNil)
+
+			Convey("Ensure changing to a plan without auto scaling keeps and resizes the readers.", func() {
+				modified, err := provider.Modify(ctx, dbInstance, &ProviderPlan{
+					ID:                     "aws-cluster-larger-plan",
+					Provider:               AWSCluster,
+					Scheme:                 "postgres",
+					providerPrivateDetails: `{"Instance":{"DBInstanceClass":"db.r4.xlarge","Engine":"aurora-postgresql"},"Cluster":{"Engine":"aurora-postgresql","EngineVersion":"9.6.8"},"Readers":2}`,
+				})
+				So(err, ShouldBeNil)
+				So(modified.Endpoint, ShouldContainSubstring, ".cluster-fake.")
+				So(len(autoscaling.targets), ShouldEqual, 0)
+				So(len(awssvc.clusters[dbInstance.Name].DBClusterMembers), ShouldEqual, 3)
+				for _, member := range awssvc.clusters[dbInstance.Name].DBClusterMembers {
+					So(*awssvc.instances[*member.DBInstanceIdentifier].DBInstanceClass, ShouldEqual, "db.r4.xlarge")
+				}
+			})
+
+			Convey("Ensure deprovisioning removes the auto scaling.", func() {
+				So(provider.Deprovision(ctx, dbInstance, false), ShouldBeNil)
+				So(len(autoscaling.targets), ShouldEqual, 0)
+				So(len(awssvc.clusters), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,230 @@
+package broker
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAzureFlexibleProvider(t *testing.T) {
+	ctx := context.Background()
+	plan := &ProviderPlan{
+		ID:                     "azure-test-plan",
+		Provider:               AzureFlexible,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"engine":"postgres","engine_version":"14","sku_name":"Standard_B1ms","sku_tier":"Burstable","storage_size_gb":32,"backup_retention_days":7}`,
+	}
+
+	Convey("Given an azure flexible server provider against a local resource manager", t, func() {
+		arm := newFakeARM()
+		defer arm.Close()
+		provider := NewAzureFlexibleProviderWithClient("test", http.DefaultClient, arm.server.URL, "sub", "group", "eastus")
+		provider.instanceCache = NewInstanceCache(0)
+		provider.pollInterval = 0
+
+		Convey("Ensure azure states are understood by the broker.", func() {
+			So(IsAvailable("Ready"), ShouldEqual, true)
+			So(IsReady("Ready"), ShouldEqual, true)
+			So(InProgress("Provisioning"), ShouldEqual, true)
+			So(InProgress("Updating"), ShouldEqual, true)
+			So(CanBeModified("Ready"), ShouldEqual, true)
+			So(CanBeModified("Updating"), ShouldEqual, false)
+			So(CanBeModified("Stopped"), ShouldEqual, false)
+			So(CanGetBindings("Dropping"), ShouldEqual, false)
+			So(CanBeDeleted("Provisioning"), ShouldEqual, false)
+			So(CanBeDeleted("Stopped"), ShouldEqual, true)
+		})
+
+		Convey("Ensure unsupported engines are refused.", func() {
+			_, err := provider.Provision(ctx, "instance-id", &ProviderPlan{Provider: AzureFlexible, providerPrivateDetails: `{"engine":"oracle"}`}, "owner")
+			So(err, ShouldNotBeNil)
+			So(ValidateProviderPrivateDetails(AzureFlexible, plan.providerPrivateDetails), ShouldBeNil)
+		})
+
+		dbInstance, err := provider.Provision(ctx, "instance-id", plan, "Owner")
+		So(err, ShouldBeNil)
+		So(dbInstance.Id, ShouldEqual, "instance-id")
+		So(dbInstance.Status, ShouldEqual, "Provisioning")
+		So(dbInstance.Ready, ShouldEqual, false)
+		So(dbInstance.Username, ShouldNotEqual, "")
+		So(dbInstance.Password, ShouldNotEqual, "")
+		So(strings.HasPrefix(dbInstance.Name, "test"), ShouldEqual, true)
+		So(dbInstance.ProviderId, ShouldEqual, "/subscriptions/sub/resourceGroups/group/providers/Microsoft.DBforPostgreSQL/flexibleServers/"+dbInstance.Name)
+		server := arm.servers[dbInstance.Name]
+		So(server.Sku.Name, ShouldEqual, "Standard_B1ms")
+		So(server.Properties.Storage.StorageSizeGB, ShouldEqual, 32)
+		So(server.Properties.AdministratorLoginPassword, ShouldEqual, dbInstance.Password)
+		So(server.Tags["billing-code"], ShouldEqual, "owner")
+		So(server.Location, ShouldEqual, "eastus")
+		dbInstance.Plan = plan
+
+		Convey("Ensure it becomes available and the database is created after provisioning.", func() {
+			_, err := provider.PerformPostProvision(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "Provisioning")
+			So(InProgress(fetched.Status), ShouldEqual, true)
+			fetched, err = provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "Ready")
+			So(fetched.Ready, ShouldEqual, true)
+			So(fetched.Engine, ShouldEqual, "postgres")
+			So(fetched.EngineVersion, ShouldEqual, "14")
+			So(fetched.Endpoint, ShouldEqual, dbInstance.Name+".postgres.database.azure.com:5432/"+dbInstance.Name)
+			_, err = provider.PerformPostProvision(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(arm.databases[dbInstance.Name], ShouldResemble, []string{dbInstance.Name})
+
+			_, err = provider.GetInstance(ctx, "does-not-exist", plan)
+			So(err.Error(), ShouldEqual, "Cannot find database instance")
+		})
+
+		Convey("Ensure it can be modified, but not to another engine or while busy.", func() {
+			newPlan := &ProviderPlan{
+				ID:                     "azure-test-plan-2",
+				Provider:               AzureFlexible,
+				Scheme:                 "postgres",
+				providerPrivateDetails: `{"engine":"postgres","engine_version":"15","sku_name":"Standard_D2ds_v4","sku_tier":"GeneralPurpose","storage_size_gb":64,"high_availability":"ZoneRedundant"}`,
+			}
+			_, err := provider.Modify(ctx, dbInstance, newPlan)
+			So(err, ShouldNotBeNil)
+			provider.GetInstance(ctx, dbInstance.Name, plan)
+			modified, err := provider.Modify(ctx, dbInstance, newPlan)
+			So(err, ShouldBeNil)
+			So(modified.EngineVersion, ShouldEqual, "15")
+			So(modified.Username, ShouldEqual, dbInstance.Username)
+			So(modified.Plan.ID, ShouldEqual, "azure-test-plan-2")
+			So(arm.servers[dbInstance.Name].Sku.Tier, ShouldEqual, "GeneralPurpose")
+			So(arm.servers[dbInstance.Name].Properties.Storage.StorageSizeGB, ShouldEqual, 64)
+			So(arm.servers[dbInstance.Name].Properties.HighAvailability.Mode, ShouldEqual, "ZoneRedundant")
+
+			mysqlPlan := &ProviderPlan{Provider: AzureFlexible, Scheme: "mysql", providerPrivateDetails: `{"engine":"mysql","engine_version":"8.0.21"}`}
+			dbInstance.Plan = newPlan
+			_, err = provider.Modify(ctx, dbInstance, mysqlPlan)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure backups can be listed, taken and restored into a new server.", func() {
+			provider.GetInstance(ctx, dbInstance.Name, plan)
+			dbInstance.Ready = true
+			backups, err := provider.ListBackups(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldEqual, 1)
+			So(*backups[0].Status, ShouldEqual, "available")
+
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(*backup.Status, ShouldEqual, "creating")
+			fetched, err := provider.GetBackup(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+			So(*fetched.Status, ShouldEqual, "available")
+			So(*fetched.Progress, ShouldEqual, 100)
+			_, err = provider.GetBackup(ctx, dbInstance, "does-not-exist")
+			So(err.Error(), ShouldEqual, "Not found")
+
+			So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldNotBeNil)
+			restoredDb, err := provider.RestoreBackupInto(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+			So(restoredDb.Name, ShouldNotEqual, dbInstance.Name)
+			So(restoredDb.Id, ShouldEqual, dbInstance.Id)
+			So(restoredDb.Password, ShouldEqual, dbInstance.Password)
+			So(restoredDb.Ready, ShouldEqual, true)
+			So(restoredDb.Endpoint, ShouldEqual, restoredDb.Name+".postgres.database.azure.com:5432/"+dbInstance.Name)
+			restored := arm.servers[restoredDb.Name]
+			So(restored.Properties.CreateMode, ShouldEqual, "PointInTimeRestore")
+			So(restored.Properties.SourceServerResourceId, ShouldEqual, dbInstance.ProviderId)
+			So(restored.Properties.PointInTimeUTC, ShouldEqual, fetched.Created)
+			So(restored.Tags["billing-code"], ShouldEqual, "owner")
+
+			// the existing server is untouched, it's removed once the restored one is recorded.
+			So(len(arm.servers), ShouldEqual, 2)
+			So(arm.servers[dbInstance.Name].Properties.State, ShouldEqual, "Ready")
+			So(arm.servers[dbInstance.Name].Tags[azureFlexibleDatabaseTag], ShouldEqual, "")
+
+			_, err = provider.CreateReadReplica(ctx, restoredDb)
+			So(err, ShouldBeNil)
+			replica, err := provider.GetReadReplica(ctx, restoredDb)
+			So(err, ShouldBeNil)
+			So(replica.Endpoint, ShouldEqual, restoredDb.Name+"-ro.postgres.database.azure.com:5432/"+dbInstance.Name)
+
+			_, err = provider.RestoreBackupInto(ctx, dbInstance, "does-not-exist")
+			So(err.Error(), ShouldEqual, "Not found")
+			So(len(arm.servers), ShouldEqual, 3)
+		})
+
+		Convey("Ensure restarts, tags and logs work.", func() {
+			provider.GetInstance(ctx, dbInstance.Name, plan)
+			dbInstance.Ready = true
+			So(provider.Restart(ctx, dbInstance), ShouldBeNil)
+			So(arm.servers[dbInstance.Name].Properties.State, ShouldEqual, "Restarting")
+
+			So(provider.Tag(ctx, dbInstance, "app", "foo"), ShouldBeNil)
+			So(arm.servers[dbInstance.Name].Tags["app"], ShouldEqual, "foo")
+			So(provider.Untag(ctx, dbInstance, "app"), ShouldBeNil)
+			So(arm.servers[dbInstance.Name].Tags, ShouldResemble, map[string]string{"billing-code": "owner"})
+
+			logs, err := provider.ListLogs(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(*logs[0].Size, ShouldEqual, 2048)
+			data, err := provider.GetLogs(ctx, dbInstance, *logs[0].Name)
+			So(err, ShouldBeNil)
+			So(data, ShouldEqual, "log file "+dbInstance.Name+".log\n")
+			_, err = provider.GetLogs(ctx, dbInstance, "does-not-exist")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure read replicas can be created and removed.", func() {
+			fetched, _ := provider.GetInstance(ctx, dbInstance.Name, plan)
+			_, err := provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			fetched, _ = provider.GetInstance(ctx, dbInstance.Name, plan)
+			dbInstance.Status = fetched.Status
+			replica, err := provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Name, ShouldEqual, dbInstance.Name+"-ro")
+			So(replica.Username, ShouldEqual, dbInstance.Username)
+			So(arm.servers[dbInstance.Name+"-ro"].Properties.CreateMode, ShouldEqual, "Replica")
+			_, err = provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			provider.GetReadReplica(ctx, dbInstance)
+			replica, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Status, ShouldEqual, "Ready")
+			So(replica.Endpoint, ShouldEqual, dbInstance.Name+"-ro.postgres.database.azure.com:5432/"+dbInstance.Name)
+			So(provider.DeleteReadReplica(ctx, dbInstance), ShouldBeNil)
+			provider.GetReadReplica(ctx, dbInstance)
+			_, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure mysql servers use the mysql resource provider.", func() {
+			mysqlPlan := &ProviderPlan{ID: "azure-mysql", Provider: AzureFlexible, Scheme: "mysql", providerPrivateDetails: `{"engine":"mysql","engine_version":"8.0.21","sku_name":"Standard_B1ms","sku_tier":"Burstable"}`}
+			mysql, err := provider.Provision(ctx, "mysql-id", mysqlPlan, "owner")
+			So(err, ShouldBeNil)
+			provider.GetInstance(ctx, mysql.Name, mysqlPlan)
+			fetched, err := provider.GetInstance(ctx, mysql.Name, mysqlPlan)
+			So(err, ShouldBeNil)
+			So(fetched.Engine, ShouldEqual, "mysql")
+			So(fetched.Endpoint, ShouldEqual, mysql.Name+".mysql.database.azure.com:3306/"+mysql.Name)
+		})
+
+		Convey("Ensure deprovisioning removes the server and its replica.", func() {
+			So(provider.Deprovision(ctx, dbInstance, true), ShouldBeNil)
+			So(arm.servers[dbInstance.Name].Properties.State, ShouldEqual, "Dropping")
+			provider.GetInstance(ctx, dbInstance.Name, plan)
+			_, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err.Error(), ShouldEqual, "Cannot find database instance")
+			So(provider.Deprovision(ctx, dbInstance, false).Error(), ShouldEqual, "Cannot find database instance")
+		})
+
+		Convey("Ensure roles are not available.", func() {
+			So(provider.Capabilities(plan).Has(RolesCapability), ShouldEqual, false)
+			_, err := provider.CreateReadOnlyUser(ctx, dbInstance)
+			So(err, ShouldEqual, ErrFeatureNotAvailable)
+		})
+	})
+}
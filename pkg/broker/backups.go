@@ -0,0 +1,421 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/golang/glog"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A BackupStore holds the dumps taken for logical backups, dumps are streamed in and out of it so
+// they never have to fit in memory.
+type BackupStore interface {
+	Put(context.Context, string, io.Reader) (int64, error)
+	Get(context.Context, string) (io.ReadCloser, error)
+	Delete(context.Context, string) error
+}
+
+// NewBackupStore opens the store a backup location points to, this is either a directory on the
+// brokers filesystem (file:///var/backups or just /var/backups) or an S3 compatible bucket
+// (s3://bucket/prefix). Buckets not on AWS are given with an endpoint, e.g.
+// s3://bucket/prefix?endpoint=https://minio.example.com&region=us-east-1, credentials for buckets come
+// from the usual AWS environment variables.
+func NewBackupStore(location string) (BackupStore, error) {
+	if location == "" {
+		return nil, errors.New("No backup location was given.")
+	}
+	loc, err := url.Parse(location)
+	if err != nil {
+		return nil, errors.New("The backup location " + location + " is not a valid url: " + err.Error())
+	}
+	switch loc.Scheme {
+	case "", "file":
+		if loc.Path == "" || !path.IsAbs(loc.Path) {
+			return nil, errors.New("The backup location " + location + " must be an absolute path.")
+		}
+		return FileBackupStore{Root: loc.Path}, nil
+	case "s3":
+		if loc.Host == "" {
+			return nil, errors.New("The backup location " + location + " has no bucket.")
+		}
+		config := &aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))}
+		if region := loc.Query().Get("region"); region != "" {
+			config.Region = aws.String(region)
+		}
+		if endpoint := loc.Query().Get("endpoint"); endpoint != "" {
+			config.Endpoint = aws.String(endpoint)
+			config.S3ForcePathStyle = aws.Bool(true)
+		}
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, err
+		}
+		return S3BackupStore{
+			Bucket: loc.Host,
+			Prefix: strings.Trim(loc.Path, "/"),
+			svc:    s3.New(sess),
+		}, nil
+	}
+	return nil, errors.New("The backup location " + location + " must be a file:// or s3:// url.")
+}
+
+type FileBackupStore struct {
+	Root string
+}
+
+func (store FileBackupStore) path(key string) (string, error) {
+	file := filepath.Join(store.Root, filepath.FromSlash(key))
+	if !strings.HasPrefix(file, filepath.Clean(store.Root)+string(filepath.Separator)) {
+		return "", errors.New("The backup " + key + " is outside of the backup location.")
+	}
+	return file, nil
+}
+
+// Writes to a temporary file first so a failed dump never replaces a good one.
+func (store FileBackupStore) Put(ctx context.Context, key string, body io.Reader) (int64, error) {
+	file, err := store.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(file+".partial", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(out, body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file + ".partial")
+		return 0, err
+	}
+	return size, os.Rename(file+".partial", file)
+}
+
+func (store FileBackupStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(file)
+}
+
+func (store FileBackupStore) Delete(ctx context.Context, key string) error {
+	file, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type S3BackupStore struct {
+	Bucket string
+	Prefix string
+	svc    *s3.S3
+}
+
+func (store S3BackupStore) key(key string) string {
+	return path.Join(store.Prefix, key)
+}
+
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	reader.count += int64(n)
+	return n, err
+}
+
+// The dump is uploaded in parts as it's read, so its size doesn't need to be known up front.
+func (store S3BackupStore) Put(ctx context.Context, key string, body io.Reader) (int64, error) {
+	counter := &countingReader{Reader: body}
+	_, err := s3manager.NewUploaderWithClient(store.svc).UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(store.key(key)),
+		Body:   counter,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return counter.count, nil
+}
+
+func (store S3BackupStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := store.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(store.key(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
+
+func (store S3BackupStore) Delete(ctx context.Context, key string) error {
+	_, err := store.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(store.key(key)),
+	})
+	return err
+}
+
+// A LogicalBackup is a dump of a database taken by the broker, Location is the backup location it was
+// stored in and Path where in that location. Progress is a percentage.
+type LogicalBackup struct {
+	Id       string
+	Database string
+	Location string
+	Path     string
+	Status   string
+	Progress int64
+	Size     int64
+	Created  time.Time
+}
+
+func (backup LogicalBackup) Spec() DatabaseBackupSpec {
+	id := backup.Id
+	status := backup.Status
+	progress := backup.Progress
+	return DatabaseBackupSpec{
+		Database: DatabaseSpec{
+			Name: backup.Database,
+		},
+		Id:       &id,
+		Progress: &progress,
+		Status:   &status,
+		Created:  backup.Created.UTC().Format(time.RFC3339),
+	}
+}
+
+// LogicalBackupStore records the logical backups taken of each database, backups are found by the
+// name of their database and their id. Missing backups return a "Not found" error.
+type LogicalBackupStore interface {
+	AddLogicalBackup(LogicalBackup) error
+	UpdateLogicalBackup(LogicalBackup) error
+	GetLogicalBackup(string, string) (LogicalBackup, error)
+	ListLogicalBackups(string) ([]LogicalBackup, error)
+}
+
+var logicalBackups = struct {
+	sync.RWMutex
+	store LogicalBackupStore
+}{}
+
+// SetLogicalBackupStore sets where providers record their logical backups, without one they cannot
+// take any.
+func SetLogicalBackupStore(store LogicalBackupStore) {
+	logicalBackups.Lock()
+	defer logicalBackups.Unlock()
+	logicalBackups.store = store
+}
+
+func getLogicalBackupStore() (LogicalBackupStore, error) {
+	logicalBackups.RLock()
+	defer logicalBackups.RUnlock()
+	if logicalBackups.store == nil {
+		return nil, errors.New("Logical backups require storage to record them in.")
+	}
+	return logicalBackups.store, nil
+}
+
+// A LogicalBackuper is a provider that takes backups by dumping databases into a BackupStore. Dumps can
+// take far longer than a request so CreateBackup only records the backup, the worker then takes it
+// with PerformBackup.
+type LogicalBackuper interface {
+	PerformBackup(context.Context, *DbInstance, string) error
+}
+
+// A PointInTimeRestorer is a provider that can restore a database to any time between the earliest and
+// latest restorable times, rather than only to a backup. Providers that restore into a new database
+// return it with the same id, the database it replaced is then deprovisioned.
+type PointInTimeRestorer interface {
+	RestorableTimes(context.Context, *DbInstance) (time.Time, time.Time, error)
+	RestoreToTime(context.Context, *DbInstance, time.Time) (*DbInstance, error)
+}
+
+// A ReplacingRestorer is a provider that can only restore a backup by creating a new database from it.
+// The new database is returned once it's available with the same id, as with RestoreToTime the database
+// it replaces is deprovisioned after the new one is recorded. RestoreBackup is not used for these providers.
+type ReplacingRestorer interface {
+	RestoreBackupInto(context.Context, *DbInstance, string) (*DbInstance, error)
+}
+
+// Ensures a database can be restored to the time.
+func validateRestoreTime(ctx context.Context, restorer PointInTimeRestorer, dbInstance *DbInstance, at time.Time) error {
+	earliest, latest, err := restorer.RestorableTimes(ctx, dbInstance)
+	if err != nil {
+		return err
+	}
+	if at.Before(earliest) || at.After(latest) {
+		return errors.New("The database can only be restored to a time between " + earliest.UTC().Format(time.RFC3339) + " and " + latest.UTC().Format(time.RFC3339) + ".")
+	}
+	return nil
+}
+
+// Records a new backup of a database to be taken into the backup location.
+func addLogicalBackup(dbInstance *DbInstance, location string) (LogicalBackup, error) {
+	store, err := getLogicalBackupStore()
+	if err != nil {
+		return LogicalBackup{}, err
+	}
+	created := time.Now().UTC()
+	id := dbInstance.Name + "-" + created.Format("20060102150405") + "-" + strings.ToLower(RandomString(4))
+	backup := LogicalBackup{
+		Id:       id,
+		Database: dbInstance.Name,
+		Location: location,
+		Path:     dbInstance.Name + "/" + id + ".dump",
+		Status:   "creating",
+		Created:  created,
+	}
+	if err = store.AddLogicalBackup(backup); err != nil {
+		return LogicalBackup{}, err
+	}
+	return backup, nil
+}
+
+func listLogicalBackups(dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	store, err := getLogicalBackupStore()
+	if err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	backups, err := store.ListLogicalBackups(dbInstance.Name)
+	if err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	out := make([]DatabaseBackupSpec, 0)
+	for _, backup := range backups {
+		out = append(out, backup.Spec())
+	}
+	return out, nil
+}
+
+func getLogicalBackup(dbInstance *DbInstance, Id string) (LogicalBackup, error) {
+	store, err := getLogicalBackupStore()
+	if err != nil {
+		return LogicalBackup{}, err
+	}
+	return store.GetLogicalBackup(dbInstance.Name, Id)
+}
+
+// The id of an available backup of the database to fork, or of its newest available backup for the
+// LatestBackup. Backups of other databases can't be forked from.
+func forkLogicalBackup(dbInstance *DbInstance, backup string) (string, error) {
+	store, err := getLogicalBackupStore()
+	if err != nil {
+		return "", err
+	}
+	backups, err := store.ListLogicalBackups(dbInstance.Name)
+	if err != nil {
+		return "", err
+	}
+	var latest *LogicalBackup
+	for i, b := range backups {
+		if b.Status != "available" {
+			continue
+		}
+		if backup != LatestBackup && b.Id == backup {
+			return backup, nil
+		}
+		if backup == LatestBackup && (latest == nil || b.Created.After(latest.Created)) {
+			latest = &backups[i]
+		}
+	}
+	if latest == nil {
+		return "", errors.New("The backup " + backup + " of " + dbInstance.Name + " could not be found or is not available.")
+	}
+	return latest.Id, nil
+}
+
+// Takes a recorded backup, dump writes the database out and is streamed straight into the backup
+// store, it can report its progress as a percentage. The backup is marked failed if anything goes wrong.
+func takeLogicalBackup(ctx context.Context, dbInstance *DbInstance, Id string, dump func(io.Writer, func(int64)) error) error {
+	store, err := getLogicalBackupStore()
+	if err != nil {
+		return err
+	}
+	backup, err := store.GetLogicalBackup(dbInstance.Name, Id)
+	if err != nil {
+		return err
+	}
+	size, err := putLogicalBackup(ctx, backup, func(out io.Writer) error {
+		return dump(out, func(progress int64) {
+			backup.Progress = progress
+			if err := store.UpdateLogicalBackup(backup); err != nil {
+				glog.Errorf("Unable to record the progress of backup %s: %s\n", backup.Id, err.Error())
+			}
+		})
+	})
+	if err != nil {
+		backup.Status = "failed"
+		if uerr := store.UpdateLogicalBackup(backup); uerr != nil {
+			return errors.New(err.Error() + " (and the backup could not be marked failed: " + uerr.Error() + ")")
+		}
+		return err
+	}
+	backup.Status = "available"
+	backup.Progress = 100
+	backup.Size = size
+	return store.UpdateLogicalBackup(backup)
+}
+
+func putLogicalBackup(ctx context.Context, backup LogicalBackup, dump func(io.Writer) error) (int64, error) {
+	backupStore, err := NewBackupStore(backup.Location)
+	if err != nil {
+		return 0, err
+	}
+	reader, writer := io.Pipe()
+	dumped := make(chan error, 1)
+	go func() {
+		err := dump(writer)
+		writer.CloseWithError(err)
+		dumped <- err
+	}()
+	size, err := backupStore.Put(ctx, backup.Path, reader)
+	// stop the dump if the store gave up before reading all of it.
+	reader.CloseWithError(errors.New("The backup store stopped reading the dump."))
+	derr := <-dumped
+	if err != nil {
+		return 0, err
+	}
+	if derr != nil {
+		return 0, derr
+	}
+	return size, nil
+}
+
+// Opens the dump of an available backup for restoring.
+func openLogicalBackup(ctx context.Context, dbInstance *DbInstance, Id string) (io.ReadCloser, error) {
+	backup, err := getLogicalBackup(dbInstance, Id)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Status != "available" {
+		return nil, errors.New("The backup " + Id + " is " + backup.Status + " and cannot be restored.")
+	}
+	backupStore, err := NewBackupStore(backup.Location)
+	if err != nil {
+		return nil, err
+	}
+	return backupStore.Get(ctx, backup.Path)
+}
@@ -0,0 +1,203 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type fakeLogicalBackupStore struct {
+	backups map[string]LogicalBackup
+}
+
+func newFakeLogicalBackupStore() *fakeLogicalBackupStore {
+	return &fakeLogicalBackupStore{backups: make(map[string]LogicalBackup)}
+}
+
+func (store *fakeLogicalBackupStore) AddLogicalBackup(backup LogicalBackup) error {
+	store.backups[backup.Id] = backup
+	return nil
+}
+
+func (store *fakeLogicalBackupStore) UpdateLogicalBackup(backup LogicalBackup) error {
+	store.backups[backup.Id] = backup
+	return nil
+}
+
+func (store *fakeLogicalBackupStore) GetLogicalBackup(database string, Id string) (LogicalBackup, error) {
+	backup, ok := store.backups[Id]
+	if !ok || backup.Database != database {
+		return LogicalBackup{}, errors.New("Not found")
+	}
+	return backup, nil
+}
+
+func (store *fakeLogicalBackupStore) ListLogicalBackups(database string) ([]LogicalBackup, error) {
+	backups := make([]LogicalBackup, 0)
+	for _, backup := range store.backups {
+		if backup.Database == database {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
+
+func TestLogicalBackups(t *testing.T) {
+	Convey("Given a backup location", t, func() {
+		dir, err := ioutil.TempDir("", "backups")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		Convey("Ensure locations are turned into the right store.", func() {
+			store, err := NewBackupStore("file://" + dir)
+			So(err, ShouldBeNil)
+			So(store.(FileBackupStore).Root, ShouldEqual, dir)
+			store, err = NewBackupStore(dir)
+			So(err, ShouldBeNil)
+			So(store.(FileBackupStore).Root, ShouldEqual, dir)
+			store, err = NewBackupStore("s3://bucket/some/prefix/?endpoint=http://localhost:9000&region=us-east-1")
+			So(err, ShouldBeNil)
+			So(store.(S3BackupStore).Bucket, ShouldEqual, "bucket")
+			So(store.(S3BackupStore).key("db/id.dump"), ShouldEqual, "some/prefix/db/id.dump")
+			_, err = NewBackupStore("relative/path")
+			So(err, ShouldNotBeNil)
+			_, err = NewBackupStore("gs://bucket")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure dumps can be written, read and removed from a directory.", func() {
+			store := FileBackupStore{Root: dir}
+			size, err := store.Put(context.TODO(), "db/one.dump", strings.NewReader("some data"))
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 9)
+			reader, err := store.Get(context.TODO(), "db/one.dump")
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadAll(reader)
+			reader.Close()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some data")
+			_, err = store.Put(context.TODO(), "../escape.dump", strings.NewReader("some data"))
+			So(err, ShouldNotBeNil)
+			So(store.Delete(context.TODO(), "db/one.dump"), ShouldBeNil)
+			_, err = store.Get(context.TODO(), "db/one.dump")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure backups are recorded, taken and opened.", func() {
+			_, err := addLogicalBackup(&DbInstance{Name: "testdb"}, dir)
+			So(err, ShouldNotBeNil)
+
+			records := newFakeLogicalBackupStore()
+			SetLogicalBackupStore(records)
+			defer SetLogicalBackupStore(nil)
+			dbInstance := &DbInstance{Name: "testdb"}
+			backup, err := addLogicalBackup(dbInstance, dir)
+			So(err, ShouldBeNil)
+			So(backup.Status, ShouldEqual, "creating")
+			So(*backup.Spec().Id, ShouldEqual, backup.Id)
+
+			_, err = openLogicalBackup(context.TODO(), dbInstance, backup.Id)
+			So(err, ShouldNotBeNil)
+			var reported int64
+			So(takeLogicalBackup(context.TODO(), dbInstance, backup.Id, func(out io.Writer, progress func(int64)) error {
+				progress(50)
+				reported = records.backups[backup.Id].Progress
+				_, err := io.WriteString(out, "dumped")
+				return err
+			}), ShouldBeNil)
+			So(reported, ShouldEqual, 50)
+			So(records.backups[backup.Id].Progress, ShouldEqual, 100)
+			So(records.backups[backup.Id].Status, ShouldEqual, "available")
+			So(records.backups[backup.Id].Size, ShouldEqual, 6)
+
+			dump, err := openLogicalBackup(context.TODO(), dbInstance, backup.Id)
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadAll(dump)
+			dump.Close()
+			So(string(data), ShouldEqual, "dumped")
+
+			failed, err := addLogicalBackup(dbInstance, dir)
+			So(err, ShouldBeNil)
+			So(takeLogicalBackup(context.TODO(), dbInstance, failed.Id, func(out io.Writer, progress func(int64)) error {
+				io.WriteString(out, "partial")
+				return errors.New("pg_dump failed")
+			}), ShouldNotBeNil)
+			So(records.backups[failed.Id].Status, ShouldEqual, "failed")
+
+			backups, err := listLogicalBackups(dbInstance)
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldEqual, 2)
+			_, err = getLogicalBackup(&DbInstance{Name: "otherdb"}, backup.Id)
+			So(err.Error(), ShouldEqual, "Not found")
+		})
+	})
+}
+
+func TestPostgresSharedBackups(t *testing.T) {
+	if os.Getenv("TEST_SHARED_POSTGRES") == "" {
+		return
+	}
+	ctx := context.TODO()
+	Convey("Given a shared postgres plan with a backup location.", t, func() {
+		dir, err := ioutil.TempDir("", "backups")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		records := newFakeLogicalBackupStore()
+		SetLogicalBackupStore(records)
+		defer SetLogicalBackupStore(nil)
+
+		plan := &ProviderPlan{
+			ID:                     "backup-test-plan",
+			Provider:               PostgresShared,
+			Scheme:                 "postgres",
+			providerPrivateDetails: `{"master_uri":"` + os.Getenv("DATABASE_URL") + `", "engine":"postgres", "engine_version":"10.4", "backup_location":"file://` + dir + `"}`,
+		}
+		provider, err := NewPostgresSharedProvider("test")
+		So(err, ShouldBeNil)
+		So(provider.Capabilities(plan).Has(BackupsCapability), ShouldEqual, true)
+		dbInstance, err := provider.Provision(ctx, "backup-test-instance", plan, "owner")
+		So(err, ShouldBeNil)
+		dbInstance.Plan = plan
+		dbUrl := dbInstance.Scheme + "://" + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint + "?sslmode=disable"
+
+		db, err := sql.Open("postgres", dbUrl)
+		So(err, ShouldBeNil)
+		_, err = db.Exec("create table test_table (id int)")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("insert into test_table (id) values (1)")
+		So(err, ShouldBeNil)
+		role, err := provider.CreateReadOnlyUser(ctx, dbInstance)
+		So(err, ShouldBeNil)
+
+		backup, err := provider.CreateBackup(ctx, dbInstance)
+		So(err, ShouldBeNil)
+		So(*backup.Status, ShouldEqual, "creating")
+		So(provider.PerformBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+		backup, err = provider.GetBackup(ctx, dbInstance, *backup.Id)
+		So(err, ShouldBeNil)
+		So(*backup.Status, ShouldEqual, "available")
+
+		_, err = db.Exec("delete from test_table")
+		So(err, ShouldBeNil)
+		db.Close()
+		So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+
+		reader, err := sql.Open("postgres", "postgres://"+role.Username+":"+role.Password+"@"+role.Endpoint+"?sslmode=disable")
+		So(err, ShouldBeNil)
+		defer reader.Close()
+		var count int
+		So(reader.QueryRow("select count(*) from test_table").Scan(&count), ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+		So(provider.Deprovision(ctx, dbInstance, true), ShouldBeNil)
+		backups, err := provider.ListBackups(ctx, dbInstance)
+		So(err, ShouldBeNil)
+		So(len(backups), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type instanceCacheEntry struct {
+	instance DbInstance
+	expires  time.Time
+}
+
+// InstanceCache is a thread safe cache of database instances shared by every request using a
+// provider. Entries expire after the ttl and are swept lazily on writes, so there's no goroutine
+// to leak. Copies go in and copies come out, callers are free to modify what they get back.
+type InstanceCache struct {
+	sync.RWMutex
+	ttl       time.Duration
+	lastSweep time.Time
+	entries   map[string]instanceCacheEntry
+}
+
+func NewInstanceCache(ttl time.Duration) *InstanceCache {
+	return &InstanceCache{
+		ttl:       ttl,
+		lastSweep: time.Now(),
+		entries:   make(map[string]instanceCacheEntry),
+	}
+}
+
+func instanceCacheKey(name string, plan *ProviderPlan) string {
+	if plan == nil {
+		return name + "|"
+	}
+	return name + "|" + plan.ID
+}
+
+func (c *InstanceCache) Get(name string, plan *ProviderPlan) (*DbInstance, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	entry, ok := c.entries[instanceCacheKey(name, plan)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	dbInstance := entry.instance
+	return &dbInstance, true
+}
+
+func (c *InstanceCache) Set(name string, plan *ProviderPlan, dbInstance *DbInstance) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastSweep) > c.ttl {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[instanceCacheKey(name, plan)] = instanceCacheEntry{instance: *dbInstance, expires: now.Add(c.ttl)}
+}
+
+// Invalidate removes the database from the cache regardless of the plan it was cached under,
+// this should be called after anything that changes the database on the provider.
+func (c *InstanceCache) Invalidate(name string) {
+	c.Lock()
+	defer c.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, name+"|") {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *InstanceCache) Clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = make(map[string]instanceCacheEntry)
+}
@@ -0,0 +1,64 @@
+package broker
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInstanceCache(t *testing.T) {
+	Convey("Given an instance cache", t, func() {
+		plan := &ProviderPlan{ID: "plan"}
+		cache := NewInstanceCache(time.Millisecond * 50)
+		cache.Set("db1", plan, &DbInstance{Name: "db1", Status: "available"})
+
+		Convey("Ensure cached instances are returned as copies.", func() {
+			dbInstance, ok := cache.Get("db1", plan)
+			So(ok, ShouldEqual, true)
+			So(dbInstance.Status, ShouldEqual, "available")
+			dbInstance.Password = "secret"
+			dbInstance, ok = cache.Get("db1", plan)
+			So(ok, ShouldEqual, true)
+			So(dbInstance.Password, ShouldEqual, "")
+		})
+
+		Convey("Ensure instances are cached per plan.", func() {
+			_, ok := cache.Get("db1", &ProviderPlan{ID: "other-plan"})
+			So(ok, ShouldEqual, false)
+		})
+
+		Convey("Ensure instances expire.", func() {
+			time.Sleep(time.Millisecond * 60)
+			_, ok := cache.Get("db1", plan)
+			So(ok, ShouldEqual, false)
+		})
+
+		Convey("Ensure instances can be invalidated.", func() {
+			cache.Set("db10", plan, &DbInstance{Name: "db10"})
+			cache.Invalidate("db1")
+			_, ok := cache.Get("db1", plan)
+			So(ok, ShouldEqual, false)
+			_, ok = cache.Get("db10", plan)
+			So(ok, ShouldEqual, true)
+		})
+
+		Convey("Ensure the cache can be used concurrently.", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					name := "db" + strconv.Itoa(i)
+					for j := 0; j < 100; j++ {
+						cache.Set(name, plan, &DbInstance{Name: name})
+						cache.Get(name, plan)
+						cache.Invalidate(name)
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	})
+}
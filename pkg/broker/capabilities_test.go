@@ -0,0 +1,84 @@
+package broker
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProviderCapabilities(t *testing.T) {
+	Convey("Given a set of actions and an instance whose provider only supports roles", t, func() {
+		handled := false
+		handler := func(string, map[string]string, *broker.RequestContext) (interface{}, error) {
+			handled = true
+			return map[string]interface{}{"status": "OK"}, nil
+		}
+		base := ActionBase{}
+		base.capabilities = func(instanceId string) (ProviderCapabilities, error) {
+			if instanceId != "known" {
+				return nil, NotFound()
+			}
+			return ProviderCapabilities{RolesCapability}, nil
+		}
+		base.AddActions("list_roles", "roles", "GET", RolesCapability, handler)
+		base.AddActions("list_backups", "backups", "GET", BackupsCapability, handler)
+		base.AddActions("restart", "restart", "PUT", RestartCapability, handler)
+
+		router := mux.NewRouter()
+		So(base.RouteActions(router), ShouldBeNil)
+
+		Convey("Ensure only supported actions are advertised.", func() {
+			capabilities, err := base.capabilities("known")
+			So(err, ShouldBeNil)
+			extensions := base.ConvertActionsToExtensions("known", capabilities)
+			So(len(extensions), ShouldEqual, 1)
+			So(extensions[0].DiscoveryURL, ShouldEqual, "/v2/service_instances/known/actions/list_roles/schema")
+		})
+
+		Convey("Ensure a supported action is handled.", func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/v2/service_instances/known/actions/roles", nil))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(handled, ShouldEqual, true)
+		})
+
+		Convey("Ensure an unsupported action returns not implemented without calling the handler.", func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/v2/service_instances/known/actions/backups", nil))
+			So(w.Code, ShouldEqual, http.StatusNotImplemented)
+			So(w.Body.String(), ShouldContainSubstring, "backups")
+			So(handled, ShouldEqual, false)
+		})
+
+		Convey("Ensure an unknown instance returns not found.", func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("PUT", "/v2/service_instances/unknown/actions/restart", nil))
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+			So(handled, ShouldEqual, false)
+		})
+	})
+
+	Convey("Given errors returned from a provider", t, func() {
+		Convey("Ensure features not available on the plan are a conflict.", func() {
+			httpErr, ok := osb.IsHTTPError(ProviderActionError(ErrFeatureNotAvailable))
+			So(ok, ShouldEqual, true)
+			So(httpErr.StatusCode, ShouldEqual, http.StatusConflict)
+		})
+		Convey("Ensure any other error is an internal server error.", func() {
+			httpErr, ok := osb.IsHTTPError(ProviderActionError(errors.New("boom")))
+			So(ok, ShouldEqual, true)
+			So(httpErr.StatusCode, ShouldEqual, http.StatusInternalServerError)
+		})
+		Convey("Ensure shared and gcloud providers do not advertise backups.", func() {
+			So(PostgresSharedProvider{}.Capabilities(nil).Has(BackupsCapability), ShouldEqual, false)
+			So(MysqlSharedProvider{}.Capabilities(nil).Has(RolesCapability), ShouldEqual, true)
+			So(GCloudInstanceProvider{}.Capabilities(nil).Has(LogsCapability), ShouldEqual, false)
+			So(AWSInstanceProvider{}.Capabilities(nil).Has(ReplicasCapability), ShouldEqual, true)
+		})
+	})
+}
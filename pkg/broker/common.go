@@ -109,6 +109,13 @@ func UnprocessableEntity() error {
 	}
 }
 
+func NotImplementedWithMessage(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusNotImplemented,
+		Description: &description,
+	}
+}
+
 func NotFound() error {
 	description := "Not Found"
 	return osb.HTTPStatusCodeError{
@@ -117,15 +124,26 @@ func NotFound() error {
 	}
 }
 
+// Providers return ErrFeatureNotAvailable when the plan cannot perform an operation, this is a
+// conflict with the plan the instance is on rather than a failure of the broker.
+func ProviderActionError(err error) error {
+	if err == ErrFeatureNotAvailable {
+		return ConflictErrorWithMessage(err.Error())
+	}
+	return InternalServerError()
+}
+
 type Action struct {
-	name    string
-	path    string
-	method  string
-	handler func(string, map[string]string, *broker.RequestContext) (interface{}, error)
+	name       string
+	path       string
+	method     string
+	capability Capability
+	handler    func(string, map[string]string, *broker.RequestContext) (interface{}, error)
 }
 
 type ActionBase struct {
-	actions []Action
+	actions      []Action
+	capabilities func(string) (ProviderCapabilities, error)
 	sync.RWMutex
 }
 
@@ -137,7 +155,12 @@ func InitFromOptions(ctx context.Context, o Options) (Storage, string, error) {
 		return nil, "", errors.New("The name prefix was not specified, set NAME_PREFIX in your environment or provide it via the cli using -name-prefix")
 	}
 	storage, err := InitStorage(ctx, o)
-	return storage, o.NamePrefix, err
+	if err != nil {
+		return nil, "", err
+	}
+	SetSharedTenantStore(storage)
+	SetLogicalBackupStore(storage)
+	return storage, o.NamePrefix, nil
 }
 
 func (b *ActionBase) ActionSchemaHandler(w http.ResponseWriter, r *http.Request) {
@@ -216,6 +239,44 @@ func (b *ActionBase) ActionSchemaHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func writeActionError(w http.ResponseWriter, herr error) {
+	type e struct {
+		ErrorMessage *string `json:"error,omitempty"`
+		Description  *string `json:"description,omitempty"`
+	}
+	if httpErr, ok := osb.IsHTTPError(herr); ok {
+		body := &e{}
+		if httpErr.Description != nil {
+			body.Description = httpErr.Description
+		}
+		if httpErr.ErrorMessage != nil {
+			body.ErrorMessage = httpErr.ErrorMessage
+		}
+		HttpWrite(w, httpErr.StatusCode, body)
+	} else {
+		msg := "InternalServerError"
+		description := "Internal Server Error"
+		body := &e{ErrorMessage:&msg, Description:&description}
+		HttpWrite(w, 500, body)
+	}
+}
+
+// Ensure the provider behind the instance supports the action before we ever call its handler,
+// if it doesn't we return a 501 rather than letting the provider fail with a 500.
+func (b *ActionBase) checkCapability(action Action, instanceId string) error {
+	if action.capability == "" || b.capabilities == nil {
+		return nil
+	}
+	capabilities, err := b.capabilities(instanceId)
+	if err != nil {
+		return err
+	}
+	if !capabilities.Has(action.capability) {
+		return NotImplementedWithMessage("The " + action.name + " action is not supported by this database's provider (missing capability: " + string(action.capability) + ").")
+	}
+	return nil
+}
+
 func (b *ActionBase) RouteActions(router *mux.Router) error {
 	for _, action := range b.actions {
 		glog.Infof("Adding route %s /v2/service_instances/{instance_id}/actions/%s\n", action.method, action.path)
@@ -223,29 +284,14 @@ func (b *ActionBase) RouteActions(router *mux.Router) error {
 		router.HandleFunc("/v2/service_instances/{instance_id}/actions/" + action.path, func(w http.ResponseWriter, r *http.Request) {
 			vars := mux.Vars(r)
 			c := broker.RequestContext{Request: r, Writer: w}
+			if herr := b.checkCapability(act, vars["instance_id"]); herr != nil {
+				writeActionError(w, herr)
+				return
+			}
 			obj, herr := act.handler(vars["instance_id"], vars, &c)
 			if herr != nil {
-				type e struct {
-					ErrorMessage *string `json:"error,omitempty"`
-					Description  *string `json:"description,omitempty"`
-				}
-				if httpErr, ok := osb.IsHTTPError(herr); ok {
-					body := &e{}
-					if httpErr.Description != nil {
-						body.Description = httpErr.Description
-					}
-					if httpErr.ErrorMessage != nil {
-						body.ErrorMessage = httpErr.ErrorMessage
-					}
-					HttpWrite(w, httpErr.StatusCode, body)
-					return
-				} else {
-					msg := "InternalServerError"
-					description := "Internal Server Error"
-					body := &e{ErrorMessage:&msg, Description:&description}
-					HttpWrite(w, 500, body)
-					return
-				}
+				writeActionError(w, herr)
+				return
 			}
 			if obj != nil {
 				HttpWrite(w, 200, obj)
@@ -258,10 +304,14 @@ func (b *ActionBase) RouteActions(router *mux.Router) error {
 	return nil
 }
 
-func (b *ActionBase) ConvertActionsToExtensions(serviceId string) []osb.ExtensionAPI {
+// Only advertise the actions the instance's provider can actually perform.
+func (b *ActionBase) ConvertActionsToExtensions(serviceId string, capabilities ProviderCapabilities) []osb.ExtensionAPI {
 	extensions := make([]osb.ExtensionAPI, 0)
 	var baseUrl = ""
 	for _, action := range b.actions {
+		if !capabilities.Has(action.capability) {
+			continue
+		}
 		extensions = append(extensions, osb.ExtensionAPI{
 			DiscoveryURL: baseUrl + "/v2/service_instances/" + serviceId + "/actions/" + action.name + "/schema",
 			ServerURL:    baseUrl + "/v2/service_instances/" + serviceId + "/actions/",
@@ -270,14 +320,15 @@ func (b *ActionBase) ConvertActionsToExtensions(serviceId string) []osb.Extensio
 	return extensions
 }
 
-func (b *ActionBase) AddActions(name string, path string, method string, handler func(string, map[string]string, *broker.RequestContext) (interface{}, error)) error {
+func (b *ActionBase) AddActions(name string, path string, method string, capability Capability, handler func(string, map[string]string, *broker.RequestContext) (interface{}, error)) error {
 	b.Lock()
 	defer b.Unlock()
 	b.actions = append(b.actions, Action{
-		name:    name,
-		path:    path,
-		method:  method,
-		handler: handler,
+		name:       name,
+		path:       path,
+		method:     method,
+		capability: capability,
+		handler:    handler,
 	})
 	return nil
 }
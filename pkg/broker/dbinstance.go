@@ -63,7 +63,9 @@ type DatabaseBackupSpec struct {
 func IsAvailable(status string) bool {
 	return status == "available" ||
 			// gcloud status
-			status == "RUNNABLE"
+			status == "RUNNABLE" ||
+			// azure status
+			status == "Ready"
 }
 
 func IsReady(status string) bool {
@@ -73,7 +75,9 @@ func IsReady(status string) bool {
 		status == "backing-up" ||
 		// gcloud states
 		status == "RUNNABLE" ||
-		status == "UNKNOWN_STATE"
+		status == "UNKNOWN_STATE" ||
+		// azure states
+		status == "Ready"
 }
 
 func InProgress(status string) bool {
@@ -82,7 +86,9 @@ func InProgress(status string) bool {
 		status == "renaming" || status == "upgrading" || status == "backtracking" ||
 		status == "maintenance" || status == "resetting-master-credentials" ||
 		// gcloud states
-		status == "PENDING_CREATE" || status == "MAINTENANCE"
+		status == "PENDING_CREATE" || status == "MAINTENANCE" ||
+		// azure states
+		status == "Provisioning" || status == "Updating" || status == "Starting" || status == "Restarting"
 
 }
 
@@ -92,7 +98,10 @@ func CanGetBindings(status string) bool {
 			status != "stopping" && status != "stopped" && status != "deleting" &&
 			// gcloud states
 			status != "SUSPENDED" && status != "PENDING_CREATE" && status != "MAINTENANCE" &&
-			status != "FAILED" && status != "UNKNOWN_STATE"
+			status != "FAILED" && status != "UNKNOWN_STATE" &&
+			// azure states
+			status != "Provisioning" && status != "Starting" && status != "Stopping" &&
+			status != "Stopped" && status != "Dropping" && status != "Disabled"
 }
 
 func CanBeModified(status string) bool {
@@ -103,7 +112,11 @@ func CanBeModified(status string) bool {
 		status != "maintenance" && status != "resetting-master-credentials" &&
 		// gcloud states
 		status != "SUSPENDED" && status != "PENDING_CREATE" && status != "MAINTENANCE" &&
-		status != "FAILED" && status != "UNKNOWN_STATE"
+		status != "FAILED" && status != "UNKNOWN_STATE" &&
+		// azure states
+		status != "Provisioning" && status != "Updating" && status != "Starting" &&
+		status != "Restarting" && status != "Stopping" && status != "Stopped" &&
+		status != "Dropping" && status != "Disabled"
 }
 
 func CanBeDeleted(status string) bool {
@@ -112,7 +125,9 @@ func CanBeDeleted(status string) bool {
 		status != "renaming" && status != "upgrading" && status != "backtracking" &&
 		status != "maintenance" && status != "resetting-master-credentials" && 
 		status != "SUSPENDED" && status != "PENDING_CREATE" && status != "MAINTENANCE" &&
-		status != "FAILED" && status != "UNKNOWN_STATE"
+		status != "FAILED" && status != "UNKNOWN_STATE" &&
+		status != "Provisioning" && status != "Starting" && status != "Restarting" &&
+		status != "Dropping"
 }
 
 /** gcloud settings **/
@@ -126,3 +141,15 @@ func CanBeDeleted(status string) bool {
     // MAINTENANCE: The instance is down for maintenance.
     // FAILED: The instance creation failed.
     // UNKNOWN_STATE: The state of the instance is unknown.
+
+/** azure settings **/
+// State: The state of the flexible server (postgres or mysql). This can
+    // be one of the following.
+    // Ready: The server is running and accepting connections.
+    // Provisioning: The server is being created or restored (the broker
+    // also uses this before azure reports a state).
+    // Updating: The server is being scaled, upgraded or reconfigured.
+    // Starting, Restarting, Stopping, Stopped: The server is changing (or
+    // has changed) its power state.
+    // Dropping: The server is being deleted.
+    // Disabled: The server has been disabled, for example due to billing.
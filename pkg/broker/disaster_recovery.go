@@ -0,0 +1,115 @@
+package broker
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"os"
+	"time"
+)
+
+// How often the worker brings the disaster recovery copies of databases up to date, this can be
+// changed by setting DISASTER_RECOVERY_INTERVAL in the environment to a go duration.
+const defaultDisasterRecoveryInterval = time.Hour * 6
+
+// A DisasterRecoverer is a provider that can keep a copy of databases somewhere else, such as
+// another region, for plans that ask for it. SyncRecovery brings the copy up to date and says what
+// it did. PromoteRecovery turns the copy into the database when the original is lost and returns
+// the promoted database, which may have a new name and endpoint. PromoteRecovery is given the
+// database as the broker last recorded it and must not depend on the original being reachable.
+type DisasterRecoverer interface {
+	SyncRecovery(context.Context, *DbInstance) (string, error)
+	PromoteRecovery(context.Context, *DbInstance) (*DbInstance, error)
+}
+
+func disasterRecoveryInterval() time.Duration {
+	if value := os.Getenv("DISASTER_RECOVERY_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err == nil && interval > 0 {
+			return interval
+		}
+		glog.Errorf("WARNING: The interval DISASTER_RECOVERY_INTERVAL=%s is not a valid duration, using the default.\n", value)
+	}
+	return defaultDisasterRecoveryInterval
+}
+
+// The database as the broker last recorded it, without asking its provider. This is for when the
+// provider (or the region the database is in) can't be reached.
+func GetRecordedInstanceById(storage Storage, Id string) (*DbInstance, error) {
+	entry, err := storage.GetInstance(Id)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := storage.GetPlanByID(entry.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	return &DbInstance{
+		Id:       entry.Id,
+		Name:     entry.Name,
+		Plan:     plan,
+		Username: entry.Username,
+		Password: entry.Password,
+		Endpoint: entry.Endpoint,
+		Status:   entry.Status,
+		Ready:    IsReady(entry.Status),
+		Scheme:   plan.Scheme,
+	}, nil
+}
+
+// Schedules a task to sync the disaster recovery copy of every database on a plan that keeps one,
+// databases that already have a sync waiting are skipped.
+func ScheduleDisasterRecoveryTasks(namePrefix string, storage Storage) {
+	services, err := storage.GetServices()
+	if err != nil {
+		glog.Errorf("Unable to schedule disaster recovery, cannot get services: %s\n", err.Error())
+		return
+	}
+	for _, service := range services {
+		plans, err := storage.GetPlans(service.ID)
+		if err != nil {
+			glog.Errorf("Unable to schedule disaster recovery, cannot get plans for %s: %s\n", service.ID, err.Error())
+			continue
+		}
+		for i := range plans {
+			plan := &plans[i]
+			provider, err := GetProviderByPlan(namePrefix, plan)
+			if err != nil {
+				continue
+			}
+			if _, ok := provider.(DisasterRecoverer); !ok || !provider.Capabilities(plan).Has(DisasterRecoveryCapability) {
+				continue
+			}
+			entries, err := storage.GetInstancesByPlan(plan.ID)
+			if err != nil {
+				glog.Errorf("Unable to schedule disaster recovery, cannot get databases on plan %s: %s\n", plan.ID, err.Error())
+				continue
+			}
+			for _, entry := range entries {
+				syncing, err := storage.IsSyncingRecovery(entry.Id)
+				if err != nil {
+					glog.Errorf("Unable to get disaster recovery status of %s: %s\n", entry.Id, err.Error())
+					continue
+				}
+				if syncing {
+					continue
+				}
+				if _, err = storage.AddTask(entry.Id, SyncRecoveryTask, ""); err != nil {
+					glog.Errorf("Error: Unable to schedule disaster recovery sync! (%s): %s\n", entry.Name, err.Error())
+				}
+			}
+		}
+	}
+}
+
+func TickTocDisasterRecoveryTasks(ctx context.Context, namePrefix string, storage Storage) {
+	next_check := time.NewTicker(disasterRecoveryInterval())
+	defer next_check.Stop()
+	for {
+		ScheduleDisasterRecoveryTasks(namePrefix, storage)
+		select {
+		case <-ctx.Done():
+			return
+		case <-next_check.C:
+		}
+	}
+}
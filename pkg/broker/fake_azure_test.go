@@ -0,0 +1,216 @@
+package broker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeARM is a stateful, local stub of the azure resource manager endpoints for flexible servers.
+// Like azure, changes are accepted and finish later: servers that are provisioning, updating or
+// restarting are ready after they've been read once and dropped servers are gone after they've been
+// read once. Log files are served from the stub as well.
+type fakeARM struct {
+	sync.Mutex
+	server    *httptest.Server
+	servers   map[string]*azureFlexibleServer
+	databases map[string][]string
+	backups   map[string][]azureFlexibleBackupResource
+	requests  []string
+}
+
+func newFakeARM() *fakeARM {
+	arm := &fakeARM{
+		servers:   make(map[string]*azureFlexibleServer),
+		databases: make(map[string][]string),
+		backups:   make(map[string][]azureFlexibleBackupResource),
+	}
+	arm.server = httptest.NewServer(http.HandlerFunc(arm.serveHTTP))
+	return arm
+}
+
+func (arm *fakeARM) Close() {
+	arm.server.Close()
+}
+
+func (arm *fakeARM) write(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if obj != nil {
+		json.NewEncoder(w).Encode(obj)
+	}
+}
+
+func (arm *fakeARM) error(w http.ResponseWriter, status int, code string, message string) {
+	arm.write(w, status, map[string]interface{}{"error": map[string]string{"code": code, "message": message}})
+}
+
+func (arm *fakeARM) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	arm.Lock()
+	defer arm.Unlock()
+	arm.requests = append(arm.requests, r.Method+" "+r.URL.Path)
+	if strings.HasPrefix(r.URL.Path, "/logs/") {
+		w.Write([]byte("log file " + strings.TrimPrefix(r.URL.Path, "/logs/") + "\n"))
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		arm.error(w, http.StatusBadRequest, "MissingApiVersionParameter", "The api-version query parameter is required.")
+		return
+	}
+	// /subscriptions/{id}/resourceGroups/{group}/providers/{namespace}/flexibleServers/{name}[/{child}[/{childName}]]
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 9 || parts[7] != "flexibleServers" {
+		arm.error(w, http.StatusBadRequest, "InvalidResourceType", "Unknown resource "+r.URL.Path)
+		return
+	}
+	name := parts[8]
+	body, _ := ioutil.ReadAll(r.Body)
+	server, exists := arm.servers[name]
+	if len(parts) == 9 {
+		arm.serveServer(w, r.Method, strings.Join(parts[:9], "/"), name, server, exists, body)
+		return
+	}
+	if !exists {
+		arm.error(w, http.StatusNotFound, "ResourceNotFound", "The server "+name+" was not found.")
+		return
+	}
+	child := ""
+	if len(parts) > 10 {
+		child = parts[10]
+	}
+	switch {
+	case parts[9] == "restart" && r.Method == http.MethodPost:
+		server.Properties.State = "Restarting"
+		arm.write(w, http.StatusAccepted, nil)
+	case parts[9] == "databases" && r.Method == http.MethodPut:
+		if server.Properties.State != "Ready" {
+			arm.error(w, http.StatusConflict, "ServerIsBusy", "The server "+name+" is "+server.Properties.State+".")
+			return
+		}
+		arm.databases[name] = append(arm.databases[name], child)
+		arm.write(w, http.StatusOK, map[string]string{"name": child})
+	case parts[9] == "backups" && child == "" && r.Method == http.MethodGet:
+		arm.write(w, http.StatusOK, map[string]interface{}{"value": arm.backups[name]})
+	case parts[9] == "backups" && r.Method == http.MethodGet:
+		for _, backup := range arm.backups[name] {
+			if backup.Name == child {
+				arm.write(w, http.StatusOK, backup)
+				return
+			}
+		}
+		arm.error(w, http.StatusNotFound, "ResourceNotFound", "The backup "+child+" was not found.")
+	case parts[9] == "backups" && r.Method == http.MethodPut:
+		backup := azureFlexibleBackupResource{Name: child}
+		backup.Properties.BackupType = "Customer On-Demand"
+		arm.write(w, http.StatusAccepted, backup)
+		backup.Properties.CompletedTime = time.Now().UTC().Format(time.RFC3339)
+		arm.backups[name] = append(arm.backups[name], backup)
+	case parts[9] == "logFiles" && r.Method == http.MethodGet:
+		log := azureFlexibleLogFile{Name: name + ".log"}
+		log.Properties.SizeInKb = 2
+		log.Properties.LastModifiedTime = time.Now().UTC().Format(time.RFC3339)
+		log.Properties.Url = arm.server.URL + "/logs/" + name + ".log"
+		arm.write(w, http.StatusOK, map[string]interface{}{"value": []azureFlexibleLogFile{log}})
+	default:
+		arm.error(w, http.StatusBadRequest, "InvalidRequest", "Unknown request "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (arm *fakeARM) serveServer(w http.ResponseWriter, method string, id string, name string, server *azureFlexibleServer, exists bool, body []byte) {
+	if !exists && method != http.MethodPut {
+		arm.error(w, http.StatusNotFound, "ResourceNotFound", "The server "+name+" was not found.")
+		return
+	}
+	switch method {
+	case http.MethodGet:
+		c := *server
+		c.Properties.AdministratorLoginPassword = ""
+		arm.write(w, http.StatusOK, c)
+		switch server.Properties.State {
+		case "Provisioning", "Updating", "Restarting":
+			server.Properties.State = "Ready"
+		case "Dropping":
+			delete(arm.servers, name)
+		}
+	case http.MethodPut:
+		if exists {
+			arm.error(w, http.StatusConflict, "ServerAlreadyExists", "The server "+name+" already exists.")
+			return
+		}
+		var created azureFlexibleServer
+		if err := json.Unmarshal(body, &created); err != nil {
+			arm.error(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+		if created.Properties.CreateMode == "PointInTimeRestore" || created.Properties.CreateMode == "Replica" {
+			sourceId := strings.Split(created.Properties.SourceServerResourceId, "/")
+			source, ok := arm.servers[sourceId[len(sourceId)-1]]
+			if !ok {
+				arm.error(w, http.StatusBadRequest, "SourceServerNotFound", "The source server was not found.")
+				return
+			}
+			restored := *source
+			restored.Properties = source.Properties
+			restored.Properties.CreateMode = created.Properties.CreateMode
+			restored.Properties.SourceServerResourceId = created.Properties.SourceServerResourceId
+			restored.Properties.PointInTimeUTC = created.Properties.PointInTimeUTC
+			restored.Properties.RestorePointInTime = created.Properties.RestorePointInTime
+			restored.Tags = created.Tags
+			restored.Location = created.Location
+			if created.Properties.CreateMode == "Replica" {
+				restored.Properties.ReplicationRole = "AsyncReplica"
+			}
+			created = restored
+		}
+		created.Id = id
+		created.Name = name
+		created.Properties.State = "Provisioning"
+		created.Properties.FullyQualifiedDomainName = name + ".postgres.database.azure.com"
+		if strings.Contains(id, "Microsoft.DBforMySQL") {
+			created.Properties.FullyQualifiedDomainName = name + ".mysql.database.azure.com"
+		}
+		arm.servers[name] = &created
+		arm.backups[name] = []azureFlexibleBackupResource{{Name: "backup_" + strconv.FormatInt(time.Now().Unix(), 10)}}
+		arm.backups[name][0].Properties.BackupType = "Full"
+		arm.backups[name][0].Properties.CompletedTime = time.Now().UTC().Format(time.RFC3339)
+		arm.write(w, http.StatusAccepted, nil)
+	case http.MethodPatch:
+		var update azureFlexibleServer
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &update); err != nil {
+			arm.error(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+			return
+		}
+		json.Unmarshal(body, &fields)
+		if _, ok := fields["tags"]; ok {
+			server.Tags = update.Tags
+		}
+		if update.Sku != nil {
+			server.Sku = update.Sku
+		}
+		if update.Properties.Storage != nil {
+			server.Properties.Storage = update.Properties.Storage
+		}
+		if update.Properties.Backup != nil {
+			server.Properties.Backup = update.Properties.Backup
+		}
+		if update.Properties.HighAvailability != nil {
+			server.Properties.HighAvailability = update.Properties.HighAvailability
+		}
+		if update.Properties.Version != "" {
+			server.Properties.Version = update.Properties.Version
+		}
+		server.Properties.State = "Updating"
+		arm.write(w, http.StatusAccepted, nil)
+	case http.MethodDelete:
+		server.Properties.State = "Dropping"
+		arm.write(w, http.StatusAccepted, nil)
+	default:
+		arm.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", method+" is not allowed.")
+	}
+}
@@ -0,0 +1,150 @@
+package broker
+
+import (
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fakeDynamic is a stateful, in memory stand in for the kubernetes api through the dynamic client.
+// Like an operator, the fake settles resources after they've been read once: clusters become
+// healthy with every instance ready and backups complete. Deleted resources are terminating until
+// they've been read once. Status is kept across updates the same as a status subresource, and
+// calls that are not implemented panic through the nil embedded interface.
+type fakeDynamic struct {
+	sync.Mutex
+	objects  map[schema.GroupVersionResource]map[string]*unstructured.Unstructured
+	versions int
+}
+
+func newFakeDynamic() *fakeDynamic {
+	return &fakeDynamic{objects: make(map[schema.GroupVersionResource]map[string]*unstructured.Unstructured)}
+}
+
+type fakeDynamicResource struct {
+	dynamic.ResourceInterface
+	client    *fakeDynamic
+	resource  schema.GroupVersionResource
+	namespace string
+}
+
+func (f *fakeDynamic) Resource(resource schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &fakeDynamicResource{client: f, resource: resource}
+}
+
+func (f *fakeDynamic) get(resource schema.GroupVersionResource, namespace string, name string) *unstructured.Unstructured {
+	f.Lock()
+	defer f.Unlock()
+	return f.objects[resource][namespace+"/"+name]
+}
+
+func (r *fakeDynamicResource) Namespace(namespace string) dynamic.ResourceInterface {
+	c := *r
+	c.namespace = namespace
+	return &c
+}
+
+func (r *fakeDynamicResource) notFound(name string) error {
+	return k8serrors.NewNotFound(schema.GroupResource{Group: r.resource.Group, Resource: r.resource.Resource}, name)
+}
+
+func (r *fakeDynamicResource) settle(obj *unstructured.Unstructured) {
+	switch r.resource.Resource {
+	case "clusters":
+		instances, _, _ := unstructured.NestedInt64(obj.Object, "spec", "instances")
+		unstructured.SetNestedField(obj.Object, kubernetesPostgresHealthy, "status", "phase")
+		unstructured.SetNestedField(obj.Object, instances, "status", "readyInstances")
+		unstructured.SetNestedField(obj.Object, obj.GetName()+"-rw", "status", "writeService")
+		unstructured.SetNestedField(obj.Object, obj.GetName()+"-ro", "status", "readService")
+	case "backups":
+		unstructured.SetNestedField(obj.Object, "completed", "status", "phase")
+		unstructured.SetNestedField(obj.Object, obj.GetCreationTimestamp().UTC().Format(time.RFC3339), "status", "startedAt")
+	}
+}
+
+func (r *fakeDynamicResource) Create(obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.client.Lock()
+	defer r.client.Unlock()
+	if r.client.objects[r.resource] == nil {
+		r.client.objects[r.resource] = make(map[string]*unstructured.Unstructured)
+	}
+	key := r.namespace + "/" + obj.GetName()
+	if _, ok := r.client.objects[r.resource][key]; ok {
+		return nil, k8serrors.NewAlreadyExists(schema.GroupResource{Group: r.resource.Group, Resource: r.resource.Resource}, obj.GetName())
+	}
+	stored := obj.DeepCopy()
+	unstructured.RemoveNestedField(stored.Object, "status")
+	stored.SetNamespace(r.namespace)
+	stored.SetCreationTimestamp(metav1.Now())
+	r.client.versions++
+	stored.SetResourceVersion(strconv.Itoa(r.client.versions))
+	r.client.objects[r.resource][key] = stored
+	return stored.DeepCopy(), nil
+}
+
+func (r *fakeDynamicResource) Update(obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.client.Lock()
+	defer r.client.Unlock()
+	key := r.namespace + "/" + obj.GetName()
+	current, ok := r.client.objects[r.resource][key]
+	if !ok {
+		return nil, r.notFound(obj.GetName())
+	}
+	stored := obj.DeepCopy()
+	if status, ok, _ := unstructured.NestedMap(current.Object, "status"); ok {
+		unstructured.SetNestedMap(stored.Object, status, "status")
+	} else {
+		unstructured.RemoveNestedField(stored.Object, "status")
+	}
+	r.client.versions++
+	stored.SetResourceVersion(strconv.Itoa(r.client.versions))
+	r.client.objects[r.resource][key] = stored
+	return stored.DeepCopy(), nil
+}
+
+func (r *fakeDynamicResource) Delete(name string, options *metav1.DeleteOptions, subresources ...string) error {
+	r.client.Lock()
+	defer r.client.Unlock()
+	current, ok := r.client.objects[r.resource][r.namespace+"/"+name]
+	if !ok {
+		return r.notFound(name)
+	}
+	now := metav1.Now()
+	current.SetDeletionTimestamp(&now)
+	return nil
+}
+
+func (r *fakeDynamicResource) Get(name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.client.Lock()
+	defer r.client.Unlock()
+	key := r.namespace + "/" + name
+	current, ok := r.client.objects[r.resource][key]
+	if !ok {
+		return nil, r.notFound(name)
+	}
+	c := current.DeepCopy()
+	if current.GetDeletionTimestamp() != nil {
+		delete(r.client.objects[r.resource], key)
+	} else {
+		r.settle(current)
+	}
+	return c, nil
+}
+
+func (r *fakeDynamicResource) List(options metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	r.client.Lock()
+	defer r.client.Unlock()
+	list := &unstructured.UnstructuredList{}
+	for _, obj := range r.client.objects[r.resource] {
+		if obj.GetNamespace() == r.namespace {
+			list.Items = append(list.Items, *obj.DeepCopy())
+			r.settle(obj)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,867 @@
+package broker
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeRDS is a stateful, in memory stand in for RDS. Anything that changes an instance or cluster
+// puts it into a transitional status (creating, modifying, renaming, ...) that becomes available
+// after it has been described once, or immediately when a waiter is used. Calls that are not
+// implemented panic through the nil embedded interface so untested paths are obvious.
+type fakeRDS struct {
+	rdsiface.RDSAPI
+	sync.Mutex
+	instances        map[string]*rds.DBInstance
+	clusters         map[string]*rds.DBCluster
+	snapshots        map[string]*rds.DBSnapshot
+	clusterSnapshots map[string]*rds.DBClusterSnapshot
+	// rds keeps the database name of a cluster snapshot but doesn't return it
+	snapshotDBNames map[string]*string
+	tags            map[string][]*rds.Tag
+	restoredFrom    map[string]string
+	restoredAt      map[string]time.Time
+	upgradeTargets  map[string][]string
+	parameterGroups []*rds.DBParameterGroup
+	// the fake of another region, cross region sources are given by their arn and found here.
+	peer *fakeRDS
+}
+
+func newFakeRDS() *fakeRDS {
+	return &fakeRDS{
+		instances:        make(map[string]*rds.DBInstance),
+		clusters:         make(map[string]*rds.DBCluster),
+		snapshots:        make(map[string]*rds.DBSnapshot),
+		clusterSnapshots: make(map[string]*rds.DBClusterSnapshot),
+		snapshotDBNames:  make(map[string]*string),
+		tags:             make(map[string][]*rds.Tag),
+		restoredFrom:     make(map[string]string),
+		restoredAt:       make(map[string]time.Time),
+		upgradeTargets:   make(map[string][]string),
+		parameterGroups:  make([]*rds.DBParameterGroup, 0),
+	}
+}
+
+func fakeRDSArn(kind string, id string) *string {
+	return aws.String("arn:aws:rds:us-west-2:000000000000:" + kind + ":" + id)
+}
+
+func fakeParameterGroupFamily(engine string, version string) *string {
+	v := strings.Split(version, ".")
+	if len(v) > 1 && v[0] == "9" {
+		return aws.String(engine + v[0] + "." + v[1])
+	}
+	return aws.String(engine + v[0])
+}
+
+func (f *fakeRDS) instanceNotFound(id string) error {
+	return awserr.New(rds.ErrCodeDBInstanceNotFoundFault, "DBInstance "+id+" not found.", nil)
+}
+
+func (f *fakeRDS) clusterNotFound(id string) error {
+	return awserr.New(rds.ErrCodeDBClusterNotFoundFault, "DBCluster "+id+" not found.", nil)
+}
+
+// Finds the source of a cross region copy or replica by its arn in the peer region.
+func (f *fakeRDS) peerInstance(arn string) (*rds.DBInstance, bool) {
+	if f.peer == nil || !strings.HasPrefix(arn, *fakeRDSArn("db", "")) {
+		return nil, false
+	}
+	f.peer.Lock()
+	defer f.peer.Unlock()
+	instance, ok := f.peer.instances[strings.TrimPrefix(arn, *fakeRDSArn("db", ""))]
+	return instance, ok
+}
+
+func (f *fakeRDS) peerSnapshot(arn string) (*rds.DBSnapshot, bool) {
+	if f.peer == nil || !strings.HasPrefix(arn, *fakeRDSArn("snapshot", "")) {
+		return nil, false
+	}
+	f.peer.Lock()
+	defer f.peer.Unlock()
+	snapshot, ok := f.peer.snapshots[strings.TrimPrefix(arn, *fakeRDSArn("snapshot", ""))]
+	return snapshot, ok
+}
+
+func (f *fakeRDS) settle(instance *rds.DBInstance) {
+	instance.DBInstanceStatus = aws.String("available")
+	instance.LatestRestorableTime = aws.Time(time.Now())
+	instance.Endpoint = &rds.Endpoint{
+		Address: aws.String(*instance.DBInstanceIdentifier + ".fake.us-west-2.rds.amazonaws.com"),
+		Port:    aws.Int64(5432),
+	}
+}
+
+// Clusters have a writer and reader endpoint as soon as they're created.
+func (f *fakeRDS) setClusterEndpoints(cluster *rds.DBCluster) {
+	cluster.Endpoint = aws.String(*cluster.DBClusterIdentifier + ".cluster-fake.us-west-2.rds.amazonaws.com")
+	cluster.ReaderEndpoint = aws.String(*cluster.DBClusterIdentifier + ".cluster-ro-fake.us-west-2.rds.amazonaws.com")
+	cluster.Port = aws.Int64(5432)
+}
+
+func (f *fakeRDS) copyInstance(instance *rds.DBInstance) *rds.DBInstance {
+	c := *instance
+	return &c
+}
+
+func (f *fakeRDS) addInstance(instance *rds.DBInstance) {
+	instance.DBInstanceArn = fakeRDSArn("db", *instance.DBInstanceIdentifier)
+	instance.DBInstanceStatus = aws.String("creating")
+	f.instances[*instance.DBInstanceIdentifier] = instance
+	if instance.DBClusterIdentifier != nil {
+		if cluster, ok := f.clusters[*instance.DBClusterIdentifier]; ok {
+			cluster.DBClusterMembers = append(cluster.DBClusterMembers, &rds.DBClusterMember{
+				DBInstanceIdentifier: instance.DBInstanceIdentifier,
+				IsClusterWriter:      aws.Bool(len(cluster.DBClusterMembers) == 0),
+			})
+		}
+	}
+}
+
+func (f *fakeRDS) removeClusterMember(clusterId *string, instanceId string) {
+	if clusterId == nil {
+		return
+	}
+	if cluster, ok := f.clusters[*clusterId]; ok {
+		members := make([]*rds.DBClusterMember, 0)
+		for _, member := range cluster.DBClusterMembers {
+			if *member.DBInstanceIdentifier != instanceId {
+				members = append(members, member)
+			}
+		}
+		cluster.DBClusterMembers = members
+	}
+}
+
+func (f *fakeRDS) CreateDBInstanceWithContext(ctx aws.Context, input *rds.CreateDBInstanceInput, opts ...request.Option) (*rds.CreateDBInstanceOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[*input.DBInstanceIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBInstanceAlreadyExistsFault, "DB Instance already exists", nil)
+	}
+	instance := &rds.DBInstance{
+		DBInstanceIdentifier:  input.DBInstanceIdentifier,
+		DBName:                input.DBName,
+		DBInstanceClass:       input.DBInstanceClass,
+		AllocatedStorage:      input.AllocatedStorage,
+		MasterUsername:        input.MasterUsername,
+		Engine:                input.Engine,
+		EngineVersion:         input.EngineVersion,
+		DBClusterIdentifier:   input.DBClusterIdentifier,
+		VpcSecurityGroups:     make([]*rds.VpcSecurityGroupMembership, 0),
+		BackupRetentionPeriod: input.BackupRetentionPeriod,
+	}
+	if instance.BackupRetentionPeriod == nil {
+		instance.BackupRetentionPeriod = aws.Int64(1)
+	}
+	if input.DBClusterIdentifier != nil {
+		cluster, ok := f.clusters[*input.DBClusterIdentifier]
+		if !ok {
+			return nil, f.clusterNotFound(*input.DBClusterIdentifier)
+		}
+		instance.DBName = cluster.DatabaseName
+		instance.MasterUsername = cluster.MasterUsername
+		instance.EngineVersion = cluster.EngineVersion
+	}
+	for _, group := range input.VpcSecurityGroupIds {
+		instance.VpcSecurityGroups = append(instance.VpcSecurityGroups, &rds.VpcSecurityGroupMembership{VpcSecurityGroupId: group, Status: aws.String("active")})
+	}
+	f.addInstance(instance)
+	f.tags[*instance.DBInstanceArn] = input.Tags
+	return &rds.CreateDBInstanceOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) CreateDBInstanceReadReplicaWithContext(ctx aws.Context, input *rds.CreateDBInstanceReadReplicaInput, opts ...request.Option) (*rds.CreateDBInstanceReadReplicaOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	source, ok := f.instances[*input.SourceDBInstanceIdentifier]
+	crossRegion := false
+	if !ok {
+		if source, ok = f.peerInstance(*input.SourceDBInstanceIdentifier); !ok {
+			return nil, f.instanceNotFound(*input.SourceDBInstanceIdentifier)
+		}
+		crossRegion = true
+	}
+	if _, ok := f.instances[*input.DBInstanceIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBInstanceAlreadyExistsFault, "DB Instance already exists", nil)
+	}
+	instance := &rds.DBInstance{
+		DBInstanceIdentifier:                  input.DBInstanceIdentifier,
+		DBName:                                source.DBName,
+		DBInstanceClass:                       input.DBInstanceClass,
+		MasterUsername:                        source.MasterUsername,
+		Engine:                                source.Engine,
+		EngineVersion:                         source.EngineVersion,
+		ReadReplicaSourceDBInstanceIdentifier: source.DBInstanceIdentifier,
+		VpcSecurityGroups:                     source.VpcSecurityGroups,
+	}
+	if crossRegion {
+		instance.ReadReplicaSourceDBInstanceIdentifier = input.SourceDBInstanceIdentifier
+		instance.VpcSecurityGroups = []*rds.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("default"), Status: aws.String("active")}}
+	} else {
+		source.ReadReplicaDBInstanceIdentifiers = append(source.ReadReplicaDBInstanceIdentifiers, input.DBInstanceIdentifier)
+	}
+	f.addInstance(instance)
+	return &rds.CreateDBInstanceReadReplicaOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) DescribeDBInstancesWithContext(ctx aws.Context, input *rds.DescribeDBInstancesInput, opts ...request.Option) (*rds.DescribeDBInstancesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	out := make([]*rds.DBInstance, 0)
+	for id, instance := range f.instances {
+		if input.DBInstanceIdentifier != nil && *input.DBInstanceIdentifier != id {
+			continue
+		}
+		out = append(out, f.copyInstance(instance))
+		if *instance.DBInstanceStatus != "available" {
+			f.settle(instance)
+		}
+	}
+	if input.DBInstanceIdentifier != nil && len(out) == 0 {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	return &rds.DescribeDBInstancesOutput{DBInstances: out}, nil
+}
+
+func (f *fakeRDS) WaitUntilDBInstanceAvailableWithContext(ctx aws.Context, input *rds.DescribeDBInstancesInput, opts ...request.WaiterOption) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	instance, ok := f.instances[*input.DBInstanceIdentifier]
+	if !ok {
+		return awserr.New(request.WaiterResourceNotReadyErrorCode, "failed waiting for successful resource state", f.instanceNotFound(*input.DBInstanceIdentifier))
+	}
+	f.settle(instance)
+	return nil
+}
+
+func (f *fakeRDS) DeleteDBInstanceWithContext(ctx aws.Context, input *rds.DeleteDBInstanceInput, opts ...request.Option) (*rds.DeleteDBInstanceOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	instance, ok := f.instances[*input.DBInstanceIdentifier]
+	if !ok {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	if (input.SkipFinalSnapshot == nil || !*input.SkipFinalSnapshot) && input.FinalDBSnapshotIdentifier != nil {
+		f.snapshots[*input.FinalDBSnapshotIdentifier] = &rds.DBSnapshot{
+			DBInstanceIdentifier: instance.DBInstanceIdentifier,
+			DBSnapshotIdentifier: input.FinalDBSnapshotIdentifier,
+			Engine:               instance.Engine,
+			EngineVersion:        instance.EngineVersion,
+			MasterUsername:       instance.MasterUsername,
+			PercentProgress:      aws.Int64(100),
+			SnapshotCreateTime:   aws.Time(time.Now()),
+			Status:               aws.String("available"),
+		}
+	}
+	delete(f.instances, *input.DBInstanceIdentifier)
+	f.removeClusterMember(instance.DBClusterIdentifier, *input.DBInstanceIdentifier)
+	instance.DBInstanceStatus = aws.String("deleting")
+	return &rds.DeleteDBInstanceOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) ModifyDBInstanceWithContext(ctx aws.Context, input *rds.ModifyDBInstanceInput, opts ...request.Option) (*rds.ModifyDBInstanceOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	instance, ok := f.instances[*input.DBInstanceIdentifier]
+	if !ok {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	instance.DBInstanceStatus = aws.String("modifying")
+	if input.NewDBInstanceIdentifier != nil {
+		if _, ok := f.instances[*input.NewDBInstanceIdentifier]; ok {
+			return nil, awserr.New(rds.ErrCodeDBInstanceAlreadyExistsFault, "DB Instance already exists", nil)
+		}
+		delete(f.instances, *input.DBInstanceIdentifier)
+		if instance.DBClusterIdentifier != nil {
+			for _, member := range f.clusters[*instance.DBClusterIdentifier].DBClusterMembers {
+				if *member.DBInstanceIdentifier == *input.DBInstanceIdentifier {
+					member.DBInstanceIdentifier = input.NewDBInstanceIdentifier
+				}
+			}
+		}
+		instance.DBInstanceIdentifier = input.NewDBInstanceIdentifier
+		instance.DBInstanceArn = fakeRDSArn("db", *input.NewDBInstanceIdentifier)
+		instance.DBInstanceStatus = aws.String("renaming")
+		f.instances[*input.NewDBInstanceIdentifier] = instance
+	}
+	if input.EngineVersion != nil && *input.EngineVersion != *instance.EngineVersion {
+		valid := false
+		for _, target := range f.upgradeTargets[*instance.EngineVersion] {
+			if target == *input.EngineVersion {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, awserr.New("InvalidParameterCombination", "Cannot upgrade "+*instance.Engine+" from "+*instance.EngineVersion+" to "+*input.EngineVersion, nil)
+		}
+		instance.EngineVersion = input.EngineVersion
+		instance.DBInstanceStatus = aws.String("upgrading")
+	}
+	if input.DBInstanceClass != nil {
+		instance.DBInstanceClass = input.DBInstanceClass
+	}
+	if input.AllocatedStorage != nil {
+		instance.AllocatedStorage = input.AllocatedStorage
+	}
+	if input.DBParameterGroupName != nil {
+		instance.DBParameterGroups = []*rds.DBParameterGroupStatus{{DBParameterGroupName: input.DBParameterGroupName}}
+	}
+	if input.VpcSecurityGroupIds != nil {
+		instance.VpcSecurityGroups = make([]*rds.VpcSecurityGroupMembership, 0)
+		for _, group := range input.VpcSecurityGroupIds {
+			instance.VpcSecurityGroups = append(instance.VpcSecurityGroups, &rds.VpcSecurityGroupMembership{VpcSecurityGroupId: group, Status: aws.String("active")})
+		}
+	}
+	return &rds.ModifyDBInstanceOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) RebootDBInstanceWithContext(ctx aws.Context, input *rds.RebootDBInstanceInput, opts ...request.Option) (*rds.RebootDBInstanceOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	instance, ok := f.instances[*input.DBInstanceIdentifier]
+	if !ok {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	instance.DBInstanceStatus = aws.String("rebooting")
+	return &rds.RebootDBInstanceOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) DescribeDBEngineVersionsWithContext(ctx aws.Context, input *rds.DescribeDBEngineVersionsInput, opts ...request.Option) (*rds.DescribeDBEngineVersionsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	targets := make([]*rds.UpgradeTarget, 0)
+	for _, target := range f.upgradeTargets[*input.EngineVersion] {
+		targets = append(targets, &rds.UpgradeTarget{
+			Engine:                input.Engine,
+			EngineVersion:         aws.String(target),
+			IsMajorVersionUpgrade: aws.Bool(strings.Split(target, ".")[0] != strings.Split(*input.EngineVersion, ".")[0]),
+		})
+	}
+	return &rds.DescribeDBEngineVersionsOutput{DBEngineVersions: []*rds.DBEngineVersion{{
+		Engine:                 input.Engine,
+		EngineVersion:          input.EngineVersion,
+		DBParameterGroupFamily: fakeParameterGroupFamily(*input.Engine, *input.EngineVersion),
+		ValidUpgradeTarget:     targets,
+	}}}, nil
+}
+
+func (f *fakeRDS) DescribeDBParameterGroupsWithContext(ctx aws.Context, input *rds.DescribeDBParameterGroupsInput, opts ...request.Option) (*rds.DescribeDBParameterGroupsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	return &rds.DescribeDBParameterGroupsOutput{DBParameterGroups: f.parameterGroups}, nil
+}
+
+func (f *fakeRDS) AddTagsToResourceWithContext(ctx aws.Context, input *rds.AddTagsToResourceInput, opts ...request.Option) (*rds.AddTagsToResourceOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.tags[*input.ResourceName] = append(f.tags[*input.ResourceName], input.Tags...)
+	return &rds.AddTagsToResourceOutput{}, nil
+}
+
+func (f *fakeRDS) RemoveTagsFromResourceWithContext(ctx aws.Context, input *rds.RemoveTagsFromResourceInput, opts ...request.Option) (*rds.RemoveTagsFromResourceOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	tags := make([]*rds.Tag, 0)
+	for _, tag := range f.tags[*input.ResourceName] {
+		keep := true
+		for _, key := range input.TagKeys {
+			if *key == *tag.Key {
+				keep = false
+			}
+		}
+		if keep {
+			tags = append(tags, tag)
+		}
+	}
+	f.tags[*input.ResourceName] = tags
+	return &rds.RemoveTagsFromResourceOutput{}, nil
+}
+
+func (f *fakeRDS) CreateDBSnapshotWithContext(ctx aws.Context, input *rds.CreateDBSnapshotInput, opts ...request.Option) (*rds.CreateDBSnapshotOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	instance, ok := f.instances[*input.DBInstanceIdentifier]
+	if !ok {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	snapshot := &rds.DBSnapshot{
+		DBInstanceIdentifier: input.DBInstanceIdentifier,
+		DBSnapshotIdentifier: input.DBSnapshotIdentifier,
+		DBSnapshotArn:        fakeRDSArn("snapshot", *input.DBSnapshotIdentifier),
+		Engine:               instance.Engine,
+		EngineVersion:        instance.EngineVersion,
+		MasterUsername:       instance.MasterUsername,
+		PercentProgress:      aws.Int64(0),
+		SnapshotCreateTime:   aws.Time(time.Now()),
+		SnapshotType:         aws.String("manual"),
+		Status:               aws.String("creating"),
+	}
+	f.snapshots[*input.DBSnapshotIdentifier] = snapshot
+	c := *snapshot
+	snapshot.PercentProgress = aws.Int64(100)
+	snapshot.Status = aws.String("available")
+	return &rds.CreateDBSnapshotOutput{DBSnapshot: &c}, nil
+}
+
+func (f *fakeRDS) DescribeDBSnapshotsWithContext(ctx aws.Context, input *rds.DescribeDBSnapshotsInput, opts ...request.Option) (*rds.DescribeDBSnapshotsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	out := make([]*rds.DBSnapshot, 0)
+	for id, snapshot := range f.snapshots {
+		if input.DBSnapshotIdentifier != nil && *input.DBSnapshotIdentifier != id {
+			continue
+		}
+		if input.DBInstanceIdentifier != nil && *input.DBInstanceIdentifier != *snapshot.DBInstanceIdentifier {
+			continue
+		}
+		if input.SnapshotType != nil && (snapshot.SnapshotType == nil || *input.SnapshotType != *snapshot.SnapshotType) {
+			continue
+		}
+		c := *snapshot
+		out = append(out, &c)
+	}
+	return &rds.DescribeDBSnapshotsOutput{DBSnapshots: out}, nil
+}
+
+func (f *fakeRDS) CopyDBSnapshotWithContext(ctx aws.Context, input *rds.CopyDBSnapshotInput, opts ...request.Option) (*rds.CopyDBSnapshotOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	source, ok := f.peerSnapshot(*input.SourceDBSnapshotIdentifier)
+	if !ok {
+		return nil, awserr.New(rds.ErrCodeDBSnapshotNotFoundFault, "DBSnapshot "+*input.SourceDBSnapshotIdentifier+" not found.", nil)
+	}
+	if _, ok := f.snapshots[*input.TargetDBSnapshotIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBSnapshotAlreadyExistsFault, "DBSnapshot already exists", nil)
+	}
+	snapshot := *source
+	snapshot.DBSnapshotIdentifier = input.TargetDBSnapshotIdentifier
+	snapshot.DBSnapshotArn = fakeRDSArn("snapshot", *input.TargetDBSnapshotIdentifier)
+	snapshot.SnapshotType = aws.String("manual")
+	snapshot.SourceDBSnapshotIdentifier = input.SourceDBSnapshotIdentifier
+	snapshot.SourceRegion = input.SourceRegion
+	snapshot.KmsKeyId = input.KmsKeyId
+	snapshot.Status = aws.String("copying")
+	f.snapshots[*input.TargetDBSnapshotIdentifier] = &snapshot
+	c := snapshot
+	snapshot.Status = aws.String("available")
+	return &rds.CopyDBSnapshotOutput{DBSnapshot: &c}, nil
+}
+
+func (f *fakeRDS) DeleteDBSnapshotWithContext(ctx aws.Context, input *rds.DeleteDBSnapshotInput, opts ...request.Option) (*rds.DeleteDBSnapshotOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	snapshot, ok := f.snapshots[*input.DBSnapshotIdentifier]
+	if !ok {
+		return nil, awserr.New(rds.ErrCodeDBSnapshotNotFoundFault, "DBSnapshot "+*input.DBSnapshotIdentifier+" not found.", nil)
+	}
+	delete(f.snapshots, *input.DBSnapshotIdentifier)
+	return &rds.DeleteDBSnapshotOutput{DBSnapshot: snapshot}, nil
+}
+
+func (f *fakeRDS) PromoteReadReplicaWithContext(ctx aws.Context, input *rds.PromoteReadReplicaInput, opts ...request.Option) (*rds.PromoteReadReplicaOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	instance, ok := f.instances[*input.DBInstanceIdentifier]
+	if !ok {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	if instance.ReadReplicaSourceDBInstanceIdentifier == nil {
+		return nil, awserr.New(rds.ErrCodeInvalidDBInstanceStateFault, "DBInstance "+*input.DBInstanceIdentifier+" is not a read replica.", nil)
+	}
+	instance.ReadReplicaSourceDBInstanceIdentifier = nil
+	instance.BackupRetentionPeriod = input.BackupRetentionPeriod
+	instance.DBInstanceStatus = aws.String("modifying")
+	return &rds.PromoteReadReplicaOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) RestoreDBInstanceFromDBSnapshotWithContext(ctx aws.Context, input *rds.RestoreDBInstanceFromDBSnapshotInput, opts ...request.Option) (*rds.RestoreDBInstanceFromDBSnapshotOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	snapshot, ok := f.snapshots[*input.DBSnapshotIdentifier]
+	if !ok {
+		return nil, awserr.New(rds.ErrCodeDBSnapshotNotFoundFault, "DBSnapshot "+*input.DBSnapshotIdentifier+" not found.", nil)
+	}
+	if _, ok := f.instances[*input.DBInstanceIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBInstanceAlreadyExistsFault, "DB Instance already exists", nil)
+	}
+	instance := &rds.DBInstance{
+		DBInstanceIdentifier: input.DBInstanceIdentifier,
+		DBName:               snapshot.DBInstanceIdentifier,
+		MasterUsername:       snapshot.MasterUsername,
+		Engine:               snapshot.Engine,
+		EngineVersion:        snapshot.EngineVersion,
+		DBInstanceClass:      input.DBInstanceClass,
+		AllocatedStorage:     snapshot.AllocatedStorage,
+		VpcSecurityGroups:    []*rds.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("default"), Status: aws.String("active")}},
+	}
+	f.addInstance(instance)
+	f.restoredFrom[*input.DBInstanceIdentifier] = *input.DBSnapshotIdentifier
+	f.tags[*instance.DBInstanceArn] = input.Tags
+	return &rds.RestoreDBInstanceFromDBSnapshotOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) RestoreDBInstanceToPointInTimeWithContext(ctx aws.Context, input *rds.RestoreDBInstanceToPointInTimeInput, opts ...request.Option) (*rds.RestoreDBInstanceToPointInTimeOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	source, ok := f.instances[*input.SourceDBInstanceIdentifier]
+	if !ok {
+		return nil, f.instanceNotFound(*input.SourceDBInstanceIdentifier)
+	}
+	if _, ok := f.instances[*input.TargetDBInstanceIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBInstanceAlreadyExistsFault, "DB Instance already exists", nil)
+	}
+	restoreTime := input.RestoreTime
+	if aws.BoolValue(input.UseLatestRestorableTime) {
+		restoreTime = source.LatestRestorableTime
+	}
+	if restoreTime == nil || source.LatestRestorableTime == nil || restoreTime.After(*source.LatestRestorableTime) {
+		return nil, awserr.New(rds.ErrCodeInvalidRestoreFault, "The restore time is invalid.", nil)
+	}
+	class := source.DBInstanceClass
+	if input.DBInstanceClass != nil {
+		class = input.DBInstanceClass
+	}
+	instance := &rds.DBInstance{
+		DBInstanceIdentifier:  input.TargetDBInstanceIdentifier,
+		DBName:                source.DBName,
+		DBInstanceClass:       class,
+		AllocatedStorage:      source.AllocatedStorage,
+		MasterUsername:        source.MasterUsername,
+		Engine:                source.Engine,
+		EngineVersion:         source.EngineVersion,
+		BackupRetentionPeriod: source.BackupRetentionPeriod,
+		VpcSecurityGroups:     []*rds.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("default"), Status: aws.String("active")}},
+	}
+	f.addInstance(instance)
+	f.restoredFrom[*input.TargetDBInstanceIdentifier] = *input.SourceDBInstanceIdentifier
+	f.restoredAt[*input.TargetDBInstanceIdentifier] = *restoreTime
+	f.tags[*instance.DBInstanceArn] = input.Tags
+	return &rds.RestoreDBInstanceToPointInTimeOutput{DBInstance: f.copyInstance(instance)}, nil
+}
+
+func (f *fakeRDS) DescribeDBLogFilesWithContext(ctx aws.Context, input *rds.DescribeDBLogFilesInput, opts ...request.Option) (*rds.DescribeDBLogFilesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[*input.DBInstanceIdentifier]; !ok {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	return &rds.DescribeDBLogFilesOutput{DescribeDBLogFiles: []*rds.DescribeDBLogFilesDetails{{
+		LogFileName: aws.String("error/postgresql.log"),
+		LastWritten: aws.Int64(time.Now().Unix() * 1000),
+		Size:        aws.Int64(11),
+	}}}, nil
+}
+
+func (f *fakeRDS) DownloadDBLogFilePortionWithContext(ctx aws.Context, input *rds.DownloadDBLogFilePortionInput, opts ...request.Option) (*rds.DownloadDBLogFilePortionOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[*input.DBInstanceIdentifier]; !ok {
+		return nil, f.instanceNotFound(*input.DBInstanceIdentifier)
+	}
+	return &rds.DownloadDBLogFilePortionOutput{LogFileData: aws.String("LOG: ready\n")}, nil
+}
+
+func (f *fakeRDS) CreateDBClusterWithContext(ctx aws.Context, input *rds.CreateDBClusterInput, opts ...request.Option) (*rds.CreateDBClusterOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.clusters[*input.DBClusterIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBClusterAlreadyExistsFault, "DB Cluster already exists", nil)
+	}
+	cluster := &rds.DBCluster{
+		DBClusterIdentifier:    input.DBClusterIdentifier,
+		DBClusterArn:           fakeRDSArn("cluster", *input.DBClusterIdentifier),
+		DatabaseName:           input.DatabaseName,
+		MasterUsername:         input.MasterUsername,
+		Engine:                 input.Engine,
+		EngineVersion:          input.EngineVersion,
+		Status:                 aws.String("creating"),
+		DBClusterMembers:       make([]*rds.DBClusterMember, 0),
+		VpcSecurityGroups:      make([]*rds.VpcSecurityGroupMembership, 0),
+		EarliestRestorableTime: aws.Time(time.Now()),
+	}
+	for _, group := range input.VpcSecurityGroupIds {
+		cluster.VpcSecurityGroups = append(cluster.VpcSecurityGroups, &rds.VpcSecurityGroupMembership{VpcSecurityGroupId: group, Status: aws.String("active")})
+	}
+	f.setClusterEndpoints(cluster)
+	f.clusters[*input.DBClusterIdentifier] = cluster
+	c := *cluster
+	return &rds.CreateDBClusterOutput{DBCluster: &c}, nil
+}
+
+func (f *fakeRDS) DescribeDBClustersWithContext(ctx aws.Context, input *rds.DescribeDBClustersInput, opts ...request.Option) (*rds.DescribeDBClustersOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	out := make([]*rds.DBCluster, 0)
+	for id, cluster := range f.clusters {
+		if input.DBClusterIdentifier != nil && *input.DBClusterIdentifier != id {
+			continue
+		}
+		cluster.LatestRestorableTime = aws.Time(time.Now())
+		c := *cluster
+		c.DBClusterMembers = make([]*rds.DBClusterMember, 0)
+		for _, member := range cluster.DBClusterMembers {
+			m := *member
+			c.DBClusterMembers = append(c.DBClusterMembers, &m)
+		}
+		out = append(out, &c)
+		cluster.Status = aws.String("available")
+	}
+	if input.DBClusterIdentifier != nil && len(out) == 0 {
+		return nil, f.clusterNotFound(*input.DBClusterIdentifier)
+	}
+	return &rds.DescribeDBClustersOutput{DBClusters: out}, nil
+}
+
+func (f *fakeRDS) ModifyDBClusterWithContext(ctx aws.Context, input *rds.ModifyDBClusterInput, opts ...request.Option) (*rds.ModifyDBClusterOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	cluster, ok := f.clusters[*input.DBClusterIdentifier]
+	if !ok {
+		return nil, f.clusterNotFound(*input.DBClusterIdentifier)
+	}
+	cluster.Status = aws.String("modifying")
+	if input.NewDBClusterIdentifier != nil {
+		if _, ok := f.clusters[*input.NewDBClusterIdentifier]; ok {
+			return nil, awserr.New(rds.ErrCodeDBClusterAlreadyExistsFault, "DB Cluster already exists", nil)
+		}
+		delete(f.clusters, *input.DBClusterIdentifier)
+		cluster.DBClusterIdentifier = input.NewDBClusterIdentifier
+		cluster.DBClusterArn = fakeRDSArn("cluster", *input.NewDBClusterIdentifier)
+		cluster.Status = aws.String("renaming")
+		f.setClusterEndpoints(cluster)
+		f.clusters[*input.NewDBClusterIdentifier] = cluster
+		for _, member := range cluster.DBClusterMembers {
+			if instance, ok := f.instances[*member.DBInstanceIdentifier]; ok {
+				instance.DBClusterIdentifier = input.NewDBClusterIdentifier
+			}
+		}
+	}
+	if input.EngineVersion != nil && *input.EngineVersion != *cluster.EngineVersion {
+		cluster.EngineVersion = input.EngineVersion
+		cluster.Status = aws.String("upgrading")
+		for _, member := range cluster.DBClusterMembers {
+			if instance, ok := f.instances[*member.DBInstanceIdentifier]; ok {
+				instance.EngineVersion = input.EngineVersion
+				instance.DBInstanceStatus = aws.String("upgrading")
+			}
+		}
+	}
+	c := *cluster
+	return &rds.ModifyDBClusterOutput{DBCluster: &c}, nil
+}
+
+func (f *fakeRDS) DeleteDBClusterWithContext(ctx aws.Context, input *rds.DeleteDBClusterInput, opts ...request.Option) (*rds.DeleteDBClusterOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	cluster, ok := f.clusters[*input.DBClusterIdentifier]
+	if !ok {
+		return nil, f.clusterNotFound(*input.DBClusterIdentifier)
+	}
+	if len(cluster.DBClusterMembers) != 0 {
+		return nil, awserr.New(rds.ErrCodeInvalidDBClusterStateFault, "Cluster cannot be deleted, it still contains DB instances in non-deleting state.", nil)
+	}
+	if (input.SkipFinalSnapshot == nil || !*input.SkipFinalSnapshot) && input.FinalDBSnapshotIdentifier != nil {
+		f.clusterSnapshots[*input.FinalDBSnapshotIdentifier] = &rds.DBClusterSnapshot{
+			DBClusterIdentifier:         cluster.DBClusterIdentifier,
+			DBClusterSnapshotIdentifier: input.FinalDBSnapshotIdentifier,
+			MasterUsername:              cluster.MasterUsername,
+			Engine:                      cluster.Engine,
+			EngineVersion:               cluster.EngineVersion,
+			PercentProgress:             aws.Int64(100),
+			SnapshotCreateTime:          aws.Time(time.Now()),
+			Status:                      aws.String("available"),
+		}
+		f.snapshotDBNames[*input.FinalDBSnapshotIdentifier] = cluster.DatabaseName
+	}
+	delete(f.clusters, *input.DBClusterIdentifier)
+	c := *cluster
+	c.Status = aws.String("deleting")
+	return &rds.DeleteDBClusterOutput{DBCluster: &c}, nil
+}
+
+func (f *fakeRDS) CreateDBClusterSnapshotWithContext(ctx aws.Context, input *rds.CreateDBClusterSnapshotInput, opts ...request.Option) (*rds.CreateDBClusterSnapshotOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	cluster, ok := f.clusters[*input.DBClusterIdentifier]
+	if !ok {
+		return nil, f.clusterNotFound(*input.DBClusterIdentifier)
+	}
+	snapshot := &rds.DBClusterSnapshot{
+		DBClusterIdentifier:         input.DBClusterIdentifier,
+		DBClusterSnapshotIdentifier: input.DBClusterSnapshotIdentifier,
+		MasterUsername:              cluster.MasterUsername,
+		Engine:                      cluster.Engine,
+		EngineVersion:               cluster.EngineVersion,
+		PercentProgress:             aws.Int64(100),
+		SnapshotCreateTime:          aws.Time(time.Now()),
+		Status:                      aws.String("available"),
+	}
+	f.clusterSnapshots[*input.DBClusterSnapshotIdentifier] = snapshot
+	f.snapshotDBNames[*input.DBClusterSnapshotIdentifier] = cluster.DatabaseName
+	c := *snapshot
+	return &rds.CreateDBClusterSnapshotOutput{DBClusterSnapshot: &c}, nil
+}
+
+func (f *fakeRDS) DescribeDBClusterSnapshotsWithContext(ctx aws.Context, input *rds.DescribeDBClusterSnapshotsInput, opts ...request.Option) (*rds.DescribeDBClusterSnapshotsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	out := make([]*rds.DBClusterSnapshot, 0)
+	for id, snapshot := range f.clusterSnapshots {
+		if input.DBClusterSnapshotIdentifier != nil && *input.DBClusterSnapshotIdentifier != id {
+			continue
+		}
+		if input.DBClusterIdentifier != nil && *input.DBClusterIdentifier != *snapshot.DBClusterIdentifier {
+			continue
+		}
+		c := *snapshot
+		out = append(out, &c)
+	}
+	return &rds.DescribeDBClusterSnapshotsOutput{DBClusterSnapshots: out}, nil
+}
+
+func (f *fakeRDS) RestoreDBClusterFromSnapshotWithContext(ctx aws.Context, input *rds.RestoreDBClusterFromSnapshotInput, opts ...request.Option) (*rds.RestoreDBClusterFromSnapshotOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	snapshot, ok := f.clusterSnapshots[*input.SnapshotIdentifier]
+	if !ok {
+		return nil, awserr.New(rds.ErrCodeDBClusterSnapshotNotFoundFault, "DBClusterSnapshot "+*input.SnapshotIdentifier+" not found.", nil)
+	}
+	if _, ok := f.clusters[*input.DBClusterIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBClusterAlreadyExistsFault, "DB Cluster already exists", nil)
+	}
+	cluster := &rds.DBCluster{
+		DBClusterIdentifier: input.DBClusterIdentifier,
+		DBClusterArn:        fakeRDSArn("cluster", *input.DBClusterIdentifier),
+		DatabaseName:        f.snapshotDBNames[*input.SnapshotIdentifier],
+		MasterUsername:      snapshot.MasterUsername,
+		Engine:              snapshot.Engine,
+		EngineVersion:       snapshot.EngineVersion,
+		Status:              aws.String("creating"),
+		DBClusterMembers:    make([]*rds.DBClusterMember, 0),
+		VpcSecurityGroups:   make([]*rds.VpcSecurityGroupMembership, 0),
+	}
+	for _, group := range input.VpcSecurityGroupIds {
+		cluster.VpcSecurityGroups = append(cluster.VpcSecurityGroups, &rds.VpcSecurityGroupMembership{VpcSecurityGroupId: group, Status: aws.String("active")})
+	}
+	f.setClusterEndpoints(cluster)
+	f.clusters[*input.DBClusterIdentifier] = cluster
+	f.restoredFrom[*input.DBClusterIdentifier] = *input.SnapshotIdentifier
+	f.tags[*cluster.DBClusterArn] = input.Tags
+	c := *cluster
+	return &rds.RestoreDBClusterFromSnapshotOutput{DBCluster: &c}, nil
+}
+
+func (f *fakeRDS) RestoreDBClusterToPointInTimeWithContext(ctx aws.Context, input *rds.RestoreDBClusterToPointInTimeInput, opts ...request.Option) (*rds.RestoreDBClusterToPointInTimeOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	source, ok := f.clusters[*input.SourceDBClusterIdentifier]
+	if !ok {
+		return nil, f.clusterNotFound(*input.SourceDBClusterIdentifier)
+	}
+	if _, ok := f.clusters[*input.DBClusterIdentifier]; ok {
+		return nil, awserr.New(rds.ErrCodeDBClusterAlreadyExistsFault, "DB Cluster already exists", nil)
+	}
+	restoreTime := input.RestoreToTime
+	if aws.BoolValue(input.UseLatestRestorableTime) {
+		restoreTime = aws.Time(time.Now())
+	}
+	if restoreTime == nil || source.EarliestRestorableTime == nil || restoreTime.Before(*source.EarliestRestorableTime) {
+		return nil, awserr.New(rds.ErrCodeInvalidRestoreFault, "The restore time is invalid.", nil)
+	}
+	cluster := &rds.DBCluster{
+		DBClusterIdentifier:    input.DBClusterIdentifier,
+		DBClusterArn:           fakeRDSArn("cluster", *input.DBClusterIdentifier),
+		DatabaseName:           source.DatabaseName,
+		MasterUsername:         source.MasterUsername,
+		Engine:                 source.Engine,
+		EngineVersion:          source.EngineVersion,
+		Status:                 aws.String("creating"),
+		DBClusterMembers:       make([]*rds.DBClusterMember, 0),
+		VpcSecurityGroups:      make([]*rds.VpcSecurityGroupMembership, 0),
+		EarliestRestorableTime: aws.Time(time.Now()),
+	}
+	for _, group := range input.VpcSecurityGroupIds {
+		cluster.VpcSecurityGroups = append(cluster.VpcSecurityGroups, &rds.VpcSecurityGroupMembership{VpcSecurityGroupId: group, Status: aws.String("active")})
+	}
+	f.setClusterEndpoints(cluster)
+	f.clusters[*input.DBClusterIdentifier] = cluster
+	f.restoredFrom[*input.DBClusterIdentifier] = *input.SourceDBClusterIdentifier
+	f.restoredAt[*input.DBClusterIdentifier] = *restoreTime
+	f.tags[*cluster.DBClusterArn] = input.Tags
+	c := *cluster
+	return &rds.RestoreDBClusterToPointInTimeOutput{DBCluster: &c}, nil
+}
+
+// fakeAutoScaling keeps the scalable targets and policies registered with application auto scaling,
+// it doesn't scale anything.
+type fakeAutoScaling struct {
+	applicationautoscalingiface.ApplicationAutoScalingAPI
+	sync.Mutex
+	targets  map[string]*applicationautoscaling.RegisterScalableTargetInput
+	policies map[string]*applicationautoscaling.PutScalingPolicyInput
+}
+
+func newFakeAutoScaling() *fakeAutoScaling {
+	return &fakeAutoScaling{
+		targets:  make(map[string]*applicationautoscaling.RegisterScalableTargetInput),
+		policies: make(map[string]*applicationautoscaling.PutScalingPolicyInput),
+	}
+}
+
+func (f *fakeAutoScaling) RegisterScalableTargetWithContext(ctx aws.Context, input *applicationautoscaling.RegisterScalableTargetInput, opts ...request.Option) (*applicationautoscaling.RegisterScalableTargetOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.targets[*input.ResourceId] = input
+	return &applicationautoscaling.RegisterScalableTargetOutput{}, nil
+}
+
+func (f *fakeAutoScaling) PutScalingPolicyWithContext(ctx aws.Context, input *applicationautoscaling.PutScalingPolicyInput, opts ...request.Option) (*applicationautoscaling.PutScalingPolicyOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.targets[*input.ResourceId]; !ok {
+		return nil, awserr.New(applicationautoscaling.ErrCodeObjectNotFoundException, "No scalable target registered for "+*input.ResourceId, nil)
+	}
+	f.policies[*input.ResourceId] = input
+	return &applicationautoscaling.PutScalingPolicyOutput{PolicyARN: aws.String("arn:aws:autoscaling:us-west-2:000000000000:scalingPolicy:" + *input.PolicyName)}, nil
+}
+
+func (f *fakeAutoScaling) DescribeScalableTargetsWithContext(ctx aws.Context, input *applicationautoscaling.DescribeScalableTargetsInput, opts ...request.Option) (*applicationautoscaling.DescribeScalableTargetsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	out := make([]*applicationautoscaling.ScalableTarget, 0)
+	for _, id := range input.ResourceIds {
+		if target, ok := f.targets[*id]; ok {
+			out = append(out, &applicationautoscaling.ScalableTarget{
+				ResourceId:        target.ResourceId,
+				ServiceNamespace:  target.ServiceNamespace,
+				ScalableDimension: target.ScalableDimension,
+				MinCapacity:       target.MinCapacity,
+				MaxCapacity:       target.MaxCapacity,
+			})
+		}
+	}
+	return &applicationautoscaling.DescribeScalableTargetsOutput{ScalableTargets: out}, nil
+}
+
+func (f *fakeAutoScaling) DeregisterScalableTargetWithContext(ctx aws.Context, input *applicationautoscaling.DeregisterScalableTargetInput, opts ...request.Option) (*applicationautoscaling.DeregisterScalableTargetOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.targets[*input.ResourceId]; !ok {
+		return nil, awserr.New(applicationautoscaling.ErrCodeObjectNotFoundException, "No scalable target registered for "+*input.ResourceId, nil)
+	}
+	delete(f.targets, *input.ResourceId)
+	delete(f.policies, *input.ResourceId)
+	return &applicationautoscaling.DeregisterScalableTargetOutput{}, nil
+}
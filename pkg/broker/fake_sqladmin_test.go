@@ -0,0 +1,282 @@
+package broker
+
+import (
+	"context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/sqladmin/v1beta4"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeSQLAdmin is a stateful, in memory stand in for the Cloud SQL admin api. Instances move from
+// PENDING_CREATE (or MAINTENANCE after an update or restart) to RUNNABLE after they've been read
+// once, and are only given an ip address once they're runnable, the same as Cloud SQL. Backup runs
+// likewise finish after they've been read once, and operations are done once they've been read.
+type fakeSQLAdmin struct {
+	sync.Mutex
+	instances    map[string]*sqladmin.DatabaseInstance
+	users        map[string][]*sqladmin.User
+	backupRuns   map[string][]*sqladmin.BackupRun
+	restoredFrom map[string]int64
+	clonedAt     map[string]int64
+	pending      map[string]*sqladmin.Operation
+	operations   int
+}
+
+func newFakeSQLAdmin() *fakeSQLAdmin {
+	return &fakeSQLAdmin{
+		instances:    make(map[string]*sqladmin.DatabaseInstance),
+		users:        make(map[string][]*sqladmin.User),
+		backupRuns:   make(map[string][]*sqladmin.BackupRun),
+		restoredFrom: make(map[string]int64),
+		clonedAt:     make(map[string]int64),
+		pending:      make(map[string]*sqladmin.Operation),
+	}
+}
+
+func (f *fakeSQLAdmin) notFound(instance string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: "The Cloud SQL instance " + instance + " does not exist."}
+}
+
+func (f *fakeSQLAdmin) operation(project string, instance string, kind string) *sqladmin.Operation {
+	f.operations++
+	op := &sqladmin.Operation{
+		Name:          "operation-" + strconv.Itoa(f.operations),
+		OperationType: kind,
+		Status:        "PENDING",
+		TargetId:      instance,
+		TargetProject: project,
+	}
+	f.pending[op.Name] = op
+	c := *op
+	return &c
+}
+
+func (f *fakeSQLAdmin) GetInstance(ctx context.Context, project string, instance string) (*sqladmin.DatabaseInstance, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db, ok := f.instances[instance]
+	if !ok {
+		return nil, f.notFound(instance)
+	}
+	c := *db
+	if db.State != "RUNNABLE" {
+		db.State = "RUNNABLE"
+		db.IpAddresses = []*sqladmin.IpMapping{{IpAddress: "10.0.0." + strconv.Itoa(len(f.instances)), Type: "PRIMARY"}}
+	}
+	return &c, nil
+}
+
+func (f *fakeSQLAdmin) InsertInstance(ctx context.Context, project string, instance *sqladmin.DatabaseInstance) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[instance.Name]; ok {
+		return nil, &googleapi.Error{Code: http.StatusConflict, Message: "The Cloud SQL instance already exists."}
+	}
+	db := *instance
+	db.State = "PENDING_CREATE"
+	db.Project = project
+	db.IpAddresses = nil
+	f.instances[instance.Name] = &db
+	return f.operation(project, instance.Name, "CREATE"), nil
+}
+
+func (f *fakeSQLAdmin) UpdateInstance(ctx context.Context, project string, instance string, body *sqladmin.DatabaseInstance) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	db, ok := f.instances[instance]
+	if !ok {
+		return nil, f.notFound(instance)
+	}
+	db.Settings = body.Settings
+	db.State = "MAINTENANCE"
+	return f.operation(project, instance, "UPDATE"), nil
+}
+
+func (f *fakeSQLAdmin) DeleteInstance(ctx context.Context, project string, instance string) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[instance]; !ok {
+		return nil, f.notFound(instance)
+	}
+	delete(f.instances, instance)
+	delete(f.users, instance)
+	return f.operation(project, instance, "DELETE"), nil
+}
+
+func (f *fakeSQLAdmin) RestartInstance(ctx context.Context, project string, instance string) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	db, ok := f.instances[instance]
+	if !ok {
+		return nil, f.notFound(instance)
+	}
+	db.State = "MAINTENANCE"
+	return f.operation(project, instance, "RESTART"), nil
+}
+
+func (f *fakeSQLAdmin) InsertUser(ctx context.Context, project string, instance string, user *sqladmin.User) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[instance]; !ok {
+		return nil, f.notFound(instance)
+	}
+	u := *user
+	f.users[instance] = append(f.users[instance], &u)
+	return f.operation(project, instance, "CREATE_USER"), nil
+}
+
+func (f *fakeSQLAdmin) UpdateUser(ctx context.Context, project string, instance string, user *sqladmin.User) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[instance]; !ok {
+		return nil, f.notFound(instance)
+	}
+	for i, existing := range f.users[instance] {
+		if existing.Name == user.Name && existing.Host == user.Host {
+			u := *user
+			f.users[instance][i] = &u
+			return f.operation(project, instance, "UPDATE_USER"), nil
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "The user " + user.Name + " does not exist."}
+}
+
+func (f *fakeSQLAdmin) GetOperation(ctx context.Context, project string, operation string) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	op, ok := f.pending[operation]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "The operation " + operation + " does not exist."}
+	}
+	op.Status = "DONE"
+	c := *op
+	return &c, nil
+}
+
+func (f *fakeSQLAdmin) ListBackupRuns(ctx context.Context, project string, instance string) ([]*sqladmin.BackupRun, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[instance]; !ok {
+		return nil, f.notFound(instance)
+	}
+	runs := make([]*sqladmin.BackupRun, 0)
+	for _, run := range f.backupRuns[instance] {
+		c := *run
+		runs = append(runs, &c)
+	}
+	return runs, nil
+}
+
+func (f *fakeSQLAdmin) GetBackupRun(ctx context.Context, project string, instance string, id int64) (*sqladmin.BackupRun, error) {
+	f.Lock()
+	defer f.Unlock()
+	for _, run := range f.backupRuns[instance] {
+		if run.Id == id {
+			c := *run
+			if run.Status == "RUNNING" {
+				run.Status = "SUCCESSFUL"
+				run.EndTime = run.StartTime
+			}
+			return &c, nil
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "The backup run does not exist."}
+}
+
+func (f *fakeSQLAdmin) InsertBackupRun(ctx context.Context, project string, instance string, run *sqladmin.BackupRun) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[instance]; !ok {
+		return nil, f.notFound(instance)
+	}
+	r := *run
+	r.Id = int64(f.operations + 1000)
+	r.Status = "RUNNING"
+	r.Type = "ON_DEMAND"
+	r.StartTime = time.Now().UTC().Format(time.RFC3339)
+	f.backupRuns[instance] = append(f.backupRuns[instance], &r)
+	return f.operation(project, instance, "BACKUP_VOLUME"), nil
+}
+
+func (f *fakeSQLAdmin) RestoreBackup(ctx context.Context, project string, instance string, request *sqladmin.InstancesRestoreBackupRequest) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[request.RestoreBackupContext.InstanceId]; !ok {
+		return nil, f.notFound(request.RestoreBackupContext.InstanceId)
+	}
+	db, ok := f.instances[instance]
+	if !ok {
+		return nil, f.notFound(instance)
+	}
+	db.State = "MAINTENANCE"
+	f.restoredFrom[instance] = request.RestoreBackupContext.BackupRunId
+	// the users are restored along with the data.
+	if source := request.RestoreBackupContext.InstanceId; source != instance {
+		f.users[instance] = append([]*sqladmin.User{}, f.users[source]...)
+	}
+	return f.operation(project, instance, "RESTORE_VOLUME"), nil
+}
+
+func (f *fakeSQLAdmin) CloneInstance(ctx context.Context, project string, instance string, request *sqladmin.InstancesCloneRequest) (*sqladmin.Operation, error) {
+	f.Lock()
+	defer f.Unlock()
+	db, ok := f.instances[instance]
+	if !ok {
+		return nil, f.notFound(instance)
+	}
+	name := request.CloneContext.DestinationInstanceName
+	if _, ok := f.instances[name]; ok {
+		return nil, &googleapi.Error{Code: http.StatusConflict, Message: "The Cloud SQL instance already exists."}
+	}
+	clone := *db
+	clone.Name = name
+	clone.State = "RUNNABLE"
+	clone.IpAddresses = []*sqladmin.IpMapping{{IpAddress: "10.0.0." + strconv.Itoa(len(f.instances)+1), Type: "PRIMARY"}}
+	f.instances[name] = &clone
+	f.users[name] = append([]*sqladmin.User{}, f.users[instance]...)
+	f.clonedAt[name] = request.CloneContext.PitrTimestampMs
+	return f.operation(project, name, "CLONE"), nil
+}
+
+// fakeCloudLogging holds log entries in memory, filters are only matched on the log name and the
+// instance the entries are from.
+type fakeCloudLogging struct {
+	entries []*logging.LogEntry
+}
+
+func (f *fakeCloudLogging) add(project string, instance string, log string, at time.Time, text string) {
+	f.entries = append(f.entries, &logging.LogEntry{
+		LogName:     "projects/" + project + "/logs/" + strings.Replace(log, "/", "%2F", -1),
+		Resource:    &logging.MonitoredResource{Type: "cloudsql_database", Labels: map[string]string{"database_id": project + ":" + instance}},
+		Timestamp:   at.UTC().Format(time.RFC3339Nano),
+		TextPayload: text,
+	})
+}
+
+func (f *fakeCloudLogging) ListLogEntries(ctx context.Context, project string, filter string, orderBy string, limit int) ([]*logging.LogEntry, error) {
+	entries := make([]*logging.LogEntry, 0)
+	for _, entry := range f.entries {
+		if strings.Contains(filter, "logName=\""+entry.LogName+"\"") && strings.Contains(filter, "database_id=\""+entry.Resource.Labels["database_id"]+"\"") {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if orderBy == "timestamp desc" {
+			return entries[i].Timestamp > entries[j].Timestamp
+		}
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
@@ -0,0 +1,39 @@
+package broker
+
+import (
+	"context"
+	"errors"
+)
+
+// Forking from the newest backup of a database rather than a backup by its id.
+const LatestBackup = "latest"
+
+// A Forker is a provider that can create a database as a copy of another database it has. Fork
+// starts creating the new database and returns straight away, the worker calls PerformFork once
+// the new database is available to finish the copy, such as loading the data or giving the copy
+// its own credentials. The backup is the id of a backup of the database being forked from, the
+// LatestBackup for its newest backup, or empty to fork the database as it is now.
+type Forker interface {
+	Fork(ctx context.Context, Id string, plan *ProviderPlan, Owner string, from *DbInstance, backup string) (*DbInstance, error)
+	PerformFork(ctx context.Context, dbInstance *DbInstance, from *DbInstance, backup string, Owner string) (*DbInstance, error)
+}
+
+// The database to fork from and the backup to fork in the parameters of a provision request, both
+// are empty when the request isn't a fork.
+func forkParameters(parameters map[string]interface{}) (string, string, error) {
+	var from, backup string
+	if value, ok := parameters["fork_from"]; ok && value != nil {
+		if from, ok = value.(string); !ok || from == "" {
+			return "", "", errors.New("The fork_from parameter must be the id of a database.")
+		}
+	}
+	if value, ok := parameters["backup"]; ok && value != nil {
+		if backup, ok = value.(string); !ok || backup == "" {
+			return "", "", errors.New("The backup parameter must be the id of a backup or " + LatestBackup + ".")
+		}
+		if from == "" {
+			return "", "", errors.New("The backup parameter can only be used with fork_from.")
+		}
+	}
+	return from, backup, nil
+}
@@ -0,0 +1,73 @@
+package broker
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestForks(t *testing.T) {
+	Convey("Given the parameters of a provision request", t, func() {
+		Convey("Ensure requests without fork_from aren't forks.", func() {
+			from, backup, err := forkParameters(nil)
+			So(err, ShouldBeNil)
+			So(from, ShouldEqual, "")
+			So(backup, ShouldEqual, "")
+			from, backup, err = forkParameters(map[string]interface{}{"other": "value"})
+			So(err, ShouldBeNil)
+			So(from, ShouldEqual, "")
+		})
+
+		Convey("Ensure the database and backup to fork are read.", func() {
+			from, backup, err := forkParameters(map[string]interface{}{"fork_from": "db-id"})
+			So(err, ShouldBeNil)
+			So(from, ShouldEqual, "db-id")
+			So(backup, ShouldEqual, "")
+			from, backup, err = forkParameters(map[string]interface{}{"fork_from": "db-id", "backup": LatestBackup})
+			So(err, ShouldBeNil)
+			So(from, ShouldEqual, "db-id")
+			So(backup, ShouldEqual, LatestBackup)
+		})
+
+		Convey("Ensure invalid parameters are rejected.", func() {
+			_, _, err := forkParameters(map[string]interface{}{"fork_from": 42})
+			So(err, ShouldNotBeNil)
+			_, _, err = forkParameters(map[string]interface{}{"fork_from": ""})
+			So(err, ShouldNotBeNil)
+			_, _, err = forkParameters(map[string]interface{}{"fork_from": "db-id", "backup": true})
+			So(err, ShouldNotBeNil)
+			_, _, err = forkParameters(map[string]interface{}{"backup": LatestBackup})
+			So(err.Error(), ShouldEqual, "The backup parameter can only be used with fork_from.")
+		})
+	})
+
+	Convey("Given logical backups of a database", t, func() {
+		records := newFakeLogicalBackupStore()
+		SetLogicalBackupStore(records)
+		defer SetLogicalBackupStore(nil)
+		dbInstance := &DbInstance{Name: "test-db"}
+		now := time.Now()
+		records.AddLogicalBackup(LogicalBackup{Id: "old", Database: "test-db", Status: "available", Created: now.Add(-time.Hour)})
+		records.AddLogicalBackup(LogicalBackup{Id: "new", Database: "test-db", Status: "available", Created: now})
+		records.AddLogicalBackup(LogicalBackup{Id: "running", Database: "test-db", Status: "creating", Created: now.Add(time.Hour)})
+		records.AddLogicalBackup(LogicalBackup{Id: "other", Database: "other-db", Status: "available", Created: now})
+
+		Convey("Ensure the latest available backup is forked.", func() {
+			Id, err := forkLogicalBackup(dbInstance, LatestBackup)
+			So(err, ShouldBeNil)
+			So(Id, ShouldEqual, "new")
+		})
+
+		Convey("Ensure only available backups of the database can be forked.", func() {
+			Id, err := forkLogicalBackup(dbInstance, "old")
+			So(err, ShouldBeNil)
+			So(Id, ShouldEqual, "old")
+			_, err = forkLogicalBackup(dbInstance, "running")
+			So(err.Error(), ShouldEqual, "The backup running of test-db could not be found or is not available.")
+			_, err = forkLogicalBackup(dbInstance, "other")
+			So(err, ShouldNotBeNil)
+			_, err = forkLogicalBackup(&DbInstance{Name: "empty-db"}, LatestBackup)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
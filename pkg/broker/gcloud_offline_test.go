@@ -0,0 +1,284 @@
+package broker
+
+import (
+	"context"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGCloudInstanceProviderOffline(t *testing.T) {
+	ctx := context.Background()
+	plan := &ProviderPlan{
+		ID:                     "gcloud-test-plan",
+		Provider:               GCloudInstance,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"tier":"db-f1-micro"}`,
+		basePlan: osb.Plan{
+			Metadata: map[string]interface{}{
+				"engine": map[string]string{"type": "postgres", "version": "9.6"},
+			},
+		},
+	}
+
+	Convey("Given a gcloud instance provider backed by a fake sql admin api", t, func() {
+		svc := newFakeSQLAdmin()
+		provider := NewGCloudInstanceProviderWithClient("test", svc, "project", "us-west1")
+		provider.instanceCache = NewInstanceCache(0)
+		provider.pollInterval = 0
+
+		dbInstance, err := provider.Provision(ctx, "instance-id", plan, "Owner")
+		So(err, ShouldBeNil)
+		So(dbInstance.Status, ShouldEqual, "PENDING_CREATE")
+		So(dbInstance.Ready, ShouldEqual, false)
+		So(dbInstance.Engine, ShouldEqual, "postgres")
+		So(dbInstance.EngineVersion, ShouldEqual, "9.6")
+		So(strings.HasPrefix(dbInstance.Name, "test"), ShouldEqual, true)
+		So(svc.instances[dbInstance.Name].DatabaseVersion, ShouldEqual, "POSTGRES_9_6")
+		So(svc.instances[dbInstance.Name].Settings.UserLabels["billing-code"], ShouldEqual, "owner")
+
+		Convey("Ensure the database becomes runnable with an endpoint.", func() {
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "RUNNABLE")
+			So(fetched.Ready, ShouldEqual, true)
+			So(fetched.Endpoint, ShouldEqual, "10.0.0.1/"+dbInstance.Name)
+		})
+
+		Convey("Ensure post provisioning creates the user.", func() {
+			_, err := provider.PerformPostProvision(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(svc.users[dbInstance.Name]), ShouldEqual, 1)
+			So(svc.users[dbInstance.Name][0].Name, ShouldEqual, dbInstance.Username)
+			So(svc.users[dbInstance.Name][0].Password, ShouldEqual, dbInstance.Password)
+		})
+
+		Convey("Ensure it can be modified and restarted.", func() {
+			So(provider.Tag(ctx, dbInstance, "App", "foo"), ShouldBeNil)
+			newPlan := &ProviderPlan{
+				ID:                     "gcloud-test-plan-2",
+				Provider:               GCloudInstance,
+				Scheme:                 "postgres",
+				providerPrivateDetails: `{"tier":"db-n1-standard-1"}`,
+			}
+			modified, err := provider.Modify(ctx, dbInstance, newPlan)
+			So(err, ShouldBeNil)
+			So(modified.Plan.ID, ShouldEqual, "gcloud-test-plan-2")
+			So(modified.Status, ShouldEqual, "MAINTENANCE")
+			So(svc.instances[dbInstance.Name].Settings.Tier, ShouldEqual, "db-n1-standard-1")
+			So(svc.instances[dbInstance.Name].Settings.UserLabels["app"], ShouldEqual, "foo")
+			So(svc.instances[dbInstance.Name].Settings.UserLabels["billing-code"], ShouldEqual, "owner")
+
+			So(provider.Restart(ctx, dbInstance), ShouldBeNil)
+			restarted, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(restarted.Status, ShouldEqual, "MAINTENANCE")
+		})
+
+		Convey("Ensure backups can be taken and restored.", func() {
+			So(provider.Capabilities(plan).Has(BackupsCapability), ShouldEqual, true)
+			So(provider.Capabilities(plan).Has(RestoreCapability), ShouldEqual, true)
+
+			_, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			dbInstance, err = provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(*backup.Status, ShouldEqual, "creating")
+			So(*backup.Progress, ShouldEqual, 0)
+			So(provider.RestoreBackup(ctx, dbInstance, *backup.Id).Error(), ShouldEqual, "Cannot restore a backup that has not finished.")
+
+			backup, err = provider.GetBackup(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+			So(*backup.Status, ShouldEqual, "available")
+			So(*backup.Progress, ShouldEqual, 100)
+			backups, err := provider.ListBackups(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldEqual, 1)
+			So(*backups[0].Id, ShouldEqual, *backup.Id)
+
+			_, err = provider.GetBackup(ctx, dbInstance, "not-a-backup")
+			So(err.Error(), ShouldEqual, "Not found")
+			_, err = provider.GetBackup(ctx, dbInstance, "42")
+			So(err.Error(), ShouldEqual, "Not found")
+
+			So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+			So(strconv.FormatInt(svc.restoredFrom[dbInstance.Name], 10), ShouldEqual, *backup.Id)
+		})
+
+		Convey("Ensure it can be forked as a clone or from a backup with its own password.", func() {
+			_, err := provider.PerformPostProvision(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			dbInstance.Status = fetched.Status
+			dbInstance.Ready = fetched.Ready
+			dbInstance.Plan = plan
+
+			fork, err := provider.Fork(ctx, "fork-id", plan, "Other", dbInstance, "")
+			So(err, ShouldBeNil)
+			So(fork.Name, ShouldNotEqual, dbInstance.Name)
+			So(fork.Username, ShouldEqual, dbInstance.Username)
+			So(fork.Password, ShouldNotEqual, dbInstance.Password)
+			forked, err := provider.PerformFork(ctx, fork, dbInstance, "", "Other")
+			So(err, ShouldBeNil)
+			So(forked.Id, ShouldEqual, "fork-id")
+			So(forked.Ready, ShouldEqual, true)
+			So(svc.users[fork.Name][0].Password, ShouldEqual, fork.Password)
+			So(svc.users[dbInstance.Name][0].Password, ShouldEqual, dbInstance.Password)
+			So(svc.instances[fork.Name].Settings.UserLabels["billing-code"], ShouldEqual, "other")
+
+			_, err = provider.Fork(ctx, "fork-id-2", plan, "Other", dbInstance, LatestBackup)
+			So(err, ShouldNotBeNil)
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			_, err = provider.GetBackup(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+			fork, err = provider.Fork(ctx, "fork-id-2", plan, "Other", dbInstance, LatestBackup)
+			So(err, ShouldBeNil)
+			So(fork.Username, ShouldEqual, dbInstance.Username)
+			forked, err = provider.PerformFork(ctx, fork, dbInstance, LatestBackup, "Other")
+			So(err, ShouldBeNil)
+			So(strconv.FormatInt(svc.restoredFrom[fork.Name], 10), ShouldEqual, *backup.Id)
+			So(svc.users[fork.Name][0].Password, ShouldEqual, fork.Password)
+		})
+
+		Convey("Ensure restoring to a point in time needs log archiving.", func() {
+			So(provider.Capabilities(plan).Has(PointInTimeCapability), ShouldEqual, false)
+			_, _, err := provider.RestorableTimes(ctx, dbInstance)
+			So(err, ShouldEqual, ErrFeatureNotAvailable)
+		})
+
+		Convey("Ensure tags are kept as labels.", func() {
+			So(provider.Capabilities(plan).Has(TagsCapability), ShouldEqual, true)
+			So(provider.Tag(ctx, dbInstance, "App", "My.App-1"), ShouldBeNil)
+			So(provider.Tag(ctx, dbInstance, "1Binding", strings.Repeat("a", 70)), ShouldBeNil)
+			labels := svc.instances[dbInstance.Name].Settings.UserLabels
+			So(labels["app"], ShouldEqual, "my_app-1")
+			So(labels["tag_1binding"], ShouldEqual, strings.Repeat("a", 63))
+			So(labels["billing-code"], ShouldEqual, "owner")
+
+			So(provider.Untag(ctx, dbInstance, "App"), ShouldBeNil)
+			_, ok := svc.instances[dbInstance.Name].Settings.UserLabels["app"]
+			So(ok, ShouldEqual, false)
+		})
+
+		Convey("Ensure logs are read from cloud logging.", func() {
+			So(provider.Capabilities(plan).Has(LogsCapability), ShouldEqual, false)
+			_, err := provider.ListLogs(ctx, dbInstance)
+			So(err, ShouldEqual, ErrFeatureNotAvailable)
+
+			logs := &fakeCloudLogging{}
+			provider.logs = logs
+			So(provider.Capabilities(plan).Has(LogsCapability), ShouldEqual, true)
+			now := time.Now()
+			logs.add("project", dbInstance.Name, "cloudsql.googleapis.com/postgres.log", now.Add(-time.Minute), "first")
+			logs.add("project", dbInstance.Name, "cloudsql.googleapis.com/postgres.log", now, "second")
+			logs.add("project", "other", "cloudsql.googleapis.com/postgres.log", now, "other")
+
+			list, err := provider.ListLogs(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(list), ShouldEqual, 1)
+			So(*list[0].Name, ShouldEqual, "cloudsql.googleapis.com/postgres.log")
+			So(*list[0].Size, ShouldEqual, 13)
+			So(list[0].Updated, ShouldEqual, now.UTC().Format(time.RFC3339))
+
+			data, err := provider.GetLogs(ctx, dbInstance, *list[0].Name)
+			So(err, ShouldBeNil)
+			So(data, ShouldEqual, "first\nsecond\n")
+			_, err = provider.GetLogs(ctx, dbInstance, "cloudsql.googleapis.com/mysql.err")
+			So(err.Error(), ShouldEqual, "Not found")
+		})
+
+		Convey("Ensure read replicas are reached through the database of the instance.", func() {
+			So(provider.Capabilities(plan).Has(ReplicasCapability), ShouldEqual, true)
+			_, err := provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			dbInstance, err = provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			dbInstance.Id = "instance-id"
+
+			replica, err := provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Id, ShouldEqual, "instance-id")
+			So(replica.Name, ShouldEqual, dbInstance.Name+"-ro")
+			So(replica.Ready, ShouldEqual, false)
+			So(svc.instances[replica.Name].MasterInstanceName, ShouldEqual, dbInstance.Name)
+			So(svc.instances[replica.Name].DatabaseVersion, ShouldEqual, "POSTGRES_9_6")
+
+			replica, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Ready, ShouldEqual, true)
+			So(replica.Endpoint, ShouldEqual, "10.0.0.2/"+dbInstance.Name)
+
+			So(provider.DeleteReadReplica(ctx, dbInstance), ShouldBeNil)
+			_, ok := svc.instances[dbInstance.Name+"-ro"]
+			So(ok, ShouldEqual, false)
+		})
+
+		Convey("Ensure deprovisioning removes the database.", func() {
+			So(provider.Deprovision(ctx, dbInstance, false), ShouldBeNil)
+			_, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldNotBeNil)
+			So(provider.Deprovision(ctx, dbInstance, false), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a gcloud instance with point in time recovery", t, func() {
+		svc := newFakeSQLAdmin()
+		provider := NewGCloudInstanceProviderWithClient("test", svc, "project", "us-west1")
+		provider.instanceCache = NewInstanceCache(0)
+		provider.pollInterval = 0
+		pitrPlan := &ProviderPlan{
+			ID:                     "gcloud-pitr-plan",
+			Provider:               GCloudInstance,
+			Scheme:                 "postgres",
+			providerPrivateDetails: `{"tier":"db-f1-micro","backupConfiguration":{"enabled":true,"replicationLogArchivingEnabled":true}}`,
+			basePlan:               plan.basePlan,
+		}
+		So(provider.Capabilities(pitrPlan).Has(PointInTimeCapability), ShouldEqual, true)
+
+		dbInstance, err := provider.Provision(ctx, "instance-id", pitrPlan, "Owner")
+		So(err, ShouldBeNil)
+		dbInstance, err = provider.GetInstance(ctx, dbInstance.Name, pitrPlan)
+		So(err, ShouldBeNil)
+		dbInstance.Id = "instance-id"
+		dbInstance.Username = "user"
+		dbInstance.Password = "pass"
+
+		Convey("Ensure it can only be restored to times since its first backup.", func() {
+			_, _, err := provider.RestorableTimes(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			_, err = provider.GetBackup(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+
+			earliest, latest, err := provider.RestorableTimes(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(earliest.After(latest), ShouldEqual, false)
+			So(validateRestoreTime(ctx, provider, dbInstance, latest), ShouldBeNil)
+			So(validateRestoreTime(ctx, provider, dbInstance, earliest.Add(-time.Hour)), ShouldNotBeNil)
+		})
+
+		Convey("Ensure a restore clones the database into a new instance with the same credentials.", func() {
+			at := time.Now().Add(-time.Minute)
+			restored, err := provider.RestoreToTime(ctx, dbInstance, at)
+			So(err, ShouldBeNil)
+			So(restored.Name, ShouldNotEqual, dbInstance.Name)
+			So(strings.HasPrefix(restored.Name, "test"), ShouldEqual, true)
+			So(restored.Id, ShouldEqual, "instance-id")
+			So(restored.Username, ShouldEqual, "user")
+			So(restored.Password, ShouldEqual, "pass")
+			So(restored.Ready, ShouldEqual, true)
+			So(svc.clonedAt[restored.Name], ShouldEqual, at.UnixNano()/int64(time.Millisecond))
+			So(svc.instances[dbInstance.Name], ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Bindings given the database url use the password of the database, bindings with this auth parameter
+// get a user of their own that logs in with short lived tokens from the iam-token action instead.
+const IAMBindingAuth = "iam"
+
+// How long the tokens rds issues for iam authentication can be used to log in.
+const iamTokenLifetime = time.Minute * 15
+
+// The connection details of a binding that logs in with iam tokens rather than a password.
+type IAMCredentials struct {
+	Host     string
+	Port     string
+	Username string
+	Database string
+	Region   string
+}
+
+// An IAMAuthenticator is a provider whose databases can be logged into with short lived tokens
+// rather than passwords. CreateIAMUser creates a user that can only log in with a token and
+// returns its name, IAMAuthToken issues a token for the user.
+type IAMAuthenticator interface {
+	CreateIAMUser(context.Context, *DbInstance) (string, error)
+	DeleteIAMUser(context.Context, *DbInstance, string) error
+	IAMCredentials(*DbInstance, string) (IAMCredentials, error)
+	IAMAuthToken(context.Context, *DbInstance, string) (string, error)
+}
+
+// The auth parameter of a bind request, bindings are given the database url unless it's IAMBindingAuth.
+func bindingAuthParameter(parameters map[string]interface{}) (string, error) {
+	value, ok := parameters["auth"]
+	if !ok || value == nil {
+		return "", nil
+	}
+	auth, ok := value.(string)
+	if !ok || (auth != "" && auth != "password" && auth != IAMBindingAuth) {
+		return "", errors.New("The auth parameter must be password or " + IAMBindingAuth + ".")
+	}
+	if auth == "password" {
+		return "", nil
+	}
+	return auth, nil
+}
+
+// The credentials of an iam binding, apps get a token to log in with as the user from the token url.
+func iamBindingCredentials(dbInstance *DbInstance, bindingId string, credentials IAMCredentials) map[string]interface{} {
+	return map[string]interface{}{
+		"DATABASE_HOST":          credentials.Host,
+		"DATABASE_PORT":          credentials.Port,
+		"DATABASE_USER":          credentials.Username,
+		"DATABASE_NAME":          credentials.Database,
+		"DATABASE_REGION":        credentials.Region,
+		"DATABASE_IAM_TOKEN_URL": "/v2/service_instances/" + dbInstance.Id + "/actions/iam-token?binding=" + bindingId,
+	}
+}
+
+// Splits an endpoint of the form host:port/database.
+func splitEndpoint(endpoint string) (string, string, string, error) {
+	database := ""
+	if i := strings.Index(endpoint, "/"); i != -1 {
+		database = endpoint[i+1:]
+		endpoint = endpoint[:i]
+	}
+	i := strings.LastIndex(endpoint, ":")
+	if i == -1 || endpoint[:i] == "" {
+		return "", "", "", errors.New("The endpoint " + endpoint + " does not have a host and port.")
+	}
+	return endpoint[:i], endpoint[i+1:], database, nil
+}
+
+// Creates a user that logs in through rds_iam, the user is a member of the owner and acts as the owner
+// once logged in so it can do (and owns) everything the owner does.
+func createPostgresIAMUser(ctx context.Context, dbInstance *DbInstance, databaseUri string, username string) error {
+	if dbInstance.Engine != "postgres" {
+		return errors.New("I do not know how to do this on anything other than postgres.")
+	}
+	db, err := sql.Open("postgres", databaseUri)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.ExecContext(ctx, "create user "+username+" with login"); err != nil {
+		return err
+	}
+	if _, err = db.ExecContext(ctx, "grant rds_iam to "+username); err != nil {
+		return err
+	}
+	if _, err = db.ExecContext(ctx, "grant "+dbInstance.Username+" to "+username); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "alter role "+username+" set role "+dbInstance.Username)
+	return err
+}
+
+func deletePostgresIAMUser(ctx context.Context, dbInstance *DbInstance, databaseUri string, username string) error {
+	if dbInstance.Engine != "postgres" {
+		return errors.New("I do not know how to do this on anything other than postgres.")
+	}
+	db, err := sql.Open("postgres", databaseUri)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.ExecContext(ctx, "select pg_terminate_backend(pid) from pg_stat_activity where usename = $1", username); err != nil {
+		return err
+	}
+	// anything the user owns is handed to the owner so dropping what's left only revokes its grants.
+	if _, err = db.ExecContext(ctx, "reassign owned by "+username+" to "+dbInstance.Username); err != nil {
+		return err
+	}
+	if _, err = db.ExecContext(ctx, "drop owned by "+username); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "drop user if exists "+username)
+	return err
+}
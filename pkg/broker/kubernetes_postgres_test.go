@@ -0,0 +1,173 @@
+package broker
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strings"
+	"testing"
+)
+
+func TestKubernetesPostgresProvider(t *testing.T) {
+	ctx := context.Background()
+	plan := &ProviderPlan{
+		ID:                     "kubernetes-test-plan",
+		Provider:               KubernetesPostgres,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"instances":1,"image_name":"ghcr.io/cloudnative-pg/postgresql:15.4","engine_version":"15.4","storage_size":"1Gi","parameters":{"max_connections":"100"},"backup":{"barmanObjectStore":{"destinationPath":"s3://backups/"}}}`,
+	}
+
+	Convey("Given a kubernetes postgres provider backed by a fake dynamic client", t, func() {
+		client := newFakeDynamic()
+		provider := NewKubernetesPostgresProviderWithClient("test", client, "databases")
+		provider.pollInterval = 0
+
+		Convey("Ensure backups are only available on plans that configure them.", func() {
+			So(provider.Capabilities(plan).Has(BackupsCapability), ShouldEqual, true)
+			So(provider.Capabilities(plan).Has(RolesCapability), ShouldEqual, false)
+			noBackups := &ProviderPlan{Provider: KubernetesPostgres, providerPrivateDetails: `{"engine_version":"15.4"}`}
+			So(provider.Capabilities(noBackups).Has(BackupsCapability), ShouldEqual, false)
+			So(provider.Capabilities(nil).Has(RestartCapability), ShouldEqual, true)
+			So(provider.Capabilities(noBackups).Has(ReplicasCapability), ShouldEqual, true)
+		})
+
+		dbInstance, err := provider.Provision(ctx, "instance-id", plan, "Owner")
+		So(err, ShouldBeNil)
+		So(dbInstance.Status, ShouldEqual, "creating")
+		So(dbInstance.Ready, ShouldEqual, false)
+		So(strings.HasPrefix(dbInstance.Name, "test"), ShouldEqual, true)
+		So(dbInstance.Endpoint, ShouldEqual, dbInstance.Name+"-rw.databases.svc:5432/"+dbInstance.Name)
+		dbInstance.Plan = plan
+
+		cluster := client.get(kubernetesPostgresClusters, "databases", dbInstance.Name)
+		So(cluster, ShouldNotBeNil)
+		instances, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "instances")
+		So(instances, ShouldEqual, 1)
+		owner, _, _ := unstructured.NestedString(cluster.Object, "spec", "bootstrap", "initdb", "owner")
+		So(owner, ShouldEqual, dbInstance.Username)
+		So(cluster.GetLabels()["billing-code"], ShouldEqual, "owner")
+
+		Convey("Ensure the status, endpoint and credentials are read back.", func() {
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "creating")
+			fetched, err = provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "available")
+			So(fetched.Ready, ShouldEqual, true)
+			So(fetched.EngineVersion, ShouldEqual, "15.4")
+			So(fetched.Username, ShouldEqual, dbInstance.Username)
+			So(fetched.Password, ShouldEqual, dbInstance.Password)
+			So(fetched.ProviderId, ShouldEqual, "databases/"+dbInstance.Name)
+
+			_, err = provider.GetInstance(ctx, "does-not-exist", plan)
+			So(err.Error(), ShouldEqual, "Cannot find database instance")
+		})
+
+		Convey("Ensure it can be modified within a major version only.", func() {
+			provider.GetInstance(ctx, dbInstance.Name, plan)
+			newPlan := &ProviderPlan{
+				ID:                     "kubernetes-test-plan-2",
+				Provider:               KubernetesPostgres,
+				Scheme:                 "postgres",
+				providerPrivateDetails: `{"instances":2,"image_name":"ghcr.io/cloudnative-pg/postgresql:15.5","engine_version":"15.5","storage_size":"5Gi"}`,
+			}
+			modified, err := provider.Modify(ctx, dbInstance, newPlan)
+			So(err, ShouldBeNil)
+			So(modified.EngineVersion, ShouldEqual, "15.5")
+			So(modified.Username, ShouldEqual, dbInstance.Username)
+			cluster := client.get(kubernetesPostgresClusters, "databases", dbInstance.Name)
+			instances, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "instances")
+			So(instances, ShouldEqual, 2)
+			size, _, _ := unstructured.NestedString(cluster.Object, "spec", "storage", "size")
+			So(size, ShouldEqual, "5Gi")
+			_, hasBackup, _ := unstructured.NestedMap(cluster.Object, "spec", "backup")
+			So(hasBackup, ShouldEqual, false)
+
+			majorPlan := &ProviderPlan{Provider: KubernetesPostgres, providerPrivateDetails: `{"engine_version":"16.1"}`}
+			_, err = provider.Modify(ctx, modified, majorPlan)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure a backup can be taken and restored into a new cluster.", func() {
+			provider.GetInstance(ctx, dbInstance.Name, plan)
+			dbInstance.Ready = true
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(*backup.Status, ShouldEqual, "pending")
+			_, err = provider.RestoreBackupInto(ctx, dbInstance, *backup.Id)
+			So(err, ShouldNotBeNil)
+
+			backups, err := provider.ListBackups(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldEqual, 1)
+			fetched, err := provider.GetBackup(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+			So(*fetched.Status, ShouldEqual, "completed")
+			So(*fetched.Progress, ShouldEqual, 100)
+			_, err = provider.GetBackup(ctx, &DbInstance{Name: "other"}, *backup.Id)
+			So(err, ShouldNotBeNil)
+
+			So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldNotBeNil)
+			restoredDb, err := provider.RestoreBackupInto(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+			So(restoredDb.Name, ShouldNotEqual, dbInstance.Name)
+			So(restoredDb.Id, ShouldEqual, dbInstance.Id)
+			So(restoredDb.Username, ShouldEqual, dbInstance.Username)
+			So(restoredDb.Password, ShouldEqual, dbInstance.Password)
+			So(restoredDb.Ready, ShouldEqual, true)
+			So(restoredDb.Endpoint, ShouldEqual, restoredDb.Name+"-rw.databases.svc:5432/"+dbInstance.Name)
+
+			// the existing cluster is untouched, it's removed once the restored one is recorded.
+			So(client.get(kubernetesPostgresClusters, "databases", dbInstance.Name).GetDeletionTimestamp(), ShouldBeNil)
+			restored := client.get(kubernetesPostgresClusters, "databases", restoredDb.Name)
+			So(restored, ShouldNotBeNil)
+			source, _, _ := unstructured.NestedString(restored.Object, "spec", "bootstrap", "recovery", "backup", "name")
+			So(source, ShouldEqual, *backup.Id)
+			owner, _, _ := unstructured.NestedString(restored.Object, "spec", "bootstrap", "recovery", "owner")
+			So(owner, ShouldEqual, dbInstance.Username)
+			_, hasInitdb, _ := unstructured.NestedMap(restored.Object, "spec", "bootstrap", "initdb")
+			So(hasInitdb, ShouldEqual, false)
+
+			fetchedDb, err := provider.GetInstance(ctx, restoredDb.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetchedDb.Endpoint, ShouldEqual, restoredDb.Endpoint)
+			So(fetchedDb.Password, ShouldEqual, dbInstance.Password)
+		})
+
+		Convey("Ensure read replicas scale the cluster.", func() {
+			provider.GetInstance(ctx, dbInstance.Name, plan)
+			_, err := provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			replica, err := provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Endpoint, ShouldEqual, dbInstance.Name+"-ro.databases.svc:5432/"+dbInstance.Name)
+			So(replica.Status, ShouldEqual, "creating")
+			_, err = provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			replica, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Status, ShouldEqual, "available")
+			So(provider.DeleteReadReplica(ctx, dbInstance), ShouldBeNil)
+			_, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure tags and restarts update the cluster.", func() {
+			So(provider.Tag(ctx, dbInstance, "app", "foo"), ShouldBeNil)
+			So(client.get(kubernetesPostgresClusters, "databases", dbInstance.Name).GetLabels()["app"], ShouldEqual, "foo")
+			So(provider.Untag(ctx, dbInstance, "app"), ShouldBeNil)
+			_, ok := client.get(kubernetesPostgresClusters, "databases", dbInstance.Name).GetLabels()["app"]
+			So(ok, ShouldEqual, false)
+			So(provider.Restart(ctx, dbInstance), ShouldBeNil)
+			So(client.get(kubernetesPostgresClusters, "databases", dbInstance.Name).GetAnnotations()[kubernetesPostgresRestartAnnotation], ShouldNotEqual, "")
+		})
+
+		Convey("Ensure deprovisioning removes the cluster and credentials and takes a final backup.", func() {
+			So(provider.Deprovision(ctx, dbInstance, true), ShouldBeNil)
+			So(client.get(kubernetesPostgresClusters, "databases", dbInstance.Name).GetDeletionTimestamp(), ShouldNotBeNil)
+			So(client.get(kubernetesSecrets, "databases", dbInstance.Name+"-credentials").GetDeletionTimestamp(), ShouldNotBeNil)
+			So(client.get(kubernetesPostgresBackups, "databases", dbInstance.Name+"-final"), ShouldNotBeNil)
+		})
+	})
+}
@@ -7,6 +7,7 @@ import (
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,32 +23,46 @@ func NewBusinessLogic(ctx context.Context, o Options) (*BusinessLogic, error) {
 	if err != nil {
 		return nil, err
 	}
+	return NewBusinessLogicWithStorage(storage, namePrefix), nil
+}
 
+// NewBusinessLogicWithStorage creates the broker logic with storage from InitFromOptions, so it can be
+// shared with the background tasks when they run in the same process.
+func NewBusinessLogicWithStorage(storage Storage, namePrefix string) *BusinessLogic {
 	bl := BusinessLogic{
 		storage:    storage,
 		namePrefix: namePrefix,
 	}
+	bl.ActionBase.capabilities = bl.GetCapabilitiesById
+
+	bl.AddActions("list_backups", "backups", "GET", BackupsCapability, bl.ActionListBackups)
+	bl.AddActions("get_backup", "backups/{backup}", "GET", BackupsCapability, bl.ActionGetBackup)
+	bl.AddActions("create_backup", "backups", "POST", BackupsCapability, bl.ActionCreateBackup)
+	bl.AddActions("restore_backup", "backups/{backup}", "PUT", RestoreCapability, bl.ActionRestoreBackup)
+	bl.AddActions("restore_to_time", "restore-to-time", "PUT", PointInTimeCapability, bl.ActionRestoreToTime)
+	bl.AddActions("promote_dr", "promote-dr", "PUT", DisasterRecoveryCapability, bl.ActionPromoteDisasterRecovery)
 
-	bl.AddActions("list_backups", "backups", "GET", bl.ActionListBackups)
-	bl.AddActions("get_backup", "backups/{backup}", "GET", bl.ActionGetBackup)
-	bl.AddActions("create_backup", "backups", "POST", bl.ActionCreateBackup)
-	bl.AddActions("restore_backup", "backups/{backup}", "PUT", bl.ActionRestoreBackup)
+	bl.AddActions("list_roles", "roles", "GET", RolesCapability, bl.ActionListRoles)
+	bl.AddActions("get_role", "roles/{role}", "GET", RolesCapability, bl.ActionGetRole)
+	bl.AddActions("create_role", "roles", "POST", RolesCapability, bl.ActionCreateRole)
+	bl.AddActions("rotate_role", "roles/{role}", "PUT", RolesCapability, bl.ActionRotateRole)
+	bl.AddActions("delete_role", "roles/{role}", "DELETE", RolesCapability, bl.ActionDeleteRole)
+	bl.AddActions("iam_token", "iam-token", "GET", IAMAuthCapability, bl.ActionIAMToken)
 
-	bl.AddActions("list_roles", "roles", "GET", bl.ActionListRoles)
-	bl.AddActions("get_role", "roles/{role}", "GET", bl.ActionGetRole)
-	bl.AddActions("create_role", "roles", "POST", bl.ActionCreateRole)
-	bl.AddActions("rotate_role", "roles/{role}", "PUT", bl.ActionRotateRole)
-	bl.AddActions("delete_role", "roles/{role}", "DELETE", bl.ActionDeleteRole)
+	bl.AddActions("view_logs", "logs", "GET", LogsCapability, bl.ActionViewLogs)
 
-	bl.AddActions("view_logs", "logs", "GET", bl.ActionViewLogs)
+	bl.AddActions("restart", "restart", "PUT", RestartCapability, bl.ActionRestart)
 
-	bl.AddActions("restart", "restart", "PUT", bl.ActionRestart)
+	bl.AddActions("get_replica", "replica", "GET", ReplicasCapability, bl.ActionGetReplica)
+	bl.AddActions("create_replica", "replica", "PUT", ReplicasCapability, bl.ActionCreateReplica)
+	bl.AddActions("delete_replica", "replica", "DELETE", ReplicasCapability, bl.ActionDeleteReplica)
+	bl.AddActions("get_readers", "readers", "GET", ReadersCapability, bl.ActionGetReaders)
+	bl.AddActions("scale_readers", "readers", "PUT", ReadersCapability, bl.ActionScaleReaders)
 
-	bl.AddActions("get_replica", "replica", "GET", bl.ActionGetReplica)
-	bl.AddActions("create_replica", "replica", "PUT", bl.ActionCreateReplica)
-	bl.AddActions("delete_replica", "replica", "DELETE", bl.ActionDeleteReplica)
+	bl.AddActions("upgrade_preview", "upgrade-preview", "GET", "", bl.ActionUpgradePreview)
+	bl.AddActions("upgrade_version", "version", "PUT", VersionsCapability, bl.ActionUpgradeVersion)
 
-	return &bl, nil
+	return &bl
 }
 
 func (b *BusinessLogic) GetCatalog(c *broker.RequestContext) (*broker.CatalogResponse, error) {
@@ -61,8 +76,10 @@ func (b *BusinessLogic) GetCatalog(c *broker.RequestContext) (*broker.CatalogRes
 	return response, nil
 }
 
-func (b *BusinessLogic) ActionGetReplica(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionGetReplica(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -80,8 +97,10 @@ func (b *BusinessLogic) ActionGetReplica(InstanceID string, vars map[string]stri
 	return replica, nil
 }
 
-func (b *BusinessLogic) ActionCreateReplica(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionCreateReplica(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -104,16 +123,16 @@ func (b *BusinessLogic) ActionCreateReplica(InstanceID string, vars map[string]s
 		return nil, InternalServerError()
 	}
 
-	newDbInstance, err := provider.CreateReadReplica(dbInstance)
+	newDbInstance, err := provider.CreateReadReplica(ctx, dbInstance)
 	if err != nil {
 		glog.Errorf("Unable to create read replica on db, CreateReadReplica failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 
 	if err = b.storage.AddReplica(newDbInstance); err != nil {
 		// TODO: Clean up.
 		glog.Errorf("Error inserting record into provisioned_replicas table: %s\n", err.Error())
-		provider.DeleteReadReplica(newDbInstance)
+		provider.DeleteReadReplica(ctx, newDbInstance)
 		if err != nil {
 			glog.Errorf("Error cleaning up unrecorded database replica: %#v because %s\n", newDbInstance, err.Error())
 			// TODO add task to remove it later?
@@ -130,8 +149,10 @@ func (b *BusinessLogic) ActionCreateReplica(InstanceID string, vars map[string]s
 	return newDbInstance, nil
 }
 
-func (b *BusinessLogic) ActionDeleteReplica(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionDeleteReplica(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -141,11 +162,11 @@ func (b *BusinessLogic) ActionDeleteReplica(InstanceID string, vars map[string]s
 		return nil, InternalServerError()
 	}
 
-	readDbReplica, err := provider.GetReadReplica(dbInstance)
+	readDbReplica, err := provider.GetReadReplica(ctx, dbInstance)
 
-	if err = provider.DeleteReadReplica(dbInstance); err != nil {
+	if err = provider.DeleteReadReplica(ctx, dbInstance); err != nil {
 		glog.Errorf("Unable to delete read replica on db, CreateReadReplica failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 
 	if err = b.storage.DeleteReplica(readDbReplica); err != nil {
@@ -156,8 +177,85 @@ func (b *BusinessLogic) ActionDeleteReplica(InstanceID string, vars map[string]s
 	return readDbReplica, nil
 }
 
-func (b *BusinessLogic) ActionListRoles(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionGetReaders(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
+	if err != nil {
+		return nil, NotFound()
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to get readers, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	scaler, ok := provider.(ReaderScaler)
+	if !ok {
+		return nil, ProviderActionError(ErrFeatureNotAvailable)
+	}
+	count, automatic, err := scaler.Readers(ctx, dbInstance)
+	if err != nil {
+		glog.Errorf("Unable to get readers: %s\n", err.Error())
+		return nil, ProviderActionError(err)
+	}
+	return map[string]interface{}{"readers": count, "auto_scaling": automatic}, nil
+}
+
+// Scales the readers of a cluster to ?count= readers, the replica of the database is kept in step so
+// bindings get the reader endpoint while the database has readers.
+func (b *BusinessLogic) ActionScaleReaders(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
+	if err != nil {
+		return nil, NotFound()
+	}
+	var count int64 = -1
+	if c != nil && c.Request != nil && c.Request.URL != nil {
+		if count, err = strconv.ParseInt(c.Request.URL.Query().Get("count"), 10, 64); err != nil {
+			count = -1
+		}
+	}
+	if err = validateReaderCount(count); err != nil {
+		return nil, UnprocessableEntityWithMessage("ReadersError", err.Error())
+	}
+	if !IsAvailable(dbInstance.Status) {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "Clients MUST wait until pending requests have completed for the specified resources.")
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to scale readers, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	scaler, ok := provider.(ReaderScaler)
+	if !ok {
+		return nil, ProviderActionError(ErrFeatureNotAvailable)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if err = scaler.ScaleReaders(ctx, dbInstance, count); err != nil {
+		glog.Errorf("Unable to scale readers: %s\n", err.Error())
+		return nil, ProviderActionError(err)
+	}
+	replica, err := syncReaders(ctx, b.storage, provider, dbInstance)
+	if err != nil {
+		glog.Errorf("Unable to record the readers of %s: %s\n", dbInstance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	if replica != nil && !IsAvailable(replica.Status) {
+		if _, err = b.storage.AddTask(dbInstance.Id, ResyncReplicasFromProviderTask, ""); err != nil {
+			glog.Errorf("Error: Unable to schedule resync from provider! (%s): %s\n", replica.Name, err.Error())
+		}
+	}
+	return map[string]interface{}{"readers": count}, nil
+}
+
+func (b *BusinessLogic) ActionListRoles(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -171,8 +269,10 @@ func (b *BusinessLogic) ActionListRoles(InstanceID string, vars map[string]strin
 	return roles, nil
 }
 
-func (b *BusinessLogic) ActionGetRole(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionGetRole(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -184,8 +284,10 @@ func (b *BusinessLogic) ActionGetRole(InstanceID string, vars map[string]string,
 	return role, nil
 }
 
-func (b *BusinessLogic) ActionCreateRole(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionCreateRole(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -195,14 +297,14 @@ func (b *BusinessLogic) ActionCreateRole(InstanceID string, vars map[string]stri
 		return nil, InternalServerError()
 	}
 
-	dbUrl, err := provider.CreateReadOnlyUser(dbInstance)
+	dbUrl, err := provider.CreateReadOnlyUser(ctx, dbInstance)
 	if err != nil {
 		glog.Errorf("Unable to create read only role, CreateReadOnlyUser failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 
 	if _, err = b.storage.AddRole(dbInstance, dbUrl.Username, dbUrl.Password); err != nil {
-		if delerr := provider.DeleteReadOnlyUser(dbInstance, dbUrl.Username); delerr != nil {
+		if delerr := provider.DeleteReadOnlyUser(ctx, dbInstance, dbUrl.Username); delerr != nil {
 			glog.Errorf("Unable to remove read only role when trying to unwind changes, orphaned read only user: %s on db %s: %s\n", dbUrl.Username, dbInstance.Name, delerr.Error())
 		}
 		glog.Errorf("Unable to insert the role: %s\n", err.Error())
@@ -212,8 +314,10 @@ func (b *BusinessLogic) ActionCreateRole(InstanceID string, vars map[string]stri
 	return dbUrl, nil
 }
 
-func (b *BusinessLogic) ActionRotateRole(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionRotateRole(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -226,10 +330,10 @@ func (b *BusinessLogic) ActionRotateRole(InstanceID string, vars map[string]stri
 		return nil, InternalServerError()
 	}
 
-	dbUrl, err := provider.RotatePasswordReadOnlyUser(dbInstance, role)
+	dbUrl, err := provider.RotatePasswordReadOnlyUser(ctx, dbInstance, role)
 	if err != nil {
 		glog.Errorf("Unable to rotate password on read only role, RotatePasswordReadOnlyUser failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 
 	if _, err = b.storage.UpdateRole(dbInstance, role, dbUrl.Password); err != nil {
@@ -240,14 +344,13 @@ func (b *BusinessLogic) ActionRotateRole(InstanceID string, vars map[string]stri
 	return dbUrl, nil
 }
 
-func (b *BusinessLogic) ActionDeleteRole(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionDeleteRole(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
-	if dbInstance.Engine != "postgres" && dbInstance.Engine != "mysql" {
-		return nil, ConflictErrorWithMessage("I do not know how to do this on anything other than postgres or mysql..")
-	}
 	role := vars["role"]
 
 	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
@@ -266,9 +369,9 @@ func (b *BusinessLogic) ActionDeleteRole(InstanceID string, vars map[string]stri
 		return nil, NotFound()
 	}
 
-	if err = provider.DeleteReadOnlyUser(dbInstance, role); err != nil {
+	if err = provider.DeleteReadOnlyUser(ctx, dbInstance, role); err != nil {
 		glog.Errorf("Unable to delete read only user, DeleteReadOnlyUser failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 	if err = b.storage.DeleteRole(dbInstance, role); err != nil {
 		glog.Errorf("Unable to delete database role, %s\n", err.Error())
@@ -278,8 +381,10 @@ func (b *BusinessLogic) ActionDeleteRole(InstanceID string, vars map[string]stri
 	return map[string]interface{}{"status": "OK"}, nil
 }
 
-func (b *BusinessLogic) ActionListLogs(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionListLogs(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -288,16 +393,18 @@ func (b *BusinessLogic) ActionListLogs(InstanceID string, vars map[string]string
 		glog.Errorf("Unable to list logs on db, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
-	logs, err := provider.ListLogs(dbInstance)
+	logs, err := provider.ListLogs(ctx, dbInstance)
 	if err != nil {
 		glog.Errorf("Unable to get a list of logs: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 	return logs, nil
 }
 
-func (b *BusinessLogic) ActionGetLogs(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionGetLogs(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -307,10 +414,10 @@ func (b *BusinessLogic) ActionGetLogs(InstanceID string, vars map[string]string,
 		glog.Errorf("Unable to get db logs, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
-	logs, err := provider.GetLogs(dbInstance, path)
+	logs, err := provider.GetLogs(ctx, dbInstance, path)
 	if err != nil {
 		glog.Errorf("Unable to get logs, %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 	return logs, nil
 }
@@ -331,8 +438,8 @@ func (v databaseLogsArray) Less(i, j int) bool {
 	return a.After(b)
 }
 
-func (b *BusinessLogic) ActionViewLogs(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	logsInt, err := b.ActionListLogs(InstanceID, vars, context)
+func (b *BusinessLogic) ActionViewLogs(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	logsInt, err := b.ActionListLogs(InstanceID, vars, c)
 	if err != nil {
 		return nil, err
 	}
@@ -342,7 +449,7 @@ func (b *BusinessLogic) ActionViewLogs(InstanceID string, vars map[string]string
 		return map[string]interface{}{"logs": ""}, nil
 	}
 	logpath := strings.Split(*logs[0].Name, "/")
-	logsDataInt, err := b.ActionGetLogs(InstanceID, map[string]string{"dir": logpath[0], "file": logpath[1]}, context)
+	logsDataInt, err := b.ActionGetLogs(InstanceID, map[string]string{"dir": logpath[0], "file": logpath[1]}, c)
 	if err != nil {
 		return nil, err
 	}
@@ -350,8 +457,10 @@ func (b *BusinessLogic) ActionViewLogs(InstanceID string, vars map[string]string
 	return map[string]interface{}{"logs": logdata}, nil
 }
 
-func (b *BusinessLogic) ActionRestart(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionRestart(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -360,15 +469,105 @@ func (b *BusinessLogic) ActionRestart(InstanceID string, vars map[string]string,
 		glog.Errorf("Unable to restart db, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
-	if err = provider.Restart(dbInstance); err != nil {
+	if err = provider.Restart(ctx, dbInstance); err != nil {
 		glog.Errorf("Unable to restart db, %s\n", err.Error())
+		return nil, ProviderActionError(err)
+	}
+	return map[string]interface{}{"status": "OK"}, nil
+}
+
+// Previews changing the database to the plan given in the query, nothing is changed.
+func (b *BusinessLogic) ActionUpgradePreview(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
+	if err != nil {
+		return nil, NotFound()
+	}
+	var planId, switchover string
+	if c != nil && c.Request != nil && c.Request.URL != nil {
+		planId = c.Request.URL.Query().Get("plan")
+		switchover = c.Request.URL.Query().Get("switchover")
+	}
+	if planId == "" {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", "The plan to preview changing to must be given.")
+	}
+	if strings.ToLower(planId) == strings.ToLower(dbInstance.Plan.ID) {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", "Cannot upgrade to the same plan.")
+	}
+	if switchover != "" && switchover != "replication" {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", "The switchover parameter must be 'replication'.")
+	}
+	plan, err := b.storage.GetPlanByID(planId)
+	if err != nil {
+		glog.Errorf("Unable to preview upgrade (GetPlanByID failed): %s\n", err.Error())
+		return nil, NotFound()
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to preview upgrade, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	preview, err := previewUpgrade(ctx, provider, dbInstance, plan, switchover == "replication")
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", err.Error())
+	}
+	return preview, nil
+}
+
+// Schedules an upgrade of the database engine to the version given in the query, if a maintenance window
+// is given (e.g., window=sun:05:00-sun:06:00) the upgrade waits until the window to start.
+func (b *BusinessLogic) ActionUpgradeVersion(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
+	if err != nil {
+		return nil, NotFound()
+	}
+	var version, window string
+	if c != nil && c.Request != nil && c.Request.URL != nil {
+		version = c.Request.URL.Query().Get("version")
+		window = c.Request.URL.Query().Get("window")
+	}
+	if version == "" {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", "The version to upgrade to must be given.")
+	}
+	if window != "" {
+		if _, err = parseMaintenanceWindow(window); err != nil {
+			return nil, UnprocessableEntityWithMessage("UpgradeError", err.Error())
+		}
+	}
+	if !IsAvailable(dbInstance.Status) {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "Clients MUST wait until pending requests have completed for the specified resources.")
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to upgrade version, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	upgrader, ok := provider.(VersionUpgrader)
+	if !ok {
+		return nil, ProviderActionError(ErrFeatureNotAvailable)
+	}
+	if err = validateUpgradeVersion(ctx, upgrader, dbInstance, version); err != nil {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", err.Error())
+	}
+	byteData, err := json.Marshal(UpgradeVersionTaskMetadata{Version: version, Window: window})
+	if err != nil {
+		glog.Errorf("Error: failed to marshal upgrade version task metadata: %s\n", err)
+		return nil, InternalServerError()
+	}
+	if _, err = b.storage.AddTask(dbInstance.Id, UpgradeVersionTask, string(byteData)); err != nil {
+		glog.Errorf("Error: Unable to schedule version upgrade! (%s): %s\n", dbInstance.Name, err.Error())
 		return nil, InternalServerError()
 	}
 	return map[string]interface{}{"status": "OK"}, nil
 }
 
-func (b *BusinessLogic) ActionRestoreBackup(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionRestoreBackup(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -384,8 +583,128 @@ func (b *BusinessLogic) ActionRestoreBackup(InstanceID string, vars map[string]s
 	return map[string]interface{}{"status": "OK"}, nil
 }
 
-func (b *BusinessLogic) ActionCreateBackup(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+// Schedules a restore of the database to the time given in the query as RFC3339 (e.g., time=2019-01-06T05:00:00Z).
+func (b *BusinessLogic) ActionRestoreToTime(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
+	if err != nil {
+		return nil, NotFound()
+	}
+	var value string
+	if c != nil && c.Request != nil && c.Request.URL != nil {
+		value = c.Request.URL.Query().Get("time")
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("RestoreError", "The time to restore to must be given as an RFC3339 time, e.g. 2019-01-06T05:00:00Z.")
+	}
+	if !IsAvailable(dbInstance.Status) {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "Clients MUST wait until pending requests have completed for the specified resources.")
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to restore to a point in time, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	restorer, ok := provider.(PointInTimeRestorer)
+	if !ok {
+		return nil, ProviderActionError(ErrFeatureNotAvailable)
+	}
+	if err = validateRestoreTime(ctx, restorer, dbInstance, at); err != nil {
+		if err == ErrFeatureNotAvailable {
+			return nil, ProviderActionError(err)
+		}
+		return nil, UnprocessableEntityWithMessage("RestoreError", err.Error())
+	}
+	byteData, err := json.Marshal(RestoreToTimeTaskMetadata{Time: at})
+	if err != nil {
+		glog.Errorf("Error: failed to marshal restore to time task metadata: %s\n", err)
+		return nil, InternalServerError()
+	}
+	if _, err = b.storage.AddTask(dbInstance.Id, RestoreToTimeTask, string(byteData)); err != nil {
+		glog.Errorf("Error: Unable to schedule restore to a point in time! (%s): %s\n", dbInstance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return map[string]interface{}{"status": "OK"}, nil
+}
+
+// Promotes the disaster recovery copy of a database in place of it, this is meant for when the region
+// of the database is lost so only what the broker recorded about the database is used.
+func (b *BusinessLogic) ActionPromoteDisasterRecovery(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	dbInstance, err := GetRecordedInstanceById(b.storage, InstanceID)
+	if err != nil {
+		return nil, NotFound()
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to promote disaster recovery copy, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	if _, ok := provider.(DisasterRecoverer); !ok {
+		return nil, ProviderActionError(ErrFeatureNotAvailable)
+	}
+	promoting, err := b.storage.IsPromotingRecovery(InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get database (%s) status, IsPromotingRecovery failed: %s\n", InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+	if promoting {
+		return nil, UnprocessableEntityWithMessage("ConcurrencyError", "The disaster recovery copy of this database is already being promoted.")
+	}
+	if _, err = b.storage.AddTask(dbInstance.Id, PromoteRecoveryTask, ""); err != nil {
+		glog.Errorf("Error: Unable to schedule promoting the disaster recovery copy! (%s): %s\n", dbInstance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return map[string]interface{}{"status": "OK"}, nil
+}
+
+func (b *BusinessLogic) ActionIAMToken(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
+	if err != nil {
+		return nil, NotFound()
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to issue iam token, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	authenticator, ok := provider.(IAMAuthenticator)
+	if !ok {
+		return nil, ProviderActionError(ErrFeatureNotAvailable)
+	}
+	var bindingId string
+	if c != nil && c.Request != nil && c.Request.URL != nil {
+		bindingId = c.Request.URL.Query().Get("binding")
+	}
+	if bindingId == "" {
+		return nil, UnprocessableEntityWithMessage("IAMAuthError", "The binding to issue a token for must be given.")
+	}
+	username, err := b.storage.GetIAMBinding(dbInstance, bindingId)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to issue iam token, cannot get binding %s: %s\n", bindingId, err.Error())
+		return nil, InternalServerError()
+	}
+	token, err := authenticator.IAMAuthToken(ctx, dbInstance, username)
+	if err != nil {
+		glog.Errorf("Unable to issue iam token for %s on %s: %s\n", username, dbInstance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	return map[string]interface{}{
+		"token":    token,
+		"username": username,
+		"expires":  time.Now().Add(iamTokenLifetime).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+func (b *BusinessLogic) ActionCreateBackup(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -397,16 +716,29 @@ func (b *BusinessLogic) ActionCreateBackup(InstanceID string, vars map[string]st
 		glog.Errorf("Unable to create backup, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
-	backup, err := provider.CreateBackup(dbInstance)
+	backup, err := provider.CreateBackup(ctx, dbInstance)
 	if err != nil {
 		glog.Errorf("Unable to create backup, create backup failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
+	}
+	if _, ok := provider.(LogicalBackuper); ok {
+		byteData, err := json.Marshal(BackupDbTaskMetadata{Backup: *backup.Id})
+		if err != nil {
+			glog.Errorf("Error: failed to marshal backup task metadata: %s\n", err)
+			return nil, InternalServerError()
+		}
+		if _, err = b.storage.AddTask(dbInstance.Id, BackupDbTask, string(byteData)); err != nil {
+			glog.Errorf("Error: Unable to schedule backup! (%s): %s\n", dbInstance.Name, err.Error())
+			return nil, InternalServerError()
+		}
 	}
 	return backup, nil
 }
 
-func (b *BusinessLogic) ActionListBackups(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionListBackups(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -415,16 +747,18 @@ func (b *BusinessLogic) ActionListBackups(InstanceID string, vars map[string]str
 		glog.Errorf("Unable to list backups, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
-	backups, err := provider.ListBackups(dbInstance)
+	backups, err := provider.ListBackups(ctx, dbInstance)
 	if err != nil {
 		glog.Errorf("Unable to list backups, create backup failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 	return backups, nil
 }
 
-func (b *BusinessLogic) ActionGetBackup(InstanceID string, vars map[string]string, context *broker.RequestContext) (interface{}, error) {
-	dbInstance, err := b.GetInstanceById(InstanceID)
+func (b *BusinessLogic) ActionGetBackup(InstanceID string, vars map[string]string, c *broker.RequestContext) (interface{}, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, InstanceID)
 	if err != nil {
 		return nil, NotFound()
 	}
@@ -433,17 +767,17 @@ func (b *BusinessLogic) ActionGetBackup(InstanceID string, vars map[string]strin
 		glog.Errorf("Unable to create backup, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return nil, InternalServerError()
 	}
-	backup, err := provider.GetBackup(dbInstance, vars["backup"])
+	backup, err := provider.GetBackup(ctx, dbInstance, vars["backup"])
 	if err != nil && err.Error() == "Not found" {
 		return nil, NotFound()
 	} else if err != nil {
 		glog.Errorf("Unable to get backup, get backup failed: %s\n", err.Error())
-		return nil, InternalServerError()
+		return nil, ProviderActionError(err)
 	}
 	return backup, nil
 }
 
-func GetInstanceById(namePrefix string, storage Storage, Id string) (*DbInstance, error) {
+func GetInstanceById(ctx context.Context, namePrefix string, storage Storage, Id string) (*DbInstance, error) {
 	entry, err := storage.GetInstance(Id)
 	if err != nil {
 		return nil, err
@@ -459,7 +793,7 @@ func GetInstanceById(namePrefix string, storage Storage, Id string) (*DbInstance
 		return nil, err
 	}
 
-	dbInstance, err := provider.GetInstance(entry.Name, plan)
+	dbInstance, err := provider.GetInstance(ctx, entry.Name, plan)
 	if err != nil {
 		return nil, err
 	}
@@ -472,7 +806,7 @@ func GetInstanceById(namePrefix string, storage Storage, Id string) (*DbInstance
 	return dbInstance, nil
 }
 
-func GetReplicaById(namePrefix string, storage Storage, Id string) (*DbInstance, error) {
+func GetReplicaById(ctx context.Context, namePrefix string, storage Storage, Id string) (*DbInstance, error) {
 	entry, err := storage.GetInstance(Id)
 	if err != nil {
 		return nil, err
@@ -488,12 +822,12 @@ func GetReplicaById(namePrefix string, storage Storage, Id string) (*DbInstance,
 		return nil, err
 	}
 
-	dbInstance, err := provider.GetInstance(entry.Name, plan)
+	dbInstance, err := provider.GetInstance(ctx, entry.Name, plan)
 	if err != nil {
 		return nil, err
 	}
 
-	replica, err := provider.GetReadReplica(dbInstance)
+	replica, err := provider.GetReadReplica(ctx, dbInstance)
 	if err != nil {
 		return nil, err
 	}
@@ -511,16 +845,49 @@ func GetReplicaById(namePrefix string, storage Storage, Id string) (*DbInstance,
 	return replica, nil
 }
 
-func (b *BusinessLogic) GetInstanceById(Id string) (*DbInstance, error) {
-	return GetInstanceById(b.namePrefix, b.storage, Id)
+// requestContext is the context for calls to providers made while handling a request, it ends when
+// the client goes away or the request timeout is reached, whichever comes first.
+func requestContext(c *broker.RequestContext) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if c != nil && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	return WithOperationTimeout(ctx, RequestOperation)
+}
+
+func (b *BusinessLogic) GetInstanceById(ctx context.Context, Id string) (*DbInstance, error) {
+	return GetInstanceById(ctx, b.namePrefix, b.storage, Id)
+}
+
+// Look up what the provider behind an instance is capable of without having to ask the provider
+// for the instance itself (which can be slow).
+func (b *BusinessLogic) GetCapabilitiesById(Id string) (ProviderCapabilities, error) {
+	entry, err := b.storage.GetInstance(Id)
+	if err != nil && err.Error() == "Cannot find database instance" {
+		return nil, NotFound()
+	} else if err != nil {
+		glog.Errorf("Unable to get capabilities, cannot find database instance %s: %s\n", Id, err.Error())
+		return nil, InternalServerError()
+	}
+	plan, err := b.storage.GetPlanByID(entry.PlanId)
+	if err != nil {
+		glog.Errorf("Unable to get capabilities, cannot find plan for %s: %s\n", Id, err.Error())
+		return nil, InternalServerError()
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, plan)
+	if err != nil {
+		glog.Errorf("Unable to get capabilities, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	return provider.Capabilities(plan), nil
 }
 
-func (b *BusinessLogic) GetUnclaimedInstance(PlanId string, InstanceId string) (*DbInstance, error) {
+func (b *BusinessLogic) GetUnclaimedInstance(ctx context.Context, PlanId string, InstanceId string) (*DbInstance, error) {
 	dbEntry, err := b.storage.GetUnclaimedInstance(PlanId, InstanceId)
 	if err != nil {
 		return nil, err
 	}
-	dbInstance, err := b.GetInstanceById(dbEntry.Id)
+	dbInstance, err := b.GetInstanceById(ctx, dbEntry.Id)
 	if err != nil {
 		if err = b.storage.ReturnClaimedInstance(dbEntry.Id); err != nil {
 			return nil, err
@@ -534,6 +901,8 @@ func (b *BusinessLogic) GetUnclaimedInstance(PlanId string, InstanceId string) (
 // that can take up to 10 minutes in my experience (depending on the provider), and aside from the API call timing
 // out the other issue is it can cause the mutex lock to make the entire API unresponsive.
 func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
 	b.Lock()
 	defer b.Unlock()
 	response := broker.ProvisionResponse{}
@@ -558,7 +927,12 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 		return nil, UnprocessableEntityWithMessage("InstanceInvalid", "The instance ID was either already in-use or invalid. ("+err.Error()+")")
 	}
 
-	dbInstance, err := b.GetInstanceById(request.InstanceID)
+	forkFrom, backup, err := forkParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("ForkInvalid", err.Error())
+	}
+
+	dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 
 	if err == nil {
 		if dbInstance.Plan.ID != request.PlanID {
@@ -567,16 +941,19 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 		response.Exists = true
 	} else if err != nil && err.Error() == "Cannot find database instance" {
 		response.Exists = false
-		dbInstance, err = b.GetUnclaimedInstance(request.PlanID, request.InstanceID)
-
-		if err != nil && err.Error() == "Cannot find database instance" {
+		if forkFrom != "" {
+			// forks are never preprovisioned, they're made from the database they're forked from.
+			if dbInstance, err = b.forkInstance(ctx, request, plan, forkFrom, backup, c); err != nil {
+				return nil, err
+			}
+		} else if dbInstance, err = b.GetUnclaimedInstance(ctx, request.PlanID, request.InstanceID); err != nil && err.Error() == "Cannot find database instance" {
 			// Create a new one
 			provider, err := GetProviderByPlan(b.namePrefix, plan)
 			if err != nil {
 				glog.Errorf("Unable to provision, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 				return nil, InternalServerError()
 			}
-			dbInstance, err = provider.Provision(request.InstanceID, plan, request.OrganizationGUID)
+			dbInstance, err = provider.Provision(ctx, request.InstanceID, plan, request.OrganizationGUID)
 			if err != nil {
 				glog.Errorf("Error provisioning database: %s\n", err.Error())
 				return nil, InternalServerError()
@@ -585,7 +962,7 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 			if err = b.storage.AddInstance(dbInstance); err != nil {
 				glog.Errorf("Error inserting record into provisioned table: %s\n", err.Error())
 
-				if err = provider.Deprovision(dbInstance, false); err != nil {
+				if err = provider.Deprovision(ctx, dbInstance, false); err != nil {
 					glog.Errorf("Error cleaning up (deprovision failed) after insert record failed but provision succeeded (Database Id:%s Name: %s) %s\n", dbInstance.Id, dbInstance.Name, err.Error())
 					if _, err = b.storage.AddTask(dbInstance.Id, DeleteTask, dbInstance.Name); err != nil {
 						glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", dbInstance.Name, err.Error())
@@ -597,21 +974,16 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 				if _, err = b.storage.AddTask(dbInstance.Id, PerformPostProvisionTask, ""); err != nil {
 					glog.Errorf("Error: Unable to schedule resync from provider! (%s): %s\n", dbInstance.Name, err.Error())
 				}
-				// This is a hack to support callbacks, hopefully this will become an OSB standard.
-				if c != nil && c.Request != nil && c.Request.URL != nil && c.Request.URL.Query().Get("webhook") != "" && c.Request.URL.Query().Get("secret") != "" {
-					// Schedule a callback
-					byteData, err := json.Marshal(WebhookTaskMetadata{Url: c.Request.URL.Query().Get("webhook"), Secret: c.Request.URL.Query().Get("secret")})
-					if err != nil {
-						glog.Errorf("Error: failed to marshal webhook task metadata: %s\n", err)
-					}
-					if _, err = b.storage.AddTask(dbInstance.Id, NotifyCreateServiceWebhookTask, string(byteData)); err != nil {
-						glog.Errorf("Error: Unable to schedule resync from provider! (%s): %s\n", dbInstance.Name, err.Error())
-					}
-				}
+				b.scheduleCreateWebhook(c, dbInstance)
 			}
 		} else if err != nil {
 			glog.Errorf("Got fatal error from unclaimed instance endpoint: %s\n", err.Error())
 			return nil, InternalServerError()
+		} else if provider, err := GetProviderByPlan(b.namePrefix, plan); err == nil {
+			// readers of preprovisioned clusters are only recorded once they're claimed.
+			if _, err = syncReaders(ctx, b.storage, provider, dbInstance); err != nil {
+				glog.Errorf("Error: Unable to record the readers of claimed instance (%s): %s\n", dbInstance.Name, err.Error())
+			}
 		}
 	} else {
 		glog.Errorf("Unable to get instances: %s\n", err.Error())
@@ -626,17 +998,92 @@ func (b *BusinessLogic) Provision(request *osb.ProvisionRequest, c *broker.Reque
 		response.Async = false
 	}
 
-	response.ExtensionAPIs = b.ConvertActionsToExtensions(dbInstance.Id)
+	provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+	if err != nil {
+		glog.Errorf("Unable to provision, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	response.ExtensionAPIs = b.ConvertActionsToExtensions(dbInstance.Id, provider.Capabilities(dbInstance.Plan))
 
 	return &response, nil
 }
 
+// This is a hack to support callbacks, hopefully this will become an OSB standard.
+func (b *BusinessLogic) scheduleCreateWebhook(c *broker.RequestContext, dbInstance *DbInstance) {
+	if c != nil && c.Request != nil && c.Request.URL != nil && c.Request.URL.Query().Get("webhook") != "" && c.Request.URL.Query().Get("secret") != "" {
+		// Schedule a callback
+		byteData, err := json.Marshal(WebhookTaskMetadata{Url: c.Request.URL.Query().Get("webhook"), Secret: c.Request.URL.Query().Get("secret")})
+		if err != nil {
+			glog.Errorf("Error: failed to marshal webhook task metadata: %s\n", err)
+		}
+		if _, err = b.storage.AddTask(dbInstance.Id, NotifyCreateServiceWebhookTask, string(byteData)); err != nil {
+			glog.Errorf("Error: Unable to schedule resync from provider! (%s): %s\n", dbInstance.Name, err.Error())
+		}
+	}
+}
+
+// Creates a new database on the plan from another database (or one of its backups), the database has to be
+// on the same provider and engine as the plan. The fork is finished by the worker once it's available.
+func (b *BusinessLogic) forkInstance(ctx context.Context, request *osb.ProvisionRequest, plan *ProviderPlan, forkFrom string, backup string, c *broker.RequestContext) (*DbInstance, error) {
+	fromDb, err := b.GetInstanceById(ctx, forkFrom)
+	if err != nil && err.Error() == "Cannot find database instance" {
+		return nil, UnprocessableEntityWithMessage("ForkInvalid", "The database to fork from ("+forkFrom+") could not be found.")
+	} else if err != nil {
+		glog.Errorf("Unable to fork, cannot get the database to fork from (%s): %s\n", forkFrom, err.Error())
+		return nil, InternalServerError()
+	}
+	if fromDb.Plan.Provider != plan.Provider || fromDb.Plan.Scheme != plan.Scheme {
+		return nil, UnprocessableEntityWithMessage("ForkInvalid", "A database can only be forked into a plan with the same provider and engine.")
+	}
+	provider, err := GetProviderByPlan(b.namePrefix, plan)
+	if err != nil {
+		glog.Errorf("Unable to fork, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	forker, ok := provider.(Forker)
+	if !ok {
+		return nil, UnprocessableEntityWithMessage("ForkUnavailable", "Databases on this plan cannot be forked.")
+	}
+	byteData, err := json.Marshal(ForkDbTaskMetadata{From: fromDb.Id, Backup: backup, Owner: request.OrganizationGUID})
+	if err != nil {
+		glog.Errorf("Unable to marshal fork task meta data: %s\n", err.Error())
+		return nil, InternalServerError()
+	}
+	dbInstance, err := forker.Fork(ctx, request.InstanceID, plan, request.OrganizationGUID, fromDb, backup)
+	if err == ErrFeatureNotAvailable {
+		return nil, UnprocessableEntityWithMessage("ForkUnavailable", err.Error())
+	} else if err != nil {
+		glog.Errorf("Error forking database %s: %s\n", fromDb.Name, err.Error())
+		return nil, UnprocessableEntityWithMessage("ForkError", err.Error())
+	}
+
+	if err = b.storage.AddInstance(dbInstance); err != nil {
+		glog.Errorf("Error inserting record into provisioned table: %s\n", err.Error())
+
+		if err = provider.Deprovision(ctx, dbInstance, false); err != nil {
+			glog.Errorf("Error cleaning up (deprovision failed) after insert record failed but fork succeeded (Database Id:%s Name: %s) %s\n", dbInstance.Id, dbInstance.Name, err.Error())
+			if _, err = b.storage.AddTask(dbInstance.Id, DeleteTask, dbInstance.Name); err != nil {
+				glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", dbInstance.Name, err.Error())
+			}
+		}
+		return nil, InternalServerError()
+	}
+	if _, err = b.storage.AddTask(dbInstance.Id, ForkDbTask, string(byteData)); err != nil {
+		glog.Errorf("Error: Unable to schedule fork of database! (%s): %s\n", dbInstance.Name, err.Error())
+		return nil, InternalServerError()
+	}
+	b.scheduleCreateWebhook(c, dbInstance)
+	return dbInstance, nil
+}
+
 func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
 	b.Lock()
 	defer b.Unlock()
 
 	response := broker.DeprovisionResponse{}
-	dbInstance, err := b.GetInstanceById(request.InstanceID)
+	dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 	if err != nil && err.Error() == "Cannot find database instance" {
 		return nil, NotFound()
 	} else if err != nil {
@@ -656,7 +1103,7 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 		return nil, InternalServerError()
 	}
 	if replicas > 0 {
-		if provider.DeleteReadReplica(dbInstance); err != nil {
+		if provider.DeleteReadReplica(ctx, dbInstance); err != nil {
 			glog.Errorf("Error failed to remove replica: (Id: %s Name: %s) %s\n", dbInstance.Id, dbInstance.Name, err.Error())
 			if _, err = b.storage.AddTask(dbInstance.Id, DeleteTask, dbInstance.Name); err != nil {
 				glog.Errorf("Error: Unable to schedule delete from provider! (%s): %s\n", dbInstance.Name, err.Error())
@@ -669,7 +1116,7 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 		}
 
 	}
-	if err = provider.Deprovision(dbInstance, true); err != nil {
+	if err = provider.Deprovision(ctx, dbInstance, true); err != nil {
 		glog.Errorf("Error failed to deprovision: (Id: %s Name: %s) %s\n", dbInstance.Id, dbInstance.Name, err.Error())
 		if _, err = b.storage.AddTask(dbInstance.Id, DeleteTask, dbInstance.Name); err != nil {
 			glog.Errorf("Error: Unable to schedule delete from provider! (%s): %s\n", dbInstance.Name, err.Error())
@@ -689,11 +1136,13 @@ func (b *BusinessLogic) Deprovision(request *osb.DeprovisionRequest, c *broker.R
 }
 
 func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
 	response := broker.UpdateInstanceResponse{}
 	if !request.AcceptsIncomplete {
 		return nil, UnprocessableEntity()
 	}
-	dbInstance, err := b.GetInstanceById(request.InstanceID)
+	dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 	if err != nil && err.Error() == "Cannot find database instance" {
 		return nil, NotFound()
 	} else if err != nil {
@@ -718,9 +1167,17 @@ func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.Req
 		return nil, err
 	}
 
+	switchover, err := switchoverFromParameters(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", err.Error())
+	}
+	if switchover != nil && migrationEngine(dbInstance.Engine) != "postgres" {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", "Only postgres databases can be switched over with replication.")
+	}
+
 	// If the user has requested to upgrade across providers
-	if dbInstance.Plan.Provider != target_plan.Provider && dbInstance.Engine == "postgres" {
-		byteData, err := json.Marshal(ChangeProvidersTaskMetadata{Plan: *request.PlanID})
+	if dbInstance.Plan.Provider != target_plan.Provider && migrationEngine(dbInstance.Engine) != "" {
+		byteData, err := json.Marshal(ChangeProvidersTaskMetadata{Plan: *request.PlanID, Switchover: switchover})
 		if err != nil {
 			glog.Errorf("Unable to marshal change provider task meta data: %s\n", err.Error())
 			return nil, err
@@ -731,10 +1188,10 @@ func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.Req
 		}
 		response.Async = true
 		return &response, nil
-	} else if dbInstance.Plan.Provider != target_plan.Provider && dbInstance.Engine != "postgres" {
-		return nil, UnprocessableEntityWithMessage("UpgradeError", "Cannot upgrade across providers for non-postgres databases.")
+	} else if dbInstance.Plan.Provider != target_plan.Provider {
+		return nil, UnprocessableEntityWithMessage("UpgradeError", "Cannot upgrade across providers for databases other than postgres or mysql.")
 	} else {
-		byteData, err := json.Marshal(ChangePlansTaskMetadata{Plan: *request.PlanID})
+		byteData, err := json.Marshal(ChangePlansTaskMetadata{Plan: *request.PlanID, Switchover: switchover})
 		if err != nil {
 			glog.Errorf("Unable to marshal change plans task meta data: %s\n", err.Error())
 			return nil, err
@@ -749,6 +1206,8 @@ func (b *BusinessLogic) Update(request *osb.UpdateInstanceRequest, c *broker.Req
 }
 
 func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
 	response := broker.LastOperationResponse{}
 
 	upgrading, err := b.storage.IsUpgrading(request.InstanceID)
@@ -763,9 +1222,18 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 		return nil, InternalServerError()
 	}
 
+	forking, err := b.storage.IsForking(request.InstanceID)
+	if err != nil {
+		glog.Errorf("Unable to get database (%s) status, IsForking failed: %s\n", request.InstanceID, err.Error())
+		return nil, InternalServerError()
+	}
+
 	if upgrading {
 		desc := "upgrading"
-		dbInstance, err := b.GetInstanceById(request.InstanceID)
+		if progress, err := b.storage.GetUpgradeProgress(request.InstanceID); err == nil && progress != "" {
+			desc = "upgrading: " + progress
+		}
+		dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 		if err == nil && !IsAvailable(dbInstance.Status) {
 			desc = dbInstance.Status
 		}
@@ -774,7 +1242,16 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 		return &response, nil
 	} else if restoring {
 		desc := "restoring"
-		dbInstance, err := b.GetInstanceById(request.InstanceID)
+		dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
+		if err == nil && !IsAvailable(dbInstance.Status) {
+			desc = dbInstance.Status
+		}
+		response.Description = &desc
+		response.State = osb.StateInProgress
+		return &response, nil
+	} else if forking {
+		desc := "forking"
+		dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 		if err == nil && !IsAvailable(dbInstance.Status) {
 			desc = dbInstance.Status
 		}
@@ -783,7 +1260,7 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 		return &response, nil
 	}
 
-	dbInstance, err := b.GetInstanceById(request.InstanceID)
+	dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 	if err != nil && err.Error() == "Cannot find database instance" {
 		return nil, NotFound()
 	} else if err != nil {
@@ -807,9 +1284,15 @@ func (b *BusinessLogic) LastOperation(request *osb.LastOperationRequest, c *brok
 }
 
 func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	auth, err := bindingAuthParameter(request.Parameters)
+	if err != nil {
+		return nil, UnprocessableEntityWithMessage("BindingError", err.Error())
+	}
 	b.Lock()
 	defer b.Unlock()
-	dbInstance, err := b.GetInstanceById(request.InstanceID)
+	dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 	if err != nil && err.Error() == "Cannot find database instance" {
 		return nil, NotFound()
 	} else if err != nil {
@@ -826,17 +1309,50 @@ func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext)
 		return nil, InternalServerError()
 	}
 
-	if request.BindResource != nil && request.BindResource.AppGUID != nil {
-		if err = provider.Tag(dbInstance, "Binding", request.BindingID); err != nil {
+	if request.BindResource != nil && request.BindResource.AppGUID != nil && provider.Capabilities(dbInstance.Plan).Has(TagsCapability) {
+		if err = provider.Tag(ctx, dbInstance, "Binding", request.BindingID); err != nil {
 			glog.Errorf("Error tagging: %s with %s, got %s\n", request.InstanceID, *request.BindResource.AppGUID, err.Error())
 			return nil, InternalServerError()
 		}
-		if err = provider.Tag(dbInstance, "App", *request.BindResource.AppGUID); err != nil {
+		if err = provider.Tag(ctx, dbInstance, "App", *request.BindResource.AppGUID); err != nil {
 			glog.Errorf("Error tagging: %s with %s, got %s\n", request.InstanceID, *request.BindResource.AppGUID, err.Error())
 			return nil, InternalServerError()
 		}
 	}
 
+	if auth == IAMBindingAuth {
+		authenticator, ok := provider.(IAMAuthenticator)
+		if !ok || !provider.Capabilities(dbInstance.Plan).Has(IAMAuthCapability) {
+			return nil, UnprocessableEntityWithMessage("BindingError", "The plan of this database does not allow iam authentication.")
+		}
+		// binding again with the same id gives back the same user rather than creating another.
+		username, err := b.storage.GetIAMBinding(dbInstance, request.BindingID)
+		if err != nil && err.Error() == "sql: no rows in result set" {
+			if username, err = authenticator.CreateIAMUser(ctx, dbInstance); err != nil {
+				glog.Errorf("Error creating iam user on %s: %s\n", request.InstanceID, err.Error())
+				return nil, InternalServerError()
+			}
+			if err = b.storage.AddIAMBinding(dbInstance, request.BindingID, username); err != nil {
+				glog.Errorf("Error recording iam binding %s (user %s) on %s: %s\n", request.BindingID, username, request.InstanceID, err.Error())
+				return nil, InternalServerError()
+			}
+		} else if err != nil {
+			glog.Errorf("Error: Bind, iam bindings table returned error: %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		credentials, err := authenticator.IAMCredentials(dbInstance, username)
+		if err != nil {
+			glog.Errorf("Error getting iam credentials for %s: %s\n", request.InstanceID, err.Error())
+			return nil, InternalServerError()
+		}
+		return &broker.BindResponse{
+			BindResponse: osb.BindResponse{
+				Async:       false,
+				Credentials: iamBindingCredentials(dbInstance, request.BindingID, credentials),
+			},
+		}, nil
+	}
+
 	dbUrl, err := b.storage.GetReplicas(dbInstance)
 	scheme := dbInstance.Scheme + "://"
 	if dbInstance.Scheme == "" {
@@ -858,7 +1374,7 @@ func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext)
 				Async: false,
 				Credentials: map[string]interface{}{
 					"DATABASE_URL":          scheme + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint,
-					"DATABASE_READONLY_URL": scheme + "://" + dbUrl.Username + ":" + dbUrl.Password + "@" + dbUrl.Endpoint,
+					"DATABASE_READONLY_URL": scheme + dbUrl.Username + ":" + dbUrl.Password + "@" + dbUrl.Endpoint,
 				},
 			},
 		}
@@ -870,10 +1386,12 @@ func (b *BusinessLogic) Bind(request *osb.BindRequest, c *broker.RequestContext)
 }
 
 func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
 	b.Lock()
 	defer b.Unlock()
 
-	dbInstance, err := b.GetInstanceById(request.InstanceID)
+	dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 	if err != nil && err.Error() == "Cannot find database instance" {
 		return nil, NotFound()
 	} else if err != nil {
@@ -890,12 +1408,34 @@ func (b *BusinessLogic) Unbind(request *osb.UnbindRequest, c *broker.RequestCont
 		return nil, InternalServerError()
 	}
 
-	if err = provider.Untag(dbInstance, "Binding"); err != nil {
-		glog.Errorf("Error untagging: %s\n", err.Error())
-		return nil, InternalServerError()
+	if provider.Capabilities(dbInstance.Plan).Has(TagsCapability) {
+		if err = provider.Untag(ctx, dbInstance, "Binding"); err != nil {
+			glog.Errorf("Error untagging: %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		if err = provider.Untag(ctx, dbInstance, "App"); err != nil {
+			glog.Errorf("Error untagging: got %s\n", err.Error())
+			return nil, InternalServerError()
+		}
 	}
-	if err = provider.Untag(dbInstance, "App"); err != nil {
-		glog.Errorf("Error untagging: got %s\n", err.Error())
+
+	username, err := b.storage.GetIAMBinding(dbInstance, request.BindingID)
+	if err == nil {
+		authenticator, ok := provider.(IAMAuthenticator)
+		if !ok {
+			glog.Errorf("Error: Unbind, %s has an iam binding but its provider cannot remove iam users.\n", request.InstanceID)
+			return nil, InternalServerError()
+		}
+		if err = authenticator.DeleteIAMUser(ctx, dbInstance, username); err != nil {
+			glog.Errorf("Error deleting iam user %s on %s: %s\n", username, request.InstanceID, err.Error())
+			return nil, InternalServerError()
+		}
+		if err = b.storage.DeleteIAMBinding(dbInstance, request.BindingID); err != nil {
+			glog.Errorf("Error deleting iam binding %s on %s: %s\n", request.BindingID, request.InstanceID, err.Error())
+			return nil, InternalServerError()
+		}
+	} else if err.Error() != "sql: no rows in result set" {
+		glog.Errorf("Error: Unbind, iam bindings table returned error: %s\n", err.Error())
 		return nil, InternalServerError()
 	}
 
@@ -910,8 +1450,10 @@ func (b *BusinessLogic) ValidateBrokerAPIVersion(version string) error {
 	return nil
 }
 
-func (b *BusinessLogic) GetBinding(request *osb.GetBindingRequest, context *broker.RequestContext) (*osb.GetBindingResponse, error) {
-	dbInstance, err := b.GetInstanceById(request.InstanceID)
+func (b *BusinessLogic) GetBinding(request *osb.GetBindingRequest, c *broker.RequestContext) (*osb.GetBindingResponse, error) {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+	dbInstance, err := b.GetInstanceById(ctx, request.InstanceID)
 	if err == nil && !CanGetBindings(dbInstance.Status) {
 		return nil, UnprocessableEntityWithMessage("ServiceNotYetAvailable", "The service requested is not yet available.")
 	}
@@ -922,6 +1464,31 @@ func (b *BusinessLogic) GetBinding(request *osb.GetBindingRequest, context *brok
 		return nil, err
 	}
 
+	username, err := b.storage.GetIAMBinding(dbInstance, request.BindingID)
+	if err == nil {
+		provider, err := GetProviderByPlan(b.namePrefix, dbInstance.Plan)
+		if err != nil {
+			glog.Errorf("Unable to get binding, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
+			return nil, InternalServerError()
+		}
+		authenticator, ok := provider.(IAMAuthenticator)
+		if !ok {
+			glog.Errorf("Error: Get binding, %s has an iam binding but its provider cannot issue iam credentials.\n", request.InstanceID)
+			return nil, InternalServerError()
+		}
+		credentials, err := authenticator.IAMCredentials(dbInstance, username)
+		if err != nil {
+			glog.Errorf("Error getting iam credentials for %s: %s\n", request.InstanceID, err.Error())
+			return nil, InternalServerError()
+		}
+		return &osb.GetBindingResponse{
+			Credentials: iamBindingCredentials(dbInstance, request.BindingID, credentials),
+		}, nil
+	} else if err.Error() != "sql: no rows in result set" {
+		glog.Errorf("Error getting iam binding during get binding: %s\n", err.Error())
+		return nil, err
+	}
+
 	dbUrl, err := b.storage.GetReplicas(dbInstance)
 	scheme := dbInstance.Scheme + "://"
 	if dbInstance.Scheme == "" {
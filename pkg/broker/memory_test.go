@@ -0,0 +1,184 @@
+package broker
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryProvider(t *testing.T) {
+	ctx := context.Background()
+	plan := &ProviderPlan{
+		ID:                     "memory-test-plan",
+		Provider:               Memory,
+		Scheme:                 "postgres",
+		providerPrivateDetails: `{"engine":"postgres","engine_version":"10.4"}`,
+	}
+
+	Convey("Given a memory provider", t, func() {
+		provider := MemoryProvider{namePrefix: "test", databases: newMemoryDatabases()}
+
+		Convey("Ensure it is registered and supports every capability.", func() {
+			So(GetProvidersFromString("memory"), ShouldEqual, Memory)
+			So(ValidateProviderPrivateDetails(Memory, `{"engine":"postgres","engine_version":"10.4","host":"localhost:5432","provision_seconds":30}`), ShouldBeNil)
+			capabilities := provider.Capabilities(plan)
+			for _, capability := range []Capability{BackupsCapability, RestoreCapability, RolesCapability, LogsCapability, RestartCapability, ReplicasCapability, TagsCapability} {
+				So(capabilities.Has(capability), ShouldEqual, true)
+			}
+		})
+
+		dbInstance, err := provider.Provision(ctx, "instance-id", plan, "Owner")
+		So(err, ShouldBeNil)
+		So(dbInstance.Id, ShouldEqual, "instance-id")
+		So(dbInstance.Status, ShouldEqual, "creating")
+		So(dbInstance.Ready, ShouldEqual, false)
+		So(dbInstance.Username, ShouldNotEqual, "")
+		So(dbInstance.Password, ShouldNotEqual, "")
+		So(strings.HasPrefix(dbInstance.Name, "test"), ShouldEqual, true)
+		So(dbInstance.Endpoint, ShouldEqual, "localhost:5432/"+dbInstance.Name)
+
+		Convey("Ensure it becomes available and is shared with other providers.", func() {
+			fetched, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "available")
+			So(fetched.Ready, ShouldEqual, true)
+			So(fetched.Username, ShouldEqual, "")
+			So(fetched.EngineVersion, ShouldEqual, "10.4")
+
+			other := MemoryProvider{namePrefix: "other", databases: provider.databases}
+			_, err = other.GetInstance(ctx, dbInstance.Name, plan)
+			So(err, ShouldBeNil)
+			_, err = provider.GetInstance(ctx, "does-not-exist", plan)
+			So(err.Error(), ShouldEqual, "Cannot find database instance")
+		})
+
+		Convey("Ensure it stays in transition until the provision time has passed.", func() {
+			slowPlan := &ProviderPlan{
+				ID:                     "memory-slow-plan",
+				Provider:               Memory,
+				Scheme:                 "postgres",
+				providerPrivateDetails: `{"engine":"postgres","engine_version":"10.4","provision_seconds":3600}`,
+			}
+			slow, err := provider.Provision(ctx, "slow-id", slowPlan, "owner")
+			So(err, ShouldBeNil)
+			fetched, err := provider.GetInstance(ctx, slow.Name, slowPlan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "creating")
+			So(InProgress(fetched.Status), ShouldEqual, true)
+			_, err = provider.Modify(ctx, slow, plan)
+			So(err, ShouldNotBeNil)
+
+			provider.databases.databases[slow.Name].until = time.Now()
+			fetched, err = provider.GetInstance(ctx, slow.Name, slowPlan)
+			So(err, ShouldBeNil)
+			So(fetched.Status, ShouldEqual, "available")
+		})
+
+		Convey("Ensure it can be modified to a new version but not a new engine.", func() {
+			newPlan := &ProviderPlan{
+				ID:                     "memory-test-plan-2",
+				Provider:               Memory,
+				Scheme:                 "postgres",
+				providerPrivateDetails: `{"engine":"postgres","engine_version":"11.6","host":"db.example.com:5432"}`,
+			}
+			modified, err := provider.Modify(ctx, dbInstance, newPlan)
+			So(err, ShouldBeNil)
+			So(modified.EngineVersion, ShouldEqual, "11.6")
+			So(modified.Plan.ID, ShouldEqual, "memory-test-plan-2")
+			So(modified.Endpoint, ShouldEqual, "db.example.com:5432/"+dbInstance.Name)
+			So(modified.Username, ShouldEqual, dbInstance.Username)
+
+			mysqlPlan := &ProviderPlan{ID: "memory-mysql", Provider: Memory, Scheme: "mysql", providerPrivateDetails: `{"engine":"mysql","engine_version":"5.7"}`}
+			_, err = provider.Modify(ctx, dbInstance, mysqlPlan)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure backups can be created, listed and restored.", func() {
+			dbInstance.Ready = false
+			_, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			dbInstance.Ready = true
+			backup, err := provider.CreateBackup(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(*backup.Status, ShouldEqual, "available")
+			So(backup.Database.Name, ShouldEqual, dbInstance.Name)
+			backups, err := provider.ListBackups(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(backups), ShouldEqual, 1)
+			fetched, err := provider.GetBackup(ctx, dbInstance, *backup.Id)
+			So(err, ShouldBeNil)
+			So(*fetched.Id, ShouldEqual, *backup.Id)
+			So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+			So(provider.RestoreBackup(ctx, dbInstance, "does-not-exist"), ShouldNotBeNil)
+			_, err = provider.GetBackup(ctx, &DbInstance{Name: "other"}, *backup.Id)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure read only roles can be created, rotated and removed.", func() {
+			role, err := provider.CreateReadOnlyUser(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(strings.HasPrefix(role.Username, "rdo1"), ShouldEqual, true)
+			So(role.Plan, ShouldEqual, plan.ID)
+			rotated, err := provider.RotatePasswordReadOnlyUser(ctx, dbInstance, role.Username)
+			So(err, ShouldBeNil)
+			So(rotated.Password, ShouldNotEqual, role.Password)
+			So(provider.DeleteReadOnlyUser(ctx, dbInstance, role.Username), ShouldBeNil)
+			So(provider.DeleteReadOnlyUser(ctx, dbInstance, role.Username), ShouldNotBeNil)
+			_, err = provider.RotatePasswordReadOnlyUser(ctx, dbInstance, role.Username)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure replicas, tags, logs and restarts work.", func() {
+			replica, err := provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Name, ShouldEqual, dbInstance.Name+"-ro")
+			So(replica.Endpoint, ShouldEqual, "localhost:5432/"+dbInstance.Name+"-ro")
+			_, err = provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+			replica, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(replica.Username, ShouldEqual, dbInstance.Username)
+			So(provider.DeleteReadReplica(ctx, dbInstance), ShouldBeNil)
+			_, err = provider.GetReadReplica(ctx, dbInstance)
+			So(err, ShouldNotBeNil)
+
+			So(provider.Tag(ctx, dbInstance, "App", "foo"), ShouldBeNil)
+			So(provider.databases.databases[dbInstance.Name].tags["App"], ShouldEqual, "foo")
+			So(provider.databases.databases[dbInstance.Name].tags["billing-code"], ShouldEqual, "owner")
+			So(provider.Untag(ctx, dbInstance, "App"), ShouldBeNil)
+			So(len(provider.databases.databases[dbInstance.Name].tags), ShouldEqual, 1)
+
+			So(provider.Restart(ctx, dbInstance), ShouldBeNil)
+			logs, err := provider.ListLogs(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			data, err := provider.GetLogs(ctx, dbInstance, *logs[0].Name)
+			So(err, ShouldBeNil)
+			So(data, ShouldContainSubstring, "creating")
+			So(data, ShouldContainSubstring, "rebooting")
+			_, err = provider.GetLogs(ctx, dbInstance, "does-not-exist")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Ensure deprovisioning removes the database and its replica.", func() {
+			_, err := provider.CreateReadReplica(ctx, dbInstance)
+			So(err, ShouldBeNil)
+			So(provider.Deprovision(ctx, dbInstance, true), ShouldBeNil)
+			So(len(provider.databases.databases), ShouldEqual, 0)
+			_, ok := provider.databases.backups[dbInstance.Name+"-final"]
+			So(ok, ShouldEqual, true)
+			So(provider.Deprovision(ctx, dbInstance, false), ShouldNotBeNil)
+		})
+
+		Convey("Ensure a cancelled context is respected.", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := provider.GetInstance(cancelled, dbInstance.Name, plan)
+			So(err, ShouldEqual, context.Canceled)
+			_, err = provider.Provision(cancelled, "instance-id", plan, "owner")
+			So(err, ShouldEqual, context.Canceled)
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io"
+)
+
+// Mysql databases are copied by loading a dump of the old database into the new one as the dump is
+// taken, see dumpMysqlDatabase. It's loaded by the owner of the new database so everything copied
+// belongs to them.
+func migrateMysql(ctx context.Context, from string, to string, progress func(string)) ([]migratedTable, error) {
+	config, err := mysql.ParseDSN(from)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]migratedTable, 0)
+	var percent int64
+	reader, writer := io.Pipe()
+	dumped := make(chan error, 1)
+	go func() {
+		err := dumpMysqlDatabase(ctx, from, config.DBName, writer, func(p int64) {
+			percent = p
+		}, func(table string, rows int64) {
+			tables = append(tables, migratedTable{name: table, rows: rows})
+			progress(fmt.Sprintf("copied table %s (%d rows, about %d%% done)", table, rows, percent))
+		})
+		writer.CloseWithError(err)
+		dumped <- err
+	}()
+	err = loadMysqlDump(ctx, to, reader)
+	// stop the dump if the load gave up before reading all of it.
+	reader.CloseWithError(errors.New("Loading the copy stopped."))
+	derr := <-dumped
+	if err != nil {
+		return nil, err
+	}
+	if derr != nil {
+		return nil, derr
+	}
+
+	progress("verifying row counts")
+	db, err := sql.Open("mysql", to)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	for _, table := range tables {
+		var copied int64
+		if err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteMysqlName(table.name)).Scan(&copied); err != nil {
+			return nil, errors.New("Cannot count the rows copied into " + table.name + ": " + err.Error())
+		}
+		if copied != table.rows {
+			return nil, fmt.Errorf("The table %s has %d rows but %d were copied.", table.name, table.rows, copied)
+		}
+	}
+	return tables, nil
+}
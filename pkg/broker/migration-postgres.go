@@ -0,0 +1,474 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// Postgres databases are copied by reading their definitions out of the catalog and recreating them
+// on the new database, the rows of every table are then streamed across with COPY. Everything is
+// read from one snapshot of the old database and created by the owner of the new database, so
+// everything copied belongs to them. Grants, comments, rules, policies and table inheritance are not
+// copied.
+
+// Objects that belong to the database rather than to postgres or an extension, n is the namespace
+// of the object and the oid is the objects.
+const postgresUserObject = `n.nspname not in ('pg_catalog', 'information_schema') and n.nspname not like 'pg\_%%'
+	and not exists (select 1 from pg_depend e where e.objid = %s and e.deptype = 'e')`
+
+// Either a transaction or a database.
+type rowQuerier interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+type postgresTable struct {
+	oid      int64
+	schema   string
+	table    string
+	name     string
+	unlogged bool
+	columns  []string
+	copied   []string
+	selects  []string
+	rows     int64
+}
+
+func postgresUserObjects(oid string) string {
+	return fmt.Sprintf(postgresUserObject, oid)
+}
+
+// Runs a query that returns one statement per row.
+func postgresStatements(ctx context.Context, db rowQuerier, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statements := make([]string, 0)
+	for rows.Next() {
+		var statement string
+		if err = rows.Scan(&statement); err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, rows.Err()
+}
+
+func execStatements(ctx context.Context, conn *sql.Conn, statements []string) error {
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return errors.New("Cannot run " + statement + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Some objects can only be created once the objects they use have been, when the order isn't known
+// the statements are retried until no more succeed. The statements that never did are returned.
+func execStatementsInPasses(ctx context.Context, conn *sql.Conn, statements []string) ([]string, error) {
+	var last error
+	for len(statements) > 0 {
+		failed := make([]string, 0)
+		for _, statement := range statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				if ctx.Err() != nil {
+					return statements, ctx.Err()
+				}
+				failed = append(failed, statement)
+				last = errors.New("Cannot run " + statement + ": " + err.Error())
+			}
+		}
+		if len(failed) == len(statements) {
+			return failed, last
+		}
+		statements = failed
+	}
+	return statements, nil
+}
+
+// Copies a postgres database, if rows is false only the schema is copied and the tables are left empty.
+func migratePostgres(ctx context.Context, from string, to string, rows bool, progress func(string)) ([]migratedTable, error) {
+	source, err := sql.Open("postgres", from)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	target, err := sql.Open("postgres", to)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	// every read comes from the same snapshot so the copy is consistent.
+	tx, err := source.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, errors.New("Cannot start the snapshot to copy from: " + err.Error())
+	}
+	defer tx.Rollback()
+	conn, err := target.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// values are copied as text, these keep the text the same on both sides.
+	for _, setting := range []string{"set timezone = 'UTC'", "set extra_float_digits = 3", "set datestyle = 'ISO, YMD'", "set intervalstyle = 'postgres'", "set bytea_output = 'hex'"} {
+		if _, err = tx.ExecContext(ctx, setting); err != nil {
+			return nil, err
+		}
+		if _, err = conn.ExecContext(ctx, setting); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = conn.ExecContext(ctx, "set check_function_bodies = false"); err != nil {
+		return nil, err
+	}
+	var version int
+	if err = tx.QueryRowContext(ctx, "select current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return nil, err
+	}
+	if version >= 100000 {
+		var partitioned bool
+		if err = tx.QueryRowContext(ctx, "select exists (select 1 from pg_class where relkind = 'p')").Scan(&partitioned); err != nil {
+			return nil, err
+		}
+		if partitioned {
+			return nil, errors.New("Databases with partitioned tables cannot be copied.")
+		}
+	}
+
+	progress("copying schema")
+	if err = migratePostgresSchema(ctx, tx, conn); err != nil {
+		return nil, err
+	}
+	functions, err := postgresStatements(ctx, tx, `select pg_get_functiondef(p.oid) from pg_proc p join pg_namespace n on n.oid = p.pronamespace
+		where `+postgresUserObjects("p.oid")+` and `+postgresFunctionKind(version)+` order by p.oid`)
+	if err != nil {
+		return nil, err
+	}
+	// functions that use tables are created once the tables are.
+	functions, _ = execStatementsInPasses(ctx, conn, functions)
+	sequences, err := migratePostgresSequences(ctx, tx, conn, version)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := listPostgresTables(ctx, tx, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if _, err = conn.ExecContext(ctx, table.create()); err != nil {
+			return nil, errors.New("Cannot create table " + table.name + ": " + err.Error())
+		}
+	}
+	functions, _ = execStatementsInPasses(ctx, conn, functions)
+
+	for i := 0; rows && i < len(tables); i++ {
+		if err = copyPostgresTable(ctx, tx, conn, &tables[i], func(copied int64) {
+			progress(fmt.Sprintf("copying table %s (%d of %d, %d rows)", tables[i].name, i+1, len(tables), copied))
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	progress("copying sequences, constraints and indexes")
+	if rows {
+		if err = copyPostgresSequences(ctx, tx, conn, sequences); err != nil {
+			return nil, err
+		}
+	}
+	for _, query := range []string{
+		// sequences are owned by the columns they were created for, so they go when the column does.
+		`select format('alter sequence %I.%I owned by %I.%I.%I', n.nspname, s.relname, tn.nspname, t.relname, a.attname)
+			from pg_depend d join pg_class s on s.oid = d.objid and s.relkind = 'S' join pg_namespace n on n.oid = s.relnamespace
+			join pg_class t on t.oid = d.refobjid join pg_namespace tn on tn.oid = t.relnamespace
+			join pg_attribute a on a.attrelid = t.oid and a.attnum = d.refobjsubid
+			where d.deptype = 'a' and d.classid = 'pg_class'::regclass and d.refclassid = 'pg_class'::regclass and ` + postgresUserObjects("s.oid"),
+		`select format('alter table only %I.%I add constraint %I %s', n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid))
+			from pg_constraint con join pg_class c on c.oid = con.conrelid join pg_namespace n on n.oid = c.relnamespace
+			where con.contype in ('p', 'u', 'c', 'x') and con.conislocal and c.relkind = 'r' and ` + postgresUserObjects("c.oid") + `
+			order by con.oid`,
+		`select pg_get_indexdef(i.indexrelid) from pg_index i join pg_class c on c.oid = i.indrelid join pg_namespace n on n.oid = c.relnamespace
+			where c.relkind = 'r' and ` + postgresUserObjects("c.oid") + `
+			and not exists (select 1 from pg_constraint con where con.conindid = i.indexrelid and con.contype in ('p', 'u', 'x'))
+			order by i.indexrelid`,
+		`select format('alter table only %I.%I add constraint %I %s', n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid))
+			from pg_constraint con join pg_class c on c.oid = con.conrelid join pg_namespace n on n.oid = c.relnamespace
+			where con.contype = 'f' and c.relkind = 'r' and ` + postgresUserObjects("c.oid") + ` order by con.oid`,
+	} {
+		statements, err := postgresStatements(ctx, tx, query)
+		if err != nil {
+			return nil, err
+		}
+		if err = execStatements(ctx, conn, statements); err != nil {
+			return nil, err
+		}
+	}
+
+	progress("copying views and triggers")
+	views, err := postgresStatements(ctx, tx, `select format('create %s %I.%I as %s', case when c.relkind = 'm' then 'materialized view' else 'view' end,
+			n.nspname, c.relname, trim(trailing ';' from pg_get_viewdef(c.oid)))
+		from pg_class c join pg_namespace n on n.oid = c.relnamespace where c.relkind in ('v', 'm') and `+postgresUserObjects("c.oid")+` order by c.oid`)
+	if err != nil {
+		return nil, err
+	}
+	// views can use other views and functions, functions can return views.
+	if failed, err := execStatementsInPasses(ctx, conn, append(views, functions...)); len(failed) > 0 {
+		return nil, err
+	}
+	triggers, err := postgresStatements(ctx, tx, `select pg_get_triggerdef(t.oid) from pg_trigger t join pg_class c on c.oid = t.tgrelid
+		join pg_namespace n on n.oid = c.relnamespace where not t.tgisinternal and `+postgresUserObjects("c.oid")+` order by t.oid`)
+	if err != nil {
+		return nil, err
+	}
+	if err = execStatements(ctx, conn, triggers); err != nil {
+		return nil, err
+	}
+
+	if !rows {
+		return migratedTables(tables), nil
+	}
+	progress("verifying row counts")
+	for _, table := range tables {
+		var copied int64
+		if err = conn.QueryRowContext(ctx, "select count(*) from only "+table.name).Scan(&copied); err != nil {
+			return nil, errors.New("Cannot count the rows copied into " + table.name + ": " + err.Error())
+		}
+		if copied != table.rows {
+			return nil, fmt.Errorf("The table %s has %d rows but %d were copied.", table.name, table.rows, copied)
+		}
+	}
+	return migratedTables(tables), nil
+}
+
+func migratedTables(tables []postgresTable) []migratedTable {
+	migrated := make([]migratedTable, 0)
+	for _, table := range tables {
+		migrated = append(migrated, migratedTable{name: table.name, rows: table.rows})
+	}
+	return migrated
+}
+
+// Sequences aren't part of a table's rows, so their values are copied separately.
+func copyPostgresSequences(ctx context.Context, source rowQuerier, conn *sql.Conn, sequences []string) error {
+	for _, sequence := range sequences {
+		var last int64
+		var called bool
+		if err := source.QueryRowContext(ctx, "select last_value, is_called from "+sequence).Scan(&last, &called); err != nil {
+			return errors.New("Cannot read sequence " + sequence + ": " + err.Error())
+		}
+		if _, err := conn.ExecContext(ctx, "select setval($1, $2, $3)", sequence, last, called); err != nil {
+			return errors.New("Cannot set sequence " + sequence + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func postgresFunctionKind(version int) string {
+	// aggregates and window functions have no definition to copy.
+	if version >= 110000 {
+		return "p.prokind in ('f', 'p')"
+	}
+	return "not p.proisagg and not p.proiswindow"
+}
+
+// Creates the schemas, extensions and types of the database.
+func migratePostgresSchema(ctx context.Context, tx *sql.Tx, conn *sql.Conn) error {
+	for _, query := range []string{
+		`select format('create schema if not exists %I', n.nspname) from pg_namespace n where ` + postgresUserObjects("n.oid") + ` order by n.oid`,
+		`select format('create extension if not exists %I with schema %I', x.extname, n.nspname) from pg_extension x
+			join pg_namespace n on n.oid = x.extnamespace where x.extname <> 'plpgsql' order by x.oid`,
+		`select format('create type %I.%I as enum (%s)', n.nspname, t.typname, string_agg(quote_literal(e.enumlabel), ', ' order by e.enumsortorder))
+			from pg_type t join pg_enum e on e.enumtypid = t.oid join pg_namespace n on n.oid = t.typnamespace
+			where ` + postgresUserObjects("t.oid") + ` group by t.oid, n.nspname, t.typname order by t.oid`,
+		`select format('create type %I.%I as (%s)', n.nspname, t.typname, string_agg(format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod)), ', ' order by a.attnum))
+			from pg_type t join pg_class c on c.oid = t.typrelid and c.relkind = 'c' join pg_attribute a on a.attrelid = c.oid and a.attnum > 0 and not a.attisdropped
+			join pg_namespace n on n.oid = t.typnamespace where ` + postgresUserObjects("t.oid") + ` group by t.oid, n.nspname, t.typname order by t.oid`,
+		`select format('create domain %I.%I as %s', n.nspname, t.typname, format_type(t.typbasetype, t.typtypmod))
+			|| case when t.typnotnull then ' not null' else '' end || coalesce(' default ' || t.typdefault, '')
+			|| coalesce((select string_agg(format(' constraint %I %s', con.conname, pg_get_constraintdef(con.oid)), '' order by con.oid) from pg_constraint con where con.contypid = t.oid), '')
+			from pg_type t join pg_namespace n on n.oid = t.typnamespace where t.typtype = 'd' and ` + postgresUserObjects("t.oid") + ` order by t.oid`,
+	} {
+		statements, err := postgresStatements(ctx, tx, query)
+		if err != nil {
+			return err
+		}
+		if err = execStatements(ctx, conn, statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Creates the sequences that aren't part of identity columns, the names of every sequence are returned
+// so their values can be copied once the rows are.
+func migratePostgresSequences(ctx context.Context, tx *sql.Tx, conn *sql.Conn, version int) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `select format('%I.%I', s.sequence_schema, s.sequence_name), s.data_type, s.start_value, s.minimum_value, s.maximum_value, s.increment, s.cycle_option,
+			exists (select 1 from pg_depend d where d.objid = c.oid and d.deptype = 'i')
+		from information_schema.sequences s join pg_namespace n on n.nspname = s.sequence_schema join pg_class c on c.relnamespace = n.oid and c.relname = s.sequence_name
+		where `+postgresUserObjects("c.oid")+` order by c.oid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sequences := make([]string, 0)
+	statements := make([]string, 0)
+	for rows.Next() {
+		var name, dataType, start, min, max, increment, cycle string
+		var identity bool
+		if err = rows.Scan(&name, &dataType, &start, &min, &max, &increment, &cycle, &identity); err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, name)
+		if identity {
+			continue
+		}
+		statement := "create sequence " + name
+		if version >= 100000 && dataType != "bigint" {
+			statement += " as " + dataType
+		}
+		statement += " increment by " + increment + " minvalue " + min + " maxvalue " + max + " start with " + start
+		if cycle == "YES" {
+			statement += " cycle"
+		}
+		statements = append(statements, statement)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sequences, execStatements(ctx, conn, statements)
+}
+
+func listPostgresTables(ctx context.Context, tx *sql.Tx, version int) ([]postgresTable, error) {
+	rows, err := tx.QueryContext(ctx, `select c.oid, n.nspname, c.relname, format('%I.%I', n.nspname, c.relname), c.relpersistence = 'u'
+		from pg_class c join pg_namespace n on n.oid = c.relnamespace where c.relkind = 'r' and `+postgresUserObjects("c.oid")+` order by c.oid`)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]postgresTable, 0)
+	for rows.Next() {
+		var table postgresTable
+		if err = rows.Scan(&table.oid, &table.schema, &table.table, &table.name, &table.unlogged); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	identity := "''"
+	if version >= 100000 {
+		identity = "case a.attidentity when 'a' then ' generated always as identity' when 'd' then ' generated by default as identity' else '' end"
+	}
+	defaults := "coalesce(' default ' || pg_get_expr(ad.adbin, ad.adrelid), '')"
+	generated := "false"
+	if version >= 120000 {
+		defaults = "case when a.attgenerated = 's' then ' generated always as (' || pg_get_expr(ad.adbin, ad.adrelid) || ') stored' else " + defaults + " end"
+		generated = "a.attgenerated = 's'"
+	}
+	for i := range tables {
+		rows, err := tx.QueryContext(ctx, `select a.attname, format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod))
+				|| case when a.attcollation <> t.typcollation then format(' collate %I.%I', cn.nspname, co.collname) else '' end
+				|| `+defaults+` || `+identity+` || case when a.attnotnull then ' not null' else '' end,
+				a.atttypid = 'bytea'::regtype, `+generated+`
+			from pg_attribute a join pg_type t on t.oid = a.atttypid left join pg_attrdef ad on ad.adrelid = a.attrelid and ad.adnum = a.attnum
+			left join pg_collation co on co.oid = a.attcollation left join pg_namespace cn on cn.oid = co.collnamespace
+			where a.attrelid = $1 and a.attnum > 0 and not a.attisdropped order by a.attnum`, tables[i].oid)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name, definition string
+			var bytea, isGenerated bool
+			if err = rows.Scan(&name, &definition, &bytea, &isGenerated); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tables[i].columns = append(tables[i].columns, definition)
+			if isGenerated {
+				continue
+			}
+			// bytea is the only type copied as is, everything else is copied as text.
+			tables[i].copied = append(tables[i].copied, name)
+			if bytea {
+				tables[i].selects = append(tables[i].selects, pq.QuoteIdentifier(name))
+			} else {
+				tables[i].selects = append(tables[i].selects, pq.QuoteIdentifier(name)+"::text")
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+func (table postgresTable) create() string {
+	if table.unlogged {
+		return "create unlogged table " + table.name + " (" + strings.Join(table.columns, ", ") + ")"
+	}
+	return "create table " + table.name + " (" + strings.Join(table.columns, ", ") + ")"
+}
+
+// Streams the rows of a table into the new database, the rows are counted first so the count can
+// be checked once everything is copied.
+func copyPostgresTable(ctx context.Context, tx *sql.Tx, conn *sql.Conn, table *postgresTable, progress func(int64)) error {
+	progress(0)
+	if err := tx.QueryRowContext(ctx, "select count(*) from only "+table.name).Scan(&table.rows); err != nil {
+		return errors.New("Cannot count the rows of " + table.name + ": " + err.Error())
+	}
+	if table.rows == 0 || len(table.copied) == 0 {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx, "select "+strings.Join(table.selects, ", ")+" from only "+table.name)
+	if err != nil {
+		return errors.New("Cannot read the rows of " + table.name + ": " + err.Error())
+	}
+	defer rows.Close()
+	copyTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer copyTx.Rollback()
+	stmt, err := copyTx.PrepareContext(ctx, pq.CopyInSchema(table.schema, table.table, table.copied...))
+	if err != nil {
+		return errors.New("Cannot copy into " + table.name + ": " + err.Error())
+	}
+	defer stmt.Close()
+	values := make([]interface{}, len(table.copied))
+	pointers := make([]interface{}, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var copied int64
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, values...); err != nil {
+			return errors.New("Cannot copy into " + table.name + ": " + err.Error())
+		}
+		if copied++; copied%100000 == 0 {
+			progress(copied)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return errors.New("Cannot read the rows of " + table.name + ": " + err.Error())
+	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return errors.New("Cannot copy into " + table.name + ": " + err.Error())
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+	return copyTx.Commit()
+}
@@ -0,0 +1,319 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// A replication switchover changes the plan of a postgres database by replicating it into a new
+// database with logical replication, rather than copying it while it's in use. Once the new database
+// has caught up, writes to the old database are blocked just long enough for the last changes and the
+// sequences to be copied, then the database is switched to the new one.
+type ReplicationSwitchover struct {
+	MaxLagBytes    int64 `json:"max_lag_bytes"`    // how far behind the new database can be when writes are blocked
+	CatchUpMinutes int64 `json:"catch_up_minutes"` // how long the new database has to catch up before the switchover is abandoned
+}
+
+const (
+	defaultMaxLagBytes    = 1024 * 1024
+	defaultCatchUpMinutes = 60
+	// how long the last changes have to replicate once writes are blocked.
+	switchoverDrainTimeout = time.Minute * 2
+	replicationPollPeriod  = time.Second * 10
+)
+
+// The switchover asked for by the parameters of a plan change, if any. Parameters are given as
+// {"switchover":"replication", "max_lag_bytes":1048576, "catch_up_minutes":60}, the last two are optional.
+func switchoverFromParameters(parameters map[string]interface{}) (*ReplicationSwitchover, error) {
+	mode, ok := parameters["switchover"]
+	if !ok {
+		return nil, nil
+	}
+	if mode != "replication" {
+		return nil, errors.New("The switchover parameter must be 'replication'.")
+	}
+	switchover := ReplicationSwitchover{MaxLagBytes: defaultMaxLagBytes, CatchUpMinutes: defaultCatchUpMinutes}
+	for name, value := range map[string]*int64{"max_lag_bytes": &switchover.MaxLagBytes, "catch_up_minutes": &switchover.CatchUpMinutes} {
+		if given, ok := parameters[name]; ok {
+			number, ok := given.(float64)
+			if !ok || number <= 0 {
+				return nil, errors.New("The " + name + " parameter must be a positive number.")
+			}
+			*value = int64(number)
+		}
+	}
+	return &switchover, nil
+}
+
+// Replicates a postgres database into a new one and switches to it, switch records the new database
+// and is only called once it has every change. If anything fails before the switch, replication is
+// torn down and writes to the old database are allowed again, the new database is left for the
+// caller to remove. Writes stay blocked on the old database after the switch.
+func replicateDatabase(ctx context.Context, from *DbInstance, to *DbInstance, switchover ReplicationSwitchover, progress func(string), switchTo func() error) (string, error) {
+	if migrationEngine(from.Engine) != "postgres" || migrationEngine(to.Engine) != "postgres" {
+		return "", errors.New("Only postgres databases can be switched over with replication.")
+	}
+	source, err := sql.Open("postgres", migrationConnection(from))
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+	// one connection, so ending the owners other sessions never ends our own.
+	source.SetMaxOpenConns(1)
+	target, err := sql.Open("postgres", migrationConnection(to))
+	if err != nil {
+		return "", err
+	}
+	defer target.Close()
+	if err = checkPostgresReplication(ctx, source); err != nil {
+		return "", err
+	}
+	if err = checkPostgresSubscription(ctx, target); err != nil {
+		return "", err
+	}
+
+	tables, err := migratePostgres(ctx, migrationConnection(from), migrationConnection(to), false, progress)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0)
+	for _, table := range tables {
+		names = append(names, table.name)
+	}
+	replication := postgresReplication{
+		name:   "switchover_" + strings.ToLower(RandomString(8)),
+		source: source,
+		target: target,
+	}
+	if err = replication.start(ctx, migrationConnection(from), names); err != nil {
+		replication.stop()
+		return "", err
+	}
+	if err = replication.catchUp(ctx, switchover, progress); err != nil {
+		replication.stop()
+		return "", err
+	}
+
+	progress("blocking writes and waiting for the last changes")
+	blocked := time.Now()
+	if err = replication.blockWrites(ctx); err != nil {
+		replication.stop()
+		replication.allowWrites()
+		return "", err
+	}
+	if err = replication.drain(ctx); err != nil {
+		replication.stop()
+		replication.allowWrites()
+		return "", err
+	}
+	conn, err := target.Conn(ctx)
+	if err != nil {
+		replication.stop()
+		replication.allowWrites()
+		return "", err
+	}
+	defer conn.Close()
+	sequences, err := postgresStatements(ctx, source, `select format('%I.%I', n.nspname, c.relname) from pg_class c join pg_namespace n on n.oid = c.relnamespace
+		where c.relkind = 'S' and `+postgresUserObjects("c.oid"))
+	if err == nil {
+		err = copyPostgresSequences(ctx, source, conn, sequences)
+	}
+	if err != nil {
+		replication.stop()
+		replication.allowWrites()
+		return "", err
+	}
+	replication.stop()
+	progress("switching to " + to.Name)
+	if err = switchTo(); err != nil {
+		replication.allowWrites()
+		return "", err
+	}
+	return fmt.Sprintf("Switched %s to %s with replication of %d tables, writes were blocked for %s.", from.Name, to.Name, len(tables), time.Since(blocked).Round(time.Second)), nil
+}
+
+// Logical replication needs the old database to keep enough in its write ahead log to decode it, and every
+// table needs a primary key (or replica identity) or updates and deletes to it would fail once published.
+func checkPostgresReplication(ctx context.Context, source *sql.DB) error {
+	var level string
+	if err := source.QueryRowContext(ctx, "show wal_level").Scan(&level); err != nil {
+		return err
+	}
+	if level != "logical" {
+		return errors.New("The database cannot be replicated as its wal_level is " + level + " rather than logical.")
+	}
+	missing, err := postgresStatements(ctx, source, `select format('%I.%I', n.nspname, c.relname) from pg_class c join pg_namespace n on n.oid = c.relnamespace
+		where c.relkind = 'r' and `+postgresUserObjects("c.oid")+` and (c.relreplident = 'n' or (c.relreplident = 'd'
+		and not exists (select 1 from pg_constraint con where con.conrelid = c.oid and con.contype = 'p'))) order by 1`)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return errors.New("The database cannot be replicated as these tables have no primary key: " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Returned by replicateDatabase when the new database can't subscribe to the old one, before anything is copied.
+var errCannotSubscribe = errors.New("The owner of the new database cannot create subscriptions, it must be a superuser or rds_superuser.")
+
+// Creating a subscription needs a superuser, or on aws the rds_superuser role (pg_create_subscription on
+// postgres 16 and above).
+func checkPostgresSubscription(ctx context.Context, target *sql.DB) error {
+	var allowed bool
+	if err := target.QueryRowContext(ctx, `select exists (select 1 from pg_roles where rolname = current_user and rolsuper)
+		or exists (select 1 from pg_roles where rolname in ('rds_superuser', 'pg_create_subscription') and pg_has_role(current_user, oid, 'member'))`).Scan(&allowed); err != nil {
+		return err
+	}
+	if !allowed {
+		return errCannotSubscribe
+	}
+	return nil
+}
+
+// The publication, subscription and replication slot share a name.
+type postgresReplication struct {
+	name   string
+	source *sql.DB
+	target *sql.DB
+	owner  string // set once writes are blocked on the source
+}
+
+func (replication postgresReplication) start(ctx context.Context, connection string, tables []string) error {
+	publication := "create publication " + pq.QuoteIdentifier(replication.name) + " for table " + strings.Join(tables, ", ")
+	if len(tables) == 0 {
+		// a subscription still needs a publication to connect to, even if there's nothing in it.
+		publication = "create publication " + pq.QuoteIdentifier(replication.name)
+	}
+	if _, err := replication.source.ExecContext(ctx, publication); err != nil {
+		return errors.New("Cannot publish the tables of the database: " + err.Error())
+	}
+	if _, err := replication.target.ExecContext(ctx, "create subscription "+pq.QuoteIdentifier(replication.name)+
+		" connection '"+strings.Replace(connection, "'", "''", -1)+"' publication "+pq.QuoteIdentifier(replication.name)); err != nil {
+		return errors.New("Cannot subscribe to the database: " + err.Error())
+	}
+	return nil
+}
+
+// The number of tables still having their rows copied and how many bytes of changes the new database
+// is behind by.
+func (replication postgresReplication) lag(ctx context.Context) (int64, int64, error) {
+	var copying, behind int64
+	if err := replication.target.QueryRowContext(ctx, `select count(*) from pg_subscription_rel r join pg_subscription s on s.oid = r.srsubid
+		where s.subname = $1 and r.srsubstate not in ('r', 's')`, replication.name).Scan(&copying); err != nil {
+		return 0, 0, err
+	}
+	if err := replication.source.QueryRowContext(ctx, `select coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn), -1)::bigint
+		from pg_replication_slots where slot_name = $1`, replication.name).Scan(&behind); err != nil {
+		return 0, 0, errors.New("Cannot find the replication slot: " + err.Error())
+	}
+	return copying, behind, nil
+}
+
+// Waits for the rows of every table to be copied and the new database to be within the lag allowed,
+// giving up if that doesn't happen in time.
+func (replication postgresReplication) catchUp(ctx context.Context, switchover ReplicationSwitchover, progress func(string)) error {
+	deadline := time.Now().Add(time.Duration(switchover.CatchUpMinutes) * time.Minute)
+	t := time.NewTicker(replicationPollPeriod)
+	defer t.Stop()
+	for {
+		copying, behind, err := replication.lag(ctx)
+		if err != nil {
+			return err
+		}
+		if copying == 0 && behind >= 0 && behind <= switchover.MaxLagBytes {
+			return nil
+		}
+		status := fmt.Sprintf("%d tables copying, %d bytes behind", copying, behind)
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Replication did not catch up within %d minutes (%s), the switchover was abandoned.", switchover.CatchUpMinutes, status)
+		}
+		progress("replicating: " + status)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Logins by the owner of the old database are disabled and its other sessions are ended, so nothing else
+// can be written while the last changes replicate. Our own session stays open to allow them again.
+func (replication *postgresReplication) blockWrites(ctx context.Context) error {
+	var owner string
+	if err := replication.source.QueryRowContext(ctx, "select current_user").Scan(&owner); err != nil {
+		return err
+	}
+	if _, err := replication.source.ExecContext(ctx, "alter role "+pq.QuoteIdentifier(owner)+" nologin"); err != nil {
+		return errors.New("Cannot block writes to the database: " + err.Error())
+	}
+	replication.owner = owner
+	if _, err := replication.source.ExecContext(ctx, `select pg_terminate_backend(pid) from pg_stat_activity
+		where datname = current_database() and usename = current_user and pid <> pg_backend_pid() and backend_type = 'client backend'`); err != nil {
+		return errors.New("Cannot end the sessions writing to the database: " + err.Error())
+	}
+	return nil
+}
+
+func (replication postgresReplication) allowWrites() {
+	if replication.owner == "" {
+		return
+	}
+	if _, err := replication.source.ExecContext(context.Background(), "alter role "+pq.QuoteIdentifier(replication.owner)+" login"); err != nil {
+		glog.Errorf("ERROR: Logins by %s are still disabled after the switchover failed: %s\n", replication.owner, err.Error())
+	}
+}
+
+// Waits for every change made before writes were blocked to reach the new database.
+func (replication postgresReplication) drain(ctx context.Context) error {
+	var position string
+	if err := replication.source.QueryRowContext(ctx, "select pg_current_wal_lsn()::text").Scan(&position); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(switchoverDrainTimeout)
+	for {
+		var drained bool
+		if err := replication.source.QueryRowContext(ctx, "select confirmed_flush_lsn >= $2::pg_lsn from pg_replication_slots where slot_name = $1",
+			replication.name, position).Scan(&drained); err != nil {
+			return err
+		}
+		if drained {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("The last changes did not replicate in time, the switchover was abandoned.")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Removes the subscription, publication and replication slot. Errors are only logged, as a slot left
+// behind keeps write ahead log on the old database it's worth knowing about.
+func (replication postgresReplication) stop() {
+	ctx := context.Background()
+	subscription := pq.QuoteIdentifier(replication.name)
+	if _, err := replication.target.ExecContext(ctx, "drop subscription if exists "+subscription); err != nil {
+		// without the old database the slot can't be dropped with the subscription.
+		replication.target.ExecContext(ctx, "alter subscription "+subscription+" disable")
+		replication.target.ExecContext(ctx, "alter subscription "+subscription+" set (slot_name = none)")
+		if _, err = replication.target.ExecContext(ctx, "drop subscription if exists "+subscription); err != nil {
+			glog.Errorf("Unable to drop the subscription %s: %s\n", replication.name, err.Error())
+		}
+	}
+	if _, err := replication.source.ExecContext(ctx, "select pg_drop_replication_slot(slot_name) from pg_replication_slots where slot_name = $1 and not active", replication.name); err != nil {
+		glog.Errorf("ERROR: The replication slot %s could not be dropped, it must be removed by hand: %s\n", replication.name, err.Error())
+	}
+	if _, err := replication.source.ExecContext(ctx, "drop publication if exists "+subscription); err != nil {
+		glog.Errorf("Unable to drop the publication %s: %s\n", replication.name, err.Error())
+	}
+}
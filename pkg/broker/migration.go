@@ -0,0 +1,216 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// A TenantMover is a provider that moves a database itself when it changes to another plan of the
+// same provider, rather than having a new database provisioned and the data copied over. The database
+// keeps its name and credentials, and the read only roles given are recreated with the same credentials.
+// Progress is reported as a short description of the step being taken.
+type TenantMover interface {
+	MoveTenant(context.Context, *DbInstance, *ProviderPlan, []DatabaseUrlSpec, func(string)) (*DbInstance, error)
+}
+
+// The steps to move a database from one host of a shared provider to another, each provider
+// fills these in for its engine.
+type sharedTenantMove struct {
+	name   string
+	from   string       // the key of the host the database is on
+	to     string       // the uri of the host the database is moving to
+	create func() error // creates the owner and an empty database on the new host
+	pause  func() error // stops and locks out connections to the database on the old host
+	resume func() error // lets connections back in on the old host, if the move fails
+	copy   func() error // copies the schema and data to the new host
+	roles  func() error // recreates the read only roles on the new host
+	remove func() error // removes the database from the new host, if the move fails
+	drop   func() error // removes the database from the old host, once moved
+}
+
+// Runs the move, the database is unavailable from when it is paused until its host is switched. If a
+// step fails before the switch the new host is cleaned up and the database is left where it was.
+func (move sharedTenantMove) run(progress func(string)) error {
+	to := sharedHostKey(move.to)
+	undo := func(paused bool, err error) error {
+		if paused {
+			if rerr := move.resume(); rerr != nil {
+				glog.Errorf("ERROR: Unable to let connections back in to %s on %s after a failed move: %s\n", move.name, move.from, rerr.Error())
+			}
+		}
+		if rerr := move.remove(); rerr != nil {
+			glog.Errorf("ERROR: Orphaned Database! Unable to remove %s from %s after a failed move: %s\n", move.name, to, rerr.Error())
+		}
+		return err
+	}
+	progress("creating database on " + to)
+	if err := move.create(); err != nil {
+		return undo(false, errors.New("Cannot create the database on "+to+": "+err.Error()))
+	}
+	progress("pausing connections on " + move.from)
+	if err := move.pause(); err != nil {
+		return undo(true, errors.New("Cannot pause connections to the database on "+move.from+": "+err.Error()))
+	}
+	progress("copying data to " + to)
+	if err := move.copy(); err != nil {
+		return undo(true, errors.New("Cannot copy the database to "+to+": "+err.Error()))
+	}
+	progress("recreating roles on " + to)
+	if err := move.roles(); err != nil {
+		return undo(true, errors.New("Cannot recreate the read only roles on "+to+": "+err.Error()))
+	}
+	progress("switching to " + to)
+	if err := recordSharedTenant(move.name, move.to); err != nil {
+		return undo(true, errors.New("Cannot record the new host of the database: "+err.Error()))
+	}
+	progress("removing database from " + move.from)
+	if err := move.drop(); err != nil {
+		// the database has already moved so this isn't a failure, but someone needs to clean it up.
+		glog.Errorf("ERROR: Orphaned Database! Unable to remove %s from %s after it moved to %s: %s\n", move.name, move.from, to, err.Error())
+	}
+	return nil
+}
+
+// Picks the uri of the host a database should be on after it changes to a plan, it stays where it is if
+// the plan still has its host and the host isn't draining, otherwise it's placed like a new database.
+func moveSharedTenantTo(fromUri string, masterUri string, hosts []SharedHost, placement string, hostSize func(string) (int64, error)) (string, error) {
+	for _, host := range hosts {
+		if host.Key() == sharedHostKey(fromUri) && !host.Draining {
+			return host.Uri, nil
+		}
+	}
+	return placeSharedTenant(masterUri, hosts, placement, hostSize)
+}
+
+// Takes the password out of a uri so it can be given to a command through its environment, arguments
+// can be seen by anyone on the machine.
+func withoutPassword(uri string) (string, string) {
+	db, err := url.Parse(uri)
+	if err != nil || db.User == nil {
+		return uri, ""
+	}
+	password, _ := db.User.Password()
+	db.User = url.User(db.User.Username())
+	return db.String(), password
+}
+
+// Runs from with its output piped into to, neither is run through a shell so nothing in their
+// arguments is interpreted. Errors include the end of the failing commands stderr.
+func pipeCommands(ctx context.Context, from *exec.Cmd, to *exec.Cmd) error {
+	var fromErr, toErr bytes.Buffer
+	from.Stderr = &fromErr
+	to.Stderr = &toErr
+	pipe, err := from.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	to.Stdin = pipe
+	if err = to.Start(); err != nil {
+		return err
+	}
+	if err = from.Run(); err != nil {
+		to.Wait()
+		return errors.New(commandName(from) + " failed: " + err.Error() + " " + stderrTail(fromErr))
+	}
+	if err = to.Wait(); err != nil {
+		return errors.New(commandName(to) + " failed: " + err.Error() + " " + stderrTail(toErr))
+	}
+	return ctx.Err()
+}
+
+// Runs a command reading from in and writing to out, either can be nil. Errors include the end of its stderr.
+func runCommand(cmd *exec.Cmd, in io.Reader, out io.Writer) error {
+	var stderr bytes.Buffer
+	cmd.Stdin = in
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New(commandName(cmd) + " failed: " + err.Error() + " " + stderrTail(stderr))
+	}
+	return nil
+}
+
+func commandName(cmd *exec.Cmd) string {
+	if len(cmd.Args) == 0 {
+		return cmd.Path
+	}
+	return cmd.Args[0]
+}
+
+func stderrTail(out bytes.Buffer) string {
+	tail := strings.TrimSpace(out.String())
+	if len(tail) > 1024 {
+		tail = tail[len(tail)-1024:]
+	}
+	return tail
+}
+
+type migratedTable struct {
+	name string
+	rows int64
+}
+
+// The engine databases are copied between providers with, for the engine a provider reports. Engines
+// that can't be copied return an empty string.
+func migrationEngine(engine string) string {
+	engine = strings.ToLower(engine)
+	if strings.Contains(engine, "postgres") {
+		return "postgres"
+	}
+	if strings.Contains(engine, "mysql") || engine == "aurora" || engine == "mariadb" {
+		return "mysql"
+	}
+	return ""
+}
+
+// How the migration connects to a database as its owner, this is a url for postgres and a dsn for mysql.
+func migrationConnection(dbInstance *DbInstance) string {
+	endpoint := dbInstance.Endpoint
+	if !strings.Contains(endpoint, "/") {
+		endpoint = endpoint + "/" + dbInstance.Name
+	}
+	if migrationEngine(dbInstance.Engine) == "mysql" {
+		if !strings.HasPrefix(endpoint, "tcp(") {
+			parts := strings.SplitN(endpoint, "/", 2)
+			endpoint = "tcp(" + parts[0] + ")/" + parts[1]
+		}
+		return dbInstance.Username + ":" + dbInstance.Password + "@" + endpoint
+	}
+	return "postgres://" + url.UserPassword(dbInstance.Username, dbInstance.Password).String() + "@" + endpoint
+}
+
+// Copies the schema and rows of a database into a new empty database of the same engine, this is how a
+// database changes providers. Progress is reported as a short description of what's being copied, the
+// rows in every table are counted on both sides once copied and any difference fails the copy. A summary
+// of what was copied is returned.
+func migrateDatabase(ctx context.Context, from *DbInstance, to *DbInstance, progress func(string)) (string, error) {
+	engine := migrationEngine(from.Engine)
+	if engine == "" {
+		return "", errors.New("Only postgres and mysql databases can be copied to another provider.")
+	}
+	if migrationEngine(to.Engine) != engine {
+		return "", errors.New("Cannot copy a " + from.Engine + " database into a " + to.Engine + " database.")
+	}
+	var tables []migratedTable
+	var err error
+	if engine == "postgres" {
+		tables, err = migratePostgres(ctx, migrationConnection(from), migrationConnection(to), true, progress)
+	} else {
+		tables, err = migrateMysql(ctx, migrationConnection(from), migrationConnection(to), progress)
+	}
+	if err != nil {
+		return "", err
+	}
+	var rows int64
+	for _, table := range tables {
+		rows += table.rows
+	}
+	return fmt.Sprintf("Copied %d tables (%d rows) from %s to %s.", len(tables), rows, from.Name, to.Name), nil
+}
@@ -0,0 +1,444 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dumps of mysql databases are plain sql that the mysql client can also load. Names in the dump are
+// not qualified with the database and definers are left out, so a dump can be loaded into any
+// database by its owner and everything it creates is owned by them.
+
+const mysqlInsertSize = 512 * 1024
+
+var mysqlDefiner = regexp.MustCompile("DEFINER=`[^`]*`@`[^`]*` ")
+
+func quoteMysqlName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func quoteMysqlString(value string) string {
+	var out strings.Builder
+	out.WriteByte('\'')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case 0:
+			out.WriteString(`\0`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\'':
+			out.WriteString(`\'`)
+		case '"':
+			out.WriteString(`\"`)
+		case '\032':
+			out.WriteString(`\Z`)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	out.WriteByte('\'')
+	return out.String()
+}
+
+// Writes a column value as a sql literal, the type is the columns database type name.
+func mysqlValue(value interface{}, columnType string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999") + "'"
+	case []byte:
+		switch columnType {
+		case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR":
+			return string(v)
+		case "BIT", "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY":
+			if len(v) == 0 {
+				return "''"
+			}
+			return "0x" + hex.EncodeToString(v)
+		}
+		return quoteMysqlString(string(v))
+	}
+	return quoteMysqlString(fmt.Sprint(value))
+}
+
+type mysqlDumpTable struct {
+	name string
+	view bool
+	rows int64
+}
+
+// Dumps the schema and rows of a database as they were at a single point in time, tables are read
+// in one consistent snapshot so (for transactional tables) the dump never has half of a change in it.
+// Progress is an estimated percentage, as the row counts of tables are estimates. If dumped is given
+// it's called with the number of rows in each table once the table is dumped.
+func dumpMysqlDatabase(ctx context.Context, dsn string, name string, out io.Writer, progress func(int64), dumped func(string, int64)) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, statement := range []string{
+		"SET NAMES utf8mb4",
+		"SET time_zone = '+00:00'",
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT",
+	} {
+		if _, err = conn.ExecContext(ctx, statement); err != nil {
+			return errors.New("Cannot start the snapshot to dump from: " + err.Error())
+		}
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	tables, err := listMysqlDumpTables(ctx, conn, name)
+	if err != nil {
+		return err
+	}
+	var done, reported int64
+	var total int64 = 1
+	for _, table := range tables {
+		total += table.rows + 1
+	}
+	advance := func(rows int64) {
+		done += rows
+		if percent := done * 100 / total; percent > reported && percent < 100 {
+			reported = percent
+			progress(percent)
+		}
+	}
+
+	w := bufio.NewWriterSize(out, mysqlInsertSize)
+	fmt.Fprintf(w, "-- Logical backup of %s taken %s\n", quoteMysqlName(name), time.Now().UTC().Format(time.RFC3339))
+	w.WriteString("SET NAMES utf8mb4;\nSET time_zone = '+00:00';\nSET FOREIGN_KEY_CHECKS = 0;\nSET UNIQUE_CHECKS = 0;\nSET SQL_MODE = 'NO_AUTO_VALUE_ON_ZERO';\n\n")
+	for _, table := range tables {
+		if table.view {
+			continue
+		}
+		rows, err := dumpMysqlTable(ctx, conn, name, table.name, w, advance)
+		if err != nil {
+			return err
+		}
+		advance(1)
+		if dumped != nil {
+			dumped(table.name, rows)
+		}
+	}
+	views := make(map[string]string)
+	for _, table := range tables {
+		if !table.view {
+			continue
+		}
+		var viewName, create, charset, collation string
+		if err = conn.QueryRowContext(ctx, "SHOW CREATE VIEW "+quoteMysqlName(table.name)).Scan(&viewName, &create, &charset, &collation); err != nil {
+			return errors.New("Cannot get the definition of view " + table.name + ": " + err.Error())
+		}
+		views[table.name] = strings.Replace(mysqlDefiner.ReplaceAllString(create, ""), quoteMysqlName(name)+".", "", -1)
+	}
+	for _, view := range orderMysqlViews(views) {
+		fmt.Fprintf(w, "DROP VIEW IF EXISTS %s;\n%s;\n\n", quoteMysqlName(view), views[view])
+		advance(1)
+	}
+	if err = dumpMysqlTriggers(ctx, conn, name, w); err != nil {
+		return err
+	}
+	w.WriteString("SET FOREIGN_KEY_CHECKS = 1;\nSET UNIQUE_CHECKS = 1;\n")
+	return w.Flush()
+}
+
+func listMysqlDumpTables(ctx context.Context, conn *sql.Conn, name string) ([]mysqlDumpTable, error) {
+	rows, err := conn.QueryContext(ctx, "select table_name, table_type, coalesce(table_rows, 0) from information_schema.tables where table_schema = ? order by table_name", name)
+	if err != nil {
+		return nil, errors.New("Cannot list the tables to dump: " + err.Error())
+	}
+	defer rows.Close()
+	tables := make([]mysqlDumpTable, 0)
+	for rows.Next() {
+		var table mysqlDumpTable
+		var tableType string
+		if err = rows.Scan(&table.name, &tableType, &table.rows); err != nil {
+			return nil, err
+		}
+		table.view = tableType == "VIEW"
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// Views are created after the views they select from, views are given by name with their definitions.
+func orderMysqlViews(views map[string]string) []string {
+	names := make([]string, 0)
+	for view := range views {
+		names = append(names, view)
+	}
+	sort.Strings(names)
+	ordered := make([]string, 0)
+	added := make(map[string]bool)
+	for len(ordered) < len(names) {
+		progressed := false
+		for _, view := range names {
+			if added[view] {
+				continue
+			}
+			ready := true
+			for _, other := range names {
+				if other != view && !added[other] && strings.Contains(views[view], quoteMysqlName(other)) {
+					ready = false
+				}
+			}
+			if ready {
+				ordered = append(ordered, view)
+				added[view] = true
+				progressed = true
+			}
+		}
+		// views that refer to each other can't be created anyway, the load will say why.
+		if !progressed {
+			for _, view := range names {
+				if !added[view] {
+					ordered = append(ordered, view)
+					added[view] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// Generated columns are computed by mysql and can't be inserted into, extra is the extra column of
+// information_schema.columns (e.g., "VIRTUAL GENERATED"). Columns with an expression as their default
+// are DEFAULT_GENERATED and are inserted as normal.
+func isMysqlGeneratedColumn(extra string) bool {
+	extra = strings.ToUpper(extra)
+	return strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED")
+}
+
+// The columns of a table the dump inserts into, in the order they're defined.
+func listMysqlDumpColumns(ctx context.Context, conn *sql.Conn, name string, table string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "select column_name, extra from information_schema.columns where table_schema = ? and table_name = ? order by ordinal_position", name, table)
+	if err != nil {
+		return nil, errors.New("Cannot list the columns of table " + table + ": " + err.Error())
+	}
+	defer rows.Close()
+	columns := make([]string, 0)
+	for rows.Next() {
+		var column, extra string
+		if err = rows.Scan(&column, &extra); err != nil {
+			return nil, err
+		}
+		if !isMysqlGeneratedColumn(extra) {
+			columns = append(columns, column)
+		}
+	}
+	return columns, rows.Err()
+}
+
+func quoteMysqlNames(names []string) string {
+	quoted := make([]string, 0)
+	for _, name := range names {
+		quoted = append(quoted, quoteMysqlName(name))
+	}
+	return strings.Join(quoted, ",")
+}
+
+func dumpMysqlTable(ctx context.Context, conn *sql.Conn, name string, table string, w *bufio.Writer, advance func(int64)) (int64, error) {
+	var tableName, create string
+	if err := conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteMysqlName(table)).Scan(&tableName, &create); err != nil {
+		return 0, errors.New("Cannot get the definition of table " + table + ": " + err.Error())
+	}
+	fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\n%s;\n\n", quoteMysqlName(table), create)
+
+	names, err := listMysqlDumpColumns(ctx, conn, name, table)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT "+quoteMysqlNames(names)+" FROM "+quoteMysqlName(table))
+	if err != nil {
+		return 0, errors.New("Cannot read the rows of table " + table + ": " + err.Error())
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var insert strings.Builder
+	var pending, count int64
+	flush := func() {
+		if insert.Len() > 0 {
+			w.WriteString(insert.String())
+			w.WriteString(";\n")
+			insert.Reset()
+			advance(pending)
+			pending = 0
+		}
+	}
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return 0, err
+		}
+		if insert.Len() == 0 {
+			insert.WriteString("INSERT INTO " + quoteMysqlName(table) + " (" + quoteMysqlNames(names) + ") VALUES (")
+		} else {
+			insert.WriteString(",(")
+		}
+		for i, value := range values {
+			if i > 0 {
+				insert.WriteByte(',')
+			}
+			insert.WriteString(mysqlValue(value, columns[i].DatabaseTypeName()))
+		}
+		insert.WriteByte(')')
+		pending++
+		count++
+		if insert.Len() >= mysqlInsertSize {
+			flush()
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.New("Cannot read the rows of table " + table + ": " + err.Error())
+	}
+	flush()
+	w.WriteString("\n")
+	return count, nil
+}
+
+func dumpMysqlTriggers(ctx context.Context, conn *sql.Conn, name string, w *bufio.Writer) error {
+	rows, err := conn.QueryContext(ctx, "select trigger_name from information_schema.triggers where trigger_schema = ? order by trigger_name", name)
+	if err != nil {
+		return errors.New("Cannot list the triggers to dump: " + err.Error())
+	}
+	triggers := make([]string, 0)
+	for rows.Next() {
+		var trigger string
+		if err = rows.Scan(&trigger); err != nil {
+			rows.Close()
+			return err
+		}
+		triggers = append(triggers, trigger)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, trigger := range triggers {
+		// the columns returned differ between versions, so the statement is found by its name.
+		rows, err := conn.QueryContext(ctx, "SHOW CREATE TRIGGER "+quoteMysqlName(trigger))
+		if err != nil {
+			return errors.New("Cannot get the definition of trigger " + trigger + ": " + err.Error())
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		values := make([]sql.RawBytes, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		create := ""
+		if rows.Next() {
+			if err = rows.Scan(pointers...); err != nil {
+				rows.Close()
+				return err
+			}
+			for i, column := range columns {
+				if column == "SQL Original Statement" {
+					create = string(values[i])
+				}
+			}
+		}
+		rows.Close()
+		if create == "" {
+			return errors.New("Cannot get the definition of trigger " + trigger)
+		}
+		fmt.Fprintf(w, "DROP TRIGGER IF EXISTS %s;\nDELIMITER ;;\n%s;;\nDELIMITER ;\n\n", quoteMysqlName(trigger), mysqlDefiner.ReplaceAllString(create, ""))
+	}
+	return nil
+}
+
+// Loads a dump taken by dumpMysqlDatabase into the database the dsn points to.
+func loadMysqlDump(ctx context.Context, dsn string, in io.Reader) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return splitMysqlDump(in, func(query string) error {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			if len(query) > 200 {
+				query = query[:200] + "..."
+			}
+			return errors.New("Cannot load the statement " + query + ": " + err.Error())
+		}
+		return nil
+	})
+}
+
+// Splits a dump into its statements, statements end at the end of a line with the current delimiter.
+func splitMysqlDump(in io.Reader, run func(string) error) error {
+	reader := bufio.NewReaderSize(in, mysqlInsertSize)
+	delimiter := ";"
+	var statement strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			trimmed := strings.TrimSpace(line)
+			if statement.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "-- ")) {
+				// nothing to run
+			} else if statement.Len() == 0 && strings.HasPrefix(trimmed, "DELIMITER ") {
+				delimiter = strings.TrimSpace(strings.TrimPrefix(trimmed, "DELIMITER "))
+			} else {
+				statement.WriteString(line)
+				if strings.HasSuffix(trimmed, delimiter) {
+					query := strings.TrimSuffix(strings.TrimSpace(statement.String()), delimiter)
+					statement.Reset()
+					if err := run(query); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(statement.String()) != "" {
+		return errors.New("The dump ended part way through a statement.")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMysqlDump(t *testing.T) {
+	Convey("Given values from a mysql table", t, func() {
+		Convey("Ensure values are written as literals mysql reads back the same.", func() {
+			So(quoteMysqlName("we`ird"), ShouldEqual, "`we``ird`")
+			So(quoteMysqlString("it's a \\ \"test\"\n"), ShouldEqual, `'it\'s a \\ \"test\"\n'`)
+			So(mysqlValue(nil, "VARCHAR"), ShouldEqual, "NULL")
+			So(mysqlValue([]byte("42"), "INT"), ShouldEqual, "42")
+			So(mysqlValue([]byte("1.50"), "DECIMAL"), ShouldEqual, "1.50")
+			So(mysqlValue([]byte{0, 255}, "BLOB"), ShouldEqual, "0x00ff")
+			So(mysqlValue([]byte{}, "VARBINARY"), ShouldEqual, "''")
+			So(mysqlValue([]byte("text"), "VARCHAR"), ShouldEqual, "'text'")
+			So(mysqlValue(int64(-7), "BIGINT"), ShouldEqual, "-7")
+			So(mysqlValue(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC), "DATETIME"), ShouldEqual, "'2019-01-02 03:04:05'")
+		})
+
+		Convey("Ensure generated columns are left out of inserts.", func() {
+			So(isMysqlGeneratedColumn("VIRTUAL GENERATED"), ShouldEqual, true)
+			So(isMysqlGeneratedColumn("STORED GENERATED"), ShouldEqual, true)
+			So(isMysqlGeneratedColumn("DEFAULT_GENERATED"), ShouldEqual, false)
+			So(isMysqlGeneratedColumn("auto_increment"), ShouldEqual, false)
+			So(isMysqlGeneratedColumn(""), ShouldEqual, false)
+			So(quoteMysqlNames([]string{"id", "na`me"}), ShouldEqual, "`id`,`na``me`")
+		})
+
+		Convey("Ensure views are created after the views they select from.", func() {
+			views := map[string]string{
+				"a_totals": "CREATE VIEW `a_totals` AS select sum(`n`) from `b_items`",
+				"b_items":  "CREATE VIEW `b_items` AS select `n` from `items`",
+				"c_other":  "CREATE VIEW `c_other` AS select 1",
+			}
+			So(orderMysqlViews(views), ShouldResemble, []string{"b_items", "c_other", "a_totals"})
+		})
+
+		Convey("Ensure dumps are split into statements, including triggers.", func() {
+			dump := "-- Logical backup\nSET NAMES utf8mb4;\n\nCREATE TABLE `t` (\n  `id` int\n);\n" +
+				"INSERT INTO `t` VALUES (1),(2);\nDELIMITER ;;\nCREATE TRIGGER `tr` BEFORE INSERT ON `t` FOR EACH ROW BEGIN\n  SET NEW.id = 1;\nEND;;\nDELIMITER ;\n"
+			var statements []string
+			So(splitMysqlDump(strings.NewReader(dump), func(statement string) error {
+				statements = append(statements, statement)
+				return nil
+			}), ShouldBeNil)
+			So(statements, ShouldResemble, []string{
+				"SET NAMES utf8mb4",
+				"CREATE TABLE `t` (\n  `id` int\n)",
+				"INSERT INTO `t` VALUES (1),(2)",
+				"CREATE TRIGGER `tr` BEFORE INSERT ON `t` FOR EACH ROW BEGIN\n  SET NEW.id = 1;\nEND",
+			})
+			So(splitMysqlDump(strings.NewReader("CREATE TABLE `t` (\n"), func(string) error { return nil }), ShouldNotBeNil)
+			So(splitMysqlDump(strings.NewReader("SELECT 1;\n"), func(string) error { return errors.New("failed") }), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMysqlSharedBackups(t *testing.T) {
+	if os.Getenv("TEST_SHARED_MYSQL") == "" {
+		return
+	}
+	ctx := context.TODO()
+	Convey("Given a shared mysql plan with a backup location.", t, func() {
+		dir, err := ioutil.TempDir("", "backups")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		records := newFakeLogicalBackupStore()
+		SetLogicalBackupStore(records)
+		defer SetLogicalBackupStore(nil)
+
+		plan := &ProviderPlan{
+			ID:                     "backup-test-plan",
+			Provider:               MysqlShared,
+			Scheme:                 "mysql",
+			providerPrivateDetails: `{"master_uri":"` + os.Getenv("MYSQL_URL") + `", "engine":"mysql", "engine_version":"5.7", "scheme_type":"dsn", "backup_location":"file://` + dir + `"}`,
+		}
+		provider, err := NewMysqlSharedProvider("test")
+		So(err, ShouldBeNil)
+		So(provider.Capabilities(plan).Has(BackupsCapability), ShouldEqual, true)
+		dbInstance, err := provider.Provision(ctx, "backup-test-instance", plan, "owner")
+		So(err, ShouldBeNil)
+		dbInstance.Plan = plan
+
+		db, err := sql.Open("mysql", dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint)
+		So(err, ShouldBeNil)
+		_, err = db.Exec("create table test_table (id int, name varchar(20), data blob, doubled int as (id * 2) stored)")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("insert into test_table values (1, 'it''s', x'00ff')")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("create view test_view as select id from test_table")
+		So(err, ShouldBeNil)
+
+		backup, err := provider.CreateBackup(ctx, dbInstance)
+		So(err, ShouldBeNil)
+		So(*backup.Status, ShouldEqual, "creating")
+		So(provider.PerformBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+		backup, err = provider.GetBackup(ctx, dbInstance, *backup.Id)
+		So(err, ShouldBeNil)
+		So(*backup.Status, ShouldEqual, "available")
+		So(*backup.Progress, ShouldEqual, 100)
+
+		_, err = db.Exec("delete from test_table")
+		So(err, ShouldBeNil)
+		db.Close()
+		So(provider.RestoreBackup(ctx, dbInstance, *backup.Id), ShouldBeNil)
+
+		db, err = sql.Open("mysql", dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint)
+		So(err, ShouldBeNil)
+		defer db.Close()
+		var name string
+		var data []byte
+		So(db.QueryRow("select name, data from test_table").Scan(&name, &data), ShouldBeNil)
+		So(name, ShouldEqual, "it's")
+		So(data, ShouldResemble, []byte{0, 255})
+		var doubled int
+		So(db.QueryRow("select doubled from test_table").Scan(&doubled), ShouldBeNil)
+		So(doubled, ShouldEqual, 2)
+		var count int
+		So(db.QueryRow("select count(*) from test_view").Scan(&count), ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+		So(provider.Deprovision(ctx, dbInstance, true), ShouldBeNil)
+		backups, err := provider.ListBackups(ctx, dbInstance)
+		So(err, ShouldBeNil)
+		So(len(backups), ShouldEqual, 3)
+	})
+}
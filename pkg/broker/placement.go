@@ -0,0 +1,220 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang/glog"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// The ways a database can be placed on one of a shared plan's hosts.
+const (
+	PlaceByTenants  = "tenants"   // the host with the fewest databases (the default)
+	PlaceBySize     = "size"      // the host with the least data on it
+	PlaceByFreeDisk = "free_disk" // the host with the most disk left, hosts must set disk_size_gb
+)
+
+// A SharedHost is one of the servers a shared plan can place databases on. The weight scales how
+// much of the load the host should take relative to the others (defaults to 1), max_tenants and
+// disk_size_gb cap what's put on it. Draining hosts take no new databases but keep serving the ones
+// already on them.
+type SharedHost struct {
+	Uri        string  `json:"uri"`
+	Weight     float64 `json:"weight"`
+	MaxTenants int64   `json:"max_tenants"`
+	DiskSizeGB int64   `json:"disk_size_gb"`
+	Draining   bool    `json:"draining"`
+}
+
+// The key a host is recorded under, this is the host and port of its uri so credentials in the uri
+// can change without losing track of the databases on it.
+func (host SharedHost) Key() string {
+	return sharedHostKey(host.Uri)
+}
+
+func sharedHostKey(uri string) string {
+	db, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	return db.Host
+}
+
+// The key of the master uri in a shared plans provider private details, the uri is often given as
+// an environment variable so it's expanded as it is for plans.
+func legacySharedTenantHost(providerPrivateDetails string) string {
+	var settings struct {
+		MasterUri string `json:"master_uri"`
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(providerPrivateDetails)), &settings); err != nil || settings.MasterUri == "" {
+		return ""
+	}
+	return sharedHostKey(settings.MasterUri)
+}
+
+type SharedHostUsage struct {
+	Tenants   int64
+	SizeBytes int64
+}
+
+// SharedTenantStore records which host each shared database was placed on, hosts are recorded by
+// their key.
+type SharedTenantStore interface {
+	GetSharedTenantHost(string) (string, error)
+	AddSharedTenant(string, string) error
+	DeleteSharedTenant(string) error
+	CountSharedTenants(string) (int64, error)
+}
+
+var sharedTenants = struct {
+	sync.RWMutex
+	store SharedTenantStore
+}{}
+
+// SetSharedTenantStore sets where shared providers record the host of each database, without one
+// shared plans can only use a single host.
+func SetSharedTenantStore(store SharedTenantStore) {
+	sharedTenants.Lock()
+	defer sharedTenants.Unlock()
+	sharedTenants.store = store
+}
+
+func getSharedTenantStore() SharedTenantStore {
+	sharedTenants.RLock()
+	defer sharedTenants.RUnlock()
+	return sharedTenants.store
+}
+
+// Finds the uri of the host a database lives on. Databases created before their host was recorded
+// (or without a store) live on the plans master uri.
+func sharedTenantUri(name string, masterUri string, hosts []SharedHost) (string, error) {
+	key := ""
+	if store := getSharedTenantStore(); store != nil {
+		var err error
+		if key, err = store.GetSharedTenantHost(name); err != nil {
+			return "", err
+		}
+	}
+	if key == "" {
+		if masterUri != "" {
+			return masterUri, nil
+		}
+		if len(hosts) == 1 {
+			return hosts[0].Uri, nil
+		}
+		return "", errors.New("Cannot find the host of database " + name + ", the plan has no master uri.")
+	}
+	if sharedHostKey(masterUri) == key {
+		return masterUri, nil
+	}
+	for _, host := range hosts {
+		if host.Key() == key {
+			return host.Uri, nil
+		}
+	}
+	return "", errors.New("The host " + key + " of database " + name + " is no longer in the plan.")
+}
+
+// Picks the host a new database should go on, the usage of each host is only looked up when the
+// placement or the hosts limits need it. Hosts whose usage can't be found are skipped.
+func pickSharedHost(hosts []SharedHost, placement string, usage func(SharedHost, bool, bool) (SharedHostUsage, error)) (SharedHost, error) {
+	if placement == "" {
+		placement = PlaceByTenants
+	}
+	if placement != PlaceByTenants && placement != PlaceBySize && placement != PlaceByFreeDisk {
+		return SharedHost{}, errors.New("The placement " + placement + " is not one of tenants, size or free_disk.")
+	}
+	var best *SharedHost
+	var bestScore float64
+	for i, host := range hosts {
+		if host.Draining {
+			continue
+		}
+		weight := host.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		diskSize := host.DiskSizeGB * 1024 * 1024 * 1024
+		if placement == PlaceByFreeDisk && diskSize == 0 {
+			continue
+		}
+		needTenants := placement == PlaceByTenants || host.MaxTenants > 0
+		needSize := placement != PlaceByTenants || diskSize > 0
+		current, err := usage(host, needTenants, needSize)
+		if err != nil {
+			glog.Errorf("WARNING: Unable to get the usage of shared host %s, skipping it: %s\n", host.Key(), err.Error())
+			continue
+		}
+		if host.MaxTenants > 0 && current.Tenants >= host.MaxTenants {
+			continue
+		}
+		if diskSize > 0 && current.SizeBytes >= diskSize {
+			continue
+		}
+		var score float64
+		switch placement {
+		case PlaceByTenants:
+			score = float64(current.Tenants) / weight
+		case PlaceBySize:
+			score = float64(current.SizeBytes) / weight
+		case PlaceByFreeDisk:
+			// lower is better, so the free space is negated.
+			score = -float64(diskSize-current.SizeBytes) * weight
+		}
+		if best == nil || score < bestScore {
+			best = &hosts[i]
+			bestScore = score
+		}
+	}
+	if best == nil {
+		return SharedHost{}, errors.New("None of the plans hosts can take a new database.")
+	}
+	return *best, nil
+}
+
+// Picks the uri a new database should be created on, a plan without hosts always uses its master uri.
+// The size of the data on a host is found with the provider specific hostSize.
+func placeSharedTenant(masterUri string, hosts []SharedHost, placement string, hostSize func(string) (int64, error)) (string, error) {
+	if len(hosts) == 0 {
+		return masterUri, nil
+	}
+	store := getSharedTenantStore()
+	if store == nil && len(hosts) > 1 {
+		return "", errors.New("Placing databases on more than one host requires storage to record them in.")
+	}
+	host, err := pickSharedHost(hosts, placement, func(host SharedHost, needTenants bool, needSize bool) (SharedHostUsage, error) {
+		var usage SharedHostUsage
+		var err error
+		if needTenants && store != nil {
+			if usage.Tenants, err = store.CountSharedTenants(host.Key()); err != nil {
+				return usage, err
+			}
+		}
+		if needSize {
+			if usage.SizeBytes, err = hostSize(host.Uri); err != nil {
+				return usage, err
+			}
+		}
+		return usage, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return host.Uri, nil
+}
+
+func recordSharedTenant(name string, uri string) error {
+	if store := getSharedTenantStore(); store != nil {
+		return store.AddSharedTenant(name, sharedHostKey(uri))
+	}
+	return nil
+}
+
+func forgetSharedTenant(name string) error {
+	if store := getSharedTenantStore(); store != nil {
+		return store.DeleteSharedTenant(name)
+	}
+	return nil
+}
@@ -437,11 +437,11 @@ func TestPostgresProvision(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(task.Action, ShouldEqual, ChangePlansTask)
 			var taskMetaData ChangePlansTaskMetadata
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
+			dbInstance, err := GetInstanceById(context.Background(), namePrefix, storage, task.DatabaseId)
 			So(err, ShouldBeNil)
 			err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
 			So(err, ShouldBeNil)
-			output, err := UpgradeWithinProviders(storage, dbInstance, taskMetaData.Plan, namePrefix)
+			output, err := UpgradeWithinProviders(context.Background(), storage, dbInstance, taskMetaData.Plan, namePrefix)
 			So(err, ShouldBeNil)
 			FinishedTask(storage, task.Id, task.Retries, output, "finished")
 
@@ -452,7 +452,8 @@ func TestPostgresProvision(t *testing.T) {
 			So(dres, ShouldNotBeNil)
 			So(dres.Credentials["DATABASE_URL"].(string), ShouldStartWith, "postgres://")
 			var dbUrlTo = dres.Credentials["DATABASE_URL"].(string)
-			So(dbUrlTo, ShouldNotEqual, dbUrlFrom)
+			// both plans use the same host, so the database stays where it is with the same credentials.
+			So(dbUrlTo, ShouldEqual, dbUrlFrom)
 
 			// See if the data correctly propogated into the new database
 			db, err = sql.Open("postgres", dbUrlTo + "?sslmode=disable")
@@ -0,0 +1,189 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// How long a database is unavailable for while it changes plans.
+const (
+	DowntimeNone     = "none"     // the database stays available throughout
+	DowntimeBrief    = "brief"    // the database restarts or fails over, usually for seconds to a few minutes
+	DowntimeExtended = "extended" // the database is unavailable while it's upgraded or copied, this grows with its size
+	DowntimeUnknown  = "unknown"  // the provider can't tell
+)
+
+// How a database changes plans.
+const (
+	UpgradeMethodModify      = "modify"      // the provider changes the database in place
+	UpgradeMethodMove        = "move"        // a shared database is moved to a host of the new plan, if it isn't on one
+	UpgradeMethodCopy        = "copy"        // a new database is provisioned and the database is copied into it
+	UpgradeMethodReplication = "replication" // a new database is provisioned and the database replicated into it
+)
+
+// What changing a database to another plan would do, without changing it.
+type UpgradePreview struct {
+	Plan            string                  `json:"plan"`
+	Method          string                  `json:"method"`
+	AcrossProviders bool                    `json:"across_providers"`
+	VersionSteps    []string                `json:"version_steps"`
+	Settings        []UpgradePreviewSetting `json:"settings"`
+	StorageKept     bool                    `json:"storage_kept"` // plans never shrink storage, the current storage is kept if the new plan has less
+	Downtime        string                  `json:"downtime"`
+}
+
+// A setting of the plan that differs on the new plan, the values of settings that may have
+// credentials in them are hidden.
+type UpgradePreviewSetting struct {
+	Name    string      `json:"name"`
+	Current interface{} `json:"current"`
+	Target  interface{} `json:"target"`
+	Hidden  bool        `json:"hidden,omitempty"`
+}
+
+// An UpgradePreviewer is a provider that can tell what Modify would do to a database without doing it.
+// Providers return ErrFeatureNotAvailable if the database would be copied to a new database instead.
+type UpgradePreviewer interface {
+	PreviewModify(context.Context, *DbInstance, *ProviderPlan) (*UpgradePreview, error)
+}
+
+// Previews changing a database to another plan the same way the change plans and change providers
+// tasks would make it.
+func previewUpgrade(ctx context.Context, fromProvider Provider, fromDb *DbInstance, toPlan *ProviderPlan, replication bool) (*UpgradePreview, error) {
+	settings, err := planSettingChanges(fromDb.Plan, toPlan)
+	if err != nil {
+		return nil, err
+	}
+	preview := &UpgradePreview{
+		Plan:            toPlan.ID,
+		Method:          UpgradeMethodModify,
+		AcrossProviders: fromDb.Plan.Provider != toPlan.Provider,
+		VersionSteps:    []string{},
+		Settings:        settings,
+		Downtime:        DowntimeUnknown,
+	}
+	if replication {
+		if migrationEngine(fromDb.Engine) != "postgres" {
+			return nil, errors.New("Only postgres databases can be switched over with replication.")
+		}
+		preview.Method = UpgradeMethodReplication
+		preview.Downtime = DowntimeBrief
+		return preview, nil
+	}
+
+	copied := preview.AcrossProviders
+	if !copied {
+		if _, ok := fromProvider.(TenantMover); ok {
+			preview.Method = UpgradeMethodMove
+			preview.Downtime = DowntimeExtended
+			return preview, nil
+		}
+		if previewer, ok := fromProvider.(UpgradePreviewer); ok {
+			modify, err := previewer.PreviewModify(ctx, fromDb, toPlan)
+			if err == ErrFeatureNotAvailable {
+				copied = true
+			} else if err != nil {
+				return nil, err
+			} else {
+				preview.VersionSteps = modify.VersionSteps
+				preview.StorageKept = modify.StorageKept
+				preview.Downtime = modify.Downtime
+			}
+		}
+	}
+	if copied {
+		if migrationEngine(fromDb.Engine) == "" {
+			return nil, errors.New("Cannot upgrade across providers for databases other than postgres or mysql.")
+		}
+		preview.Method = UpgradeMethodCopy
+		preview.Downtime = DowntimeExtended
+	}
+	return preview, nil
+}
+
+// The settings in the provider private details that differ between two plans, nested settings are
+// named by their path, e.g. "Cluster.EngineVersion" or "hosts.0.uri".
+func planSettingChanges(from *ProviderPlan, to *ProviderPlan) ([]UpgradePreviewSetting, error) {
+	current, err := planSettings(from)
+	if err != nil {
+		return nil, err
+	}
+	target, err := planSettings(to)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range target {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	changes := make([]UpgradePreviewSetting, 0)
+	for _, name := range names {
+		if reflect.DeepEqual(current[name], target[name]) {
+			continue
+		}
+		if sensitiveSetting(name) {
+			changes = append(changes, UpgradePreviewSetting{Name: name, Hidden: true})
+		} else {
+			changes = append(changes, UpgradePreviewSetting{Name: name, Current: current[name], Target: target[name]})
+		}
+	}
+	return changes, nil
+}
+
+func planSettings(plan *ProviderPlan) (map[string]interface{}, error) {
+	settings := make(map[string]interface{})
+	if plan == nil || strings.TrimSpace(plan.providerPrivateDetails) == "" {
+		return settings, nil
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &details); err != nil {
+		return nil, errors.New("The settings of the plan " + plan.ID + " cannot be read: " + err.Error())
+	}
+	flattenSettings("", details, settings)
+	return settings, nil
+}
+
+func flattenSettings(name string, value interface{}, settings map[string]interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			flattenSettings(settingName(name, key), nested, settings)
+		}
+	case []interface{}:
+		// hosts of shared plans are lists, their uris have to be hidden like any other.
+		for i, nested := range value {
+			flattenSettings(settingName(name, strconv.Itoa(i)), nested, settings)
+		}
+	default:
+		settings[name] = value
+	}
+}
+
+func settingName(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// Settings such as master_uri or passwords carry credentials, which should never leave the broker.
+func sensitiveSetting(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range []string{"password", "secret", "token", "credential", "key", "uri", "url", "location"} {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
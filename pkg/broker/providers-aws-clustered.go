@@ -1,13 +1,19 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/golang/glog"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"fmt"
@@ -16,14 +22,41 @@ import (
 type AWSClusteredProvider struct {
 	Provider
 	awsInstanceProvider  *AWSInstanceProvider
-	awssvc               *rds.RDS
+	awssvc               rdsiface.RDSAPI
+	autoscaling          applicationautoscalingiface.ApplicationAutoScalingAPI
 	namePrefix           string
 	awsVpcSecurityGroup  string
+	pollInterval         time.Duration
 }
 
 type AWSClusteredProviderPrivatePlanSettings struct {
 	Instance      	rds.CreateDBInstanceInput `json:"Instance"`
 	Cluster			rds.CreateDBClusterInput `json:"Cluster"`
+	// The readers a cluster is created with, changing to the plan adds readers up to this but never removes any.
+	Readers			int64 `json:"Readers,omitempty"`
+	// Scales the readers with aurora auto scaling instead, the readers can then no longer be scaled by hand.
+	AutoScaling		*AWSClusterAutoScaling `json:"AutoScaling,omitempty"`
+}
+
+// A target tracking policy that adds or removes readers to keep a metric of the readers at a target,
+// the metric is either RDSReaderAverageCPUUtilization (the default) or RDSReaderAverageDatabaseConnections.
+type AWSClusterAutoScaling struct {
+	MinCapacity				int64 `json:"MinCapacity"`
+	MaxCapacity				int64 `json:"MaxCapacity"`
+	TargetValue				float64 `json:"TargetValue"`
+	PredefinedMetricType	string `json:"PredefinedMetricType,omitempty"`
+	ScaleInCooldown			*int64 `json:"ScaleInCooldown,omitempty"`
+	ScaleOutCooldown		*int64 `json:"ScaleOutCooldown,omitempty"`
+}
+
+func init() {
+	RegisterProvider(AWSCluster, func(namePrefix string) (Provider, error) {
+		provider, err := NewAWSClusteredProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, AWSClusteredProviderPrivatePlanSettings{})
 }
 
 func NewAWSClusteredProvider(namePrefix string) (*AWSClusteredProvider, error) {
@@ -33,27 +66,100 @@ func NewAWSClusteredProvider(namePrefix string) (*AWSClusteredProvider, error) {
 	if os.Getenv("AWS_VPC_SECURITY_GROUPS") == "" {
 		return nil, errors.New("Unable to find AWS_VPC_SECURITY_GROUPS environment variable.")
 	}
-	awsInstanceProvider, err := NewAWSInstanceProvider(namePrefix)
+	sess := session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+	provider := NewAWSClusteredProviderWithClient(namePrefix, rds.New(sess), os.Getenv("AWS_VPC_SECURITY_GROUPS"))
+	provider.autoscaling = applicationautoscaling.New(sess)
+	return provider, nil
+}
+
+// NewAWSClusteredProviderWithClient creates the provider (and the instance provider it uses for
+// cluster members) on top of an existing RDS client.
+func NewAWSClusteredProviderWithClient(namePrefix string, awssvc rdsiface.RDSAPI, awsVpcSecurityGroup string) *AWSClusteredProvider {
+	return &AWSClusteredProvider{
+		namePrefix:          namePrefix,
+		awsInstanceProvider: NewAWSInstanceProviderWithClient(namePrefix, awssvc, awsVpcSecurityGroup),
+		awsVpcSecurityGroup: awsVpcSecurityGroup,
+		awssvc:              awssvc,
+		pollInterval:        time.Second * 30,
+	}
+}
+
+func (provider AWSClusteredProvider) Close() error {
+	return provider.awsInstanceProvider.Close()
+}
+
+func (provider AWSClusteredProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	return ProviderCapabilities{BackupsCapability, RestoreCapability, RolesCapability, LogsCapability, RestartCapability, ReplicasCapability, TagsCapability, ReadersCapability, PointInTimeCapability}
+}
+
+func (provider AWSClusteredProvider) planSettings(plan *ProviderPlan) (*AWSClusteredProviderPrivatePlanSettings, error) {
+	var settings AWSClusteredProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (provider AWSClusteredProvider) describeCluster(ctx context.Context, name string) (*rds.DBCluster, error) {
+	resp, err := provider.awssvc.DescribeDBClustersWithContext(ctx, &rds.DescribeDBClustersInput{
+		DBClusterIdentifier: aws.String(name),
+		MaxRecords:          aws.Int64(20),
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &AWSClusteredProvider{
-		namePrefix:          namePrefix,
-		awsInstanceProvider: awsInstanceProvider,
-		awsVpcSecurityGroup: os.Getenv("AWS_VPC_SECURITY_GROUPS"),
-		awssvc:              rds.New(session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})),
-	}, nil
+	if len(resp.DBClusters) != 1 {
+		return nil, errors.New("Found none or multiples matching this cluster name.")
+	}
+	return resp.DBClusters[0], nil
+}
+
+// The members of the cluster other than the writer.
+func clusterReaders(cluster *rds.DBCluster) []*string {
+	readers := make([]*string, 0)
+	for _, member := range cluster.DBClusterMembers {
+		if member.IsClusterWriter == nil || *member.IsClusterWriter == false {
+			readers = append(readers, member.DBInstanceIdentifier)
+		}
+	}
+	return readers
+}
+
+func clusterEndpoint(address *string, cluster *rds.DBCluster) string {
+	if address == nil || cluster.Port == nil || cluster.DatabaseName == nil {
+		return ""
+	}
+	return *address + ":" + strconv.FormatInt(*cluster.Port, 10) + "/" + *cluster.DatabaseName
+}
+
+// The status of the instance is that of the instance created with the cluster, but the endpoint is the
+// cluster (writer) endpoint, which follows the writer when aurora fails over to one of the readers.
+func (provider AWSClusteredProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	dbInstance, err := provider.awsInstanceProvider.GetInstance(ctx, name, plan)
+	if err != nil {
+		return nil, err
+	}
+	return provider.withClusterEndpoint(ctx, dbInstance)
 }
 
-func (provider AWSClusteredProvider) GetInstance(name string, plan *ProviderPlan) (*DbInstance, error) {
-	return provider.awsInstanceProvider.GetInstance(name, plan)
+func (provider AWSClusteredProvider) withClusterEndpoint(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	cluster, err := provider.describeCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	// the instance may be cached, so it's copied rather than changed.
+	clustered := *dbInstance
+	if endpoint := clusterEndpoint(cluster.Endpoint, cluster); endpoint != "" {
+		clustered.Endpoint = endpoint
+	}
+	return &clustered, nil
 }
 
-func (provider AWSClusteredProvider) PerformPostProvision(db *DbInstance) (*DbInstance, error) {
+func (provider AWSClusteredProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
 	return db, nil
 }
 
-func (provider AWSClusteredProvider) Provision(Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+func (provider AWSClusteredProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
 	var settings AWSClusteredProviderPrivatePlanSettings
 	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
 		return nil, err
@@ -70,12 +176,12 @@ func (provider AWSClusteredProvider) Provision(Id string, plan *ProviderPlan, Ow
 	settings.Instance.Tags = []*rds.Tag{{Key: aws.String("BillingCode"), Value: aws.String(Owner)}}
 	settings.Instance.DBClusterIdentifier = settings.Cluster.DatabaseName
 
-	_, err := provider.awssvc.CreateDBCluster(&settings.Cluster)
+	_, err := provider.awssvc.CreateDBClusterWithContext(ctx, &settings.Cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	dbInstance, err := provider.awsInstanceProvider.ProvisionWithSettings(Id, plan, &settings.Instance)
+	dbInstance, err := provider.awsInstanceProvider.ProvisionWithSettings(ctx, Id, plan, &settings.Instance)
 	if err != nil {
 		return nil, err
 	}
@@ -83,11 +189,35 @@ func (provider AWSClusteredProvider) Provision(Id string, plan *ProviderPlan, Ow
 		return nil, errors.New("Unable to obtain the master password for this cluster, it was nil.")
 	}
 	dbInstance.Password = *settings.Cluster.MasterUserPassword
+
+	if err = provider.provisionReaders(ctx, *settings.Cluster.DBClusterIdentifier, &settings, settings.Instance.Tags); err != nil {
+		return nil, err
+	}
 	return dbInstance, nil
 }
 
-func (provider AWSClusteredProvider) Deprovision(dbInstance *DbInstance, takeSnapshot bool) error {
-	resp, err := provider.awssvc.DescribeDBClusters(&rds.DescribeDBClustersInput{
+// Adds the readers of the plan to a new cluster, and scales them automatically if the plan does.
+func (provider AWSClusteredProvider) provisionReaders(ctx context.Context, name string, settings *AWSClusteredProviderPrivatePlanSettings, tags []*rds.Tag) error {
+	for i := int64(0); i < settings.Readers; i++ {
+		if err := provider.addReader(ctx, name, settings, tags); err != nil {
+			return err
+		}
+	}
+	if settings.AutoScaling != nil {
+		if err := provider.registerAutoScaling(ctx, name, settings.AutoScaling); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (provider AWSClusteredProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	defer provider.awsInstanceProvider.instanceCache.Invalidate(dbInstance.Name)
+	// auto scaling would otherwise add readers back while the cluster is being removed.
+	if err := provider.deregisterAutoScaling(ctx, dbInstance.Name); err != nil {
+		return err
+	}
+	resp, err := provider.awssvc.DescribeDBClustersWithContext(ctx, &rds.DescribeDBClustersInput{
 		DBClusterIdentifier: 	aws.String(dbInstance.Name),
 		MaxRecords:           	aws.Int64(20),
 	})
@@ -104,7 +234,7 @@ func (provider AWSClusteredProvider) Deprovision(dbInstance *DbInstance, takeSna
 		if member.IsClusterWriter != nil && *member.IsClusterWriter == true {
 			dbPrimaryIdentifier = member.DBInstanceIdentifier
 		} else {
-			_, err := provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+			_, err := provider.awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 				DBInstanceIdentifier:      member.DBInstanceIdentifier,
 				SkipFinalSnapshot:         aws.Bool(!takeSnapshot),
 			})
@@ -117,7 +247,7 @@ func (provider AWSClusteredProvider) Deprovision(dbInstance *DbInstance, takeSna
 	if dbPrimaryIdentifier == nil {
 		return errors.New("Unable to find primary database identifier")
 	}
-	_, err = provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+	_, err = provider.awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 		DBInstanceIdentifier:      dbPrimaryIdentifier,
 		SkipFinalSnapshot:         aws.Bool(!takeSnapshot),
 	})
@@ -126,7 +256,7 @@ func (provider AWSClusteredProvider) Deprovision(dbInstance *DbInstance, takeSna
 	}
 	// delete cluster, you can't delete the cluster until all of the db instances
 	// have been marked as removed.
-	_, err = provider.awssvc.DeleteDBCluster(&rds.DeleteDBClusterInput{
+	_, err = provider.awssvc.DeleteDBClusterWithContext(ctx, &rds.DeleteDBClusterInput{
 		DBClusterIdentifier: aws.String(dbInstance.Name),
 		SkipFinalSnapshot:   aws.Bool(!takeSnapshot),
 		FinalDBSnapshotIdentifier: aws.String(dbInstance.Name + "-final"),
@@ -134,15 +264,15 @@ func (provider AWSClusteredProvider) Deprovision(dbInstance *DbInstance, takeSna
 	return err
 }
 
-func (provider AWSClusteredProvider) UpgradeVersion(dbInstance *DbInstance, proposed string) (*DbInstance, error) {
-	versions, err := provider.awsInstanceProvider.upgradePlan(dbInstance, proposed)
+func (provider AWSClusteredProvider) UpgradeVersion(ctx context.Context, dbInstance *DbInstance, proposed string) (*DbInstance, error) {
+	versions, err := provider.awsInstanceProvider.upgradePlan(ctx, dbInstance, proposed)
 	if err != nil {
 		return nil, err
 	}
 
 	// Begin the upgrades.
 	for _, version := range versions {
-		_, err := provider.awssvc.ModifyDBCluster(&rds.ModifyDBClusterInput{
+		_, err := provider.awssvc.ModifyDBClusterWithContext(ctx, &rds.ModifyDBClusterInput{
 			EngineVersion:           aws.String(version),
 			ApplyImmediately:        aws.Bool(true),
 			DBClusterIdentifier:     aws.String(dbInstance.Name),
@@ -150,9 +280,10 @@ func (provider AWSClusteredProvider) UpgradeVersion(dbInstance *DbInstance, prop
 		if err != nil {
 			return nil, err
 		}
-		tick := time.NewTicker(time.Second * 30)
-		<-tick.C
-		err = provider.awssvc.WaitUntilDBInstanceAvailable(&rds.DescribeDBInstancesInput{
+		if err := sleepWithContext(ctx, provider.pollInterval); err != nil {
+			return nil, err
+		}
+		err = provider.awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
 			DBInstanceIdentifier: 	aws.String(dbInstance.Name),
 			MaxRecords:				aws.Int64(20),
 		})
@@ -165,7 +296,8 @@ func (provider AWSClusteredProvider) UpgradeVersion(dbInstance *DbInstance, prop
 	return dbInstance, nil
 }
 
-func (provider AWSClusteredProvider) Modify(dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+func (provider AWSClusteredProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+	defer provider.awsInstanceProvider.instanceCache.Invalidate(dbInstance.Name)
 	if !CanBeModified(dbInstance.Status) {
 		return nil, errors.New("Databases cannot be modifed during backups, upgrades or while maintenance is being performed.")
 	}
@@ -174,7 +306,7 @@ func (provider AWSClusteredProvider) Modify(dbInstance *DbInstance, plan *Provid
 		return nil, err
 	}
 
-	_, err := provider.awssvc.ModifyDBCluster(&rds.ModifyDBClusterInput{
+	_, err := provider.awssvc.ModifyDBClusterWithContext(ctx, &rds.ModifyDBClusterInput{
 		ApplyImmediately:			aws.Bool(true),
 		BacktrackWindow:			settings.Cluster.BacktrackWindow,
 		BackupRetentionPeriod:		settings.Cluster.BackupRetentionPeriod,
@@ -190,24 +322,220 @@ func (provider AWSClusteredProvider) Modify(dbInstance *DbInstance, plan *Provid
 		return nil, err
 	}
 	
-	_, err = provider.UpgradeVersion(dbInstance, *settings.Cluster.EngineVersion)
+	_, err = provider.UpgradeVersion(ctx, dbInstance, *settings.Cluster.EngineVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	return provider.awsInstanceProvider.ModifyWithSettings(dbInstance, plan, &settings.Instance)
+	// members get the version of the cluster, plans rarely repeat it on the instance.
+	if settings.Instance.EngineVersion == nil {
+		settings.Instance.EngineVersion = settings.Cluster.EngineVersion
+	}
+	newDbInstance, err := provider.awsInstanceProvider.ModifyWithSettings(ctx, dbInstance, plan, &settings.Instance)
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.modifyReaders(ctx, newDbInstance, &settings); err != nil {
+		return nil, err
+	}
+	return provider.withClusterEndpoint(ctx, newDbInstance)
+}
+
+// Brings the readers of a cluster in line with a new plan, the readers change to the instance class of the
+// plan and readers are added if the plan has more. Readers are never removed when changing plans.
+func (provider AWSClusteredProvider) modifyReaders(ctx context.Context, dbInstance *DbInstance, settings *AWSClusteredProviderPrivatePlanSettings) error {
+	cluster, err := provider.describeCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	readers := clusterReaders(cluster)
+	if settings.Instance.DBInstanceClass != nil {
+		for _, reader := range readers {
+			_, err := provider.awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
+				ApplyImmediately:     aws.Bool(true),
+				DBInstanceClass:      settings.Instance.DBInstanceClass,
+				DBInstanceIdentifier: reader,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if settings.AutoScaling != nil {
+		return provider.registerAutoScaling(ctx, dbInstance.Name, settings.AutoScaling)
+	}
+	if err = provider.deregisterAutoScaling(ctx, dbInstance.Name); err != nil {
+		return err
+	}
+	tags := []*rds.Tag{{Key: aws.String("Name"), Value: aws.String(dbInstance.Name)}}
+	for i := int64(len(readers)); i < settings.Readers; i++ {
+		if err = provider.addReader(ctx, dbInstance.Name, settings, tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds a reader to the cluster, readers get their credentials, database and security groups from the cluster.
+func (provider AWSClusteredProvider) addReader(ctx context.Context, name string, settings *AWSClusteredProviderPrivatePlanSettings, tags []*rds.Tag) error {
+	reader := settings.Instance
+	reader.DBInstanceIdentifier = aws.String(strings.ToLower(name + "-ro-" + RandomString(6)))
+	reader.DBClusterIdentifier = aws.String(name)
+	reader.DBName = nil
+	reader.MasterUsername = nil
+	reader.MasterUserPassword = nil
+	reader.VpcSecurityGroupIds = nil
+	reader.Tags = tags
+	_, err := provider.awssvc.CreateDBInstanceWithContext(ctx, &reader)
+	return err
+}
+
+func (provider AWSClusteredProvider) registerAutoScaling(ctx context.Context, name string, scaling *AWSClusterAutoScaling) error {
+	if provider.autoscaling == nil {
+		return errors.New("Auto scaling of readers is not available.")
+	}
+	if scaling.MinCapacity < 0 || scaling.MinCapacity > scaling.MaxCapacity || scaling.MaxCapacity > maxReaders {
+		return errors.New("The auto scaling of readers must have a minimum and maximum between 0 and " + strconv.Itoa(maxReaders) + ".")
+	}
+	metric := scaling.PredefinedMetricType
+	if metric == "" {
+		metric = applicationautoscaling.MetricTypeRdsreaderAverageCpuutilization
+	}
+	_, err := provider.autoscaling.RegisterScalableTargetWithContext(ctx, &applicationautoscaling.RegisterScalableTargetInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceRds),
+		ResourceId:        aws.String("cluster:" + name),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionRdsClusterReadReplicaCount),
+		MinCapacity:       aws.Int64(scaling.MinCapacity),
+		MaxCapacity:       aws.Int64(scaling.MaxCapacity),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = provider.autoscaling.PutScalingPolicyWithContext(ctx, &applicationautoscaling.PutScalingPolicyInput{
+		PolicyName:        aws.String(name + "-readers"),
+		PolicyType:        aws.String(applicationautoscaling.PolicyTypeTargetTrackingScaling),
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceRds),
+		ResourceId:        aws.String("cluster:" + name),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionRdsClusterReadReplicaCount),
+		TargetTrackingScalingPolicyConfiguration: &applicationautoscaling.TargetTrackingScalingPolicyConfiguration{
+			TargetValue:                   aws.Float64(scaling.TargetValue),
+			PredefinedMetricSpecification: &applicationautoscaling.PredefinedMetricSpecification{PredefinedMetricType: aws.String(metric)},
+			ScaleInCooldown:               scaling.ScaleInCooldown,
+			ScaleOutCooldown:              scaling.ScaleOutCooldown,
+		},
+	})
+	return err
+}
+
+// Removes the auto scaling of the readers (and its policies), clusters without it are left as they are.
+func (provider AWSClusteredProvider) deregisterAutoScaling(ctx context.Context, name string) error {
+	if provider.autoscaling == nil {
+		return nil
+	}
+	_, err := provider.autoscaling.DeregisterScalableTargetWithContext(ctx, &applicationautoscaling.DeregisterScalableTargetInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceRds),
+		ResourceId:        aws.String("cluster:" + name),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionRdsClusterReadReplicaCount),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == applicationautoscaling.ErrCodeObjectNotFoundException {
+		return nil
+	}
+	return err
+}
+
+// Whether the readers of the cluster are scaled automatically, this is only decided by the plan when
+// the cluster is created or changes plans, removing the replica removes the auto scaling as well.
+func (provider AWSClusteredProvider) autoScaled(ctx context.Context, name string) (bool, error) {
+	if provider.autoscaling == nil {
+		return false, nil
+	}
+	resp, err := provider.autoscaling.DescribeScalableTargetsWithContext(ctx, &applicationautoscaling.DescribeScalableTargetsInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceRds),
+		ResourceIds:       []*string{aws.String("cluster:" + name)},
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionRdsClusterReadReplicaCount),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(resp.ScalableTargets) > 0, nil
+}
+
+func (provider AWSClusteredProvider) Readers(ctx context.Context, dbInstance *DbInstance) (int64, bool, error) {
+	cluster, err := provider.describeCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return 0, false, err
+	}
+	automatic, err := provider.autoScaled(ctx, dbInstance.Name)
+	if err != nil {
+		return 0, false, err
+	}
+	return int64(len(clusterReaders(cluster))), automatic, nil
+}
+
+func (provider AWSClusteredProvider) ScaleReaders(ctx context.Context, dbInstance *DbInstance, count int64) error {
+	if err := validateReaderCount(count); err != nil {
+		return err
+	}
+	settings, err := provider.planSettings(dbInstance.Plan)
+	if err != nil {
+		return err
+	}
+	automatic, err := provider.autoScaled(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if automatic {
+		return errors.New("The readers of this database are scaled automatically.")
+	}
+	return provider.scaleReaders(ctx, dbInstance, settings, count)
+}
+
+func (provider AWSClusteredProvider) scaleReaders(ctx context.Context, dbInstance *DbInstance, settings *AWSClusteredProviderPrivatePlanSettings, count int64) error {
+	if dbInstance.Status != "available" {
+		return errors.New("Readers cannot be changed for databases being created, under maintenance or destroyed.")
+	}
+	cluster, err := provider.describeCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	readers := clusterReaders(cluster)
+	// after a failover the instance created with the cluster may be a reader, it is never removed.
+	for i, reader := range readers {
+		if *reader == dbInstance.Name {
+			readers[0], readers[i] = readers[i], readers[0]
+		}
+	}
+	tags := []*rds.Tag{{Key: aws.String("Name"), Value: aws.String(dbInstance.Name)}}
+	for i := int64(len(readers)); i < count; i++ {
+		if err = provider.addReader(ctx, dbInstance.Name, settings, tags); err != nil {
+			return err
+		}
+	}
+	for i := count; i < int64(len(readers)); i++ {
+		if *readers[i] == dbInstance.Name {
+			continue
+		}
+		_, err = provider.awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier: readers[i],
+			SkipFinalSnapshot:    aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (provider AWSClusteredProvider) Tag(dbInstance *DbInstance, Name string, Value string) error {
-	return provider.awsInstanceProvider.Tag(dbInstance, Name, Value)
+func (provider AWSClusteredProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
+	return provider.awsInstanceProvider.Tag(ctx, dbInstance, Name, Value)
 }
 
-func (provider AWSClusteredProvider) Untag(dbInstance *DbInstance, Name string) error {
-	return provider.awsInstanceProvider.Untag(dbInstance, Name)
+func (provider AWSClusteredProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
+	return provider.awsInstanceProvider.Untag(ctx, dbInstance, Name)
 }
 
-func (provider AWSClusteredProvider) GetBackup(dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
-	snapshots, err := provider.awssvc.DescribeDBClusterSnapshots(&rds.DescribeDBClusterSnapshotsInput{
+func (provider AWSClusteredProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	snapshots, err := provider.awssvc.DescribeDBClusterSnapshotsWithContext(ctx, &rds.DescribeDBClusterSnapshotsInput{
 		DBClusterIdentifier: aws.String(dbInstance.Name),
 		DBClusterSnapshotIdentifier: aws.String(Id),
 	})
@@ -234,8 +562,8 @@ func (provider AWSClusteredProvider) GetBackup(dbInstance *DbInstance, Id string
 	}, nil
 }
 
-func (provider AWSClusteredProvider) ListBackups(dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
-	snapshots, err := provider.awssvc.DescribeDBClusterSnapshots(&rds.DescribeDBClusterSnapshotsInput{DBClusterIdentifier: aws.String(dbInstance.Name)})
+func (provider AWSClusteredProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	snapshots, err := provider.awssvc.DescribeDBClusterSnapshotsWithContext(ctx, &rds.DescribeDBClusterSnapshotsInput{DBClusterIdentifier: aws.String(dbInstance.Name)})
 	if err != nil {
 		return []DatabaseBackupSpec{}, err
 	}
@@ -258,9 +586,9 @@ func (provider AWSClusteredProvider) ListBackups(dbInstance *DbInstance) ([]Data
 	return out, nil
 }
 
-func (provider AWSClusteredProvider) CreateBackup(dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+func (provider AWSClusteredProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
 	snapshot_name := (dbInstance.Name + "-manual-" + RandomString(10))
-	snapshot, err := provider.awssvc.CreateDBClusterSnapshot(&rds.CreateDBClusterSnapshotInput{
+	snapshot, err := provider.awssvc.CreateDBClusterSnapshotWithContext(ctx, &rds.CreateDBClusterSnapshotInput{
 		DBClusterIdentifier: aws.String(dbInstance.Name),
 		DBClusterSnapshotIdentifier: aws.String(snapshot_name),
 	})
@@ -283,19 +611,32 @@ func (provider AWSClusteredProvider) CreateBackup(dbInstance *DbInstance) (Datab
 	}, nil
 }
 
-func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id string) error {
+func (provider AWSClusteredProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	defer provider.awsInstanceProvider.instanceCache.Invalidate(dbInstance.Name)
 	var settings AWSClusteredProviderPrivatePlanSettings
 	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
 		return err
 	}
 
-	// For AWS, the best strategy for restoring (reliably) a database is to rename the existing db
-	// then create from a snapshot the existing db, and then nuke the old one once finished.
+	return provider.restoreBySwapping(ctx, dbInstance, &settings, func(renamedCluster string, vpcSecurityGroupIds []*string) error {
+		_, err := provider.awssvc.RestoreDBClusterFromSnapshotWithContext(ctx, &rds.RestoreDBClusterFromSnapshotInput{
+			DBClusterIdentifier:			aws.String(dbInstance.Name),
+			SnapshotIdentifier:				aws.String(Id),
+			DBSubnetGroupName:				settings.Cluster.DBSubnetGroupName,
+			Engine:							settings.Cluster.Engine,
+			VpcSecurityGroupIds:			vpcSecurityGroupIds,
+		})
+		return err
+	})
+}
 
+// For AWS, the best strategy for restoring (reliably) a database is to rename the existing db
+// then create the db again under its name (restore), and then nuke the old one once finished.
+func (provider AWSClusteredProvider) restoreBySwapping(ctx context.Context, dbInstance *DbInstance, settings *AWSClusteredProviderPrivatePlanSettings, restore func(renamedCluster string, vpcSecurityGroupIds []*string) error) error {
 	renamedSuffix := "-restore-" + RandomString(5)
 
 	// 1. Rename all db instances in the cluster
-	resp, err := provider.awssvc.DescribeDBClusters(&rds.DescribeDBClustersInput{
+	resp, err := provider.awssvc.DescribeDBClustersWithContext(ctx, &rds.DescribeDBClustersInput{
 		DBClusterIdentifier: 	aws.String(dbInstance.Name),
 		MaxRecords:           	aws.Int64(20),
 	})
@@ -313,7 +654,7 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 	}
 
 	for _, member := range resp.DBClusters[0].DBClusterMembers {
-		_, err := provider.awssvc.ModifyDBInstance(&rds.ModifyDBInstanceInput{
+		_, err := provider.awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
 			ApplyImmediately: 			aws.Bool(true),
 			DBInstanceIdentifier: 		member.DBInstanceIdentifier, 
 			NewDBInstanceIdentifier: 	aws.String(*member.DBInstanceIdentifier + renamedSuffix),
@@ -325,7 +666,7 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 	}
 
 	// 2. Rename the db cluster
-	_, err = provider.awssvc.ModifyDBCluster(&rds.ModifyDBClusterInput{
+	_, err = provider.awssvc.ModifyDBClusterWithContext(ctx, &rds.ModifyDBClusterInput{
 			ApplyImmediately: 			aws.Bool(true),
 			DBClusterIdentifier: 		aws.String(dbInstance.Name), 
 			NewDBClusterIdentifier: 	aws.String(dbInstance.Name + renamedSuffix),
@@ -336,7 +677,7 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 	}
 
 	// 3. Wait for the db instance to be available before requesting a retore.
-	err = provider.awssvc.WaitUntilDBInstanceAvailable(&rds.DescribeDBInstancesInput{
+	err = provider.awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: 	aws.String(dbInstance.Name + renamedSuffix),
 		MaxRecords:				aws.Int64(20),
 	})
@@ -344,19 +685,33 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 		glog.Errorf("Unable to wait for renamed db cluster: %s\n", err.Error())
 		return err
 	}
-	t := time.NewTicker(time.Second * 15)
-	<-t.C
+	if err := sleepWithContext(ctx, provider.pollInterval / 2); err != nil {
+		return err
+	}
 
 	// 4. Restore the original db cluster
-	_, err = provider.awssvc.RestoreDBClusterFromSnapshot(&rds.RestoreDBClusterFromSnapshotInput{
-		DBClusterIdentifier:			aws.String(dbInstance.Name),
-		SnapshotIdentifier:				aws.String(Id),
-		DBSubnetGroupName:				settings.Cluster.DBSubnetGroupName,
-		Engine:							settings.Cluster.Engine,
-		VpcSecurityGroupIds:			vpcSecurityGroupIds,
-	})
+	err = restore(dbInstance.Name + renamedSuffix, vpcSecurityGroupIds)
 	if err != nil {
 		glog.Errorf("Unable to restore db cluster because %s\n", err.Error())
+		// nothing was restored, so the cluster and its members are put back under their names.
+		_, renameErr := provider.awssvc.ModifyDBClusterWithContext(ctx, &rds.ModifyDBClusterInput{
+			ApplyImmediately:       aws.Bool(true),
+			DBClusterIdentifier:    aws.String(dbInstance.Name + renamedSuffix),
+			NewDBClusterIdentifier: aws.String(dbInstance.Name),
+		})
+		if renameErr != nil {
+			glog.Errorf("ERROR: Unable to rename %s back to %s after the restore failed: %s\n", dbInstance.Name + renamedSuffix, dbInstance.Name, renameErr.Error())
+		}
+		for _, member := range resp.DBClusters[0].DBClusterMembers {
+			_, renameErr := provider.awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
+				ApplyImmediately:        aws.Bool(true),
+				DBInstanceIdentifier:    aws.String(*member.DBInstanceIdentifier + renamedSuffix),
+				NewDBInstanceIdentifier: member.DBInstanceIdentifier,
+			})
+			if renameErr != nil {
+				glog.Errorf("ERROR: Unable to rename %s back to %s after the restore failed: %s\n", *member.DBInstanceIdentifier + renamedSuffix, *member.DBInstanceIdentifier, renameErr.Error())
+			}
+		}
 		return err
 	}
 
@@ -364,7 +719,7 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 	for _, member := range resp.DBClusters[0].DBClusterMembers {
 		settings.Instance.DBInstanceIdentifier 	= member.DBInstanceIdentifier
 		settings.Instance.DBClusterIdentifier 	= aws.String(dbInstance.Name)
-		_, err = provider.awsInstanceProvider.ProvisionWithSettings(*member.DBInstanceIdentifier, dbInstance.Plan, &settings.Instance)
+		_, err = provider.awsInstanceProvider.ProvisionWithSettings(ctx, *member.DBInstanceIdentifier, dbInstance.Plan, &settings.Instance)
 		if err != nil {
 			glog.Errorf("Unable to create db cluster instance because %s\n", err.Error())
 			return err
@@ -378,7 +733,7 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 			tmpstr := *member.DBInstanceIdentifier + renamedSuffix
 			dbPrimaryIdentifier = &tmpstr
 		} else {
-			_, err := provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+			_, err := provider.awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 				DBInstanceIdentifier:      aws.String(*member.DBInstanceIdentifier + renamedSuffix),
 				SkipFinalSnapshot:         aws.Bool(true),
 			})
@@ -391,7 +746,7 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 	if dbPrimaryIdentifier == nil {
 		return errors.New("Unable to find primary database identifier")
 	}
-	_, err = provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+	_, err = provider.awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 		DBInstanceIdentifier:      dbPrimaryIdentifier,
 		SkipFinalSnapshot:         aws.Bool(true),
 	})
@@ -399,7 +754,7 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 		glog.Errorf("Unable to delete db renamed primary member: %s because %s\n", *dbPrimaryIdentifier, err.Error())
 		return err
 	}
-	_, err = provider.awssvc.DeleteDBCluster(&rds.DeleteDBClusterInput{
+	_, err = provider.awssvc.DeleteDBClusterWithContext(ctx, &rds.DeleteDBClusterInput{
 		DBClusterIdentifier:      	aws.String(dbInstance.Name + renamedSuffix),
 		SkipFinalSnapshot:			aws.Bool(true),
 	})
@@ -409,50 +764,258 @@ func (provider AWSClusteredProvider) RestoreBackup(dbInstance *DbInstance, Id st
 	return err
 }
 
-func (provider AWSClusteredProvider) Restart(dbInstance *DbInstance) error {
-	return provider.awsInstanceProvider.Restart(dbInstance)
+// Aurora always keeps automated backups, so a cluster can be restored to any time it's been backing up.
+func (provider AWSClusteredProvider) RestorableTimes(ctx context.Context, dbInstance *DbInstance) (time.Time, time.Time, error) {
+	cluster, err := provider.describeCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if cluster.EarliestRestorableTime == nil || cluster.LatestRestorableTime == nil {
+		return time.Time{}, time.Time{}, errors.New("The database cannot be restored to a point in time until its first automated backup has finished.")
+	}
+	return *cluster.EarliestRestorableTime, *cluster.LatestRestorableTime, nil
 }
 
-func (provider AWSClusteredProvider) ListLogs(dbInstance *DbInstance) ([]DatabaseLogs, error) {
-	return provider.awsInstanceProvider.ListLogs(dbInstance)
+// Restores the cluster to a time the same way a backup is restored, the cluster keeps its name,
+// endpoints and credentials.
+func (provider AWSClusteredProvider) RestoreToTime(ctx context.Context, dbInstance *DbInstance, at time.Time) (*DbInstance, error) {
+	defer provider.awsInstanceProvider.instanceCache.Invalidate(dbInstance.Name)
+	settings, err := provider.planSettings(dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	if !dbInstance.Ready {
+		return nil, errors.New("Cannot restore a database that is unavailable.")
+	}
+	err = provider.restoreBySwapping(ctx, dbInstance, settings, func(renamedCluster string, vpcSecurityGroupIds []*string) error {
+		_, err := provider.awssvc.RestoreDBClusterToPointInTimeWithContext(ctx, &rds.RestoreDBClusterToPointInTimeInput{
+			DBClusterIdentifier:			aws.String(dbInstance.Name),
+			SourceDBClusterIdentifier:		aws.String(renamedCluster),
+			RestoreToTime:					aws.Time(at),
+			RestoreType:					aws.String("full-copy"),
+			DBSubnetGroupName:				settings.Cluster.DBSubnetGroupName,
+			VpcSecurityGroupIds:			vpcSecurityGroupIds,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	provider.awsInstanceProvider.instanceCache.Invalidate(dbInstance.Name)
+	restored, err := provider.GetInstance(ctx, dbInstance.Name, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	restored.Id = dbInstance.Id
+	restored.Username = dbInstance.Username
+	restored.Password = dbInstance.Password
+	return restored, nil
 }
 
-func (provider AWSClusteredProvider) GetLogs(dbInstance *DbInstance, path string) (string, error) {
-	return provider.awsInstanceProvider.GetLogs(dbInstance, path)
+// The snapshot of the cluster to fork from, backups of other clusters can't be forked from.
+func (provider AWSClusteredProvider) forkSnapshot(ctx context.Context, from *DbInstance, backup string) (string, error) {
+	snapshots, err := provider.awssvc.DescribeDBClusterSnapshotsWithContext(ctx, &rds.DescribeDBClusterSnapshotsInput{DBClusterIdentifier: aws.String(from.Name)})
+	if err != nil {
+		return "", err
+	}
+	var latest *rds.DBClusterSnapshot
+	for _, snapshot := range snapshots.DBClusterSnapshots {
+		if snapshot.Status == nil || *snapshot.Status != "available" {
+			continue
+		}
+		if backup != LatestBackup && *snapshot.DBClusterSnapshotIdentifier == backup {
+			return backup, nil
+		}
+		if backup == LatestBackup && snapshot.SnapshotCreateTime != nil && (latest == nil || snapshot.SnapshotCreateTime.After(*latest.SnapshotCreateTime)) {
+			latest = snapshot
+		}
+	}
+	if latest == nil {
+		return "", errors.New("The backup " + backup + " of " + from.Name + " could not be found or is not available.")
+	}
+	return *latest.DBClusterSnapshotIdentifier, nil
 }
 
-func (provider AWSClusteredProvider) CreateReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
-	var settings AWSClusteredProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+// Forks of the cluster as it is now are aurora clones, which share storage with the cluster until either
+// of them changes it, so they're quick to make no matter how large the cluster is. Forks of a backup are
+// restored from the snapshot. Either way the fork gets a writer and readers of the plan.
+func (provider AWSClusteredProvider) Fork(ctx context.Context, Id string, plan *ProviderPlan, Owner string, from *DbInstance, backup string) (*DbInstance, error) {
+	settings, err := provider.planSettings(plan)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(provider.namePrefix + RandomString(8))
+	tags := []*rds.Tag{{Key: aws.String("BillingCode"), Value: aws.String(Owner)}}
+
+	if backup == "" {
+		_, err = provider.awssvc.RestoreDBClusterToPointInTimeWithContext(ctx, &rds.RestoreDBClusterToPointInTimeInput{
+			DBClusterIdentifier:			aws.String(name),
+			SourceDBClusterIdentifier:		aws.String(from.Name),
+			RestoreType:					aws.String("copy-on-write"),
+			UseLatestRestorableTime:		aws.Bool(true),
+			DBClusterParameterGroupName:	settings.Cluster.DBClusterParameterGroupName,
+			DBSubnetGroupName:				settings.Cluster.DBSubnetGroupName,
+			Tags:							tags,
+			VpcSecurityGroupIds:			[]*string{aws.String(provider.awsVpcSecurityGroup)},
+		})
+	} else {
+		var snapshot string
+		if snapshot, err = provider.forkSnapshot(ctx, from, backup); err != nil {
+			return nil, err
+		}
+		_, err = provider.awssvc.RestoreDBClusterFromSnapshotWithContext(ctx, &rds.RestoreDBClusterFromSnapshotInput{
+			DBClusterIdentifier:			aws.String(name),
+			SnapshotIdentifier:				aws.String(snapshot),
+			Engine:							settings.Cluster.Engine,
+			DBClusterParameterGroupName:	settings.Cluster.DBClusterParameterGroupName,
+			DBSubnetGroupName:				settings.Cluster.DBSubnetGroupName,
+			Tags:							tags,
+			VpcSecurityGroupIds:			[]*string{aws.String(provider.awsVpcSecurityGroup)},
+		})
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	settings.Instance.DBName = aws.String(dbInstance.Name)
-	settings.Instance.DBInstanceIdentifier = aws.String(dbInstance.Name + "-ro")
-	settings.Instance.MasterUsername = aws.String(strings.ToLower("u" + RandomString(8)))
-	settings.Instance.MasterUserPassword = aws.String(RandomString(16))
-	settings.Instance.VpcSecurityGroupIds = []*string{aws.String(provider.awsVpcSecurityGroup)}
-	settings.Instance.DBClusterIdentifier = aws.String(dbInstance.Name)
+	settings.Instance.DBInstanceIdentifier = aws.String(name)
+	settings.Instance.DBClusterIdentifier = aws.String(name)
+	settings.Instance.Tags = tags
+	dbInstance, err := provider.awsInstanceProvider.ProvisionWithSettings(ctx, Id, plan, &settings.Instance)
+	if err != nil {
+		return nil, err
+	}
+	// the writer has the database name of the cluster it was forked from, rather than its own.
+	dbInstance.Name = name
+	dbInstance.Username = from.Username
+	dbInstance.Password = RandomString(16)
 
-	return provider.awsInstanceProvider.ProvisionWithSettings(dbInstance.Name + "-ro", dbInstance.Plan, &settings.Instance)
+	if err = provider.provisionReaders(ctx, name, settings, tags); err != nil {
+		return nil, err
+	}
+	return dbInstance, nil
 }
 
-func (provider AWSClusteredProvider) GetReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
-	return provider.awsInstanceProvider.GetReadReplica(dbInstance)
+// Forks have the password of the cluster they were forked from until they're given their own, then the
+// plan is applied to the fork as it would be when changing plans.
+func (provider AWSClusteredProvider) PerformFork(ctx context.Context, dbInstance *DbInstance, from *DbInstance, backup string, Owner string) (*DbInstance, error) {
+	defer provider.awsInstanceProvider.instanceCache.Invalidate(dbInstance.Name)
+	_, err := provider.awssvc.ModifyDBClusterWithContext(ctx, &rds.ModifyDBClusterInput{
+		ApplyImmediately:		aws.Bool(true),
+		DBClusterIdentifier:	aws.String(dbInstance.Name),
+		MasterUserPassword:		aws.String(dbInstance.Password),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = provider.awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: 	aws.String(dbInstance.Name),
+		MaxRecords:				aws.Int64(20),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return provider.Modify(ctx, dbInstance, dbInstance.Plan)
+}
+
+func (provider AWSClusteredProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	return provider.awsInstanceProvider.Restart(ctx, dbInstance)
 }
 
-func (provider AWSClusteredProvider) DeleteReadReplica(dbInstance *DbInstance) error {
-	return provider.awsInstanceProvider.DeleteReadReplica(dbInstance)
+func (provider AWSClusteredProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
+	return provider.awsInstanceProvider.ListLogs(ctx, dbInstance)
+}
+
+func (provider AWSClusteredProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
+	return provider.awsInstanceProvider.GetLogs(ctx, dbInstance, path)
+}
+
+// The replica of a cluster is its readers, a cluster without readers gets one. Clusters that scale their
+// readers automatically are left to scale them.
+func (provider AWSClusteredProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	settings, err := provider.planSettings(dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	count, automatic, err := provider.Readers(ctx, dbInstance)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 && !automatic {
+		if err = provider.scaleReaders(ctx, dbInstance, settings, 1); err != nil {
+			return nil, err
+		}
+	}
+	return provider.GetReadReplica(ctx, dbInstance)
+}
+
+// The replica of a cluster is the reader endpoint rather than any one of the readers, it's available once
+// all of the readers are.
+func (provider AWSClusteredProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	cluster, err := provider.describeCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	status := "available"
+	for _, reader := range clusterReaders(cluster) {
+		resp, err := provider.awssvc.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: reader,
+			MaxRecords:           aws.Int64(20),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.DBInstances) == 1 && resp.DBInstances[0].DBInstanceStatus != nil && *resp.DBInstances[0].DBInstanceStatus != "available" {
+			status = *resp.DBInstances[0].DBInstanceStatus
+		}
+	}
+	return &DbInstance{
+		Id:            dbInstance.Id,
+		Name:          dbInstance.Name + "-ro",
+		ProviderId:    *cluster.DBClusterArn,
+		Plan:          dbInstance.Plan,
+		Username:      dbInstance.Username,
+		Password:      dbInstance.Password,
+		Endpoint:      clusterEndpoint(cluster.ReaderEndpoint, cluster),
+		Status:        status,
+		Ready:         IsReady(status),
+		Engine:        dbInstance.Engine,
+		EngineVersion: dbInstance.EngineVersion,
+		Scheme:        dbInstance.Scheme,
+	}, nil
+}
+
+// Removes all of the readers, and the auto scaling that would add them back.
+func (provider AWSClusteredProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	if err := provider.deregisterAutoScaling(ctx, dbInstance.Name); err != nil {
+		return err
+	}
+	cluster, err := provider.describeCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	for _, reader := range clusterReaders(cluster) {
+		if *reader == dbInstance.Name {
+			continue
+		}
+		_, err = provider.awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier: reader,
+			SkipFinalSnapshot:    aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (provider AWSClusteredProvider) CreateReadOnlyUser(dbInstance *DbInstance) (DatabaseUrlSpec, error) {
-	return provider.awsInstanceProvider.CreateReadOnlyUser(dbInstance)
+func (provider AWSClusteredProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+	return provider.awsInstanceProvider.CreateReadOnlyUser(ctx, dbInstance)
 }
 
-func (provider AWSClusteredProvider) DeleteReadOnlyUser(dbInstance *DbInstance, role string) error {
-	return provider.awsInstanceProvider.DeleteReadOnlyUser(dbInstance, role)
+func (provider AWSClusteredProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
+	return provider.awsInstanceProvider.DeleteReadOnlyUser(ctx, dbInstance, role)
 }
 
-func (provider AWSClusteredProvider) RotatePasswordReadOnlyUser(dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
-	return provider.awsInstanceProvider.RotatePasswordReadOnlyUser(dbInstance, role)
+func (provider AWSClusteredProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+	return provider.awsInstanceProvider.RotatePasswordReadOnlyUser(ctx, dbInstance, role)
 }
@@ -1,25 +1,85 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"github.com/aws/aws-sdk-go/service/rds/rdsutils"
 	"github.com/golang/glog"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type AWSInstanceProvider struct {
 	Provider
-	awssvc              *rds.RDS
+	awssvc              rdsiface.RDSAPI
+	region              string
+	regions             *rdsRegions
+	credentials         *credentials.Credentials
 	namePrefix          string
 	awsVpcSecurityGroup string
-	instanceCache       map[string]*DbInstance
+	instanceCache       *InstanceCache
+	pollInterval        time.Duration
+}
+
+// The settings of aws-instance plans are the rds settings databases are created with, plans can
+// also keep a copy of their databases in another region to recover to.
+type AWSInstanceProviderPrivatePlanSettings struct {
+	rds.CreateDBInstanceInput
+	DisasterRecovery *AWSDisasterRecovery `json:"DisasterRecovery,omitempty"`
+}
+
+// Snapshots of databases are copied to the region on a schedule, and optionally a read replica is
+// kept there. Subnet groups, security groups and kms keys belong to a region so the ones to use in
+// the recovery region are given here rather than taken from the plan.
+type AWSDisasterRecovery struct {
+	Region              string    `json:"Region"`
+	SnapshotsKept       *int64    `json:"SnapshotsKept,omitempty"`
+	ReadReplica         bool      `json:"ReadReplica,omitempty"`
+	KmsKeyId            *string   `json:"KmsKeyId,omitempty"`
+	DBSubnetGroupName   *string   `json:"DBSubnetGroupName,omitempty"`
+	VpcSecurityGroupIds []*string `json:"VpcSecurityGroupIds,omitempty"`
+}
+
+// How many copies of snapshots are kept in the recovery region unless the plan says otherwise.
+const defaultRecoverySnapshotsKept = 7
+
+// The rds clients of regions other than the region of the broker, they're created when first used.
+type rdsRegions struct {
+	sync.Mutex
+	clients map[string]rdsiface.RDSAPI
+}
+
+func (regions *rdsRegions) client(region string) rdsiface.RDSAPI {
+	regions.Lock()
+	defer regions.Unlock()
+	if client, ok := regions.clients[region]; ok {
+		return client
+	}
+	client := rds.New(session.New(&aws.Config{Region: aws.String(region)}))
+	regions.clients[region] = client
+	return client
+}
+
+func init() {
+	RegisterProvider(AWSInstance, func(namePrefix string) (Provider, error) {
+		provider, err := NewAWSInstanceProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, AWSInstanceProviderPrivatePlanSettings{})
 }
 
 func NewAWSInstanceProvider(namePrefix string) (*AWSInstanceProvider, error) {
@@ -29,38 +89,113 @@ func NewAWSInstanceProvider(namePrefix string) (*AWSInstanceProvider, error) {
 	if os.Getenv("AWS_VPC_SECURITY_GROUPS") == "" {
 		return nil, errors.New("Unable to find AWS_VPC_SECURITY_GROUPS environment variable.")
 	}
-	t := time.NewTicker(time.Second * 5)
-	awsInstanceProvider := &AWSInstanceProvider{
+	sess := session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+	provider := NewAWSInstanceProviderWithClient(namePrefix, rds.New(sess), os.Getenv("AWS_VPC_SECURITY_GROUPS"))
+	provider.region = os.Getenv("AWS_REGION")
+	provider.credentials = sess.Config.Credentials
+	return provider, nil
+}
+
+// NewAWSInstanceProviderWithClient creates the provider on top of an existing RDS client rather
+// than one configured from the environment, such as a client for another region or a fake.
+func NewAWSInstanceProviderWithClient(namePrefix string, awssvc rdsiface.RDSAPI, awsVpcSecurityGroup string) *AWSInstanceProvider {
+	return &AWSInstanceProvider{
 		namePrefix:          namePrefix,
-		instanceCache:       make(map[string]*DbInstance),
-		awsVpcSecurityGroup: os.Getenv("AWS_VPC_SECURITY_GROUPS"),
-		awssvc:              rds.New(session.New(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})),
+		instanceCache:       NewInstanceCache(time.Second * 5),
+		awsVpcSecurityGroup: awsVpcSecurityGroup,
+		awssvc:              awssvc,
+		regions:             &rdsRegions{clients: make(map[string]rdsiface.RDSAPI)},
+		pollInterval:        time.Second * 30,
 	}
-	go (func() {
-		for {
-			awsInstanceProvider.instanceCache = make(map[string]*DbInstance)
-			<-t.C
-		}
-	})()
-	return awsInstanceProvider, nil
 }
 
-func (provider AWSInstanceProvider) GetInstance(name string, plan *ProviderPlan) (*DbInstance, error) {
-	if provider.instanceCache[name+plan.ID] != nil {
-		return provider.instanceCache[name+plan.ID], nil
+func (provider AWSInstanceProvider) Close() error {
+	provider.instanceCache.Clear()
+	return nil
+}
+
+func (provider AWSInstanceProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	capabilities := ProviderCapabilities{BackupsCapability, RestoreCapability, RolesCapability, LogsCapability, RestartCapability, ReplicasCapability, TagsCapability, VersionsCapability}
+	if plan == nil {
+		return capabilities
+	}
+	var settings AWSInstanceProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return capabilities
+	}
+	// point in time restores need the automated backups rds keeps unless a plan sets the retention period to 0.
+	if settings.BackupRetentionPeriod == nil || *settings.BackupRetentionPeriod > 0 {
+		capabilities = append(capabilities, PointInTimeCapability)
+	}
+	if settings.DisasterRecovery != nil && settings.DisasterRecovery.Region != "" {
+		capabilities = append(capabilities, DisasterRecoveryCapability)
+	}
+	// iam users are only created on postgres, mysql needs them created with its AWSAuthenticationPlugin.
+	if settings.EnableIAMDatabaseAuthentication != nil && *settings.EnableIAMDatabaseAuthentication && settings.Engine != nil && *settings.Engine == "postgres" {
+		capabilities = append(capabilities, IAMAuthCapability)
+	}
+	return capabilities
+}
+
+// The disaster recovery settings of the plan, plans without them can't keep a copy of their databases.
+func (provider AWSInstanceProvider) recoverySettings(plan *ProviderPlan) (*AWSDisasterRecovery, error) {
+	if plan == nil {
+		return nil, ErrFeatureNotAvailable
+	}
+	var settings AWSInstanceProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+	if settings.DisasterRecovery == nil || settings.DisasterRecovery.Region == "" {
+		return nil, ErrFeatureNotAvailable
+	}
+	if settings.DisasterRecovery.Region == provider.region {
+		return nil, errors.New("The disaster recovery region of the plan must be a different region than " + provider.region + ".")
+	}
+	return settings.DisasterRecovery, nil
+}
+
+// Databases promoted from their disaster recovery copy are named after the database they replaced
+// with a -dr suffix (and so are their replicas with a further -ro).
+func isRecoveryName(name string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(name, "-ro"), "-dr")
+}
+
+// The rds client of the region the database is in, databases promoted from their disaster recovery
+// copy are in the recovery region of their plan rather than the region of the broker.
+func (provider AWSInstanceProvider) clientFor(name string, plan *ProviderPlan) rdsiface.RDSAPI {
+	if !isRecoveryName(name) {
+		return provider.awssvc
 	}
-	resp, err := provider.awssvc.DescribeDBInstances(&rds.DescribeDBInstancesInput{
+	recovery, err := provider.recoverySettings(plan)
+	if err != nil {
+		return provider.awssvc
+	}
+	return provider.regions.client(recovery.Region)
+}
+
+func (provider AWSInstanceProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	awssvc := provider.clientFor(name, plan)
+	if dbInstance, ok := provider.instanceCache.Get(name, plan); ok {
+		return dbInstance, nil
+	}
+	resp, err := awssvc.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(name),
 		MaxRecords:           aws.Int64(20),
 	})
 	if err != nil {
 		return nil, err
 	}
+	// forks keep the database name of the database they were forked from.
+	var dbName = name
+	if resp.DBInstances[0].DBName != nil {
+		dbName = *resp.DBInstances[0].DBName
+	}
 	var endpoint = ""
 	if resp.DBInstances[0].Endpoint != nil && resp.DBInstances[0].Endpoint.Port != nil && resp.DBInstances[0].Endpoint.Address != nil {
-		endpoint = *resp.DBInstances[0].Endpoint.Address + ":" + strconv.FormatInt(*resp.DBInstances[0].Endpoint.Port, 10) + "/" + name
+		endpoint = *resp.DBInstances[0].Endpoint.Address + ":" + strconv.FormatInt(*resp.DBInstances[0].Endpoint.Port, 10) + "/" + dbName
 	}
-	provider.instanceCache[name+plan.ID] = &DbInstance{
+	dbInstance := &DbInstance{
 		Id:            "", // providers should not store this.
 		ProviderId:    *resp.DBInstances[0].DBInstanceArn,
 		Name:          name,
@@ -75,15 +210,16 @@ func (provider AWSInstanceProvider) GetInstance(name string, plan *ProviderPlan)
 		Scheme:        plan.Scheme,
 	}
 
-	return provider.instanceCache[name+plan.ID], nil
+	provider.instanceCache.Set(name, plan, dbInstance)
+	return dbInstance, nil
 }
 
-func (provider AWSInstanceProvider) PerformPostProvision(db *DbInstance) (*DbInstance, error) {
+func (provider AWSInstanceProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
 	return db, nil
 }
 
-func (provider AWSInstanceProvider) ProvisionWithSettings(Id string, plan *ProviderPlan, settings *rds.CreateDBInstanceInput) (*DbInstance, error) {
-	resp, err := provider.awssvc.CreateDBInstance(settings)
+func (provider AWSInstanceProvider) ProvisionWithSettings(ctx context.Context, Id string, plan *ProviderPlan, settings *rds.CreateDBInstanceInput) (*DbInstance, error) {
+	resp, err := provider.awssvc.CreateDBInstanceWithContext(ctx, settings)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +245,7 @@ func (provider AWSInstanceProvider) ProvisionWithSettings(Id string, plan *Provi
 	}, nil
 }
 
-func (provider AWSInstanceProvider) Provision(Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+func (provider AWSInstanceProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
 	var settings rds.CreateDBInstanceInput
 	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
 		return nil, err
@@ -122,7 +258,7 @@ func (provider AWSInstanceProvider) Provision(Id string, plan *ProviderPlan, Own
 	settings.Tags = []*rds.Tag{{Key: aws.String("BillingCode"), Value: aws.String(Owner)}}
 	settings.VpcSecurityGroupIds = []*string{aws.String(provider.awsVpcSecurityGroup)}
 
-	dbInstance, err := provider.ProvisionWithSettings(Id, plan, &settings)
+	dbInstance, err := provider.ProvisionWithSettings(ctx, Id, plan, &settings)
 	if err != nil {
 		return nil, err
 	}
@@ -130,28 +266,63 @@ func (provider AWSInstanceProvider) Provision(Id string, plan *ProviderPlan, Own
 	return dbInstance, nil
 }
 
-func (provider AWSInstanceProvider) Deprovision(dbInstance *DbInstance, takeSnapshot bool) error {
-	provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+func (provider AWSInstanceProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name + "-ro"),
 		SkipFinalSnapshot:    aws.Bool(!takeSnapshot),
 	})
 	var err error = nil
 	if takeSnapshot {
-		_, err = provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+		_, err = awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 			DBInstanceIdentifier:      aws.String(dbInstance.Name),
 			FinalDBSnapshotIdentifier: aws.String(dbInstance.Name + "-final"),
 			SkipFinalSnapshot:         aws.Bool(false),
 		})
 	} else {
-		_, err = provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+		_, err = awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 			DBInstanceIdentifier: aws.String(dbInstance.Name),
 			SkipFinalSnapshot:    aws.Bool(true),
 		})
 	}
+	if err == nil && !isRecoveryName(dbInstance.Name) {
+		provider.deprovisionRecovery(ctx, dbInstance, takeSnapshot)
+	}
 	return err
 }
 
-func (provider AWSInstanceProvider) upgradePlan(dbInstance *DbInstance, proposed string) ([]string, error) {
+// Removes the disaster recovery replica of the database, the snapshot copies are kept (as the final
+// snapshot is) when a snapshot is taken. Nothing may have been copied yet so errors are only logged.
+func (provider AWSInstanceProvider) deprovisionRecovery(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) {
+	recovery, err := provider.recoverySettings(dbInstance.Plan)
+	if err != nil {
+		return
+	}
+	target := provider.regions.client(recovery.Region)
+	if _, err := target.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
+		DBInstanceIdentifier: aws.String(dbInstance.Name + "-dr"),
+		SkipFinalSnapshot:    aws.Bool(true),
+	}); err != nil && !isInstanceNotFound(err) {
+		glog.Errorf("ERROR: Unable to remove the disaster recovery replica of %s in %s: %s\n", dbInstance.Name, recovery.Region, err.Error())
+	}
+	if takeSnapshot {
+		return
+	}
+	copies, err := provider.recoverySnapshots(ctx, target, dbInstance.Name)
+	if err != nil {
+		glog.Errorf("ERROR: Unable to list the disaster recovery snapshots of %s in %s: %s\n", dbInstance.Name, recovery.Region, err.Error())
+		return
+	}
+	for _, snapshot := range copies {
+		if _, err := target.DeleteDBSnapshotWithContext(ctx, &rds.DeleteDBSnapshotInput{DBSnapshotIdentifier: snapshot.DBSnapshotIdentifier}); err != nil {
+			glog.Errorf("ERROR: Unable to remove the disaster recovery snapshot %s in %s: %s\n", *snapshot.DBSnapshotIdentifier, recovery.Region, err.Error())
+		}
+	}
+}
+
+func (provider AWSInstanceProvider) upgradePlan(ctx context.Context, dbInstance *DbInstance, proposed string) ([]string, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	proposedVersion := strings.Split(proposed, ".")
 	currentVersion := strings.Split(dbInstance.EngineVersion, ".")
 
@@ -204,7 +375,7 @@ func (provider AWSInstanceProvider) upgradePlan(dbInstance *DbInstance, proposed
 			break
 		}
 
-		devres, err := provider.awssvc.DescribeDBEngineVersions(&rds.DescribeDBEngineVersionsInput{
+		devres, err := awssvc.DescribeDBEngineVersionsWithContext(ctx, &rds.DescribeDBEngineVersionsInput{
 			MaxRecords:    aws.Int64(100),
 			Engine:        aws.String(dbInstance.Engine),
 			EngineVersion: aws.String(strings.Join(currentVersion, ".")),
@@ -258,15 +429,16 @@ func (provider AWSInstanceProvider) upgradePlan(dbInstance *DbInstance, proposed
 	return versionUpgradePlan, nil
 }
 
-func (provider AWSInstanceProvider) UpgradeVersion(dbInstance *DbInstance, proposed string, settings *rds.CreateDBInstanceInput) (*DbInstance, error) {
-	versions, err := provider.upgradePlan(dbInstance, proposed)
+func (provider AWSInstanceProvider) UpgradeVersion(ctx context.Context, dbInstance *DbInstance, proposed string, settings *rds.CreateDBInstanceInput) (*DbInstance, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	versions, err := provider.upgradePlan(ctx, dbInstance, proposed)
 	if err != nil {
 		return nil, err
 	}
 
 	// Begin the upgrades.
 	for _, version := range versions {
-		err = provider.awssvc.WaitUntilDBInstanceAvailable(&rds.DescribeDBInstancesInput{
+		err = awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
 			DBInstanceIdentifier: aws.String(dbInstance.Name),
 			MaxRecords:           aws.Int64(20),
 		})
@@ -279,7 +451,7 @@ func (provider AWSInstanceProvider) UpgradeVersion(dbInstance *DbInstance, propo
 		// different parameter group (if non default) in order to to reach the target
 		// parameter group of the plan.
 
-		devres, err := provider.awssvc.DescribeDBEngineVersions(&rds.DescribeDBEngineVersionsInput{
+		devres, err := awssvc.DescribeDBEngineVersionsWithContext(ctx, &rds.DescribeDBEngineVersionsInput{
 			MaxRecords:    aws.Int64(100),
 			Engine:        aws.String(dbInstance.Engine),
 			EngineVersion: aws.String(version),
@@ -291,7 +463,7 @@ func (provider AWSInstanceProvider) UpgradeVersion(dbInstance *DbInstance, propo
 			return nil, errors.New("No valid db engine versions could be found for " + dbInstance.Engine + " " + version)
 		}
 
-		groups, err := provider.awssvc.DescribeDBParameterGroups(&rds.DescribeDBParameterGroupsInput{})
+		groups, err := awssvc.DescribeDBParameterGroupsWithContext(ctx, &rds.DescribeDBParameterGroupsInput{})
 		if err != nil {
 			return nil, err
 		}
@@ -330,7 +502,7 @@ func (provider AWSInstanceProvider) UpgradeVersion(dbInstance *DbInstance, propo
 		} else {
 			glog.Infof("Database: %s upgrading to %s %s with no specified parameter group.\n", dbInstance.Id, dbInstance.Engine, dbInstance.EngineVersion)
 		}
-		_, err = provider.awssvc.ModifyDBInstance(&rds.ModifyDBInstanceInput{
+		_, err = awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
 			AllowMajorVersionUpgrade: aws.Bool(true),
 			EngineVersion:            aws.String(version),
 			ApplyImmediately:         aws.Bool(true),
@@ -342,10 +514,11 @@ func (provider AWSInstanceProvider) UpgradeVersion(dbInstance *DbInstance, propo
 		}
 		dbInstance.EngineVersion = version
 		glog.Infof("Database: %s upgraded to %s %s\n", dbInstance.Id, dbInstance.Engine, dbInstance.EngineVersion)
-		tick := time.NewTicker(time.Second * 30)
-		<-tick.C
+		if err := sleepWithContext(ctx, provider.pollInterval); err != nil {
+			return nil, err
+		}
 	}
-	err = provider.awssvc.WaitUntilDBInstanceAvailable(&rds.DescribeDBInstancesInput{
+	err = awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		MaxRecords:           aws.Int64(20),
 	})
@@ -355,8 +528,47 @@ func (provider AWSInstanceProvider) UpgradeVersion(dbInstance *DbInstance, propo
 	return dbInstance, nil
 }
 
-func (provider AWSInstanceProvider) ModifyWithSettings(dbInstance *DbInstance, plan *ProviderPlan, settings *rds.CreateDBInstanceInput) (*DbInstance, error) {
-	dest, err := provider.awssvc.DescribeDBInstances(&rds.DescribeDBInstancesInput{
+// The versions rds can upgrade the database to directly from its current version.
+func (provider AWSInstanceProvider) UpgradeTargets(ctx context.Context, dbInstance *DbInstance) ([]string, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	devres, err := awssvc.DescribeDBEngineVersionsWithContext(ctx, &rds.DescribeDBEngineVersionsInput{
+		MaxRecords:    aws.Int64(100),
+		Engine:        aws.String(dbInstance.Engine),
+		EngineVersion: aws.String(dbInstance.EngineVersion),
+	})
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0)
+	if len(devres.DBEngineVersions) == 0 {
+		return targets, nil
+	}
+	for _, target := range devres.DBEngineVersions[0].ValidUpgradeTarget {
+		if target.EngineVersion != nil {
+			targets = append(targets, *target.EngineVersion)
+		}
+	}
+	return targets, nil
+}
+
+// Upgrades the engine of the database without changing its plan, the parameter group of the plan is
+// kept if it can be used with the new version.
+func (provider AWSInstanceProvider) UpgradeEngineVersion(ctx context.Context, dbInstance *DbInstance, version string) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	if !CanBeModified(dbInstance.Status) {
+		return nil, errors.New("Databases cannot be modifed during backups, upgrades or while maintenance is being performed.")
+	}
+	var settings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+	return provider.UpgradeVersion(ctx, dbInstance, version, &settings)
+}
+
+func (provider AWSInstanceProvider) ModifyWithSettings(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan, settings *rds.CreateDBInstanceInput) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	dest, err := awssvc.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		MaxRecords:           aws.Int64(20),
 	})
@@ -367,40 +579,46 @@ func (provider AWSInstanceProvider) ModifyWithSettings(dbInstance *DbInstance, p
 		return nil, errors.New("Cannot find database to modify!")
 	}
 	glog.Infof("Database: %s modifying settings...\n", dbInstance.Id)
-	resp, err := provider.awssvc.ModifyDBInstance(&rds.ModifyDBInstanceInput{
-		AllocatedStorage:        settings.AllocatedStorage,
-		AutoMinorVersionUpgrade: settings.AutoMinorVersionUpgrade,
-		ApplyImmediately:        aws.Bool(true),
-		DBInstanceClass:         settings.DBInstanceClass,
-		DBInstanceIdentifier:    aws.String(dbInstance.Name),
-		MultiAZ:                 settings.MultiAZ,
-		PubliclyAccessible:      settings.PubliclyAccessible,
-		CopyTagsToSnapshot:      settings.CopyTagsToSnapshot,
-		BackupRetentionPeriod:   settings.BackupRetentionPeriod,
-		StorageType:             settings.StorageType,
-		Iops:                    settings.Iops,
+	resp, err := awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
+		AllocatedStorage:                settings.AllocatedStorage,
+		AutoMinorVersionUpgrade:         settings.AutoMinorVersionUpgrade,
+		ApplyImmediately:                aws.Bool(true),
+		DBInstanceClass:                 settings.DBInstanceClass,
+		DBInstanceIdentifier:            aws.String(dbInstance.Name),
+		MultiAZ:                         settings.MultiAZ,
+		PubliclyAccessible:              settings.PubliclyAccessible,
+		CopyTagsToSnapshot:              settings.CopyTagsToSnapshot,
+		BackupRetentionPeriod:           settings.BackupRetentionPeriod,
+		StorageType:                     settings.StorageType,
+		Iops:                            settings.Iops,
+		EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	tick := time.NewTicker(time.Second * 30)
-	<-tick.C
+	if err := sleepWithContext(ctx, provider.pollInterval); err != nil {
+		return nil, err
+	}
 
+	var dbName = dbInstance.Name
+	if resp.DBInstance.DBName != nil {
+		dbName = *resp.DBInstance.DBName
+	}
 	var endpoint = dbInstance.Endpoint
 	if resp.DBInstance.Endpoint != nil && resp.DBInstance.Endpoint.Port != nil && resp.DBInstance.Endpoint.Address != nil {
-		endpoint = *resp.DBInstance.Endpoint.Address + ":" + strconv.FormatInt(*resp.DBInstance.Endpoint.Port, 10) + "/" + dbInstance.Name
+		endpoint = *resp.DBInstance.Endpoint.Address + ":" + strconv.FormatInt(*resp.DBInstance.Endpoint.Port, 10) + "/" + dbName
 	}
 
 	// TODO: What about replicas?
 
 	// Upgrade the version seperately as this may be a lot of work.
-	newDbInstance, err := provider.UpgradeVersion(dbInstance, *settings.EngineVersion, settings)
+	newDbInstance, err := provider.UpgradeVersion(ctx, dbInstance, *settings.EngineVersion, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	dest, err = provider.awssvc.DescribeDBInstances(&rds.DescribeDBInstancesInput{
+	dest, err = awssvc.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(newDbInstance.Name),
 		MaxRecords:           aws.Int64(20),
 	})
@@ -427,7 +645,7 @@ func (provider AWSInstanceProvider) ModifyWithSettings(dbInstance *DbInstance, p
 	}, nil
 }
 
-func (provider AWSInstanceProvider) Modify(dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+func (provider AWSInstanceProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
 	if !CanBeModified(dbInstance.Status) {
 		return nil, errors.New("Databases cannot be modifed during backups, upgrades or while maintenance is being performed.")
 	}
@@ -448,13 +666,47 @@ func (provider AWSInstanceProvider) Modify(dbInstance *DbInstance, plan *Provide
 		}
 	}
 
-	return provider.ModifyWithSettings(dbInstance, plan, &settings)
+	return provider.ModifyWithSettings(ctx, dbInstance, plan, &settings)
 }
 
-func (provider AWSInstanceProvider) Tag(dbInstance *DbInstance, Name string, Value string) error {
+// Previews Modify, the engine versions are the steps UpgradeVersion would take to reach the version of the plan.
+func (provider AWSInstanceProvider) PreviewModify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*UpgradePreview, error) {
+	var settings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+
+	var oldSettings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &oldSettings); err != nil {
+		return nil, err
+	}
+
+	preview := UpgradePreview{Plan: plan.ID, Method: UpgradeMethodModify, VersionSteps: []string{}, Downtime: DowntimeNone}
+	if oldSettings.AllocatedStorage != nil && settings.AllocatedStorage != nil {
+		preview.StorageKept = *oldSettings.AllocatedStorage > *settings.AllocatedStorage
+	}
+	if settings.EngineVersion != nil {
+		versions, err := provider.upgradePlan(ctx, dbInstance, *settings.EngineVersion)
+		if err != nil {
+			return nil, err
+		}
+		preview.VersionSteps = append(preview.VersionSteps, versions...)
+	}
+
+	// Engine upgrades take the database down for each step, changing the instance class restarts it (or fails over).
+	if len(preview.VersionSteps) > 0 {
+		preview.Downtime = DowntimeExtended
+	} else if aws.StringValue(oldSettings.DBInstanceClass) != aws.StringValue(settings.DBInstanceClass) {
+		preview.Downtime = DowntimeBrief
+	}
+	return &preview, nil
+}
+
+func (provider AWSInstanceProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	// TODO: what abouut read replica?
 	// TODO: Support multiple values of the same tag name, comma delimit them.
-	_, err := provider.awssvc.AddTagsToResource(&rds.AddTagsToResourceInput{
+	_, err := awssvc.AddTagsToResourceWithContext(ctx, &rds.AddTagsToResourceInput{
 		ResourceName: aws.String(dbInstance.ProviderId),
 		Tags: []*rds.Tag{
 			{
@@ -466,10 +718,11 @@ func (provider AWSInstanceProvider) Tag(dbInstance *DbInstance, Name string, Val
 	return err
 }
 
-func (provider AWSInstanceProvider) Untag(dbInstance *DbInstance, Name string) error {
+func (provider AWSInstanceProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	// TODO: what abouut read replica?
 	// TODO: Support multiple values of the same tag name, comma delimit them.
-	_, err := provider.awssvc.RemoveTagsFromResource(&rds.RemoveTagsFromResourceInput{
+	_, err := awssvc.RemoveTagsFromResourceWithContext(ctx, &rds.RemoveTagsFromResourceInput{
 		ResourceName: aws.String(dbInstance.ProviderId),
 		TagKeys: []*string{
 			aws.String(Name),
@@ -478,8 +731,9 @@ func (provider AWSInstanceProvider) Untag(dbInstance *DbInstance, Name string) e
 	return err
 }
 
-func (provider AWSInstanceProvider) GetBackup(dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
-	snapshots, err := provider.awssvc.DescribeDBSnapshots(&rds.DescribeDBSnapshotsInput{
+func (provider AWSInstanceProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	snapshots, err := awssvc.DescribeDBSnapshotsWithContext(ctx, &rds.DescribeDBSnapshotsInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		DBSnapshotIdentifier: aws.String(Id),
 	})
@@ -506,8 +760,9 @@ func (provider AWSInstanceProvider) GetBackup(dbInstance *DbInstance, Id string)
 	}, nil
 }
 
-func (provider AWSInstanceProvider) ListBackups(dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
-	snapshots, err := provider.awssvc.DescribeDBSnapshots(&rds.DescribeDBSnapshotsInput{DBInstanceIdentifier: aws.String(dbInstance.Name)})
+func (provider AWSInstanceProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	snapshots, err := awssvc.DescribeDBSnapshotsWithContext(ctx, &rds.DescribeDBSnapshotsInput{DBInstanceIdentifier: aws.String(dbInstance.Name)})
 	if err != nil {
 		return []DatabaseBackupSpec{}, err
 	}
@@ -530,12 +785,13 @@ func (provider AWSInstanceProvider) ListBackups(dbInstance *DbInstance) ([]Datab
 	return out, nil
 }
 
-func (provider AWSInstanceProvider) CreateBackup(dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+func (provider AWSInstanceProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	if !dbInstance.Ready {
 		return DatabaseBackupSpec{}, errors.New("Cannot create read only user on database that is unavailable.")
 	}
 	snapshot_name := (dbInstance.Name + "-manual-" + RandomString(10))
-	snapshot, err := provider.awssvc.CreateDBSnapshot(&rds.CreateDBSnapshotInput{
+	snapshot, err := awssvc.CreateDBSnapshotWithContext(ctx, &rds.CreateDBSnapshotInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		DBSnapshotIdentifier: aws.String(snapshot_name),
 	})
@@ -558,7 +814,9 @@ func (provider AWSInstanceProvider) CreateBackup(dbInstance *DbInstance) (Databa
 	}, nil
 }
 
-func (provider AWSInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id string) error {
+func (provider AWSInstanceProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	var settings rds.CreateDBInstanceInput
 	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
 		return err
@@ -568,9 +826,22 @@ func (provider AWSInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id str
 		return errors.New("Cannot restore backup on database that is unavailable.")
 	}
 
-	// For AWS, the best strategy for restoring (reliably) a database is to rename the existing db
-	// then create from a snapshot the existing db, and then nuke the old one once finished.
-	awsDbResp, err := provider.awssvc.DescribeDBInstances(&rds.DescribeDBInstancesInput{
+	return provider.restoreBySwapping(ctx, dbInstance, &settings, func(renamedId string) error {
+		_, err := awssvc.RestoreDBInstanceFromDBSnapshotWithContext(ctx, &rds.RestoreDBInstanceFromDBSnapshotInput{
+			DBInstanceIdentifier:            aws.String(dbInstance.Name),
+			DBSnapshotIdentifier:            aws.String(Id),
+			DBSubnetGroupName:               settings.DBSubnetGroupName,
+			EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
+		})
+		return err
+	})
+}
+
+// For AWS, the best strategy for restoring (reliably) a database is to rename the existing db
+// then create the db again under its name (restore), and then nuke the old one once finished.
+func (provider AWSInstanceProvider) restoreBySwapping(ctx context.Context, dbInstance *DbInstance, settings *rds.CreateDBInstanceInput, restore func(renamedId string) error) error {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	awsDbResp, err := awssvc.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		MaxRecords:           aws.Int64(20),
 	})
@@ -587,7 +858,7 @@ func (provider AWSInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id str
 
 	renamedId := dbInstance.Name + "-restore-" + RandomString(5)
 
-	_, err = provider.awssvc.ModifyDBInstance(&rds.ModifyDBInstanceInput{
+	_, err = awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
 		ApplyImmediately:        aws.Bool(true),
 		DBInstanceIdentifier:    aws.String(dbInstance.Name),
 		NewDBInstanceIdentifier: aws.String(renamedId),
@@ -596,20 +867,27 @@ func (provider AWSInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id str
 		return err
 	}
 
-	err = provider.awssvc.WaitUntilDBInstanceAvailable(&rds.DescribeDBInstancesInput{
+	err = awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(renamedId),
 		MaxRecords:           aws.Int64(20),
 	})
 	if err != nil {
 		return err
 	}
-	_, err = provider.awssvc.RestoreDBInstanceFromDBSnapshot(&rds.RestoreDBInstanceFromDBSnapshotInput{
-		DBInstanceIdentifier: aws.String(dbInstance.Name),
-		DBSnapshotIdentifier: aws.String(Id),
-		DBSubnetGroupName:    settings.DBSubnetGroupName,
-	})
+	if err = restore(renamedId); err != nil {
+		// nothing was restored, so the database is put back under its name.
+		_, renameErr := awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
+			ApplyImmediately:        aws.Bool(true),
+			DBInstanceIdentifier:    aws.String(renamedId),
+			NewDBInstanceIdentifier: aws.String(dbInstance.Name),
+		})
+		if renameErr != nil {
+			glog.Errorf("ERROR: Unable to rename %s back to %s after the restore failed: %s\n", renamedId, dbInstance.Name, renameErr.Error())
+		}
+		return err
+	}
 
-	err = provider.awssvc.WaitUntilDBInstanceAvailable(&rds.DescribeDBInstancesInput{
+	err = awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		MaxRecords:           aws.Int64(20),
 	})
@@ -620,7 +898,7 @@ func (provider AWSInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id str
 	// The restored instance does not have the same security groups, nor is there a way
 	// of specifying the security groups when restoring the database on the previous call,
 	// so we have to modify the newly created restore.
-	_, err = provider.awssvc.ModifyDBInstance(&rds.ModifyDBInstanceInput{
+	_, err = awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
 		ApplyImmediately:     aws.Bool(true),
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		VpcSecurityGroupIds:  dbSecurityGroups,
@@ -630,14 +908,14 @@ func (provider AWSInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id str
 		return err
 	}
 
-	err = provider.awssvc.WaitUntilDBInstanceAvailable(&rds.DescribeDBInstancesInput{
+	err = awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		MaxRecords:           aws.Int64(20),
 	})
 	if err != nil {
 		fmt.Printf("Unable to clean up database that should be removed after restoring (WaitUntilDBInstanceAvailable): %s %s\n", renamedId, err.Error())
 	}
-	_, err = provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+	_, err = awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 		DBInstanceIdentifier: aws.String(renamedId),
 		SkipFinalSnapshot:    aws.Bool(true),
 	})
@@ -647,22 +925,244 @@ func (provider AWSInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id str
 	return err
 }
 
-func (provider AWSInstanceProvider) Restart(dbInstance *DbInstance) error {
+// Instances can be restored to any time since the oldest automated backup rds keeps, up to its latest
+// restorable time which trails the present by a few minutes.
+func (provider AWSInstanceProvider) RestorableTimes(ctx context.Context, dbInstance *DbInstance) (time.Time, time.Time, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	resp, err := awssvc.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(dbInstance.Name),
+		MaxRecords:           aws.Int64(20),
+	})
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if len(resp.DBInstances) != 1 {
+		return time.Time{}, time.Time{}, errors.New("Not found")
+	}
+	if resp.DBInstances[0].BackupRetentionPeriod != nil && *resp.DBInstances[0].BackupRetentionPeriod == 0 {
+		return time.Time{}, time.Time{}, ErrFeatureNotAvailable
+	}
+	snapshots, err := awssvc.DescribeDBSnapshotsWithContext(ctx, &rds.DescribeDBSnapshotsInput{
+		DBInstanceIdentifier: aws.String(dbInstance.Name),
+		SnapshotType:         aws.String("automated"),
+	})
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	var earliest *time.Time
+	for _, snapshot := range snapshots.DBSnapshots {
+		if snapshot.SnapshotCreateTime != nil && snapshot.Status != nil && *snapshot.Status == "available" && (earliest == nil || snapshot.SnapshotCreateTime.Before(*earliest)) {
+			earliest = snapshot.SnapshotCreateTime
+		}
+	}
+	if earliest == nil || resp.DBInstances[0].LatestRestorableTime == nil {
+		return time.Time{}, time.Time{}, errors.New("The database cannot be restored to a point in time until its first automated backup has finished.")
+	}
+	return *earliest, *resp.DBInstances[0].LatestRestorableTime, nil
+}
+
+// Restores the database to a time the same way a backup is restored, the database keeps its name,
+// endpoint and credentials.
+func (provider AWSInstanceProvider) RestoreToTime(ctx context.Context, dbInstance *DbInstance, at time.Time) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	var settings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+	if !dbInstance.Ready {
+		return nil, errors.New("Cannot restore a database that is unavailable.")
+	}
+	err := provider.restoreBySwapping(ctx, dbInstance, &settings, func(renamedId string) error {
+		_, err := awssvc.RestoreDBInstanceToPointInTimeWithContext(ctx, &rds.RestoreDBInstanceToPointInTimeInput{
+			SourceDBInstanceIdentifier:      aws.String(renamedId),
+			TargetDBInstanceIdentifier:      aws.String(dbInstance.Name),
+			RestoreTime:                     aws.Time(at),
+			DBSubnetGroupName:               settings.DBSubnetGroupName,
+			EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	provider.instanceCache.Invalidate(dbInstance.Name)
+	restored, err := provider.GetInstance(ctx, dbInstance.Name, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	c := *restored
+	c.Id = dbInstance.Id
+	c.Username = dbInstance.Username
+	c.Password = dbInstance.Password
+	return &c, nil
+}
+
+// The snapshot of the database to fork from, backups of other databases can't be forked from.
+func (provider AWSInstanceProvider) forkSnapshot(ctx context.Context, from *DbInstance, backup string) (string, error) {
+	awssvc := provider.clientFor(from.Name, from.Plan)
+	snapshots, err := awssvc.DescribeDBSnapshotsWithContext(ctx, &rds.DescribeDBSnapshotsInput{DBInstanceIdentifier: aws.String(from.Name)})
+	if err != nil {
+		return "", err
+	}
+	var latest *rds.DBSnapshot
+	for _, snapshot := range snapshots.DBSnapshots {
+		if snapshot.Status == nil || *snapshot.Status != "available" {
+			continue
+		}
+		if backup != LatestBackup && *snapshot.DBSnapshotIdentifier == backup {
+			return backup, nil
+		}
+		if backup == LatestBackup && snapshot.SnapshotCreateTime != nil && (latest == nil || snapshot.SnapshotCreateTime.After(*latest.SnapshotCreateTime)) {
+			latest = snapshot
+		}
+	}
+	if latest == nil {
+		return "", errors.New("The backup " + backup + " of " + from.Name + " could not be found or is not available.")
+	}
+	return *latest.DBSnapshotIdentifier, nil
+}
+
+// Forks are restored from a snapshot of the database, or to its latest restorable time when no backup is
+// given, with the settings of the plan. Until PerformFork has run the fork has the password of the database
+// it was forked from.
+func (provider AWSInstanceProvider) Fork(ctx context.Context, Id string, plan *ProviderPlan, Owner string, from *DbInstance, backup string) (*DbInstance, error) {
+	var settings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(provider.namePrefix + RandomString(8))
+	tags := []*rds.Tag{{Key: aws.String("BillingCode"), Value: aws.String(Owner)}}
+
+	if _, err := provider.recoverySettings(from.Plan); err == nil && isRecoveryName(from.Name) {
+		return nil, errors.New("Databases promoted in their disaster recovery region cannot be forked.")
+	}
+
+	var instance *rds.DBInstance
+	if backup == "" {
+		resp, err := provider.awssvc.RestoreDBInstanceToPointInTimeWithContext(ctx, &rds.RestoreDBInstanceToPointInTimeInput{
+			SourceDBInstanceIdentifier:      aws.String(from.Name),
+			TargetDBInstanceIdentifier:      aws.String(name),
+			UseLatestRestorableTime:         aws.Bool(true),
+			AutoMinorVersionUpgrade:         settings.AutoMinorVersionUpgrade,
+			CopyTagsToSnapshot:              settings.CopyTagsToSnapshot,
+			DBInstanceClass:                 settings.DBInstanceClass,
+			DBSubnetGroupName:               settings.DBSubnetGroupName,
+			Iops:                            settings.Iops,
+			MultiAZ:                         settings.MultiAZ,
+			PubliclyAccessible:              settings.PubliclyAccessible,
+			StorageType:                     settings.StorageType,
+			Tags:                            tags,
+			EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
+		})
+		if err != nil {
+			return nil, err
+		}
+		instance = resp.DBInstance
+	} else {
+		snapshot, err := provider.forkSnapshot(ctx, from, backup)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := provider.awssvc.RestoreDBInstanceFromDBSnapshotWithContext(ctx, &rds.RestoreDBInstanceFromDBSnapshotInput{
+			DBInstanceIdentifier:            aws.String(name),
+			DBSnapshotIdentifier:            aws.String(snapshot),
+			AutoMinorVersionUpgrade:         settings.AutoMinorVersionUpgrade,
+			CopyTagsToSnapshot:              settings.CopyTagsToSnapshot,
+			DBInstanceClass:                 settings.DBInstanceClass,
+			DBSubnetGroupName:               settings.DBSubnetGroupName,
+			Iops:                            settings.Iops,
+			MultiAZ:                         settings.MultiAZ,
+			PubliclyAccessible:              settings.PubliclyAccessible,
+			StorageType:                     settings.StorageType,
+			Tags:                            tags,
+			EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
+		})
+		if err != nil {
+			return nil, err
+		}
+		instance = resp.DBInstance
+	}
+
+	return &DbInstance{
+		Id:            Id,
+		Name:          name,
+		ProviderId:    *instance.DBInstanceArn,
+		Plan:          plan,
+		Username:      from.Username,
+		Password:      RandomString(16),
+		Endpoint:      "",
+		Status:        *instance.DBInstanceStatus,
+		Ready:         IsReady(*instance.DBInstanceStatus),
+		Engine:        *instance.Engine,
+		EngineVersion: *instance.EngineVersion,
+		Scheme:        plan.Scheme,
+	}, nil
+}
+
+// Restored instances get the default security group and the password of the database they were
+// restored from, both are changed before the rest of the plan is applied to the fork.
+func (provider AWSInstanceProvider) PerformFork(ctx context.Context, dbInstance *DbInstance, from *DbInstance, backup string, Owner string) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	var settings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+	_, err := awssvc.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
+		ApplyImmediately:     aws.Bool(true),
+		DBInstanceIdentifier: aws.String(dbInstance.Name),
+		MasterUserPassword:   aws.String(dbInstance.Password),
+		VpcSecurityGroupIds:  []*string{aws.String(provider.awsVpcSecurityGroup)},
+		DBParameterGroupName: settings.DBParameterGroupName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = awssvc.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(dbInstance.Name),
+		MaxRecords:           aws.Int64(20),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the fork has at least the storage of the snapshot it was restored from.
+	resp, err := awssvc.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(dbInstance.Name),
+		MaxRecords:           aws.Int64(20),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.DBInstances) != 1 {
+		return nil, errors.New("Cannot find database to fork into!")
+	}
+	if resp.DBInstances[0].AllocatedStorage != nil && settings.AllocatedStorage != nil && *resp.DBInstances[0].AllocatedStorage > *settings.AllocatedStorage {
+		settings.AllocatedStorage = resp.DBInstances[0].AllocatedStorage
+	}
+	return provider.ModifyWithSettings(ctx, dbInstance, dbInstance.Plan, &settings)
+}
+
+func (provider AWSInstanceProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	// What about replica?
 	if !dbInstance.Ready {
 		return errors.New("Cannot restart a database that is unavailable.")
 	}
-	_, err := provider.awssvc.RebootDBInstance(&rds.RebootDBInstanceInput{
+	_, err := awssvc.RebootDBInstanceWithContext(ctx, &rds.RebootDBInstanceInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 	})
 	return err
 }
 
-func (provider AWSInstanceProvider) ListLogs(dbInstance *DbInstance) ([]DatabaseLogs, error) {
+func (provider AWSInstanceProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	// What about replica?
 	var fileLastWritten int64 = time.Now().AddDate(0, 0, -7).Unix()
 	var maxRecords int64 = 100
-	logs, err := provider.awssvc.DescribeDBLogFiles(&rds.DescribeDBLogFilesInput{
+	logs, err := awssvc.DescribeDBLogFilesWithContext(ctx, &rds.DescribeDBLogFilesInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name),
 		FileLastWritten:      &fileLastWritten,
 		MaxRecords:           &maxRecords,
@@ -685,9 +1185,10 @@ func (provider AWSInstanceProvider) ListLogs(dbInstance *DbInstance) ([]Database
 	return out, nil
 }
 
-func (provider AWSInstanceProvider) GetLogs(dbInstance *DbInstance, path string) (string, error) {
+func (provider AWSInstanceProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	// What about replica?
-	data, err := provider.awssvc.DownloadDBLogFilePortion(&rds.DownloadDBLogFilePortionInput{
+	data, err := awssvc.DownloadDBLogFilePortionWithContext(ctx, &rds.DownloadDBLogFilePortionInput{
 		DBInstanceIdentifier: &dbInstance.Name,
 		LogFileName:          &path,
 	})
@@ -701,7 +1202,8 @@ func (provider AWSInstanceProvider) GetLogs(dbInstance *DbInstance, path string)
 	}
 }
 
-func (provider AWSInstanceProvider) CreateReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
+func (provider AWSInstanceProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
 	// TODO: what about tags set?
 	if dbInstance.Status != "available" {
 		return nil, errors.New("Replicas cannot be created for databases being created, under maintenance or destroyed.")
@@ -712,17 +1214,18 @@ func (provider AWSInstanceProvider) CreateReadReplica(dbInstance *DbInstance) (*
 	}
 
 	rdsInstance := rds.CreateDBInstanceReadReplicaInput{
-		DBInstanceClass:             settings.DBInstanceClass,
-		SourceDBInstanceIdentifier:  aws.String(dbInstance.Name),
-		DBInstanceIdentifier:        aws.String(dbInstance.Name + "-ro"),
-		AutoMinorVersionUpgrade:     settings.AutoMinorVersionUpgrade,
-		MultiAZ:                     settings.MultiAZ,
-		PubliclyAccessible:          settings.PubliclyAccessible,
-		Port:                        settings.Port,
-		CopyTagsToSnapshot:          settings.CopyTagsToSnapshot,
-		KmsKeyId:                    settings.KmsKeyId,
-		StorageType:                 settings.StorageType,
-		Iops:                        settings.Iops,
+		DBInstanceClass:                 settings.DBInstanceClass,
+		SourceDBInstanceIdentifier:      aws.String(dbInstance.Name),
+		DBInstanceIdentifier:            aws.String(dbInstance.Name + "-ro"),
+		AutoMinorVersionUpgrade:         settings.AutoMinorVersionUpgrade,
+		MultiAZ:                         settings.MultiAZ,
+		PubliclyAccessible:              settings.PubliclyAccessible,
+		Port:                            settings.Port,
+		CopyTagsToSnapshot:              settings.CopyTagsToSnapshot,
+		KmsKeyId:                        settings.KmsKeyId,
+		StorageType:                     settings.StorageType,
+		Iops:                            settings.Iops,
+		EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
 		Tags: []*rds.Tag{
 			{
 				Key:   aws.String("Name"),
@@ -747,7 +1250,7 @@ func (provider AWSInstanceProvider) CreateReadReplica(dbInstance *DbInstance) (*
 	}
 		
 
-	resp, err := provider.awssvc.CreateDBInstanceReadReplica(&rdsInstance)
+	resp, err := awssvc.CreateDBInstanceReadReplicaWithContext(ctx, &rdsInstance)
 	if err != nil {
 		return nil, err
 	}
@@ -773,8 +1276,8 @@ func (provider AWSInstanceProvider) CreateReadReplica(dbInstance *DbInstance) (*
 	}, nil
 }
 
-func (provider AWSInstanceProvider) GetReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
-	rrDbInstance, err := provider.GetInstance(dbInstance.Name+"-ro", dbInstance.Plan)
+func (provider AWSInstanceProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	rrDbInstance, err := provider.GetInstance(ctx, dbInstance.Name+"-ro", dbInstance.Plan)
 	if err != nil {
 		return nil, err
 	}
@@ -783,31 +1286,343 @@ func (provider AWSInstanceProvider) GetReadReplica(dbInstance *DbInstance) (*DbI
 	return rrDbInstance, nil
 }
 
-func (provider AWSInstanceProvider) DeleteReadReplica(dbInstance *DbInstance) error {
-	_, err := provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+func (provider AWSInstanceProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	awssvc := provider.clientFor(dbInstance.Name, dbInstance.Plan)
+	_, err := awssvc.DeleteDBInstanceWithContext(ctx, &rds.DeleteDBInstanceInput{
 		DBInstanceIdentifier: aws.String(dbInstance.Name + "-ro"),
 		SkipFinalSnapshot:    aws.Bool(true),
 	})
 	return err
 }
 
-func (provider AWSInstanceProvider) CreateReadOnlyUser(dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+func (provider AWSInstanceProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
 	if !dbInstance.Ready {
 		return DatabaseUrlSpec{}, errors.New("Cannot create user on database that is unavailable.")
 	}
-	return CreatePostgresReadOnlyRole(dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint)
+	return CreatePostgresReadOnlyRole(ctx, dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint)
 }
 
-func (provider AWSInstanceProvider) DeleteReadOnlyUser(dbInstance *DbInstance, role string) error {
+func (provider AWSInstanceProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
 	if !dbInstance.Ready {
 		return errors.New("Cannot delete user on database that is unavailable.")
 	}
-	return DeletePostgresReadOnlyRole(dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint, role)
+	return DeletePostgresReadOnlyRole(ctx, dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint, role)
 }
 
-func (provider AWSInstanceProvider) RotatePasswordReadOnlyUser(dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+func (provider AWSInstanceProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
 	if !dbInstance.Ready {
 		return DatabaseUrlSpec{}, errors.New("Cannot rotate password on database that is unavailable.")
 	}
-	return RotatePostgresReadOnlyRole(dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint, role)
+	return RotatePostgresReadOnlyRole(ctx, dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint, role)
+}
+
+// The region the database is in, see clientFor.
+func (provider AWSInstanceProvider) regionFor(name string, plan *ProviderPlan) string {
+	if !isRecoveryName(name) {
+		return provider.region
+	}
+	recovery, err := provider.recoverySettings(plan)
+	if err != nil {
+		return provider.region
+	}
+	return recovery.Region
+}
+
+func (provider AWSInstanceProvider) CreateIAMUser(ctx context.Context, dbInstance *DbInstance) (string, error) {
+	if !dbInstance.Ready {
+		return "", errors.New("Cannot create user on database that is unavailable.")
+	}
+	if !provider.Capabilities(dbInstance.Plan).Has(IAMAuthCapability) {
+		return "", ErrFeatureNotAvailable
+	}
+	username := "iam" + strings.ToLower(RandomString(8))
+	if err := createPostgresIAMUser(ctx, dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint, username); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+func (provider AWSInstanceProvider) DeleteIAMUser(ctx context.Context, dbInstance *DbInstance, username string) error {
+	if !dbInstance.Ready {
+		return errors.New("Cannot delete user on database that is unavailable.")
+	}
+	return deletePostgresIAMUser(ctx, dbInstance, dbInstance.Scheme+"://"+dbInstance.Username+":"+dbInstance.Password+"@"+dbInstance.Endpoint, username)
+}
+
+func (provider AWSInstanceProvider) IAMCredentials(dbInstance *DbInstance, username string) (IAMCredentials, error) {
+	host, port, database, err := splitEndpoint(dbInstance.Endpoint)
+	if err != nil {
+		return IAMCredentials{}, err
+	}
+	return IAMCredentials{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Database: database,
+		Region:   provider.regionFor(dbInstance.Name, dbInstance.Plan),
+	}, nil
+}
+
+// Tokens are signed locally with the credentials of the broker, which must be allowed rds-db:connect
+// on the users it creates.
+func (provider AWSInstanceProvider) IAMAuthToken(ctx context.Context, dbInstance *DbInstance, username string) (string, error) {
+	if provider.credentials == nil {
+		return "", errors.New("The broker has no aws credentials to sign iam tokens with.")
+	}
+	creds, err := provider.IAMCredentials(dbInstance, username)
+	if err != nil {
+		return "", err
+	}
+	return rdsutils.BuildAuthToken(creds.Host+":"+creds.Port, creds.Region, creds.Username, provider.credentials)
+}
+
+func isInstanceNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == rds.ErrCodeDBInstanceNotFoundFault
+	}
+	return false
+}
+
+// The copies of snapshots of the database in the recovery region, newest first. Copies are named
+// after the database and the time the snapshot they're a copy of was taken.
+func (provider AWSInstanceProvider) recoverySnapshots(ctx context.Context, target rdsiface.RDSAPI, name string) ([]*rds.DBSnapshot, error) {
+	snapshots, err := target.DescribeDBSnapshotsWithContext(ctx, &rds.DescribeDBSnapshotsInput{
+		DBInstanceIdentifier: aws.String(name),
+		SnapshotType:         aws.String("manual"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	copies := make([]*rds.DBSnapshot, 0)
+	for _, snapshot := range snapshots.DBSnapshots {
+		if snapshot.DBSnapshotIdentifier != nil && strings.HasPrefix(*snapshot.DBSnapshotIdentifier, name+"-dr-") {
+			copies = append(copies, snapshot)
+		}
+	}
+	sort.Slice(copies, func(i, j int) bool {
+		return *copies[i].DBSnapshotIdentifier > *copies[j].DBSnapshotIdentifier
+	})
+	return copies, nil
+}
+
+// Copies the newest snapshot of the database to the recovery region of its plan unless it has been
+// copied already, then removes the oldest copies beyond the number the plan keeps. Plans that keep
+// a read replica in the recovery region get one if the database doesn't have it yet. Copies happen
+// in the background at rds, a copy that's still in progress is left alone until the next sync.
+func (provider AWSInstanceProvider) SyncRecovery(ctx context.Context, dbInstance *DbInstance) (string, error) {
+	recovery, err := provider.recoverySettings(dbInstance.Plan)
+	if err != nil {
+		return "", err
+	}
+	if isRecoveryName(dbInstance.Name) {
+		return "The database was promoted in " + recovery.Region + ", it has no disaster recovery copy of its own.", nil
+	}
+	var settings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+		return "", err
+	}
+	target := provider.regions.client(recovery.Region)
+	output := make([]string, 0)
+
+	snapshots, err := provider.awssvc.DescribeDBSnapshotsWithContext(ctx, &rds.DescribeDBSnapshotsInput{DBInstanceIdentifier: aws.String(dbInstance.Name)})
+	if err != nil {
+		return "", err
+	}
+	var latest *rds.DBSnapshot
+	for _, snapshot := range snapshots.DBSnapshots {
+		if snapshot.Status != nil && *snapshot.Status == "available" && snapshot.SnapshotCreateTime != nil && snapshot.DBSnapshotArn != nil && (latest == nil || snapshot.SnapshotCreateTime.After(*latest.SnapshotCreateTime)) {
+			latest = snapshot
+		}
+	}
+	copies, err := provider.recoverySnapshots(ctx, target, dbInstance.Name)
+	if err != nil {
+		return "", err
+	}
+	if latest == nil {
+		output = append(output, "No snapshots of "+dbInstance.Name+" are available to copy yet.")
+	} else {
+		copyId := dbInstance.Name + "-dr-" + latest.SnapshotCreateTime.UTC().Format("20060102150405")
+		copied := false
+		for _, snapshot := range copies {
+			if *snapshot.DBSnapshotIdentifier == copyId {
+				copied = true
+			}
+		}
+		if copied {
+			output = append(output, "The newest snapshot "+*latest.DBSnapshotIdentifier+" was already copied to "+recovery.Region+".")
+		} else {
+			resp, err := target.CopyDBSnapshotWithContext(ctx, &rds.CopyDBSnapshotInput{
+				SourceDBSnapshotIdentifier: latest.DBSnapshotArn,
+				TargetDBSnapshotIdentifier: aws.String(copyId),
+				SourceRegion:               aws.String(provider.region),
+				KmsKeyId:                   recovery.KmsKeyId,
+				CopyTags:                   aws.Bool(true),
+			})
+			if err != nil {
+				return "", err
+			}
+			copies = append([]*rds.DBSnapshot{resp.DBSnapshot}, copies...)
+			output = append(output, "Copying snapshot "+*latest.DBSnapshotIdentifier+" to "+copyId+" in "+recovery.Region+".")
+		}
+	}
+
+	kept := int64(defaultRecoverySnapshotsKept)
+	if recovery.SnapshotsKept != nil && *recovery.SnapshotsKept > 0 {
+		kept = *recovery.SnapshotsKept
+	}
+	for i := kept; i < int64(len(copies)); i++ {
+		if _, err := target.DeleteDBSnapshotWithContext(ctx, &rds.DeleteDBSnapshotInput{DBSnapshotIdentifier: copies[i].DBSnapshotIdentifier}); err != nil {
+			return "", err
+		}
+		output = append(output, "Removed snapshot "+*copies[i].DBSnapshotIdentifier+" from "+recovery.Region+".")
+	}
+
+	if recovery.ReadReplica {
+		_, err := target.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: aws.String(dbInstance.Name + "-dr"),
+			MaxRecords:           aws.Int64(20),
+		})
+		if err != nil && !isInstanceNotFound(err) {
+			return "", err
+		}
+		if err != nil {
+			// the replica is created from the arn of the database as it's in another region.
+			_, err = target.CreateDBInstanceReadReplicaWithContext(ctx, &rds.CreateDBInstanceReadReplicaInput{
+				DBInstanceIdentifier:            aws.String(dbInstance.Name + "-dr"),
+				SourceDBInstanceIdentifier:      aws.String(dbInstance.ProviderId),
+				SourceRegion:                    aws.String(provider.region),
+				DBInstanceClass:                 settings.DBInstanceClass,
+				DBSubnetGroupName:               recovery.DBSubnetGroupName,
+				KmsKeyId:                        recovery.KmsKeyId,
+				AutoMinorVersionUpgrade:         settings.AutoMinorVersionUpgrade,
+				CopyTagsToSnapshot:              settings.CopyTagsToSnapshot,
+				StorageType:                     settings.StorageType,
+				Iops:                            settings.Iops,
+				EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
+				Tags: []*rds.Tag{
+					{
+						Key:   aws.String("Name"),
+						Value: aws.String(dbInstance.Name),
+					},
+				},
+			})
+			if err != nil {
+				return "", err
+			}
+			output = append(output, "Creating read replica "+dbInstance.Name+"-dr in "+recovery.Region+".")
+		}
+	}
+	return strings.Join(output, " "), nil
+}
+
+// Promotes the read replica in the recovery region of the plan if the database has one, otherwise the
+// newest snapshot copy there is restored. The promoted database is named after the database with a
+// -dr suffix and keeps its credentials, the database itself is left as it is as its region may be
+// unreachable, it has to be removed by hand once the region is back.
+func (provider AWSInstanceProvider) PromoteRecovery(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	recovery, err := provider.recoverySettings(dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	if isRecoveryName(dbInstance.Name) {
+		return nil, errors.New("The database was already promoted in " + recovery.Region + ".")
+	}
+	var settings rds.CreateDBInstanceInput
+	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+		return nil, err
+	}
+	name := dbInstance.Name + "-dr"
+	defer provider.instanceCache.Invalidate(name)
+	target := provider.regions.client(recovery.Region)
+
+	resp, err := target.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(name),
+		MaxRecords:           aws.Int64(20),
+	})
+	if err != nil && !isInstanceNotFound(err) {
+		return nil, err
+	}
+	if err == nil && len(resp.DBInstances) == 1 {
+		// a retry may find the replica already promoted.
+		if resp.DBInstances[0].ReadReplicaSourceDBInstanceIdentifier != nil {
+			glog.Infof("Database: %s promoting read replica %s in %s\n", dbInstance.Id, name, recovery.Region)
+			_, err = target.PromoteReadReplicaWithContext(ctx, &rds.PromoteReadReplicaInput{
+				DBInstanceIdentifier:  aws.String(name),
+				BackupRetentionPeriod: settings.BackupRetentionPeriod,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		copies, err := provider.recoverySnapshots(ctx, target, dbInstance.Name)
+		if err != nil {
+			return nil, err
+		}
+		var latest *rds.DBSnapshot
+		for _, snapshot := range copies {
+			if snapshot.Status != nil && *snapshot.Status == "available" {
+				latest = snapshot
+				break
+			}
+		}
+		if latest == nil {
+			return nil, errors.New("There are no disaster recovery copies of " + dbInstance.Name + " available in " + recovery.Region + ".")
+		}
+		glog.Infof("Database: %s restoring %s in %s from %s\n", dbInstance.Id, name, recovery.Region, *latest.DBSnapshotIdentifier)
+		_, err = target.RestoreDBInstanceFromDBSnapshotWithContext(ctx, &rds.RestoreDBInstanceFromDBSnapshotInput{
+			DBInstanceIdentifier:            aws.String(name),
+			DBSnapshotIdentifier:            latest.DBSnapshotIdentifier,
+			AutoMinorVersionUpgrade:         settings.AutoMinorVersionUpgrade,
+			CopyTagsToSnapshot:              settings.CopyTagsToSnapshot,
+			DBInstanceClass:                 settings.DBInstanceClass,
+			DBSubnetGroupName:               recovery.DBSubnetGroupName,
+			Iops:                            settings.Iops,
+			MultiAZ:                         settings.MultiAZ,
+			PubliclyAccessible:              settings.PubliclyAccessible,
+			StorageType:                     settings.StorageType,
+			EnableIAMDatabaseAuthentication: settings.EnableIAMDatabaseAuthentication,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = target.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(name),
+		MaxRecords:           aws.Int64(20),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Neither replicas nor restored databases can be given security groups when they're created.
+	if len(recovery.VpcSecurityGroupIds) > 0 {
+		_, err = target.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
+			ApplyImmediately:     aws.Bool(true),
+			DBInstanceIdentifier: aws.String(name),
+			VpcSecurityGroupIds:  recovery.VpcSecurityGroupIds,
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = target.WaitUntilDBInstanceAvailableWithContext(ctx, &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: aws.String(name),
+			MaxRecords:           aws.Int64(20),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	glog.Infof("Database: %s promoted as %s in %s, %s was left as it is.\n", dbInstance.Id, name, recovery.Region, dbInstance.Name)
+
+	provider.instanceCache.Invalidate(name)
+	promoted, err := provider.GetInstance(ctx, name, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	c := *promoted
+	c.Id = dbInstance.Id
+	c.Username = dbInstance.Username
+	c.Password = dbInstance.Password
+	return &c, nil
 }
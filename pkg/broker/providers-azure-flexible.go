@@ -0,0 +1,820 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/golang/glog"
+	"golang.org/x/oauth2/clientcredentials"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// provider=azure-flexible in database
+// These values come out of the plans table provider_private_details column and are used to build
+// the Azure Database for PostgreSQL or MySQL flexible server, the engine is either postgres or mysql.
+// The network settings are passed through as is to the servers network properties (e.g., the
+// delegatedSubnetResourceId and privateDnsZoneArmResourceId).
+type AzureFlexibleProviderPrivatePlanSettings struct {
+	Engine              string                 `json:"engine"`
+	EngineVersion       string                 `json:"engine_version"`
+	SkuName             string                 `json:"sku_name"`
+	SkuTier             string                 `json:"sku_tier"`
+	StorageSizeGB       int64                  `json:"storage_size_gb"`
+	BackupRetentionDays int64                  `json:"backup_retention_days"`
+	HighAvailability    string                 `json:"high_availability"`
+	Network             map[string]interface{} `json:"network"`
+}
+
+// The resource provider, api version and port of the flexible servers for each engine.
+type azureFlexibleEngine struct {
+	namespace  string
+	apiVersion string
+	port       string
+}
+
+var azureFlexibleEngines = map[string]azureFlexibleEngine{
+	"postgres": {namespace: "Microsoft.DBforPostgreSQL", apiVersion: "2024-08-01", port: "5432"},
+	"mysql":    {namespace: "Microsoft.DBforMySQL", apiVersion: "2023-12-30", port: "3306"},
+}
+
+// The tag of servers restored from a backup with the name of their database.
+const azureFlexibleDatabaseTag = "database"
+
+type azureFlexibleSku struct {
+	Name string `json:"name,omitempty"`
+	Tier string `json:"tier,omitempty"`
+}
+
+type azureFlexibleStorage struct {
+	StorageSizeGB int64 `json:"storageSizeGB,omitempty"`
+}
+
+type azureFlexibleBackup struct {
+	BackupRetentionDays int64 `json:"backupRetentionDays,omitempty"`
+}
+
+type azureFlexibleHighAvailability struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+// Postgres and MySQL flexible servers share most of their properties, the exception is the
+// point in time to restore to which is pointInTimeUTC on postgres and restorePointInTime on mysql.
+type azureFlexibleServerProperties struct {
+	AdministratorLogin         string                         `json:"administratorLogin,omitempty"`
+	AdministratorLoginPassword string                         `json:"administratorLoginPassword,omitempty"`
+	Version                    string                         `json:"version,omitempty"`
+	State                      string                         `json:"state,omitempty"`
+	FullyQualifiedDomainName   string                         `json:"fullyQualifiedDomainName,omitempty"`
+	Storage                    *azureFlexibleStorage          `json:"storage,omitempty"`
+	Backup                     *azureFlexibleBackup           `json:"backup,omitempty"`
+	HighAvailability           *azureFlexibleHighAvailability `json:"highAvailability,omitempty"`
+	Network                    map[string]interface{}         `json:"network,omitempty"`
+	CreateMode                 string                         `json:"createMode,omitempty"`
+	SourceServerResourceId     string                         `json:"sourceServerResourceId,omitempty"`
+	PointInTimeUTC             string                         `json:"pointInTimeUTC,omitempty"`
+	RestorePointInTime         string                         `json:"restorePointInTime,omitempty"`
+	ReplicationRole            string                         `json:"replicationRole,omitempty"`
+}
+
+type azureFlexibleServer struct {
+	Id         string                        `json:"id,omitempty"`
+	Name       string                        `json:"name,omitempty"`
+	Location   string                        `json:"location,omitempty"`
+	Sku        *azureFlexibleSku             `json:"sku,omitempty"`
+	Tags       map[string]string             `json:"tags,omitempty"`
+	Properties azureFlexibleServerProperties `json:"properties"`
+}
+
+type azureFlexibleBackupResource struct {
+	Name       string `json:"name"`
+	Properties struct {
+		BackupType    string `json:"backupType"`
+		CompletedTime string `json:"completedTime"`
+	} `json:"properties"`
+}
+
+type azureFlexibleLogFile struct {
+	Name       string `json:"name"`
+	Properties struct {
+		SizeInKb         int64  `json:"sizeInKb"`
+		LastModifiedTime string `json:"lastModifiedTime"`
+		Url              string `json:"url"`
+	} `json:"properties"`
+}
+
+// azureError is the error body ARM returns along with a non 2xx status code.
+type azureError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e azureError) Error() string {
+	return "Azure returned " + strconv.Itoa(e.StatusCode) + " " + e.Code + ": " + e.Message
+}
+
+func isAzureNotFound(err error) bool {
+	aerr, ok := err.(azureError)
+	return ok && aerr.StatusCode == http.StatusNotFound
+}
+
+type AzureFlexibleProvider struct {
+	Provider
+	client         *http.Client
+	baseUrl        string
+	subscriptionId string
+	resourceGroup  string
+	location       string
+	namePrefix     string
+	instanceCache  *InstanceCache
+	pollInterval   time.Duration
+}
+
+func init() {
+	RegisterProvider(AzureFlexible, func(namePrefix string) (Provider, error) {
+		provider, err := NewAzureFlexibleProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, AzureFlexibleProviderPrivatePlanSettings{})
+}
+
+func NewAzureFlexibleProvider(namePrefix string) (*AzureFlexibleProvider, error) {
+	for _, env := range []string{"AZURE_SUBSCRIPTION_ID", "AZURE_RESOURCE_GROUP", "AZURE_LOCATION", "AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+		if os.Getenv(env) == "" {
+			return nil, errors.New("Unable to find " + env + " environment variable.")
+		}
+	}
+	config := clientcredentials.Config{
+		ClientID:     os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret: os.Getenv("AZURE_CLIENT_SECRET"),
+		TokenURL:     "https://login.microsoftonline.com/" + os.Getenv("AZURE_TENANT_ID") + "/oauth2/v2.0/token",
+		Scopes:       []string{"https://management.azure.com/.default"},
+	}
+	return NewAzureFlexibleProviderWithClient(namePrefix, config.Client(context.Background()), "https://management.azure.com", os.Getenv("AZURE_SUBSCRIPTION_ID"), os.Getenv("AZURE_RESOURCE_GROUP"), os.Getenv("AZURE_LOCATION")), nil
+}
+
+// NewAzureFlexibleProviderWithClient creates the provider on top of an existing http client and
+// resource manager url rather than ones configured from the environment, such as a local stub.
+func NewAzureFlexibleProviderWithClient(namePrefix string, client *http.Client, baseUrl string, subscriptionId string, resourceGroup string, location string) *AzureFlexibleProvider {
+	return &AzureFlexibleProvider{
+		client:         client,
+		baseUrl:        strings.TrimSuffix(baseUrl, "/"),
+		subscriptionId: subscriptionId,
+		resourceGroup:  resourceGroup,
+		location:       location,
+		namePrefix:     namePrefix,
+		instanceCache:  NewInstanceCache(time.Second * 5),
+		pollInterval:   time.Second * 30,
+	}
+}
+
+func (provider AzureFlexibleProvider) Close() error {
+	provider.instanceCache.Clear()
+	return nil
+}
+
+func (provider AzureFlexibleProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	return ProviderCapabilities{BackupsCapability, RestoreCapability, LogsCapability, RestartCapability, ReplicasCapability, TagsCapability}
+}
+
+func azureFlexibleSettings(plan *ProviderPlan) (AzureFlexibleProviderPrivatePlanSettings, azureFlexibleEngine, error) {
+	var settings AzureFlexibleProviderPrivatePlanSettings
+	if plan == nil {
+		return settings, azureFlexibleEngine{}, errors.New("Cannot find the plan for the azure flexible server.")
+	}
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return settings, azureFlexibleEngine{}, err
+	}
+	engine, ok := azureFlexibleEngines[settings.Engine]
+	if !ok {
+		return settings, azureFlexibleEngine{}, errors.New("The engine " + settings.Engine + " is not supported on azure flexible servers.")
+	}
+	return settings, engine, nil
+}
+
+func (provider AzureFlexibleProvider) serverId(engine azureFlexibleEngine, name string) string {
+	return "/subscriptions/" + provider.subscriptionId + "/resourceGroups/" + provider.resourceGroup + "/providers/" + engine.namespace + "/flexibleServers/" + name
+}
+
+func (provider AzureFlexibleProvider) request(ctx context.Context, method string, engine azureFlexibleEngine, path string, body interface{}, out interface{}) error {
+	var reader io.Reader = nil
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, provider.baseUrl+path+"?api-version="+engine.apiVersion, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var armErr struct {
+			Error azureError `json:"error"`
+		}
+		json.Unmarshal(data, &armErr)
+		armErr.Error.StatusCode = resp.StatusCode
+		return armErr.Error
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (provider AzureFlexibleProvider) getServer(ctx context.Context, engine azureFlexibleEngine, name string) (*azureFlexibleServer, error) {
+	var server azureFlexibleServer
+	if err := provider.request(ctx, http.MethodGet, engine, provider.serverId(engine, name), nil, &server); err != nil {
+		if isAzureNotFound(err) {
+			return nil, errors.New("Cannot find database instance")
+		}
+		return nil, err
+	}
+	return &server, nil
+}
+
+// The server is created (and restored) asynchronously, until it reports a state it's provisioning.
+func azureFlexibleState(server *azureFlexibleServer) string {
+	if server.Properties.State == "" {
+		return "Provisioning"
+	}
+	return server.Properties.State
+}
+
+// The database on the server, servers restored from a backup keep the database of the server they were
+// restored from and have it in a tag.
+func azureFlexibleDatabase(server *azureFlexibleServer, name string) string {
+	if database := server.Tags[azureFlexibleDatabaseTag]; database != "" {
+		return database
+	}
+	return name
+}
+
+func (provider AzureFlexibleProvider) toDbInstance(server *azureFlexibleServer, plan *ProviderPlan, settings AzureFlexibleProviderPrivatePlanSettings, engine azureFlexibleEngine, name string, database string) *DbInstance {
+	var endpoint = ""
+	if server.Properties.FullyQualifiedDomainName != "" {
+		endpoint = server.Properties.FullyQualifiedDomainName + ":" + engine.port + "/" + azureFlexibleDatabase(server, database)
+	}
+	status := azureFlexibleState(server)
+	return &DbInstance{
+		Id:            "", // providers should not store this.
+		ProviderId:    server.Id,
+		Name:          name,
+		Plan:          plan,
+		Username:      "", // providers should not store this.
+		Password:      "", // providers should not store this.
+		Endpoint:      endpoint,
+		Status:        status,
+		Ready:         IsReady(status),
+		Engine:        settings.Engine,
+		EngineVersion: server.Properties.Version,
+		Scheme:        plan.Scheme,
+	}
+}
+
+func (provider AzureFlexibleProvider) waitUntilReady(ctx context.Context, engine azureFlexibleEngine, name string) error {
+	for {
+		server, err := provider.getServer(ctx, engine, name)
+		if err != nil {
+			return err
+		}
+		state := azureFlexibleState(server)
+		if IsAvailable(state) {
+			return nil
+		}
+		if state == "Disabled" || state == "Dropping" {
+			return errors.New("The server " + name + " is " + state + " and will not become available.")
+		}
+		if err := sleepWithContext(ctx, provider.pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func (provider AzureFlexibleProvider) waitUntilDeleted(ctx context.Context, engine azureFlexibleEngine, name string) error {
+	for {
+		if err := provider.request(ctx, http.MethodGet, engine, provider.serverId(engine, name), nil, nil); err != nil {
+			if isAzureNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if err := sleepWithContext(ctx, provider.pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func (provider AzureFlexibleProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	if dbInstance, ok := provider.instanceCache.Get(name, plan); ok {
+		return dbInstance, nil
+	}
+	settings, engine, err := azureFlexibleSettings(plan)
+	if err != nil {
+		return nil, err
+	}
+	server, err := provider.getServer(ctx, engine, name)
+	if err != nil {
+		return nil, err
+	}
+	dbInstance := provider.toDbInstance(server, plan, settings, engine, name, name)
+	provider.instanceCache.Set(name, plan, dbInstance)
+	return dbInstance, nil
+}
+
+// The server only has the system databases when it's created, the database is added once the
+// server is available.
+func (provider AzureFlexibleProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
+	settings, engine, err := azureFlexibleSettings(db.Plan)
+	if err != nil {
+		return nil, err
+	}
+	properties := map[string]string{"charset": "UTF8", "collation": "en_US.utf8"}
+	if settings.Engine == "mysql" {
+		properties = map[string]string{"charset": "utf8mb4", "collation": "utf8mb4_general_ci"}
+	}
+	body := map[string]interface{}{"properties": properties}
+	if err := provider.request(ctx, http.MethodPut, engine, provider.serverId(engine, db.Name)+"/databases/"+db.Name, body, nil); err != nil {
+		glog.Infof("AzureFlexibleProvider: PerformPostProvision: Failure to create database: %s\n", err.Error())
+		return nil, err
+	}
+	return db, nil
+}
+
+func (provider AzureFlexibleProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+	settings, engine, err := azureFlexibleSettings(plan)
+	if err != nil {
+		return nil, err
+	}
+	billingCode := "unknown"
+	if Owner != "" {
+		billingCode = strings.ToLower(Owner)
+	}
+	name := strings.ToLower(provider.namePrefix + RandomString(8))
+	username := strings.ToLower("u" + RandomString(8))
+	// Azure requires passwords to have characters from three of uppercase, lowercase, digits and symbols.
+	password := RandomString(16) + strconv.FormatInt(randomSource.Int63()%10000, 10)
+
+	server := azureFlexibleServer{
+		Location: provider.location,
+		Sku:      &azureFlexibleSku{Name: settings.SkuName, Tier: settings.SkuTier},
+		Tags:     map[string]string{"billing-code": billingCode},
+		Properties: azureFlexibleServerProperties{
+			AdministratorLogin:         username,
+			AdministratorLoginPassword: password,
+			Version:                    settings.EngineVersion,
+			Storage:                    &azureFlexibleStorage{StorageSizeGB: settings.StorageSizeGB},
+			Backup:                     &azureFlexibleBackup{BackupRetentionDays: settings.BackupRetentionDays},
+			Network:                    settings.Network,
+			CreateMode:                 "Default",
+		},
+	}
+	if settings.HighAvailability != "" {
+		server.Properties.HighAvailability = &azureFlexibleHighAvailability{Mode: settings.HighAvailability}
+	}
+	var resp azureFlexibleServer
+	if err := provider.request(ctx, http.MethodPut, engine, provider.serverId(engine, name), server, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Id == "" {
+		resp.Id = provider.serverId(engine, name)
+	}
+	if resp.Properties.Version == "" {
+		resp.Properties.Version = settings.EngineVersion
+	}
+	dbInstance := provider.toDbInstance(&resp, plan, settings, engine, name, name)
+	dbInstance.Id = Id
+	dbInstance.Username = username
+	dbInstance.Password = password
+	return dbInstance, nil
+}
+
+// Flexible servers have no final snapshot, their automatic backups are removed along with them.
+func (provider AzureFlexibleProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return err
+	}
+	if err := provider.request(ctx, http.MethodDelete, engine, provider.serverId(engine, dbInstance.Name+"-ro"), nil, nil); err != nil && !isAzureNotFound(err) {
+		return err
+	}
+	if err := provider.request(ctx, http.MethodDelete, engine, provider.serverId(engine, dbInstance.Name), nil, nil); err != nil {
+		if isAzureNotFound(err) {
+			return errors.New("Cannot find database instance")
+		}
+		return err
+	}
+	return nil
+}
+
+func (provider AzureFlexibleProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	settings, engine, err := azureFlexibleSettings(plan)
+	if err != nil {
+		return nil, err
+	}
+	if dbInstance.Plan != nil {
+		current, _, err := azureFlexibleSettings(dbInstance.Plan)
+		if err != nil {
+			return nil, err
+		}
+		if current.Engine != settings.Engine {
+			return nil, errors.New("Cannot change the engine of an azure flexible server.")
+		}
+	}
+	glog.Infof("Database: %s modifying settings...\n", dbInstance.Id)
+	server, err := provider.getServer(ctx, engine, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !CanBeModified(azureFlexibleState(server)) {
+		return nil, errors.New("The database cannot be modified while it is " + azureFlexibleState(server) + ".")
+	}
+	update := azureFlexibleServer{
+		Sku: &azureFlexibleSku{Name: settings.SkuName, Tier: settings.SkuTier},
+		Properties: azureFlexibleServerProperties{
+			Storage: &azureFlexibleStorage{StorageSizeGB: settings.StorageSizeGB},
+			Backup:  &azureFlexibleBackup{BackupRetentionDays: settings.BackupRetentionDays},
+		},
+	}
+	if settings.HighAvailability != "" {
+		update.Properties.HighAvailability = &azureFlexibleHighAvailability{Mode: settings.HighAvailability}
+	}
+	if settings.EngineVersion != "" && settings.EngineVersion != server.Properties.Version {
+		update.Properties.Version = settings.EngineVersion
+		if settings.Engine == "postgres" {
+			// postgres only upgrades major versions in place when asked to.
+			update.Properties.CreateMode = "Update"
+		}
+	}
+	if err := provider.request(ctx, http.MethodPatch, engine, provider.serverId(engine, dbInstance.Name), update, nil); err != nil {
+		return nil, err
+	}
+	server, err = provider.getServer(ctx, engine, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Database: %s modifications finished.\n", dbInstance.Id)
+	newDbInstance := provider.toDbInstance(server, plan, settings, engine, dbInstance.Name, dbInstance.Name)
+	newDbInstance.Id = dbInstance.Id
+	newDbInstance.Username = dbInstance.Username
+	newDbInstance.Password = dbInstance.Password
+	return newDbInstance, nil
+}
+
+func (provider AzureFlexibleProvider) updateTags(ctx context.Context, dbInstance *DbInstance, update func(map[string]string)) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return err
+	}
+	server, err := provider.getServer(ctx, engine, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	tags := server.Tags
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	update(tags)
+	// Tags are replaced as a whole, an empty map (rather than no map) removes the last of them.
+	return provider.request(ctx, http.MethodPatch, engine, provider.serverId(engine, dbInstance.Name), map[string]interface{}{"tags": tags}, nil)
+}
+
+func (provider AzureFlexibleProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
+	return provider.updateTags(ctx, dbInstance, func(tags map[string]string) {
+		tags[Name] = Value
+	})
+}
+
+func (provider AzureFlexibleProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
+	return provider.updateTags(ctx, dbInstance, func(tags map[string]string) {
+		delete(tags, Name)
+	})
+}
+
+func azureFlexibleBackupSpec(dbInstance *DbInstance, backup azureFlexibleBackupResource) DatabaseBackupSpec {
+	var progress int64 = 0
+	status := "creating"
+	created := time.Now().UTC().Format(time.RFC3339)
+	if completed, err := time.Parse(time.RFC3339, backup.Properties.CompletedTime); err == nil {
+		progress = 100
+		status = "available"
+		created = completed.UTC().Format(time.RFC3339)
+	}
+	id := backup.Name
+	return DatabaseBackupSpec{
+		Database: DatabaseSpec{
+			Name: dbInstance.Name,
+		},
+		Id:       &id,
+		Progress: &progress,
+		Status:   &status,
+		Created:  created,
+	}
+}
+
+func (provider AzureFlexibleProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	var backup azureFlexibleBackupResource
+	if err := provider.request(ctx, http.MethodGet, engine, provider.serverId(engine, dbInstance.Name)+"/backups/"+Id, nil, &backup); err != nil {
+		if isAzureNotFound(err) {
+			return DatabaseBackupSpec{}, errors.New("Not found")
+		}
+		return DatabaseBackupSpec{}, err
+	}
+	return azureFlexibleBackupSpec(dbInstance, backup), nil
+}
+
+func (provider AzureFlexibleProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	var backups struct {
+		Value []azureFlexibleBackupResource `json:"value"`
+	}
+	if err := provider.request(ctx, http.MethodGet, engine, provider.serverId(engine, dbInstance.Name)+"/backups", nil, &backups); err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	out := make([]DatabaseBackupSpec, 0)
+	for _, backup := range backups.Value {
+		out = append(out, azureFlexibleBackupSpec(dbInstance, backup))
+	}
+	return out, nil
+}
+
+// Flexible servers are backed up automatically, a backup taken on demand is the same as one of
+// the automatic backups and either can be restored to the point in time it finished.
+func (provider AzureFlexibleProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+	if !dbInstance.Ready {
+		return DatabaseBackupSpec{}, errors.New("Cannot create backup on database that is unavailable.")
+	}
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	backup := azureFlexibleBackupResource{Name: dbInstance.Name + "-manual-" + strings.ToLower(RandomString(10))}
+	if err := provider.request(ctx, http.MethodPut, engine, provider.serverId(engine, dbInstance.Name)+"/backups/"+backup.Name, map[string]interface{}{}, &backup); err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return azureFlexibleBackupSpec(dbInstance, backup), nil
+}
+
+func (provider AzureFlexibleProvider) pointInTimeRestore(ctx context.Context, settings AzureFlexibleProviderPrivatePlanSettings, engine azureFlexibleEngine, source *azureFlexibleServer, name string, pointInTime time.Time) error {
+	server := azureFlexibleServer{
+		Location: source.Location,
+		Tags:     source.Tags,
+		Properties: azureFlexibleServerProperties{
+			CreateMode:             "PointInTimeRestore",
+			SourceServerResourceId: source.Id,
+		},
+	}
+	if server.Location == "" {
+		server.Location = provider.location
+	}
+	if settings.Engine == "mysql" {
+		server.Properties.RestorePointInTime = pointInTime.UTC().Format(time.RFC3339)
+	} else {
+		server.Properties.PointInTimeUTC = pointInTime.UTC().Format(time.RFC3339)
+	}
+	if err := provider.request(ctx, http.MethodPut, engine, provider.serverId(engine, name), server, nil); err != nil {
+		return err
+	}
+	return provider.waitUntilReady(ctx, engine, name)
+}
+
+// Backups are restored into a new server by RestoreBackupInto.
+func (provider AzureFlexibleProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	return errors.New("Backups of flexible servers can only be restored into a new server.")
+}
+
+// A restore always creates a new server and servers cannot be renamed, so the backup is restored
+// into a new server that replaces the existing one once it's ready. The existing server is left as
+// it is until then. The database keeps its name on the new server, which is recorded in a tag as it
+// no longer matches the name of the server.
+func (provider AzureFlexibleProvider) RestoreBackupInto(ctx context.Context, dbInstance *DbInstance, Id string) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	settings, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	if !dbInstance.Ready {
+		return nil, errors.New("Cannot restore backup on database that is unavailable.")
+	}
+	var backup azureFlexibleBackupResource
+	if err := provider.request(ctx, http.MethodGet, engine, provider.serverId(engine, dbInstance.Name)+"/backups/"+Id, nil, &backup); err != nil {
+		if isAzureNotFound(err) {
+			return nil, errors.New("Not found")
+		}
+		return nil, err
+	}
+	pointInTime, err := time.Parse(time.RFC3339, backup.Properties.CompletedTime)
+	if err != nil {
+		return nil, errors.New("Cannot restore a backup that has not finished.")
+	}
+	original, err := provider.getServer(ctx, engine, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	source := *original
+	source.Tags = make(map[string]string)
+	for key, value := range original.Tags {
+		source.Tags[key] = value
+	}
+	source.Tags[azureFlexibleDatabaseTag] = azureFlexibleDatabase(original, dbInstance.Name)
+	name := strings.ToLower(provider.namePrefix + RandomString(8))
+	if err := provider.pointInTimeRestore(ctx, settings, engine, &source, name, pointInTime); err != nil {
+		if derr := provider.request(context.Background(), http.MethodDelete, engine, provider.serverId(engine, name), nil, nil); derr != nil && !isAzureNotFound(derr) {
+			glog.Errorf("ERROR: Orphaned Database! Unable to clean up %s after failing to restore %s: %s\n", name, dbInstance.Name, derr.Error())
+		}
+		return nil, err
+	}
+	restored, err := provider.GetInstance(ctx, name, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	// The administrator is restored with the server, so the credentials stay the same.
+	restored.Id = dbInstance.Id
+	restored.Username = dbInstance.Username
+	restored.Password = dbInstance.Password
+	return restored, nil
+}
+
+func (provider AzureFlexibleProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	if !dbInstance.Ready {
+		return errors.New("Cannot restart a database that is unavailable.")
+	}
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return err
+	}
+	return provider.request(ctx, http.MethodPost, engine, provider.serverId(engine, dbInstance.Name)+"/restart", map[string]interface{}{}, nil)
+}
+
+func (provider AzureFlexibleProvider) listLogFiles(ctx context.Context, dbInstance *DbInstance) ([]azureFlexibleLogFile, error) {
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	var logs struct {
+		Value []azureFlexibleLogFile `json:"value"`
+	}
+	if err := provider.request(ctx, http.MethodGet, engine, provider.serverId(engine, dbInstance.Name)+"/logFiles", nil, &logs); err != nil {
+		return nil, err
+	}
+	return logs.Value, nil
+}
+
+func (provider AzureFlexibleProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
+	logs, err := provider.listLogFiles(ctx, dbInstance)
+	if err != nil {
+		return []DatabaseLogs{}, err
+	}
+	out := make([]DatabaseLogs, 0)
+	for _, log := range logs {
+		updated := time.Now().UTC().Format(time.RFC3339)
+		if modified, err := time.Parse(time.RFC3339, log.Properties.LastModifiedTime); err == nil {
+			updated = modified.UTC().Format(time.RFC3339)
+		}
+		name := log.Name
+		size := log.Properties.SizeInKb * 1024
+		out = append(out, DatabaseLogs{
+			Name:    &name,
+			Size:    &size,
+			Updated: updated,
+		})
+	}
+	return out, nil
+}
+
+// Server logs are downloaded from the (pre-signed) url azure gives for each log file, not through ARM.
+func (provider AzureFlexibleProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
+	logs, err := provider.listLogFiles(ctx, dbInstance)
+	if err != nil {
+		return "", err
+	}
+	for _, log := range logs {
+		if log.Name != path {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodGet, log.Properties.Url, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", errors.New("Unable to download log file " + path + ", azure returned " + resp.Status)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", errors.New("Not found")
+}
+
+func (provider AzureFlexibleProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	if !IsAvailable(dbInstance.Status) {
+		return nil, errors.New("Replicas cannot be created for databases being created, under maintenance or destroyed.")
+	}
+	settings, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	database := dbInstance.Name
+	if i := strings.LastIndex(dbInstance.Endpoint, "/"); i != -1 {
+		database = dbInstance.Endpoint[i+1:]
+	}
+	replica := azureFlexibleServer{
+		Location: provider.location,
+		Sku:      &azureFlexibleSku{Name: settings.SkuName, Tier: settings.SkuTier},
+		Tags:     map[string]string{"Name": dbInstance.Name, azureFlexibleDatabaseTag: database},
+		Properties: azureFlexibleServerProperties{
+			CreateMode:             "Replica",
+			SourceServerResourceId: provider.serverId(engine, dbInstance.Name),
+		},
+	}
+	var resp azureFlexibleServer
+	if err := provider.request(ctx, http.MethodPut, engine, provider.serverId(engine, dbInstance.Name+"-ro"), replica, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Id == "" {
+		resp.Id = provider.serverId(engine, dbInstance.Name+"-ro")
+	}
+	rrDbInstance := provider.toDbInstance(&resp, dbInstance.Plan, settings, engine, dbInstance.Name+"-ro", dbInstance.Name)
+	rrDbInstance.Id = dbInstance.Id
+	rrDbInstance.Username = dbInstance.Username
+	rrDbInstance.Password = dbInstance.Password
+	rrDbInstance.Scheme = dbInstance.Scheme
+	return rrDbInstance, nil
+}
+
+func (provider AzureFlexibleProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	settings, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	server, err := provider.getServer(ctx, engine, dbInstance.Name+"-ro")
+	if err != nil {
+		return nil, err
+	}
+	rrDbInstance := provider.toDbInstance(server, dbInstance.Plan, settings, engine, dbInstance.Name+"-ro", dbInstance.Name)
+	rrDbInstance.Username = dbInstance.Username
+	rrDbInstance.Password = dbInstance.Password
+	return rrDbInstance, nil
+}
+
+func (provider AzureFlexibleProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	_, engine, err := azureFlexibleSettings(dbInstance.Plan)
+	if err != nil {
+		return err
+	}
+	return provider.request(ctx, http.MethodDelete, engine, provider.serverId(engine, dbInstance.Name+"-ro"), nil, nil)
+}
+
+func (provider AzureFlexibleProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+	return DatabaseUrlSpec{}, ErrFeatureNotAvailable
+}
+
+func (provider AzureFlexibleProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
+	return ErrFeatureNotAvailable
+}
+
+func (provider AzureFlexibleProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+	return DatabaseUrlSpec{}, ErrFeatureNotAvailable
+}
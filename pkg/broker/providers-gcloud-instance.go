@@ -3,23 +3,174 @@ package broker
 import (
 	"encoding/json"
 	"errors"
-	"golang.org/x/net/context"
+	"context"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/sqladmin/v1beta4"
+	"google.golang.org/api/logging/v2"
 	"github.com/golang/glog"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// SQLAdminAPI is the part of the Cloud SQL admin api used by the gcloud provider, it exists so the
+// api can be swapped out (for example with a fake when testing).
+type SQLAdminAPI interface {
+	GetInstance(ctx context.Context, project string, instance string) (*sqladmin.DatabaseInstance, error)
+	InsertInstance(ctx context.Context, project string, instance *sqladmin.DatabaseInstance) (*sqladmin.Operation, error)
+	UpdateInstance(ctx context.Context, project string, instance string, body *sqladmin.DatabaseInstance) (*sqladmin.Operation, error)
+	DeleteInstance(ctx context.Context, project string, instance string) (*sqladmin.Operation, error)
+	RestartInstance(ctx context.Context, project string, instance string) (*sqladmin.Operation, error)
+	InsertUser(ctx context.Context, project string, instance string, user *sqladmin.User) (*sqladmin.Operation, error)
+	UpdateUser(ctx context.Context, project string, instance string, user *sqladmin.User) (*sqladmin.Operation, error)
+	GetOperation(ctx context.Context, project string, operation string) (*sqladmin.Operation, error)
+	ListBackupRuns(ctx context.Context, project string, instance string) ([]*sqladmin.BackupRun, error)
+	GetBackupRun(ctx context.Context, project string, instance string, id int64) (*sqladmin.BackupRun, error)
+	InsertBackupRun(ctx context.Context, project string, instance string, run *sqladmin.BackupRun) (*sqladmin.Operation, error)
+	RestoreBackup(ctx context.Context, project string, instance string, request *sqladmin.InstancesRestoreBackupRequest) (*sqladmin.Operation, error)
+	CloneInstance(ctx context.Context, project string, instance string, request *sqladmin.InstancesCloneRequest) (*sqladmin.Operation, error)
+}
+
+type sqlAdminService struct {
+	svc *sqladmin.Service
+}
+
+func NewSQLAdminAPI(svc *sqladmin.Service) SQLAdminAPI {
+	return sqlAdminService{svc: svc}
+}
+
+func (s sqlAdminService) GetInstance(ctx context.Context, project string, instance string) (*sqladmin.DatabaseInstance, error) {
+	return sqladmin.NewInstancesService(s.svc).Get(project, instance).Context(ctx).Do()
+}
+
+func (s sqlAdminService) InsertInstance(ctx context.Context, project string, instance *sqladmin.DatabaseInstance) (*sqladmin.Operation, error) {
+	return sqladmin.NewInstancesService(s.svc).Insert(project, instance).Context(ctx).Do()
+}
+
+func (s sqlAdminService) UpdateInstance(ctx context.Context, project string, instance string, body *sqladmin.DatabaseInstance) (*sqladmin.Operation, error) {
+	return sqladmin.NewInstancesService(s.svc).Update(project, instance, body).Context(ctx).Do()
+}
+
+func (s sqlAdminService) DeleteInstance(ctx context.Context, project string, instance string) (*sqladmin.Operation, error) {
+	return sqladmin.NewInstancesService(s.svc).Delete(project, instance).Context(ctx).Do()
+}
+
+func (s sqlAdminService) RestartInstance(ctx context.Context, project string, instance string) (*sqladmin.Operation, error) {
+	return sqladmin.NewInstancesService(s.svc).Restart(project, instance).Context(ctx).Do()
+}
+
+func (s sqlAdminService) InsertUser(ctx context.Context, project string, instance string, user *sqladmin.User) (*sqladmin.Operation, error) {
+	return sqladmin.NewUsersService(s.svc).Insert(project, instance, user).Context(ctx).Do()
+}
+
+// The user is found by its name, and its host if it has one (only mysql users have hosts).
+func (s sqlAdminService) UpdateUser(ctx context.Context, project string, instance string, user *sqladmin.User) (*sqladmin.Operation, error) {
+	call := sqladmin.NewUsersService(s.svc).Update(project, instance, user.Name, user)
+	if user.Host != "" {
+		call = call.Host(user.Host)
+	}
+	return call.Context(ctx).Do()
+}
+
+func (s sqlAdminService) GetOperation(ctx context.Context, project string, operation string) (*sqladmin.Operation, error) {
+	return sqladmin.NewOperationsService(s.svc).Get(project, operation).Context(ctx).Do()
+}
+
+func (s sqlAdminService) ListBackupRuns(ctx context.Context, project string, instance string) ([]*sqladmin.BackupRun, error) {
+	runs := make([]*sqladmin.BackupRun, 0)
+	err := sqladmin.NewBackupRunsService(s.svc).List(project, instance).Pages(ctx, func(page *sqladmin.BackupRunsListResponse) error {
+		runs = append(runs, page.Items...)
+		return nil
+	})
+	return runs, err
+}
+
+func (s sqlAdminService) GetBackupRun(ctx context.Context, project string, instance string, id int64) (*sqladmin.BackupRun, error) {
+	return sqladmin.NewBackupRunsService(s.svc).Get(project, instance, id).Context(ctx).Do()
+}
+
+func (s sqlAdminService) InsertBackupRun(ctx context.Context, project string, instance string, run *sqladmin.BackupRun) (*sqladmin.Operation, error) {
+	return sqladmin.NewBackupRunsService(s.svc).Insert(project, instance, run).Context(ctx).Do()
+}
+
+func (s sqlAdminService) RestoreBackup(ctx context.Context, project string, instance string, request *sqladmin.InstancesRestoreBackupRequest) (*sqladmin.Operation, error) {
+	return sqladmin.NewInstancesService(s.svc).RestoreBackup(project, instance, request).Context(ctx).Do()
+}
+
+func (s sqlAdminService) CloneInstance(ctx context.Context, project string, instance string, request *sqladmin.InstancesCloneRequest) (*sqladmin.Operation, error) {
+	return sqladmin.NewInstancesService(s.svc).Clone(project, instance, request).Context(ctx).Do()
+}
+
+// CloudLoggingAPI is the part of the Cloud Logging api used to read the logs of Cloud SQL instances.
+type CloudLoggingAPI interface {
+	ListLogEntries(ctx context.Context, project string, filter string, orderBy string, limit int) ([]*logging.LogEntry, error)
+}
+
+type cloudLoggingService struct {
+	svc *logging.Service
+}
+
+func NewCloudLoggingAPI(svc *logging.Service) CloudLoggingAPI {
+	return cloudLoggingService{svc: svc}
+}
+
+func (s cloudLoggingService) ListLogEntries(ctx context.Context, project string, filter string, orderBy string, limit int) ([]*logging.LogEntry, error) {
+	entries := make([]*logging.LogEntry, 0)
+	request := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + project},
+		Filter:        filter,
+		OrderBy:       orderBy,
+		PageSize:      int64(limit),
+	}
+	for {
+		resp, err := s.svc.Entries.List(request).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, resp.Entries...)
+		if resp.NextPageToken == "" || len(entries) >= limit {
+			break
+		}
+		request.PageToken = resp.NextPageToken
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
 type GCloudInstanceProvider struct {
 	Provider
-	ctx					context.Context
-	svc					*sqladmin.Service
+	svc					SQLAdminAPI
 	projectId			string
 	region				string
 	namePrefix          string
-	instanceCache 		map[string]*DbInstance
+	instanceCache 		*InstanceCache
+	pollInterval        time.Duration
+	logs                CloudLoggingAPI
+}
+
+// Cloud SQL keeps the logs point in time recovery replays for seven days.
+const gcloudPointInTimeRetention = time.Hour * 24 * 7
+
+// How far back, and how many entries, of the logs of an instance are read.
+const (
+	gcloudLogsPeriod = time.Hour * 24
+	gcloudLogsLimit  = 1000
+)
+
+func init() {
+	RegisterProvider(GCloudInstance, func(namePrefix string) (Provider, error) {
+		provider, err := NewGCloudInstanceProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, sqladmin.Settings{})
 }
 
 func NewGCloudInstanceProvider(namePrefix string) (*GCloudInstanceProvider, error) {
@@ -47,33 +198,92 @@ func NewGCloudInstanceProvider(namePrefix string) (*GCloudInstanceProvider, erro
 	if err != nil {
 		return nil, err
 	}
+	logsClient, err := google.DefaultClient(ctx, logging.LoggingReadScope)
+	if err != nil {
+		return nil, err
+	}
+	logsvc, err := logging.New(logsClient)
+	if err != nil {
+		return nil, err
+	}
 
-	t := time.NewTicker(time.Second * 30)
-	GCloudInstanceProvider := &GCloudInstanceProvider{
-		ctx:			 	 ctx,
-		projectId:			 os.Getenv("GCLOUD_PROJECT_ID"),
-		region:			 	 os.Getenv("GCLOUD_REGION"),
+	provider := NewGCloudInstanceProviderWithClient(namePrefix, NewSQLAdminAPI(svc), os.Getenv("GCLOUD_PROJECT_ID"), os.Getenv("GCLOUD_REGION"))
+	provider.logs = NewCloudLoggingAPI(logsvc)
+	return provider, nil
+}
+
+// NewGCloudInstanceProviderWithClient creates the provider on top of an existing Cloud SQL admin
+// api rather than one configured from the default credentials.
+func NewGCloudInstanceProviderWithClient(namePrefix string, svc SQLAdminAPI, projectId string, region string) *GCloudInstanceProvider {
+	return &GCloudInstanceProvider{
+		projectId:			 projectId,
+		region:			 	 region,
 		namePrefix:          namePrefix,
-		instanceCache:		 make(map[string]*DbInstance),
+		instanceCache:		 NewInstanceCache(time.Second * 30),
 		svc:              	 svc,
+		pollInterval:        time.Second * 30,
+	}
+}
+
+func (provider GCloudInstanceProvider) Close() error {
+	provider.instanceCache.Clear()
+	return nil
+}
+
+func (provider GCloudInstanceProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	capabilities := ProviderCapabilities{BackupsCapability, RestoreCapability, RolesCapability, RestartCapability, ReplicasCapability, TagsCapability}
+	if provider.logs != nil {
+		capabilities = append(capabilities, LogsCapability)
+	}
+	if plan == nil {
+		return capabilities
+	}
+	var settings sqladmin.Settings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return capabilities
+	}
+	if gcloudPointInTimeEnabled(&settings) {
+		capabilities = append(capabilities, PointInTimeCapability)
 	}
-	go (func() {
-		for {
-			GCloudInstanceProvider.instanceCache = make(map[string]*DbInstance)
-			<-t.C
+	return capabilities
+}
+
+// Point in time recovery replays the binary logs (mysql) or archived write ahead logs (postgres) kept
+// since a backup, so one of them has to be enabled along with backups.
+func gcloudPointInTimeEnabled(settings *sqladmin.Settings) bool {
+	return settings != nil && settings.BackupConfiguration != nil && settings.BackupConfiguration.Enabled &&
+		(settings.BackupConfiguration.BinaryLogEnabled || settings.BackupConfiguration.ReplicationLogArchivingEnabled)
+}
+
+func isGoogleNotFound(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == http.StatusNotFound
+}
+
+// Waits for a Cloud SQL operation to finish, an operation that finished with errors returns the first.
+func (provider GCloudInstanceProvider) waitForOperation(ctx context.Context, operation *sqladmin.Operation) error {
+	for operation.Status != "DONE" {
+		if err := sleepWithContext(ctx, provider.pollInterval); err != nil {
+			return err
 		}
-	})()
-	return GCloudInstanceProvider, nil
+		next, err := provider.svc.GetOperation(ctx, provider.projectId, operation.Name)
+		if err != nil {
+			return err
+		}
+		operation = next
+	}
+	if operation.Error != nil && len(operation.Error.Errors) > 0 {
+		return errors.New("The " + strings.ToLower(operation.OperationType) + " of " + operation.TargetId + " failed: " + operation.Error.Errors[0].Message)
+	}
+	return nil
 }
 
-func (provider GCloudInstanceProvider) GetInstance(name string, plan *ProviderPlan) (*DbInstance, error) {
-	if provider.instanceCache[name + plan.ID] != nil {
-		return provider.instanceCache[name + plan.ID], nil
+func (provider GCloudInstanceProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	if dbInstance, ok := provider.instanceCache.Get(name, plan); ok {
+		return dbInstance, nil
 	}
 	
-	svc := sqladmin.NewInstancesService(provider.svc)
-	
-	resp, err := svc.Get(provider.projectId, name).Do()
+	resp, err := provider.svc.GetInstance(ctx, provider.projectId, name)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +308,7 @@ func (provider GCloudInstanceProvider) GetInstance(name string, plan *ProviderPl
 			dbEngineVersion = "5.7"
 		}
 	}
-	provider.instanceCache[name + plan.ID] = &DbInstance{
+	dbInstance := &DbInstance{
 		Id:            "", // providers should not store this.
 		ProviderId:    resp.Name,
 		Name:          name,
@@ -113,11 +323,11 @@ func (provider GCloudInstanceProvider) GetInstance(name string, plan *ProviderPl
 		Scheme:        plan.Scheme,
 	}
 
-	return provider.instanceCache[name + plan.ID], nil
+	provider.instanceCache.Set(name, plan, dbInstance)
+	return dbInstance, nil
 }
 
-func (provider GCloudInstanceProvider) PerformPostProvision(db *DbInstance) (*DbInstance, error) {
-	usersService := sqladmin.NewUsersService(provider.svc)
+func (provider GCloudInstanceProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
 	var user sqladmin.User = sqladmin.User{
 		Instance:	db.Name,
 		Kind:		"sql#user",
@@ -125,20 +335,19 @@ func (provider GCloudInstanceProvider) PerformPostProvision(db *DbInstance) (*Db
 		Password:	db.Password,
 		Project:	provider.projectId,
 	}
-	if _, err := usersService.Insert(provider.projectId, db.Name, &user).Do(); err != nil {
+	if _, err := provider.svc.InsertUser(ctx, provider.projectId, db.Name, &user); err != nil {
 		glog.Infof("GCloudInstanceProvider: PerformPostProvision: Failure to insert new user: %s\n", err.Error())
 		return nil, err
 	}
 	return db, nil
 }
 
-func (provider GCloudInstanceProvider) ProvisionWithSettings(Id string, plan *ProviderPlan, settings *sqladmin.DatabaseInstance, user *sqladmin.User) (*DbInstance, error) {
-	svc := sqladmin.NewInstancesService(provider.svc)
-	_, err := svc.Insert(provider.projectId, settings).Do()
+func (provider GCloudInstanceProvider) ProvisionWithSettings(ctx context.Context, Id string, plan *ProviderPlan, settings *sqladmin.DatabaseInstance, user *sqladmin.User) (*DbInstance, error) {
+	_, err := provider.svc.InsertInstance(ctx, provider.projectId, settings)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := svc.Get(provider.projectId, settings.Name).Do()
+	resp, err := provider.svc.GetInstance(ctx, provider.projectId, settings.Name)
 	if err != nil {
 		glog.Infof("GCloudInstanceProvider: ProvisionWithSettings: Failure to get database: %s\n", err.Error())
 		return nil, err
@@ -164,7 +373,7 @@ func (provider GCloudInstanceProvider) ProvisionWithSettings(Id string, plan *Pr
 	}, nil
 }
 
-func (provider GCloudInstanceProvider) Provision(Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+func (provider GCloudInstanceProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
 	var settings sqladmin.Settings 
 	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
 		return nil, err
@@ -200,29 +409,39 @@ func (provider GCloudInstanceProvider) Provision(Id string, plan *ProviderPlan,
 	user.Name = strings.ToLower("u" + RandomString(8))
 	user.Password = RandomString(16)
 
-	return provider.ProvisionWithSettings(Id, plan, &dbInstanceGcloud, &user)
+	return provider.ProvisionWithSettings(ctx, Id, plan, &dbInstanceGcloud, &user)
 }
 
-func (provider GCloudInstanceProvider) Deprovision(dbInstance *DbInstance, takeSnapshot bool) error {
+func (provider GCloudInstanceProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
 	// TODO: snapshot?
-	svc := sqladmin.NewInstancesService(provider.svc)
-	_, err := svc.Delete(provider.projectId, dbInstance.Name).Do()
+	_, err := provider.svc.DeleteInstance(ctx, provider.projectId, dbInstance.Name)
 	return err
 }
 
-func (provider GCloudInstanceProvider) ModifyWithSettings(dbInstance *DbInstance, plan *ProviderPlan, settings *sqladmin.Settings) (*DbInstance, error) {
-	svc := sqladmin.NewInstancesService(provider.svc)
-
-	resp, err := svc.Get(provider.projectId, dbInstance.Name).Do()
+func (provider GCloudInstanceProvider) ModifyWithSettings(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan, settings *sqladmin.Settings) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	resp, err := provider.svc.GetInstance(ctx, provider.projectId, dbInstance.Name)
 	if err != nil {
 		return nil, err
 	}
+	// Labels are replaced with the settings, keep the ones the plan doesn't set (such as tags).
+	if resp.Settings != nil && len(resp.Settings.UserLabels) > 0 {
+		if settings.UserLabels == nil {
+			settings.UserLabels = make(map[string]string)
+		}
+		for key, value := range resp.Settings.UserLabels {
+			if _, ok := settings.UserLabels[key]; !ok {
+				settings.UserLabels[key] = value
+			}
+		}
+	}
 	resp.Settings = settings
-	_, err = svc.Update(provider.projectId, dbInstance.Name, resp).Do()
+	_, err = provider.svc.UpdateInstance(ctx, provider.projectId, dbInstance.Name, resp)
 	if err != nil {
 		return nil, err
 	}
-	resp, err = svc.Get(provider.projectId, resp.Name).Do()
+	resp, err = provider.svc.GetInstance(ctx, provider.projectId, resp.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -251,268 +470,565 @@ func (provider GCloudInstanceProvider) ModifyWithSettings(dbInstance *DbInstance
 	}, nil
 }
 
-func (provider GCloudInstanceProvider) Modify(dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+func (provider GCloudInstanceProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
 	glog.Infof("Database: %s modifying settings...\n", dbInstance.Id)
 	var settings sqladmin.Settings 
 	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
 		return nil, err
 	}
-	dbNew, err := provider.ModifyWithSettings(dbInstance, plan, &settings)
+	dbNew, err := provider.ModifyWithSettings(ctx, dbInstance, plan, &settings)
 	glog.Infof("Database: %s modifications finished.\n", dbInstance.Id)
 	return dbNew, err
 }
 
-func (provider GCloudInstanceProvider) Tag(dbInstance *DbInstance, Name string, Value string) error {
-	
-	return errors.New("unimplemented")
+// Label keys and values may only have lower case letters, numbers, underscores and dashes and be up to
+// 63 characters, keys also have to start with a letter.
+func gcloudLabel(value string) string {
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(value))
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
 }
 
-func (provider GCloudInstanceProvider) Untag(dbInstance *DbInstance, Name string) error {
-	
-	return errors.New("unimplemented")
+func gcloudLabelKey(name string) string {
+	key := gcloudLabel(name)
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		key = gcloudLabel("tag_" + key)
+	}
+	return key
 }
 
-func (provider GCloudInstanceProvider) GetBackup(dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
-	/*snapshots, err := provider.awssvc.DescribeDBSnapshots(&rds.DescribeDBSnapshotsInput{
-		DBInstanceIdentifier: aws.String(dbInstance.Name),
-		DBSnapshotIdentifier: aws.String(Id),
-	})
+func (provider GCloudInstanceProvider) updateLabels(ctx context.Context, dbInstance *DbInstance, update func(map[string]string)) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	resp, err := provider.svc.GetInstance(ctx, provider.projectId, dbInstance.Name)
 	if err != nil {
-		return DatabaseBackupSpec{}, err
+		return err
+	}
+	if resp.Settings == nil {
+		resp.Settings = &sqladmin.Settings{}
 	}
-	if len(snapshots.DBSnapshots) != 1 {
-		return DatabaseBackupSpec{}, errors.New("Not found")
+	if resp.Settings.UserLabels == nil {
+		resp.Settings.UserLabels = make(map[string]string)
 	}
+	update(resp.Settings.UserLabels)
+	_, err = provider.svc.UpdateInstance(ctx, provider.projectId, dbInstance.Name, resp)
+	return err
+}
+
+func (provider GCloudInstanceProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
+	return provider.updateLabels(ctx, dbInstance, func(labels map[string]string) {
+		labels[gcloudLabelKey(Name)] = gcloudLabel(Value)
+	})
+}
 
+func (provider GCloudInstanceProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
+	return provider.updateLabels(ctx, dbInstance, func(labels map[string]string) {
+		delete(labels, gcloudLabelKey(Name))
+	})
+}
+
+func gcloudBackupSpec(dbInstance *DbInstance, run *sqladmin.BackupRun) DatabaseBackupSpec {
+	id := strconv.FormatInt(run.Id, 10)
+	status := "creating"
+	var progress int64 = 0
+	switch run.Status {
+	case "SUCCESSFUL":
+		status = "available"
+		progress = 100
+	case "FAILED", "SKIPPED":
+		status = "failed"
+	case "DELETION_PENDING", "DELETION_FAILED", "DELETED":
+		status = "deleting"
+	}
 	created := time.Now().UTC().Format(time.RFC3339)
-	if snapshots.DBSnapshots[0].SnapshotCreateTime != nil {
-		created = snapshots.DBSnapshots[0].SnapshotCreateTime.UTC().Format(time.RFC3339)
+	for _, t := range []string{run.StartTime, run.EnqueuedTime, run.WindowStartTime} {
+		if t != "" {
+			created = t
+			break
+		}
 	}
-
 	return DatabaseBackupSpec{
 		Database: DatabaseSpec{
 			Name: dbInstance.Name,
 		},
-		Id:       snapshots.DBSnapshots[0].DBSnapshotIdentifier,
-		Progress: snapshots.DBSnapshots[0].PercentProgress,
-		Status:   snapshots.DBSnapshots[0].Status,
+		Id:       &id,
+		Progress: &progress,
+		Status:   &status,
 		Created:  created,
-	}, nil*/
-	return DatabaseBackupSpec{}, errors.New("unimplemented")
+	}
+}
+
+func (provider GCloudInstanceProvider) getBackupRun(ctx context.Context, dbInstance *DbInstance, Id string) (*sqladmin.BackupRun, error) {
+	id, err := strconv.ParseInt(Id, 10, 64)
+	if err != nil {
+		return nil, errors.New("Not found")
+	}
+	run, err := provider.svc.GetBackupRun(ctx, provider.projectId, dbInstance.Name, id)
+	if err != nil && isGoogleNotFound(err) {
+		return nil, errors.New("Not found")
+	}
+	return run, err
 }
 
-func (provider GCloudInstanceProvider) ListBackups(dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
-	/*snapshots, err := provider.awssvc.DescribeDBSnapshots(&rds.DescribeDBSnapshotsInput{DBInstanceIdentifier: aws.String(dbInstance.Name)})
+func (provider GCloudInstanceProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	run, err := provider.getBackupRun(ctx, dbInstance, Id)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return gcloudBackupSpec(dbInstance, run), nil
+}
+
+func (provider GCloudInstanceProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	runs, err := provider.svc.ListBackupRuns(ctx, provider.projectId, dbInstance.Name)
 	if err != nil {
 		return []DatabaseBackupSpec{}, err
 	}
 	out := make([]DatabaseBackupSpec, 0)
-	for _, snapshot := range snapshots.DBSnapshots {
-		created := time.Now().UTC().Format(time.RFC3339)
-		if snapshot.SnapshotCreateTime != nil {
-			created = snapshot.SnapshotCreateTime.UTC().Format(time.RFC3339)
+	for _, run := range runs {
+		out = append(out, gcloudBackupSpec(dbInstance, run))
+	}
+	return out, nil
+}
+
+func (provider GCloudInstanceProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+	if !dbInstance.Ready {
+		return DatabaseBackupSpec{}, errors.New("Cannot create backup on database that is unavailable.")
+	}
+	// The operation doesn't say which backup run it started, so the run is found by its description.
+	description := dbInstance.Name + "-manual-" + strings.ToLower(RandomString(10))
+	if _, err := provider.svc.InsertBackupRun(ctx, provider.projectId, dbInstance.Name, &sqladmin.BackupRun{Description: description, Instance: dbInstance.Name}); err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	runs, err := provider.svc.ListBackupRuns(ctx, provider.projectId, dbInstance.Name)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	for _, run := range runs {
+		if run.Description == description {
+			return gcloudBackupSpec(dbInstance, run), nil
 		}
-		out = append(out, DatabaseBackupSpec{
-			Database: DatabaseSpec{
-				Name: dbInstance.Name,
-			},
-			Id:       snapshot.DBSnapshotIdentifier,
-			Progress: snapshot.PercentProgress,
-			Status:   snapshot.Status,
-			Created:  created,
-		})
 	}
-	return out, nil*/
-	return nil, errors.New("unimplemented")
+	return DatabaseBackupSpec{}, errors.New("The backup " + description + " was started but could not be found.")
 }
 
-func (provider GCloudInstanceProvider) CreateBackup(dbInstance *DbInstance) (DatabaseBackupSpec, error) {
-	/*snapshot_name := (dbInstance.Name + "-manual-" + RandomString(10))
-	snapshot, err := provider.awssvc.CreateDBSnapshot(&rds.CreateDBSnapshotInput{
-		DBInstanceIdentifier: aws.String(dbInstance.Name),
-		DBSnapshotIdentifier: aws.String(snapshot_name),
+// Backups are restored into the instance itself, replacing everything in it.
+func (provider GCloudInstanceProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	if !dbInstance.Ready {
+		return errors.New("Cannot restore backup on database that is unavailable.")
+	}
+	run, err := provider.getBackupRun(ctx, dbInstance, Id)
+	if err != nil {
+		return err
+	}
+	if run.Status != "SUCCESSFUL" {
+		return errors.New("Cannot restore a backup that has not finished.")
+	}
+	operation, err := provider.svc.RestoreBackup(ctx, provider.projectId, dbInstance.Name, &sqladmin.InstancesRestoreBackupRequest{
+		RestoreBackupContext: &sqladmin.RestoreBackupContext{
+			BackupRunId: run.Id,
+			InstanceId:  dbInstance.Name,
+		},
 	})
 	if err != nil {
-		return DatabaseBackupSpec{}, err
+		return err
 	}
-	created := time.Now().UTC().Format(time.RFC3339)
-	if snapshot.DBSnapshot.SnapshotCreateTime != nil {
-		created = snapshot.DBSnapshot.SnapshotCreateTime.UTC().Format(time.RFC3339)
+	return provider.waitForOperation(ctx, operation)
+}
+
+// A database can be restored to any time since its oldest backup, as long as the logs to replay are still kept.
+func (provider GCloudInstanceProvider) RestorableTimes(ctx context.Context, dbInstance *DbInstance) (time.Time, time.Time, error) {
+	resp, err := provider.svc.GetInstance(ctx, provider.projectId, dbInstance.Name)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !gcloudPointInTimeEnabled(resp.Settings) {
+		return time.Time{}, time.Time{}, ErrFeatureNotAvailable
+	}
+	runs, err := provider.svc.ListBackupRuns(ctx, provider.projectId, dbInstance.Name)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
 	}
+	var earliest time.Time
+	for _, run := range runs {
+		finished, err := time.Parse(time.RFC3339, run.EndTime)
+		if run.Status != "SUCCESSFUL" || err != nil {
+			continue
+		}
+		if earliest.IsZero() || finished.Before(earliest) {
+			earliest = finished
+		}
+	}
+	if earliest.IsZero() {
+		return time.Time{}, time.Time{}, errors.New("The database has no backups to restore to a point in time from yet.")
+	}
+	latest := time.Now().UTC()
+	if retained := latest.Add(-gcloudPointInTimeRetention); earliest.Before(retained) {
+		earliest = retained
+	}
+	return earliest, latest, nil
+}
 
-	return DatabaseBackupSpec{
-		Database: DatabaseSpec{
-			Name: dbInstance.Name,
+// Cloud SQL restores to a point in time by cloning the instance into a new one, instances can't be renamed
+// (or their names reused for a while) so the database is moved to the clone and the old instance is
+// left for the caller to remove.
+func (provider GCloudInstanceProvider) RestoreToTime(ctx context.Context, dbInstance *DbInstance, at time.Time) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	if !dbInstance.Ready {
+		return nil, errors.New("Cannot restore a database that is unavailable.")
+	}
+	clone := strings.ToLower(provider.namePrefix + RandomString(8))
+	operation, err := provider.svc.CloneInstance(ctx, provider.projectId, dbInstance.Name, &sqladmin.InstancesCloneRequest{
+		CloneContext: &sqladmin.CloneContext{
+			DestinationInstanceName: clone,
+			PitrTimestampMs:         at.UnixNano() / int64(time.Millisecond),
 		},
-		Id:       snapshot.DBSnapshot.DBSnapshotIdentifier,
-		Progress: snapshot.DBSnapshot.PercentProgress,
-		Status:   snapshot.DBSnapshot.Status,
-		Created:  created,
-	}, nil*/
-	return DatabaseBackupSpec{}, errors.New("unimplemented")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.waitForOperation(ctx, operation); err != nil {
+		if _, derr := provider.svc.DeleteInstance(ctx, provider.projectId, clone); derr != nil && !isGoogleNotFound(derr) {
+			glog.Errorf("ERROR: Orphaned Database! Unable to clean up clone %s after failing to restore %s: %s\n", clone, dbInstance.Name, derr.Error())
+		}
+		return nil, err
+	}
+	restored, err := provider.GetInstance(ctx, clone, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	// Users are cloned with the instance, so the credentials stay the same.
+	restored.Id = dbInstance.Id
+	restored.Username = dbInstance.Username
+	restored.Password = dbInstance.Password
+	return restored, nil
+}
+
+// The backup run of the database to fork from, backups of other databases can't be forked from.
+func (provider GCloudInstanceProvider) forkBackupRun(ctx context.Context, from *DbInstance, backup string) (*sqladmin.BackupRun, error) {
+	if backup != LatestBackup {
+		run, err := provider.getBackupRun(ctx, from, backup)
+		if err != nil && err.Error() == "Not found" {
+			return nil, errors.New("The backup " + backup + " of " + from.Name + " could not be found.")
+		} else if err != nil {
+			return nil, err
+		}
+		if run.Status != "SUCCESSFUL" {
+			return nil, errors.New("Cannot fork a backup that has not finished.")
+		}
+		return run, nil
+	}
+	runs, err := provider.svc.ListBackupRuns(ctx, provider.projectId, from.Name)
+	if err != nil {
+		return nil, err
+	}
+	var latest *sqladmin.BackupRun
+	for _, run := range runs {
+		if run.Status == "SUCCESSFUL" && (latest == nil || run.EndTime > latest.EndTime) {
+			latest = run
+		}
+	}
+	if latest == nil {
+		return nil, errors.New("The database " + from.Name + " has no backups to fork from.")
+	}
+	return latest, nil
+}
+
+// Forks of the database as it is now are clones of its instance, they get the settings of the plan once the
+// clone has finished. Forks of a backup are new instances of the plan the backup is restored into. Either way
+// the users come from the database forked from, so the fork's user is given its own password by PerformFork.
+func (provider GCloudInstanceProvider) Fork(ctx context.Context, Id string, plan *ProviderPlan, Owner string, from *DbInstance, backup string) (*DbInstance, error) {
+	if backup != "" {
+		if _, err := provider.forkBackupRun(ctx, from, backup); err != nil {
+			return nil, err
+		}
+		dbInstance, err := provider.Provision(ctx, Id, plan, Owner)
+		if err != nil {
+			return nil, err
+		}
+		dbInstance.Username = from.Username
+		return dbInstance, nil
+	}
+	clone := strings.ToLower(provider.namePrefix + RandomString(8))
+	if _, err := provider.svc.CloneInstance(ctx, provider.projectId, from.Name, &sqladmin.InstancesCloneRequest{
+		CloneContext: &sqladmin.CloneContext{
+			DestinationInstanceName: clone,
+		},
+	}); err != nil {
+		return nil, err
+	}
+	return &DbInstance{
+		Id:            Id,
+		Name:          clone,
+		ProviderId:    clone,
+		Plan:          plan,
+		Username:      from.Username,
+		Password:      RandomString(16),
+		Endpoint:      "", // This is not immediately available.
+		Status:        "PENDING_CREATE",
+		Ready:         false,
+		Engine:        from.Engine,
+		EngineVersion: from.EngineVersion,
+		Scheme:        plan.Scheme,
+	}, nil
 }
 
-func (provider GCloudInstanceProvider) RestoreBackup(dbInstance *DbInstance, Id string) error {
-	/*_, err := provider.awssvc.RestoreDBInstanceFromDBSnapshot(&rds.RestoreDBInstanceFromDBSnapshotInput{
-		DBInstanceIdentifier: aws.String(dbInstance.Name),
-		DBSnapshotIdentifier: aws.String(Id),
+func (provider GCloudInstanceProvider) PerformFork(ctx context.Context, dbInstance *DbInstance, from *DbInstance, backup string, Owner string) (*DbInstance, error) {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	if backup != "" {
+		run, err := provider.forkBackupRun(ctx, from, backup)
+		if err != nil {
+			return nil, err
+		}
+		operation, err := provider.svc.RestoreBackup(ctx, provider.projectId, dbInstance.Name, &sqladmin.InstancesRestoreBackupRequest{
+			RestoreBackupContext: &sqladmin.RestoreBackupContext{
+				BackupRunId: run.Id,
+				InstanceId:  from.Name,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = provider.waitForOperation(ctx, operation); err != nil {
+			return nil, err
+		}
+	} else {
+		var settings sqladmin.Settings
+		if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+			return nil, err
+		}
+		// the clone has the labels of the database it was cloned from, but it's billed to its owner.
+		if settings.UserLabels == nil {
+			settings.UserLabels = make(map[string]string)
+		}
+		if Owner != "" {
+			settings.UserLabels["billing-code"] = strings.ToLower(Owner)
+		} else {
+			settings.UserLabels["billing-code"] = "unknown"
+		}
+		if _, err := provider.ModifyWithSettings(ctx, dbInstance, dbInstance.Plan, &settings); err != nil {
+			return nil, err
+		}
+	}
+	operation, err := provider.svc.UpdateUser(ctx, provider.projectId, dbInstance.Name, &sqladmin.User{
+		Instance: dbInstance.Name,
+		Kind:     "sql#user",
+		Name:     dbInstance.Username,
+		Password: dbInstance.Password,
+		Project:  provider.projectId,
 	})
-	return err*/
-	return errors.New("unimplemented")
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.waitForOperation(ctx, operation); err != nil {
+		return nil, err
+	}
+	provider.instanceCache.Invalidate(dbInstance.Name)
+	forked, err := provider.GetInstance(ctx, dbInstance.Name, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	c := *forked
+	c.Id = dbInstance.Id
+	c.Username = dbInstance.Username
+	c.Password = dbInstance.Password
+	return &c, nil
 }
 
-func (provider GCloudInstanceProvider) Restart(dbInstance *DbInstance) error {
-	svc := sqladmin.NewInstancesService(provider.svc)
-	_, err := svc.Restart(provider.projectId, dbInstance.Name).Do()
+func (provider GCloudInstanceProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name)
+	_, err := provider.svc.RestartInstance(ctx, provider.projectId, dbInstance.Name)
 	return err
 }
 
-func (provider GCloudInstanceProvider) ListLogs(dbInstance *DbInstance) ([]DatabaseLogs, error) {
-	/*
-	// What about replica?
-	var fileLastWritten int64 = time.Now().AddDate(0, 0, -7).Unix()
-	var maxRecords int64 = 100
-	logs, err := provider.awssvc.DescribeDBLogFiles(&rds.DescribeDBLogFilesInput{
-		DBInstanceIdentifier: aws.String(dbInstance.Name),
-		FileLastWritten:      &fileLastWritten,
-		MaxRecords:           &maxRecords,
-	})
+// The logs Cloud SQL writes for each engine, they're named the same as the files they'd be in, e.g.
+// cloudsql.googleapis.com/postgres.log.
+func gcloudLogNames(engine string) []string {
+	if engine == "mysql" {
+		return []string{"cloudsql.googleapis.com/mysql.err", "cloudsql.googleapis.com/mysql-slow.log", "cloudsql.googleapis.com/mysql-general.log"}
+	}
+	return []string{"cloudsql.googleapis.com/postgres.log"}
+}
+
+func (provider GCloudInstanceProvider) logsFilter(dbInstance *DbInstance, names []string) string {
+	logNames := make([]string, 0)
+	for _, name := range names {
+		logNames = append(logNames, "logName=\"projects/"+provider.projectId+"/logs/"+url.PathEscape(name)+"\"")
+	}
+	return "resource.type=\"cloudsql_database\" AND resource.labels.database_id=\"" + provider.projectId + ":" + dbInstance.Name + "\"" +
+		" AND timestamp>=\"" + time.Now().Add(-gcloudLogsPeriod).UTC().Format(time.RFC3339) + "\" AND (" + strings.Join(logNames, " OR ") + ")"
+}
+
+func gcloudLogLine(entry *logging.LogEntry) string {
+	if entry.TextPayload != "" {
+		return entry.TextPayload
+	}
+	return string(entry.JsonPayload)
+}
+
+func (provider GCloudInstanceProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
+	if provider.logs == nil {
+		return []DatabaseLogs{}, ErrFeatureNotAvailable
+	}
+	names := gcloudLogNames(dbInstance.Engine)
+	entries, err := provider.logs.ListLogEntries(ctx, provider.projectId, provider.logsFilter(dbInstance, names), "timestamp desc", gcloudLogsLimit)
 	if err != nil {
 		return []DatabaseLogs{}, err
 	}
+	sizes := make(map[string]int64)
+	updated := make(map[string]string)
+	for _, entry := range entries {
+		name, err := url.PathUnescape(entry.LogName[strings.LastIndex(entry.LogName, "/")+1:])
+		if err != nil {
+			continue
+		}
+		sizes[name] += int64(len(gcloudLogLine(entry)) + 1)
+		// entries are newest first
+		if _, ok := updated[name]; !ok {
+			updated[name] = time.Now().UTC().Format(time.RFC3339)
+			if t, err := time.Parse(time.RFC3339Nano, entry.Timestamp); err == nil {
+				updated[name] = t.UTC().Format(time.RFC3339)
+			}
+		}
+	}
 	out := make([]DatabaseLogs, 0)
-	for _, log := range logs.DescribeDBLogFiles {
-		updated := time.Now().UTC().Format(time.RFC3339)
-		if log.LastWritten != nil {
-			updated = time.Unix(*log.LastWritten/1000, 0).UTC().Format(time.RFC3339)
+	for _, name := range names {
+		if _, ok := updated[name]; !ok {
+			continue
 		}
+		logName := name
+		size := sizes[name]
 		out = append(out, DatabaseLogs{
-			Name:    log.LogFileName,
-			Size:    log.Size,
-			Updated: updated,
+			Name:    &logName,
+			Size:    &size,
+			Updated: updated[name],
 		})
 	}
-	return out, nil*/
-	return nil, errors.New("unimplemented")
+	return out, nil
 }
 
-func (provider GCloudInstanceProvider) GetLogs(dbInstance *DbInstance, path string) (string, error) {
-	/*
-	// What about replica?
-	data, err := provider.awssvc.DownloadDBLogFilePortion(&rds.DownloadDBLogFilePortionInput{
-		DBInstanceIdentifier: &dbInstance.Name,
-		LogFileName:          &path,
-	})
+func (provider GCloudInstanceProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
+	if provider.logs == nil {
+		return "", ErrFeatureNotAvailable
+	}
+	found := false
+	for _, name := range gcloudLogNames(dbInstance.Engine) {
+		if name == path {
+			found = true
+		}
+	}
+	if !found {
+		return "", errors.New("Not found")
+	}
+	// The newest entries are read, then put back in the order they were written.
+	entries, err := provider.logs.ListLogEntries(ctx, provider.projectId, provider.logsFilter(dbInstance, []string{path}), "timestamp desc", gcloudLogsLimit)
 	if err != nil {
 		return "", err
 	}
-	if data.LogFileData == nil {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[len(entries)-1-i] = gcloudLogLine(entry)
+	}
+	if len(lines) == 0 {
 		return "", nil
-	} else {
-		return *data.LogFileData, nil
-	}*/
-	return "", errors.New("unimplemented")
+	}
+	return strings.Join(lines, "\n") + "\n", nil
 }
 
-func (provider GCloudInstanceProvider) CreateReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
-	/*
-	// TODO: what about tags set?
-	if dbInstance.Status != "RUNNABLE" {
+// Replicas are Cloud SQL read replicas of the instance named after it with a -ro suffix, they have the
+// same machine type and storage as the instance and are reached with the same credentials.
+func (provider GCloudInstanceProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	if !dbInstance.Ready {
 		return nil, errors.New("Replicas cannot be created for databases being created, under maintenance or destroyed.")
 	}
-	var settings rds.CreateDBInstanceInput
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+	resp, err := provider.svc.GetInstance(ctx, provider.projectId, dbInstance.Name)
+	if err != nil {
 		return nil, err
 	}
-
-	rdsInstance := rds.CreateDBInstanceReadReplicaInput{
-		DBInstanceClass:             settings.DBInstanceClass,
-		SourceDBInstanceIdentifier:  aws.String(dbInstance.Name),
-		DBInstanceIdentifier:        aws.String(dbInstance.Name + "-ro"),
-		AutoMinorVersionUpgrade:     settings.AutoMinorVersionUpgrade,
-		MultiAZ:                     settings.MultiAZ,
-		PubliclyAccessible:          settings.PubliclyAccessible,
-		Port:                        settings.Port,
-		CopyTagsToSnapshot:          settings.CopyTagsToSnapshot,
-		KmsKeyId:                    settings.KmsKeyId,
-		DBSubnetGroupName:           settings.DBSubnetGroupName,
-		EnablePerformanceInsights:   settings.EnablePerformanceInsights,
-		PerformanceInsightsKMSKeyId: settings.KmsKeyId,
-		StorageType:                 settings.StorageType,
-		Iops:                        settings.Iops,
-		Tags: []*rds.Tag{
-			{
-				Key:   aws.String("Name"),
-				Value: aws.String(dbInstance.Name),
-			},
-		},
+	settings := &sqladmin.Settings{}
+	if resp.Settings != nil {
+		settings.Tier = resp.Settings.Tier
+		settings.DataDiskSizeGb = resp.Settings.DataDiskSizeGb
+		settings.DataDiskType = resp.Settings.DataDiskType
+		settings.IpConfiguration = resp.Settings.IpConfiguration
+		settings.StorageAutoResize = resp.Settings.StorageAutoResize
+		settings.UserLabels = resp.Settings.UserLabels
 	}
-
-	resp, err := provider.awssvc.CreateDBInstanceReadReplica(&rdsInstance)
-	if err != nil {
+	replica := &sqladmin.DatabaseInstance{
+		Name:               dbInstance.Name + "-ro",
+		MasterInstanceName: dbInstance.Name,
+		DatabaseVersion:    resp.DatabaseVersion,
+		BackendType:        "SECOND_GEN",
+		InstanceType:       "READ_REPLICA_INSTANCE",
+		Project:            provider.projectId,
+		Region:             resp.Region,
+		Settings:           settings,
+	}
+	if _, err = provider.svc.InsertInstance(ctx, provider.projectId, replica); err != nil {
 		return nil, err
 	}
-
-	var endpoint = ""
-	if resp.DBInstance.Endpoint != nil && resp.DBInstance.Endpoint.Port != nil && resp.DBInstance.Endpoint.Address != nil {
-		endpoint = *resp.DBInstance.Endpoint.Address + ":" + strconv.FormatInt(*resp.DBInstance.Endpoint.Port, 10) + "/" + dbInstance.Name
+	resp, err = provider.svc.GetInstance(ctx, provider.projectId, replica.Name)
+	if err != nil {
+		return nil, err
 	}
-
+	// The address of the replica is not available until it's created, the resync of the replica records it.
 	return &DbInstance{
-		Id:            dbInstance.Name + "-ro",
-		Name:          dbInstance.Name,
-		ProviderId:    *resp.DBInstance.DBInstanceArn,
+		Id:            dbInstance.Id,
+		Name:          replica.Name,
+		ProviderId:    replica.Name,
 		Plan:          dbInstance.Plan,
-		Username:      *resp.DBInstance.MasterUsername,
+		Username:      dbInstance.Username,
 		Password:      dbInstance.Password,
-		Endpoint:      endpoint,
-		Status:        *resp.DBInstance.DBInstanceStatus,
-		Ready:         IsReady(*resp.DBInstance.DBInstanceStatus),
-		Engine:        *resp.DBInstance.Engine,
-		EngineVersion: *resp.DBInstance.EngineVersion,
+		Endpoint:      "",
+		Status:        resp.State,
+		Ready:         IsReady(resp.State),
+		Engine:        dbInstance.Engine,
+		EngineVersion: dbInstance.EngineVersion,
 		Scheme:        dbInstance.Scheme,
-	}, nil*/
-	return nil, errors.New("unimplemented")
+	}, nil
 }
 
-func (provider GCloudInstanceProvider) GetReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
-	/*
-	rrDbInstance, err := provider.GetInstance(dbInstance.Name+"-ro", dbInstance.Plan)
+func (provider GCloudInstanceProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	rrDbInstance, err := provider.GetInstance(ctx, dbInstance.Name+"-ro", dbInstance.Plan)
 	if err != nil {
 		return nil, err
 	}
+	// The database on the replica is the one on the instance.
+	rrDbInstance.Endpoint = strings.TrimSuffix(rrDbInstance.Endpoint, "/"+rrDbInstance.Name) + "/" + dbInstance.Name
 	rrDbInstance.Username = dbInstance.Username
 	rrDbInstance.Password = dbInstance.Password
-	return rrDbInstance, nil*/
-	return nil, errors.New("unimplemented")
+	return rrDbInstance, nil
 }
 
-func (provider GCloudInstanceProvider) DeleteReadReplica(dbInstance *DbInstance) error {
-	/*_, err := provider.awssvc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
-		DBInstanceIdentifier: aws.String(dbInstance.Name+"-ro"),
-		SkipFinalSnapshot:    aws.Bool(false),
-	})
-	return err*/
-	return errors.New("unimplemented")
+func (provider GCloudInstanceProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	defer provider.instanceCache.Invalidate(dbInstance.Name + "-ro")
+	_, err := provider.svc.DeleteInstance(ctx, provider.projectId, dbInstance.Name+"-ro")
+	return err
 }
 
-func (provider GCloudInstanceProvider) CreateReadOnlyUser(dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+func (provider GCloudInstanceProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
 	if !dbInstance.Ready {
 		return DatabaseUrlSpec{}, errors.New("Cannot rotate password on database that is unavailable.")
 	}
-	return CreatePostgresReadOnlyRole(dbInstance, dbInstance.Scheme + "://" + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint + "/" + dbInstance.Name)
+	return CreatePostgresReadOnlyRole(ctx, dbInstance, dbInstance.Scheme + "://" + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint + "/" + dbInstance.Name)
 }
 
-func (provider GCloudInstanceProvider) DeleteReadOnlyUser(dbInstance *DbInstance, role string) error {
+func (provider GCloudInstanceProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
 	if !dbInstance.Ready {
 		return errors.New("Cannot rotate password on database that is unavailable.")
 	}
-	return DeletePostgresReadOnlyRole(dbInstance, dbInstance.Scheme + "://" + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint + "/" + dbInstance.Name, role)
+	return DeletePostgresReadOnlyRole(ctx, dbInstance, dbInstance.Scheme + "://" + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint + "/" + dbInstance.Name, role)
 }
 
-func (provider GCloudInstanceProvider) RotatePasswordReadOnlyUser(dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+func (provider GCloudInstanceProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
 	if !dbInstance.Ready {
 		return DatabaseUrlSpec{}, errors.New("Cannot rotate password on database that is unavailable.")
 	}
-	return RotatePostgresReadOnlyRole(dbInstance, dbInstance.Scheme + "://" + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint + "/" + dbInstance.Name, role)
+	return RotatePostgresReadOnlyRole(ctx, dbInstance, dbInstance.Scheme + "://" + dbInstance.Username + ":" + dbInstance.Password + "@" + dbInstance.Endpoint + "/" + dbInstance.Name, role)
 }
@@ -0,0 +1,802 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang/glog"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	clientrest "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// provider=kubernetes-postgres in database
+// These values come out of the plans table provider_private_details column and are used to build
+// the CloudNativePG (postgresql.cnpg.io/v1) Cluster resource. The backup settings are passed through
+// as the clusters spec.backup (e.g., a barmanObjectStore), backups and restores are only available
+// on plans that have them.
+type KubernetesPostgresProviderPrivatePlanSettings struct {
+	Instances     int64                  `json:"instances"`
+	ImageName     string                 `json:"image_name"`
+	EngineVersion string                 `json:"engine_version"`
+	StorageSize   string                 `json:"storage_size"`
+	StorageClass  string                 `json:"storage_class"`
+	Parameters    map[string]string      `json:"parameters"`
+	Resources     map[string]interface{} `json:"resources"`
+	Backup        map[string]interface{} `json:"backup"`
+}
+
+func (settings KubernetesPostgresProviderPrivatePlanSettings) PrimaryInstances() int64 {
+	if settings.Instances < 1 {
+		return 1
+	}
+	return settings.Instances
+}
+
+var (
+	kubernetesPostgresClusters = schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"}
+	kubernetesPostgresBackups  = schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "backups"}
+	kubernetesSecrets          = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "secrets"}
+)
+
+const (
+	kubernetesPostgresEngineVersionAnnotation = "database-broker.akkeris.io/engine-version"
+	kubernetesPostgresRestartAnnotation       = "kubectl.kubernetes.io/restartedAt"
+	kubernetesPostgresHealthy                 = "Cluster in healthy state"
+)
+
+type KubernetesPostgresProvider struct {
+	Provider
+	client       dynamic.Interface
+	namespace    string
+	namePrefix   string
+	pollInterval time.Duration
+}
+
+func init() {
+	RegisterProvider(KubernetesPostgres, func(namePrefix string) (Provider, error) {
+		provider, err := NewKubernetesPostgresProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, KubernetesPostgresProviderPrivatePlanSettings{})
+}
+
+func NewKubernetesPostgresProvider(namePrefix string) (*KubernetesPostgresProvider, error) {
+	if os.Getenv("KUBERNETES_POSTGRES_NAMESPACE") == "" {
+		return nil, errors.New("Unable to find KUBERNETES_POSTGRES_NAMESPACE environment variable.")
+	}
+	var config *clientrest.Config
+	var err error
+	if os.Getenv("KUBECONFIG") == "" {
+		config, err = clientrest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewKubernetesPostgresProviderWithClient(namePrefix, client, os.Getenv("KUBERNETES_POSTGRES_NAMESPACE")), nil
+}
+
+// NewKubernetesPostgresProviderWithClient creates the provider on top of an existing dynamic client
+// rather than one configured from the environment, such as a client for another cluster or a fake.
+func NewKubernetesPostgresProviderWithClient(namePrefix string, client dynamic.Interface, namespace string) *KubernetesPostgresProvider {
+	return &KubernetesPostgresProvider{
+		client:       client,
+		namespace:    namespace,
+		namePrefix:   namePrefix,
+		pollInterval: time.Second * 5,
+	}
+}
+
+func (provider KubernetesPostgresProvider) Close() error {
+	return nil
+}
+
+func (provider KubernetesPostgresProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	capabilities := ProviderCapabilities{RestartCapability, ReplicasCapability, TagsCapability}
+	if plan == nil {
+		return capabilities
+	}
+	var settings KubernetesPostgresProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err == nil && settings.Backup != nil {
+		capabilities = append(capabilities, BackupsCapability, RestoreCapability)
+	}
+	return capabilities
+}
+
+func (provider KubernetesPostgresProvider) clusters(ctx context.Context) (dynamic.ResourceInterface, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return provider.client.Resource(kubernetesPostgresClusters).Namespace(provider.namespace), nil
+}
+
+func (provider KubernetesPostgresProvider) backups(ctx context.Context) (dynamic.ResourceInterface, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return provider.client.Resource(kubernetesPostgresBackups).Namespace(provider.namespace), nil
+}
+
+func (provider KubernetesPostgresProvider) secrets(ctx context.Context) (dynamic.ResourceInterface, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return provider.client.Resource(kubernetesSecrets).Namespace(provider.namespace), nil
+}
+
+func (provider KubernetesPostgresProvider) getCluster(ctx context.Context, name string) (*unstructured.Unstructured, error) {
+	clusters, err := provider.clusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := clusters.Get(name, metav1.GetOptions{})
+	if err != nil && k8serrors.IsNotFound(err) {
+		return nil, errors.New("Cannot find database instance")
+	}
+	return cluster, err
+}
+
+func (provider KubernetesPostgresProvider) updateCluster(ctx context.Context, cluster *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	clusters, err := provider.clusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return clusters.Update(cluster, metav1.UpdateOptions{})
+}
+
+// The status of the cluster translated into the statuses the broker understands, the operator
+// reports a human readable phase rather than a fixed set of states.
+func kubernetesPostgresStatus(cluster *unstructured.Unstructured) string {
+	if cluster.GetDeletionTimestamp() != nil {
+		return "deleting"
+	}
+	phase, _, _ := unstructured.NestedString(cluster.Object, "status", "phase")
+	readyInstances, _, _ := unstructured.NestedInt64(cluster.Object, "status", "readyInstances")
+	if phase == kubernetesPostgresHealthy {
+		return "available"
+	} else if readyInstances == 0 {
+		return "creating"
+	}
+	return "modifying"
+}
+
+func (provider KubernetesPostgresProvider) endpoint(cluster *unstructured.Unstructured, service string) string {
+	database, _, _ := unstructured.NestedString(cluster.Object, "spec", "bootstrap", "initdb", "database")
+	if database == "" {
+		database, _, _ = unstructured.NestedString(cluster.Object, "spec", "bootstrap", "recovery", "database")
+	}
+	return service + "." + provider.namespace + ".svc:5432/" + database
+}
+
+func (provider KubernetesPostgresProvider) credentials(ctx context.Context, name string) (string, string, error) {
+	secrets, err := provider.secrets(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := secrets.Get(name+"-credentials", metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	data, _, _ := unstructured.NestedStringMap(secret.Object, "data")
+	username, err := base64.StdEncoding.DecodeString(data["username"])
+	if err != nil {
+		return "", "", err
+	}
+	password, err := base64.StdEncoding.DecodeString(data["password"])
+	if err != nil {
+		return "", "", err
+	}
+	return string(username), string(password), nil
+}
+
+func (provider KubernetesPostgresProvider) toDbInstance(cluster *unstructured.Unstructured, plan *ProviderPlan) *DbInstance {
+	writeService, _, _ := unstructured.NestedString(cluster.Object, "status", "writeService")
+	if writeService == "" {
+		writeService = cluster.GetName() + "-rw"
+	}
+	status := kubernetesPostgresStatus(cluster)
+	return &DbInstance{
+		Id:            "", // providers should not store this.
+		Name:          cluster.GetName(),
+		ProviderId:    cluster.GetNamespace() + "/" + cluster.GetName(),
+		Plan:          plan,
+		Endpoint:      provider.endpoint(cluster, writeService),
+		Status:        status,
+		Ready:         IsReady(status),
+		Engine:        "postgres",
+		EngineVersion: cluster.GetAnnotations()[kubernetesPostgresEngineVersionAnnotation],
+		Scheme:        "postgres",
+	}
+}
+
+func (provider KubernetesPostgresProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	cluster, err := provider.getCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	dbInstance := provider.toDbInstance(cluster, plan)
+	dbInstance.Username, dbInstance.Password, err = provider.credentials(ctx, name)
+	if err != nil {
+		glog.Errorf("WARNING: Unable to read the credentials for %s: %s\n", name, err.Error())
+	}
+	return dbInstance, nil
+}
+
+func (provider KubernetesPostgresProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
+	return db, nil
+}
+
+// applySettings sets everything on the clusters spec that comes from the plan.
+func (settings KubernetesPostgresProviderPrivatePlanSettings) applyTo(cluster *unstructured.Unstructured) error {
+	if err := unstructured.SetNestedField(cluster.Object, settings.PrimaryInstances(), "spec", "instances"); err != nil {
+		return err
+	}
+	if settings.ImageName != "" {
+		if err := unstructured.SetNestedField(cluster.Object, settings.ImageName, "spec", "imageName"); err != nil {
+			return err
+		}
+	}
+	if settings.StorageSize != "" {
+		if err := unstructured.SetNestedField(cluster.Object, settings.StorageSize, "spec", "storage", "size"); err != nil {
+			return err
+		}
+	}
+	if settings.StorageClass != "" {
+		if err := unstructured.SetNestedField(cluster.Object, settings.StorageClass, "spec", "storage", "storageClass"); err != nil {
+			return err
+		}
+	}
+	if settings.Parameters != nil {
+		if err := unstructured.SetNestedStringMap(cluster.Object, settings.Parameters, "spec", "postgresql", "parameters"); err != nil {
+			return err
+		}
+	}
+	if settings.Resources != nil {
+		if err := unstructured.SetNestedMap(cluster.Object, settings.Resources, "spec", "resources"); err != nil {
+			return err
+		}
+	}
+	if settings.Backup != nil {
+		if err := unstructured.SetNestedMap(cluster.Object, settings.Backup, "spec", "backup"); err != nil {
+			return err
+		}
+	} else {
+		unstructured.RemoveNestedField(cluster.Object, "spec", "backup")
+	}
+	annotations := cluster.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[kubernetesPostgresEngineVersionAnnotation] = settings.EngineVersion
+	cluster.SetAnnotations(annotations)
+	return nil
+}
+
+func (provider KubernetesPostgresProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+	var settings KubernetesPostgresProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
+	}
+
+	name := strings.ToLower(provider.namePrefix + RandomString(8))
+	username := strings.ToLower("u" + RandomString(8))
+	password := RandomString(16)
+	billingCode := strings.ToLower(Owner)
+	if billingCode == "" {
+		billingCode = "unknown"
+	}
+
+	secrets, err := provider.secrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "kubernetes.io/basic-auth",
+		"metadata": map[string]interface{}{
+			"name":      name + "-credentials",
+			"namespace": provider.namespace,
+		},
+		"data": map[string]interface{}{
+			"username": base64.StdEncoding.EncodeToString([]byte(username)),
+			"password": base64.StdEncoding.EncodeToString([]byte(password)),
+		},
+	}}
+	if _, err := secrets.Create(secret, metav1.CreateOptions{}); err != nil {
+		return nil, errors.New("Cannot create the credentials for the database: " + err.Error())
+	}
+
+	cluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "postgresql.cnpg.io/v1",
+		"kind":       "Cluster",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": provider.namespace,
+		},
+		"spec": map[string]interface{}{
+			"bootstrap": map[string]interface{}{
+				"initdb": map[string]interface{}{
+					"database": name,
+					"owner":    username,
+					"secret":   map[string]interface{}{"name": name + "-credentials"},
+				},
+			},
+		},
+	}}
+	cluster.SetLabels(map[string]string{"billing-code": billingCode})
+	if err := settings.applyTo(cluster); err != nil {
+		return nil, err
+	}
+	clusters, err := provider.clusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err = clusters.Create(cluster, metav1.CreateOptions{})
+	if err != nil {
+		if err := secrets.Delete(name+"-credentials", &metav1.DeleteOptions{}); err != nil {
+			glog.Errorf("WARNING: Unable to remove the credentials for %s: %s\n", name, err.Error())
+		}
+		return nil, err
+	}
+
+	dbInstance := provider.toDbInstance(cluster, plan)
+	dbInstance.Id = Id
+	dbInstance.Username = username
+	dbInstance.Password = password
+	return dbInstance, nil
+}
+
+func (provider KubernetesPostgresProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if _, ok, _ := unstructured.NestedMap(cluster.Object, "spec", "backup"); takeSnapshot && ok {
+		if _, err := provider.createBackup(ctx, dbInstance.Name, dbInstance.Name+"-final"); err != nil {
+			return err
+		}
+	}
+	clusters, err := provider.clusters(ctx)
+	if err != nil {
+		return err
+	}
+	if err := clusters.Delete(dbInstance.Name, &metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	secrets, err := provider.secrets(ctx)
+	if err != nil {
+		return err
+	}
+	if err := secrets.Delete(dbInstance.Name+"-credentials", &metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (provider KubernetesPostgresProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+	var settings KubernetesPostgresProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
+	}
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !CanBeModified(kubernetesPostgresStatus(cluster)) {
+		return nil, errors.New("Databases cannot be modified during creation, maintenance or while being destroyed.")
+	}
+	current := cluster.GetAnnotations()[kubernetesPostgresEngineVersionAnnotation]
+	if strings.Split(current, ".")[0] != strings.Split(settings.EngineVersion, ".")[0] {
+		return nil, errors.New("Unable to upgrade from " + current + " to " + settings.EngineVersion + " in place, the operator cannot change major versions.")
+	}
+	replicas, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "instances")
+	var oldSettings KubernetesPostgresProviderPrivatePlanSettings
+	if dbInstance.Plan != nil {
+		if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &oldSettings); err != nil {
+			return nil, err
+		}
+	}
+	if err := settings.applyTo(cluster); err != nil {
+		return nil, err
+	}
+	// keep any read replica that was added on top of the old plans instances
+	if replicas > oldSettings.PrimaryInstances() {
+		if err := unstructured.SetNestedField(cluster.Object, settings.PrimaryInstances()+replicas-oldSettings.PrimaryInstances(), "spec", "instances"); err != nil {
+			return nil, err
+		}
+	}
+	cluster, err = provider.updateCluster(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	modified := provider.toDbInstance(cluster, plan)
+	modified.Id = dbInstance.Id
+	modified.Username = dbInstance.Username
+	modified.Password = dbInstance.Password
+	return modified, nil
+}
+
+func (provider KubernetesPostgresProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	labels := cluster.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[Name] = Value
+	cluster.SetLabels(labels)
+	_, err = provider.updateCluster(ctx, cluster)
+	return err
+}
+
+func (provider KubernetesPostgresProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	labels := cluster.GetLabels()
+	delete(labels, Name)
+	cluster.SetLabels(labels)
+	_, err = provider.updateCluster(ctx, cluster)
+	return err
+}
+
+func kubernetesPostgresBackupSpec(backup *unstructured.Unstructured) DatabaseBackupSpec {
+	id := backup.GetName()
+	cluster, _, _ := unstructured.NestedString(backup.Object, "spec", "cluster", "name")
+	phase, _, _ := unstructured.NestedString(backup.Object, "status", "phase")
+	if phase == "" {
+		phase = "pending"
+	}
+	var progress int64 = 0
+	if phase == "completed" {
+		progress = 100
+	}
+	created := backup.GetCreationTimestamp().UTC().Format(time.RFC3339)
+	if startedAt, ok, _ := unstructured.NestedString(backup.Object, "status", "startedAt"); ok {
+		created = startedAt
+	}
+	return DatabaseBackupSpec{
+		Database: DatabaseSpec{
+			Name: cluster,
+		},
+		Id:       &id,
+		Progress: &progress,
+		Status:   &phase,
+		Created:  created,
+	}
+}
+
+func (provider KubernetesPostgresProvider) createBackup(ctx context.Context, name string, Id string) (DatabaseBackupSpec, error) {
+	backups, err := provider.backups(ctx)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	backup := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "postgresql.cnpg.io/v1",
+		"kind":       "Backup",
+		"metadata": map[string]interface{}{
+			"name":      Id,
+			"namespace": provider.namespace,
+		},
+		"spec": map[string]interface{}{
+			"cluster": map[string]interface{}{"name": name},
+		},
+	}}
+	backup, err = backups.Create(backup, metav1.CreateOptions{})
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return kubernetesPostgresBackupSpec(backup), nil
+}
+
+func (provider KubernetesPostgresProvider) getBackup(ctx context.Context, dbInstance *DbInstance, Id string) (*unstructured.Unstructured, error) {
+	backups, err := provider.backups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := backups.Get(Id, metav1.GetOptions{})
+	if err != nil && k8serrors.IsNotFound(err) {
+		return nil, errors.New("Not found")
+	} else if err != nil {
+		return nil, err
+	}
+	if cluster, _, _ := unstructured.NestedString(backup.Object, "spec", "cluster", "name"); cluster != dbInstance.Name {
+		return nil, errors.New("Not found")
+	}
+	return backup, nil
+}
+
+func (provider KubernetesPostgresProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	backup, err := provider.getBackup(ctx, dbInstance, Id)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return kubernetesPostgresBackupSpec(backup), nil
+}
+
+func (provider KubernetesPostgresProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	backups, err := provider.backups(ctx)
+	if err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	list, err := backups.List(metav1.ListOptions{})
+	if err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	out := make([]DatabaseBackupSpec, 0)
+	for i := range list.Items {
+		if cluster, _, _ := unstructured.NestedString(list.Items[i].Object, "spec", "cluster", "name"); cluster == dbInstance.Name {
+			out = append(out, kubernetesPostgresBackupSpec(&list.Items[i]))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created < out[j].Created })
+	return out, nil
+}
+
+func (provider KubernetesPostgresProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+	if !dbInstance.Ready {
+		return DatabaseBackupSpec{}, errors.New("Cannot create a backup on database that is unavailable.")
+	}
+	return provider.createBackup(ctx, dbInstance.Name, dbInstance.Name+"-manual-"+strings.ToLower(RandomString(10)))
+}
+
+// Backups are restored into a new cluster by RestoreBackupInto.
+func (provider KubernetesPostgresProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	return errors.New("Backups of kubernetes postgres clusters can only be restored into a new cluster.")
+}
+
+// The operator can only restore a backup by bootstrapping a new cluster from it, so the backup is
+// restored into a cluster with a new name (and a copy of the credentials) that replaces the existing
+// cluster once it's healthy. The existing cluster is left as it is until then.
+func (provider KubernetesPostgresProvider) RestoreBackupInto(ctx context.Context, dbInstance *DbInstance, Id string) (*DbInstance, error) {
+	if !dbInstance.Ready {
+		return nil, errors.New("Cannot restore backup on database that is unavailable.")
+	}
+	backup, err := provider.getBackup(ctx, dbInstance, Id)
+	if err != nil {
+		return nil, err
+	}
+	if phase, _, _ := unstructured.NestedString(backup.Object, "status", "phase"); phase != "completed" {
+		return nil, errors.New("Cannot restore a backup that has not completed.")
+	}
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	initdb, _, _ := unstructured.NestedMap(cluster.Object, "spec", "bootstrap", "initdb")
+	if initdb == nil {
+		initdb, _, _ = unstructured.NestedMap(cluster.Object, "spec", "bootstrap", "recovery")
+	}
+	username, password, err := provider.credentials(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(provider.namePrefix + RandomString(8))
+	secrets, err := provider.secrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "kubernetes.io/basic-auth",
+		"metadata": map[string]interface{}{
+			"name":      name + "-credentials",
+			"namespace": provider.namespace,
+		},
+		"data": map[string]interface{}{
+			"username": base64.StdEncoding.EncodeToString([]byte(username)),
+			"password": base64.StdEncoding.EncodeToString([]byte(password)),
+		},
+	}}
+	if _, err := secrets.Create(secret, metav1.CreateOptions{}); err != nil {
+		return nil, errors.New("Cannot create the credentials for the restored database: " + err.Error())
+	}
+
+	spec, _, _ := unstructured.NestedMap(cluster.Object, "spec")
+	restored := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": cluster.GetAPIVersion(),
+		"kind":       cluster.GetKind(),
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": cluster.GetNamespace(),
+		},
+		"spec": spec,
+	}}
+	restored.SetLabels(cluster.GetLabels())
+	restored.SetAnnotations(cluster.GetAnnotations())
+	recovery := map[string]interface{}{
+		"backup":   map[string]interface{}{"name": Id},
+		"database": initdb["database"],
+		"owner":    initdb["owner"],
+		"secret":   map[string]interface{}{"name": name + "-credentials"},
+	}
+	if err := unstructured.SetNestedField(restored.Object, map[string]interface{}{"recovery": recovery}, "spec", "bootstrap"); err != nil {
+		return nil, err
+	}
+
+	clusters, err := provider.clusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := clusters.Create(restored, metav1.CreateOptions{}); err != nil {
+		if err := secrets.Delete(name+"-credentials", &metav1.DeleteOptions{}); err != nil {
+			glog.Errorf("WARNING: Unable to remove the credentials for %s: %s\n", name, err.Error())
+		}
+		return nil, err
+	}
+	for {
+		restored, err = provider.getCluster(ctx, name)
+		if err == nil && kubernetesPostgresStatus(restored) == "available" {
+			break
+		}
+		if err == nil {
+			err = sleepWithContext(ctx, provider.pollInterval)
+		}
+		if err != nil {
+			glog.Errorf("Unable to restore %s from backup %s into %s: %s\n", dbInstance.Name, Id, name, err.Error())
+			if derr := provider.Deprovision(context.Background(), &DbInstance{Name: name}, false); derr != nil {
+				glog.Errorf("ERROR: Orphaned Database! Unable to clean up %s after failing to restore %s: %s\n", name, dbInstance.Name, derr.Error())
+			}
+			return nil, err
+		}
+	}
+	restoredDb := provider.toDbInstance(restored, dbInstance.Plan)
+	restoredDb.Id = dbInstance.Id
+	restoredDb.Username = username
+	restoredDb.Password = password
+	return restoredDb, nil
+}
+
+func (provider KubernetesPostgresProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	annotations := cluster.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[kubernetesPostgresRestartAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	cluster.SetAnnotations(annotations)
+	_, err = provider.updateCluster(ctx, cluster)
+	return err
+}
+
+func (provider KubernetesPostgresProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
+	return []DatabaseLogs{},
+		ErrFeatureNotAvailable
+}
+
+func (provider KubernetesPostgresProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
+	return "",
+		ErrFeatureNotAvailable
+}
+
+func (provider KubernetesPostgresProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+	return DatabaseUrlSpec{},
+		ErrFeatureNotAvailable
+}
+
+func (provider KubernetesPostgresProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
+	return ErrFeatureNotAvailable
+}
+
+func (provider KubernetesPostgresProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+	return DatabaseUrlSpec{},
+		ErrFeatureNotAvailable
+}
+
+// primaryInstances is the number of instances the plan asks for, a read replica is one instance
+// more than that served through the operators read only service.
+func (provider KubernetesPostgresProvider) primaryInstances(dbInstance *DbInstance) (int64, error) {
+	var settings KubernetesPostgresProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+		return 0, err
+	}
+	return settings.PrimaryInstances(), nil
+}
+
+func (provider KubernetesPostgresProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	primaries, err := provider.primaryInstances(dbInstance)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if kubernetesPostgresStatus(cluster) != "available" {
+		return nil, errors.New("Replicas cannot be created for databases being created, under maintenance or destroyed.")
+	}
+	if instances, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "instances"); instances > primaries {
+		return nil, errors.New("A replica already exists for " + dbInstance.Name + ".")
+	}
+	if err := unstructured.SetNestedField(cluster.Object, primaries+1, "spec", "instances"); err != nil {
+		return nil, err
+	}
+	cluster, err = provider.updateCluster(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	replica := provider.toDbInstance(cluster, dbInstance.Plan)
+	replica.Id = dbInstance.Id
+	replica.Name = dbInstance.Name + "-ro"
+	replica.Endpoint = provider.endpoint(cluster, dbInstance.Name+"-ro")
+	replica.Username = dbInstance.Username
+	replica.Password = dbInstance.Password
+	replica.Status = "creating"
+	replica.Ready = false
+	return replica, nil
+}
+
+func (provider KubernetesPostgresProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	primaries, err := provider.primaryInstances(dbInstance)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	instances, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "instances")
+	if instances <= primaries {
+		return nil, errors.New("Cannot find database instance")
+	}
+	readService, _, _ := unstructured.NestedString(cluster.Object, "status", "readService")
+	if readService == "" {
+		readService = dbInstance.Name + "-ro"
+	}
+	replica := provider.toDbInstance(cluster, dbInstance.Plan)
+	replica.Id = dbInstance.Id
+	replica.Name = dbInstance.Name + "-ro"
+	replica.Endpoint = provider.endpoint(cluster, readService)
+	replica.Username = dbInstance.Username
+	replica.Password = dbInstance.Password
+	if readyInstances, _, _ := unstructured.NestedInt64(cluster.Object, "status", "readyInstances"); readyInstances <= primaries {
+		replica.Status = "creating"
+		replica.Ready = false
+	}
+	return replica, nil
+}
+
+func (provider KubernetesPostgresProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	primaries, err := provider.primaryInstances(dbInstance)
+	if err != nil {
+		return err
+	}
+	cluster, err := provider.getCluster(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if instances, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "instances"); instances <= primaries {
+		return errors.New("Cannot find database instance")
+	}
+	if err := unstructured.SetNestedField(cluster.Object, primaries, "spec", "instances"); err != nil {
+		return err
+	}
+	_, err = provider.updateCluster(ctx, cluster)
+	return err
+}
@@ -0,0 +1,489 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// provider=memory in database
+// These values come out of the plans table provider_private_details column. The memory provider
+// keeps everything in process and never connects to a database, it exists so the broker can be ran
+// locally (and tested end to end) without any cloud credentials.
+type MemoryProviderPrivatePlanSettings struct {
+	Engine           string `json:"engine"`
+	EngineVersion    string `json:"engine_version"`
+	Host             string `json:"host"`
+	ProvisionSeconds int64  `json:"provision_seconds"`
+}
+
+func (settings MemoryProviderPrivatePlanSettings) MasterHost() string {
+	if settings.Host == "" {
+		return "localhost:5432"
+	}
+	return settings.Host
+}
+
+// The amount of time a database stays creating, modifying or rebooting before it's available.
+func (settings MemoryProviderPrivatePlanSettings) TransitionTime() time.Duration {
+	return time.Duration(settings.ProvisionSeconds) * time.Second
+}
+
+type memoryDatabase struct {
+	instance   DbInstance
+	transition string
+	until      time.Time
+	tags       map[string]string
+	roles      map[string]string
+	logs       []string
+}
+
+type memoryBackup struct {
+	database string
+	spec     DatabaseBackupSpec
+}
+
+type memoryDatabases struct {
+	sync.Mutex
+	databases map[string]*memoryDatabase
+	backups   map[string]*memoryBackup
+}
+
+func newMemoryDatabases() *memoryDatabases {
+	return &memoryDatabases{
+		databases: make(map[string]*memoryDatabase),
+		backups:   make(map[string]*memoryBackup),
+	}
+}
+
+// Every memory provider shares the same databases so the api and a worker running in the same
+// process (or providers created again after CloseProviders) see the same state.
+var sharedMemoryDatabases = newMemoryDatabases()
+
+type MemoryProvider struct {
+	Provider
+	namePrefix string
+	databases  *memoryDatabases
+}
+
+func init() {
+	RegisterProvider(Memory, func(namePrefix string) (Provider, error) {
+		provider, err := NewMemoryProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, MemoryProviderPrivatePlanSettings{})
+}
+
+func NewMemoryProvider(namePrefix string) (MemoryProvider, error) {
+	return MemoryProvider{
+		namePrefix: namePrefix,
+		databases:  sharedMemoryDatabases,
+	}, nil
+}
+
+func (provider MemoryProvider) Close() error {
+	return nil
+}
+
+func (provider MemoryProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	return ProviderCapabilities{BackupsCapability, RestoreCapability, RolesCapability, LogsCapability, RestartCapability, ReplicasCapability, TagsCapability}
+}
+
+func (db *memoryDatabase) status() string {
+	if db.transition != "" && time.Now().Before(db.until) {
+		return db.transition
+	}
+	return "available"
+}
+
+func (db *memoryDatabase) transitionTo(status string, plan *ProviderPlan) error {
+	var settings MemoryProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return err
+	}
+	db.transition = status
+	db.until = time.Now().Add(settings.TransitionTime())
+	db.log(status)
+	return nil
+}
+
+func (db *memoryDatabase) log(message string) {
+	db.logs = append(db.logs, time.Now().UTC().Format(time.RFC3339)+" LOG: database "+db.instance.Name+" "+message)
+}
+
+func (db *memoryDatabase) dbInstance(plan *ProviderPlan) *DbInstance {
+	dbInstance := db.instance
+	dbInstance.Id = ""       // providers should not store this.
+	dbInstance.Username = "" // providers should not store this.
+	dbInstance.Password = "" // providers should not store this.
+	dbInstance.Plan = plan
+	dbInstance.Status = db.status()
+	dbInstance.Ready = IsReady(dbInstance.Status)
+	return &dbInstance
+}
+
+// database returns the named database, the caller must hold the lock on the databases.
+func (provider MemoryProvider) database(ctx context.Context, name string) (*memoryDatabase, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db, ok := provider.databases.databases[name]
+	if !ok {
+		return nil, errors.New("Cannot find database instance")
+	}
+	return db, nil
+}
+
+func (provider MemoryProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return db.dbInstance(plan), nil
+}
+
+func (provider MemoryProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
+	return db, nil
+}
+
+func (provider MemoryProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+	var settings MemoryProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+
+	name := strings.ToLower(provider.namePrefix + RandomString(8))
+	db := &memoryDatabase{
+		instance: DbInstance{
+			Name:          name,
+			ProviderId:    name,
+			Endpoint:      settings.MasterHost() + "/" + name,
+			Engine:        settings.Engine,
+			EngineVersion: settings.EngineVersion,
+			Scheme:        plan.Scheme,
+		},
+		tags:  map[string]string{"billing-code": strings.ToLower(Owner)},
+		roles: make(map[string]string),
+		logs:  make([]string, 0),
+	}
+	if err := db.transitionTo("creating", plan); err != nil {
+		return nil, err
+	}
+	provider.databases.databases[name] = db
+
+	dbInstance := db.dbInstance(plan)
+	dbInstance.Id = Id
+	dbInstance.Username = strings.ToLower("u" + RandomString(8))
+	dbInstance.Password = RandomString(16)
+	dbInstance.Status = "creating"
+	dbInstance.Ready = false
+	return dbInstance, nil
+}
+
+func (provider MemoryProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	if _, err := provider.database(ctx, dbInstance.Name); err != nil {
+		return err
+	}
+	if takeSnapshot {
+		provider.backup(dbInstance.Name, dbInstance.Name+"-final")
+	}
+	delete(provider.databases.databases, dbInstance.Name)
+	delete(provider.databases.databases, dbInstance.Name+"-ro")
+	return nil
+}
+
+func (provider MemoryProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+	var settings MemoryProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
+	}
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !CanBeModified(db.status()) {
+		return nil, errors.New("Databases cannot be modified during creation, maintenance or while being destroyed.")
+	}
+	if settings.Engine != db.instance.Engine {
+		return nil, errors.New("Cannot change the engine of a database from " + db.instance.Engine + " to " + settings.Engine + ".")
+	}
+	db.instance.EngineVersion = settings.EngineVersion
+	db.instance.Endpoint = settings.MasterHost() + "/" + db.instance.Name
+	if err := db.transitionTo("modifying", plan); err != nil {
+		return nil, err
+	}
+	modified := db.dbInstance(plan)
+	modified.Id = dbInstance.Id
+	modified.Username = dbInstance.Username
+	modified.Password = dbInstance.Password
+	return modified, nil
+}
+
+func (provider MemoryProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	db.tags[Name] = Value
+	return nil
+}
+
+func (provider MemoryProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	delete(db.tags, Name)
+	return nil
+}
+
+// backup snapshots the named database, the caller must hold the lock on the databases.
+func (provider MemoryProvider) backup(name string, Id string) DatabaseBackupSpec {
+	var progress int64 = 100
+	status := "available"
+	spec := DatabaseBackupSpec{
+		Database: DatabaseSpec{
+			Name: name,
+		},
+		Id:       &Id,
+		Progress: &progress,
+		Status:   &status,
+		Created:  time.Now().UTC().Format(time.RFC3339),
+	}
+	provider.databases.backups[Id] = &memoryBackup{database: name, spec: spec}
+	return spec
+}
+
+func (provider MemoryProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	if err := ctx.Err(); err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	backup, ok := provider.databases.backups[Id]
+	if !ok || backup.database != dbInstance.Name {
+		return DatabaseBackupSpec{}, errors.New("Not found")
+	}
+	return backup.spec, nil
+}
+
+func (provider MemoryProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	if err := ctx.Err(); err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	out := make([]DatabaseBackupSpec, 0)
+	for _, backup := range provider.databases.backups {
+		if backup.database == dbInstance.Name {
+			out = append(out, backup.spec)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return *out[i].Id < *out[j].Id })
+	return out, nil
+}
+
+func (provider MemoryProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+	if !dbInstance.Ready {
+		return DatabaseBackupSpec{}, errors.New("Cannot create a backup on database that is unavailable.")
+	}
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	db.log("backed up")
+	return provider.backup(dbInstance.Name, dbInstance.Name+"-manual-"+strings.ToLower(RandomString(10))), nil
+}
+
+func (provider MemoryProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	if !dbInstance.Ready {
+		return errors.New("Cannot restore backup on database that is unavailable.")
+	}
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	backup, ok := provider.databases.backups[Id]
+	if !ok || backup.database != dbInstance.Name {
+		return errors.New("Not found")
+	}
+	return db.transitionTo("modifying", dbInstance.Plan)
+}
+
+func (provider MemoryProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if !CanBeModified(db.status()) {
+		return errors.New("Cannot restart a database that is unavailable.")
+	}
+	return db.transitionTo("rebooting", dbInstance.Plan)
+}
+
+func (provider MemoryProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return []DatabaseLogs{}, err
+	}
+	name := "memory/" + db.instance.Name + ".log"
+	size := int64(len(strings.Join(db.logs, "\n")))
+	return []DatabaseLogs{
+		{
+			Name:    &name,
+			Size:    &size,
+			Updated: time.Now().UTC().Format(time.RFC3339),
+		},
+	}, nil
+}
+
+func (provider MemoryProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return "", err
+	}
+	if path != "memory/"+db.instance.Name+".log" {
+		return "", errors.New("Not found")
+	}
+	return strings.Join(db.logs, "\n"), nil
+}
+
+func (provider MemoryProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return DatabaseUrlSpec{}, err
+	}
+	username := "rdo1" + strings.ToLower(RandomString(7))
+	password := RandomString(10)
+	db.roles[username] = password
+	return DatabaseUrlSpec{
+		Username: username,
+		Password: password,
+		Endpoint: dbInstance.Endpoint,
+		Plan:     dbInstance.Plan.ID,
+	}, nil
+}
+
+func (provider MemoryProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if _, ok := db.roles[role]; !ok {
+		return errors.New("Cannot find role " + role + ".")
+	}
+	delete(db.roles, role)
+	return nil
+}
+
+func (provider MemoryProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return DatabaseUrlSpec{}, err
+	}
+	if _, ok := db.roles[role]; !ok {
+		return DatabaseUrlSpec{}, errors.New("Cannot find role " + role + ".")
+	}
+	password := RandomString(10)
+	db.roles[role] = password
+	return DatabaseUrlSpec{
+		Username: role,
+		Password: password,
+		Endpoint: dbInstance.Endpoint,
+	}, nil
+}
+
+func (provider MemoryProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	db, err := provider.database(ctx, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	if db.status() != "available" {
+		return nil, errors.New("Replicas cannot be created for databases being created, under maintenance or destroyed.")
+	}
+	name := dbInstance.Name + "-ro"
+	if _, ok := provider.databases.databases[name]; ok {
+		return nil, errors.New("A replica already exists for " + dbInstance.Name + ".")
+	}
+	replica := &memoryDatabase{
+		instance: db.instance,
+		tags:     make(map[string]string),
+		roles:    make(map[string]string),
+		logs:     make([]string, 0),
+	}
+	replica.instance.Name = name
+	replica.instance.ProviderId = name
+	replica.instance.Endpoint = strings.Replace(db.instance.Endpoint, "/"+dbInstance.Name, "/"+name, 1)
+	if err := replica.transitionTo("creating", dbInstance.Plan); err != nil {
+		return nil, err
+	}
+	provider.databases.databases[name] = replica
+
+	rrDbInstance := replica.dbInstance(dbInstance.Plan)
+	rrDbInstance.Id = dbInstance.Id
+	rrDbInstance.Username = dbInstance.Username
+	rrDbInstance.Password = dbInstance.Password
+	rrDbInstance.Status = "creating"
+	rrDbInstance.Ready = false
+	return rrDbInstance, nil
+}
+
+func (provider MemoryProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
+	rrDbInstance, err := provider.GetInstance(ctx, dbInstance.Name+"-ro", dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	rrDbInstance.Id = dbInstance.Id
+	rrDbInstance.Username = dbInstance.Username
+	rrDbInstance.Password = dbInstance.Password
+	return rrDbInstance, nil
+}
+
+func (provider MemoryProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	provider.databases.Lock()
+	defer provider.databases.Unlock()
+	if _, err := provider.database(ctx, dbInstance.Name+"-ro"); err != nil {
+		return err
+	}
+	delete(provider.databases.databases, dbInstance.Name+"-ro")
+	return nil
+}
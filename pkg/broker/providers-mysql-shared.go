@@ -1,10 +1,13 @@
 package broker
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+	"io"
 	"net/url"
 	"strings"
 )
@@ -12,10 +15,13 @@ import (
 // provider=shared-mysql in database
 // These values come out of the plans table provider_private_details column.
 type MysqlSharedProviderPrivatePlanSettings struct {
-	MasterUri     string `json:"master_uri"`
-	Engine        string `json:"engine"`
-	EngineVersion string `json:"engine_version"`
-	SchemeType    string `json:"scheme_type"` /* Can be 'dsn', 'uri' */
+	MasterUri      string       `json:"master_uri"`
+	Engine         string       `json:"engine"`
+	EngineVersion  string       `json:"engine_version"`
+	SchemeType     string       `json:"scheme_type"`     /* Can be 'dsn', 'uri' */
+	Hosts          []SharedHost `json:"hosts"`           /* Databases are placed on one of these, if set */
+	Placement      string       `json:"placement"`       /* Can be 'tenants', 'size', 'free_disk' */
+	BackupLocation string       `json:"backup_location"` /* Where dumps are kept, a file:// or s3:// url */
 }
 
 func (psppps MysqlSharedProviderPrivatePlanSettings) MasterHost() string {
@@ -69,15 +75,66 @@ type MysqlSharedProvider struct {
 	namePrefix string
 }
 
+func init() {
+	RegisterProvider(MysqlShared, func(namePrefix string) (Provider, error) {
+		provider, err := NewMysqlSharedProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, MysqlSharedProviderPrivatePlanSettings{})
+}
+
 func NewMysqlSharedProvider(namePrefix string) (MysqlSharedProvider, error) {
 	return MysqlSharedProvider{
 		namePrefix: namePrefix,
 	}, nil
 }
 
-func (provider MysqlSharedProvider) GetInstance(name string, plan *ProviderPlan) (*DbInstance, error) {
+// The settings for an existing database, the master uri is swapped for the host the database was placed on.
+func (provider MysqlSharedProvider) tenantSettings(plan *ProviderPlan, name string) (MysqlSharedProviderPrivatePlanSettings, error) {
 	var settings MysqlSharedProviderPrivatePlanSettings
 	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return settings, err
+	}
+	uri, err := sharedTenantUri(name, settings.MasterUri, settings.Hosts)
+	if err != nil {
+		return settings, err
+	}
+	settings.MasterUri = uri
+	return settings, nil
+}
+
+// The bytes used by every database on the host the master uri points to.
+func mysqlHostSize(ctx context.Context, masterUri string) (int64, error) {
+	db, err := sql.Open("mysql", MysqlSharedProviderPrivatePlanSettings{MasterUri: masterUri}.GetMasterUriAsDsn())
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var size int64
+	err = db.QueryRowContext(ctx, "select cast(coalesce(sum(data_length + index_length), 0) as unsigned) from information_schema.tables").Scan(&size)
+	return size, err
+}
+
+func (provider MysqlSharedProvider) Close() error {
+	return nil
+}
+
+func (provider MysqlSharedProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	var settings MysqlSharedProviderPrivatePlanSettings
+	if plan == nil {
+		return ProviderCapabilities{RolesCapability}
+	}
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil || settings.BackupLocation == "" {
+		return ProviderCapabilities{RolesCapability}
+	}
+	return ProviderCapabilities{RolesCapability, BackupsCapability, RestoreCapability}
+}
+
+func (provider MysqlSharedProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	settings, err := provider.tenantSettings(plan, name)
+	if err != nil {
 		return nil, err
 	}
 	var endpoint = settings.MasterHost() + "/" + name
@@ -103,33 +160,52 @@ func (provider MysqlSharedProvider) GetInstance(name string, plan *ProviderPlan)
 	}, nil
 }
 
-func (provider MysqlSharedProvider) PerformPostProvision(db *DbInstance) (*DbInstance, error) {
+func (provider MysqlSharedProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
 	return db, nil
 }
 
-func (provider MysqlSharedProvider) Provision(Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+// Creates the owner and database on the host the settings master uri points to.
+func (provider MysqlSharedProvider) createTenant(ctx context.Context, settings MysqlSharedProviderPrivatePlanSettings, db_name string, username string, password string) error {
+	db, err := sql.Open("mysql", settings.GetMasterUriAsDsn())
+	if err != nil {
+		return errors.New("Cannot provision shared database (connection failure): " + err.Error())
+	}
+	defer db.Close()
+
+	if _, err = db.ExecContext(ctx, "CREATE USER '" + username + "' identified by '" + password + "'"); err != nil {
+		return errors.New("Failed to create user with password: " + err.Error())
+	}
+	if _, err = db.ExecContext(ctx, "CREATE DATABASE " + db_name); err != nil {
+		return errors.New("Failed to create database with owner on shared tenant " + err.Error())
+	}
+	if _, err = db.ExecContext(ctx, "GRANT all on " + db_name + ".* TO " + username); err != nil {
+		return errors.New("Failed to grant access to user on shared tenant " + err.Error())
+	}
+	return nil
+}
+
+func (provider MysqlSharedProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
 	var settings MysqlSharedProviderPrivatePlanSettings
 	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
 		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
 	}
+	uri, err := placeSharedTenant(settings.MasterUri, settings.Hosts, settings.Placement, func(uri string) (int64, error) {
+		return mysqlHostSize(ctx, uri)
+	})
+	if err != nil {
+		return nil, errors.New("Cannot find a host for the shared database: " + err.Error())
+	}
+	settings.MasterUri = uri
 
 	db_name := strings.ToLower(provider.namePrefix + RandomString(8))
 	username := strings.ToLower("u" + RandomString(8))
 	password := RandomString(16)
-	db, err := sql.Open("mysql", settings.GetMasterUriAsDsn())
-	if err != nil {
-		return nil, errors.New("Cannot provision shared database (connection failure): " + err.Error())
-	}
-	defer db.Close()
-
-	if _, err = db.Exec("CREATE USER '" + username + "' identified by '" + password + "'"); err != nil {
-		return nil, errors.New("Failed to create user with password: " + err.Error())
-	}
-	if _, err = db.Exec("CREATE DATABASE " + db_name); err != nil {
-		return nil, errors.New("Failed to create database with owner on shared tenant " + err.Error())
+	if err = provider.createTenant(ctx, settings, db_name, username, password); err != nil {
+		return nil, err
 	}
-	if _, err = db.Exec("GRANT all on " + db_name + ".* TO " + username); err != nil {
-		return nil, errors.New("Failed to grant access to user on shared tenant " + err.Error())
+	if err = recordSharedTenant(db_name, settings.MasterUri); err != nil {
+		glog.Errorf("ERROR: Orphaned Database! The host of %s could not be recorded: %s\n", db_name, err.Error())
+		return nil, errors.New("Failed to record the host of the shared database: " + err.Error())
 	}
 
 	var endpoint = settings.MasterHost() + "/" + db_name
@@ -156,146 +232,503 @@ func (provider MysqlSharedProvider) Provision(Id string, plan *ProviderPlan, Own
 	}, nil
 }
 
-// TODO: take snapshot somehow.
-func (provider MysqlSharedProvider) Deprovision(dbInstance *DbInstance, takeSnapshot bool) error {
-	var settings MysqlSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+func (provider MysqlSharedProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
 		return err
 	}
+	if takeSnapshot && settings.BackupLocation != "" {
+		backup, err := addLogicalBackup(dbInstance, settings.BackupLocation)
+		if err != nil {
+			return errors.New("Failed to record the final backup of: " + dbInstance.Name + " error: " + err.Error())
+		}
+		if err = provider.PerformBackup(ctx, dbInstance, backup.Id); err != nil {
+			return errors.New("Failed to take the final backup of: " + dbInstance.Name + " error: " + err.Error())
+		}
+	}
+	if err = provider.dropTenant(ctx, settings, dbInstance); err != nil {
+		return err
+	}
+	if err = forgetSharedTenant(dbInstance.Name); err != nil {
+		return errors.New("Failed to remove the host record of: " + dbInstance.Name + " error: " + err.Error())
+	}
+	return nil
+}
 
+// Removes the database, its owner and read only users from the host the settings master uri points to.
+func (provider MysqlSharedProvider) dropTenant(ctx context.Context, settings MysqlSharedProviderPrivatePlanSettings, dbInstance *DbInstance) error {
 	db, err := sql.Open("mysql", settings.GetMasterUriAsDsn())
 	if err != nil {
 		return errors.New("Cannot deprovision shared database (connection failure): " + err.Error())
 	}
 	defer db.Close()
 
-	// Get a list of all read only users
-	rows, err := db.Query(ApplyParamsToStatement("select grantee as role from information_schema.schema_privileges where table_schema = $1 and grantee not like $2", "'"+dbInstance.Name+"'", "'%"+dbInstance.Username+"%'"))
+	roles, err := mysqlReadOnlyRoles(ctx, db, dbInstance)
+	if err != nil {
+		return errors.New("Failed to deprovision database while trying to fetch read only user results: " + dbInstance.Name + " error: " + err.Error())
+	}
+	for _, role := range roles {
+		if err = DeleteMysqlReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name), role); err != nil {
+			return errors.New("Failed to remove read only user while deprovisioning database: " + dbInstance.Name + " error: " + err.Error())
+		}
+	}
+	if _, err = db.ExecContext(ctx, "REVOKE all privileges, grant option from " + dbInstance.Username); err != nil {
+		return errors.New("Failed to revoke access from master user to shared tenant user: " + dbInstance.Name + " error: " + err.Error())
+	}
+	if _, err = db.ExecContext(ctx, "DROP DATABASE " + dbInstance.Name); err != nil {
+		return errors.New("Failed to drop database shared tenant: " + dbInstance.Name + " error: " + err.Error())
+	}
+	if _, err = db.ExecContext(ctx, "DROP USER " + dbInstance.Username); err != nil {
+		return errors.New("Failed to remove user: " + dbInstance.Name + " error: " + err.Error())
+	}
+	return nil
+}
+
+// The read only users of a database, which is everyone with access to it other than its owner.
+func mysqlReadOnlyRoles(ctx context.Context, db *sql.DB, dbInstance *DbInstance) ([]string, error) {
+	rows, err := db.QueryContext(ctx, ApplyParamsToStatement("select grantee as role from information_schema.schema_privileges where table_schema = $1 and grantee not like $2", "'"+dbInstance.Name+"'", "'%"+dbInstance.Username+"%'"))
 	if err != nil {
-		return errors.New("Failed to query read only users in role: " + err.Error())
+		return nil, errors.New("Failed to query read only users in role: " + err.Error())
 	}
 	defer rows.Close()
+	roles := make([]string, 0)
+	seen := make(map[string]bool)
 	for rows.Next() {
 		var role string
 		if err := rows.Scan(&role); err != nil {
-			return errors.New("Failed to scan read only users in role: " + err.Error())
+			return nil, errors.New("Failed to scan read only users in role: " + err.Error())
 		}
+		// there is a row for each privilege the user has.
 		role = strings.Split(strings.Replace(role, "'", "", -1), "@")[0]
-		if err = DeleteMysqlReadOnlyRole(dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name), role); err != nil {
-			return errors.New("Failed to remove read only user while deprovisioning database: " + dbInstance.Name + " error: " + err.Error())
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
 		}
 	}
-	if err := rows.Err(); err != nil {
-		return errors.New("Failed to deprovision database while trying to fetch read only user results: " + dbInstance.Name + " error: " + err.Error())
+	return roles, rows.Err()
+}
+
+// Locks (or unlocks) the accounts of the users given and, when locking, ends the connections to the
+// database so nothing can change it.
+func setMysqlTenantLock(ctx context.Context, settings MysqlSharedProviderPrivatePlanSettings, name string, users []string, lock string) error {
+	db, err := sql.Open("mysql", settings.GetMasterUriAsDsn())
+	if err != nil {
+		return err
 	}
-	if _, err = db.Exec("REVOKE all privileges, grant option from " + dbInstance.Username); err != nil {
-		return errors.New("Failed to revoke access from master user to shared tenant user: " + dbInstance.Name + " error: " + err.Error())
+	defer db.Close()
+	for _, user := range users {
+		if _, err = db.ExecContext(ctx, "ALTER USER '" + user + "'@'%' ACCOUNT " + lock); err != nil {
+			return err
+		}
 	}
-	if _, err = db.Exec("DROP DATABASE " + dbInstance.Name); err != nil {
-		return errors.New("Failed to drop database shared tenant: " + dbInstance.Name + " error: " + err.Error())
+	if lock != "LOCK" {
+		return nil
 	}
-	if _, err = db.Exec("DROP USER " + dbInstance.Username); err != nil {
-		return errors.New("Failed to remove user: " + dbInstance.Name + " error: " + err.Error())
+	rows, err := db.QueryContext(ctx, "select id from information_schema.processlist where db = '" + name + "' and id <> connection_id()")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		// the connection may have already gone away on its own.
+		db.ExecContext(ctx, "KILL " + id)
 	}
 	return nil
 }
 
-func (provider MysqlSharedProvider) Modify(dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+// Plan changes are done by MoveTenant, as it needs the read only roles from storage.
+func (provider MysqlSharedProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
 	return nil,
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
+}
+
+// Moves a database to the host it belongs on under the new plan, if it isn't already there. The data is
+// copied with migrateMysql while the accounts using the database are locked.
+func (provider MysqlSharedProvider) MoveTenant(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan, roles []DatabaseUrlSpec, progress func(string)) (*DbInstance, error) {
+	from, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	var to MysqlSharedProviderPrivatePlanSettings
+	if err = json.Unmarshal([]byte(plan.providerPrivateDetails), &to); err != nil {
+		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
+	}
+	if to.MasterUri, err = moveSharedTenantTo(from.MasterUri, to.MasterUri, to.Hosts, to.Placement, func(uri string) (int64, error) {
+		return mysqlHostSize(ctx, uri)
+	}); err != nil {
+		return nil, errors.New("Cannot find a host for the shared database: " + err.Error())
+	}
+
+	if to.MasterHost() != from.MasterHost() {
+		users := []string{dbInstance.Username}
+		for _, role := range roles {
+			users = append(users, role.Username)
+		}
+		move := sharedTenantMove{
+			name: dbInstance.Name,
+			from: from.MasterHost(),
+			to:   to.MasterUri,
+			create: func() error {
+				return provider.createTenant(ctx, to, dbInstance.Name, dbInstance.Username, dbInstance.Password)
+			},
+			pause: func() error {
+				return setMysqlTenantLock(ctx, from, dbInstance.Name, users, "LOCK")
+			},
+			resume: func() error {
+				return setMysqlTenantLock(ctx, from, dbInstance.Name, users, "UNLOCK")
+			},
+			copy: func() error {
+				_, err := migrateMysql(ctx, from.GetMasterUriWithDbAsDsn(dbInstance.Name), to.GetMasterUriWithDbAsDsn(dbInstance.Name), progress)
+				return err
+			},
+			roles: func() error {
+				moved := *dbInstance
+				moved.Plan = plan
+				for _, role := range roles {
+					if _, err := createMysqlReadOnlyRole(ctx, &moved, to.GetMasterUriWithDbAsDsn(dbInstance.Name), role.Username, role.Password); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			remove: func() error {
+				return provider.dropTenant(ctx, to, dbInstance)
+			},
+			drop: func() error {
+				return provider.dropTenant(ctx, from, dbInstance)
+			},
+		}
+		if err = move.run(progress); err != nil {
+			return nil, err
+		}
+	} else if err = recordSharedTenant(dbInstance.Name, to.MasterUri); err != nil {
+		return nil, errors.New("Failed to record the host of the shared database: " + err.Error())
+	}
+
+	moved, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+	if err != nil {
+		return nil, err
+	}
+	moved.Id = dbInstance.Id
+	moved.Username = dbInstance.Username
+	moved.Password = dbInstance.Password
+	return moved, nil
 }
 
-func (provider MysqlSharedProvider) Tag(dbInstance *DbInstance, Name string, Value string) error {
+func (provider MysqlSharedProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
 	// do nothing
 	return nil
 }
 
-func (provider MysqlSharedProvider) Untag(dbInstance *DbInstance, Name string) error {
+func (provider MysqlSharedProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
 	// do nothing
 	return nil
 }
 
-func (provider MysqlSharedProvider) GetBackup(dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
-	return DatabaseBackupSpec{},
-		errors.New("This feature is not available on this plan.")
+func (provider MysqlSharedProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	if settings.BackupLocation == "" {
+		return DatabaseBackupSpec{}, ErrFeatureNotAvailable
+	}
+	backup, err := getLogicalBackup(dbInstance, Id)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return backup.Spec(), nil
 }
 
-func (provider MysqlSharedProvider) CreateReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
+func (provider MysqlSharedProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
 	return nil,
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider MysqlSharedProvider) GetReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
+func (provider MysqlSharedProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
 	return nil,
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider MysqlSharedProvider) DeleteReadReplica(dbInstance *DbInstance) error {
-	return errors.New("This feature is not available on this plan.")
+func (provider MysqlSharedProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	return ErrFeatureNotAvailable
+}
+
+func (provider MysqlSharedProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	if settings.BackupLocation == "" {
+		return []DatabaseBackupSpec{}, ErrFeatureNotAvailable
+	}
+	return listLogicalBackups(dbInstance)
 }
 
-func (provider MysqlSharedProvider) ListBackups(dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
-	return []DatabaseBackupSpec{},
-		errors.New("This feature is not available on this plan.")
+// Only records the backup, the dump is taken by PerformBackup in the worker.
+func (provider MysqlSharedProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	if settings.BackupLocation == "" {
+		return DatabaseBackupSpec{}, ErrFeatureNotAvailable
+	}
+	backup, err := addLogicalBackup(dbInstance, settings.BackupLocation)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return backup.Spec(), nil
+}
+
+// Dumps the database from a single consistent snapshot into the backup store.
+func (provider MysqlSharedProvider) PerformBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	return takeLogicalBackup(ctx, dbInstance, Id, func(out io.Writer, progress func(int64)) error {
+		return dumpMysqlDatabase(ctx, settings.GetMasterUriWithDbAsDsn(dbInstance.Name), dbInstance.Name, out, progress, nil)
+	})
+}
+
+// Mysql can't rename databases, so the database is emptied and the backup loaded into it. A backup of
+// the database as it was is taken first and put back if the load fails. Nothing else can use the
+// database while it's restored, the owner is given a password only the broker knows and the read
+// only users are locked out, the backup is loaded as the owner so everything in it belongs to them.
+func (provider MysqlSharedProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if settings.BackupLocation == "" {
+		return ErrFeatureNotAvailable
+	}
+	dump, err := openLogicalBackup(ctx, dbInstance, Id)
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+
+	db, err := sql.Open("mysql", settings.GetMasterUriAsDsn())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var charset, collation string
+	if err = db.QueryRowContext(ctx, "select default_character_set_name, default_collation_name from information_schema.schemata where schema_name = ?", dbInstance.Name).Scan(&charset, &collation); err != nil {
+		return errors.New("Cannot find the database to restore into: " + err.Error())
+	}
+	roles, err := mysqlReadOnlyRoles(ctx, db, dbInstance)
+	if err != nil {
+		return err
+	}
+	password := RandomString(16)
+	if _, err = db.ExecContext(ctx, "ALTER USER '" + dbInstance.Username + "'@'%' IDENTIFIED BY '" + password + "'"); err != nil {
+		return errors.New("Cannot keep the owner out of the database while restoring: " + err.Error())
+	}
+	defer func() {
+		if _, err := db.ExecContext(context.Background(), "ALTER USER '" + dbInstance.Username + "'@'%' IDENTIFIED BY '" + dbInstance.Password + "'"); err != nil {
+			glog.Errorf("ERROR: The password of the owner of %s could not be put back after a restore: %s\n", dbInstance.Name, err.Error())
+		}
+		if err := setMysqlTenantLock(context.Background(), settings, dbInstance.Name, roles, "UNLOCK"); err != nil {
+			glog.Errorf("ERROR: The read only users of %s could not be unlocked after a restore: %s\n", dbInstance.Name, err.Error())
+		}
+	}()
+	if err = setMysqlTenantLock(ctx, settings, dbInstance.Name, roles, "LOCK"); err != nil {
+		return errors.New("Cannot keep users out of the database while restoring: " + err.Error())
+	}
+
+	previous, err := addLogicalBackup(dbInstance, settings.BackupLocation)
+	if err != nil {
+		return err
+	}
+	if err = provider.PerformBackup(ctx, dbInstance, previous.Id); err != nil {
+		return errors.New("Cannot back up the database before restoring: " + err.Error())
+	}
+
+	owner, err := url.Parse(settings.MasterUri)
+	if err != nil {
+		return err
+	}
+	owner.User = url.UserPassword(dbInstance.Username, password)
+	ownerDsn := MysqlSharedProviderPrivatePlanSettings{MasterUri: owner.String()}.GetMasterUriWithDbAsDsn(dbInstance.Name)
+	load := func(in io.Reader) error {
+		if _, err := db.ExecContext(ctx, "DROP DATABASE " + quoteMysqlName(dbInstance.Name)); err != nil {
+			return err
+		}
+		// grants on a database outlive it, so the owner and read only users keep their access.
+		if _, err := db.ExecContext(ctx, "CREATE DATABASE " + quoteMysqlName(dbInstance.Name) + " CHARACTER SET " + charset + " COLLATE " + collation); err != nil {
+			return err
+		}
+		return loadMysqlDump(ctx, ownerDsn, in)
+	}
+	if err = load(dump); err != nil {
+		glog.Errorf("Unable to restore %s from backup %s, putting back the database as it was: %s\n", dbInstance.Name, Id, err.Error())
+		original, oerr := openLogicalBackup(ctx, dbInstance, previous.Id)
+		if oerr == nil {
+			oerr = load(original)
+			original.Close()
+		}
+		if oerr != nil {
+			glog.Errorf("ERROR: %s could not be put back as it was before the restore, it can be restored from backup %s: %s\n", dbInstance.Name, previous.Id, oerr.Error())
+		}
+		return errors.New("Failed to restore the backup: " + err.Error())
+	}
+	return nil
 }
 
-func (provider MysqlSharedProvider) CreateBackup(dbInstance *DbInstance) (DatabaseBackupSpec, error) {
-	return DatabaseBackupSpec{},
-		errors.New("This feature is not available on this plan.")
+// Forks are new databases on a host of the plan, the worker loads a dump of the database (or its backup)
+// into the fork as its owner so everything in it belongs to them.
+func (provider MysqlSharedProvider) Fork(ctx context.Context, Id string, plan *ProviderPlan, Owner string, from *DbInstance, backup string) (*DbInstance, error) {
+	if backup != "" {
+		if _, err := forkLogicalBackup(from, backup); err != nil {
+			return nil, err
+		}
+	}
+	dbInstance, err := provider.Provision(ctx, Id, plan, Owner)
+	if err != nil {
+		return nil, err
+	}
+	// the fork is empty until the worker has loaded into it.
+	dbInstance.Status = "creating"
+	dbInstance.Ready = false
+	return dbInstance, nil
 }
 
-func (provider MysqlSharedProvider) RestoreBackup(dbInstance *DbInstance, Id string) error {
-	return errors.New("This feature is not available on this plan.")
+// Loading a dump can't be undone, so the fork is emptied before the dump is loaded in case an earlier
+// try got part way. It keeps the character set of the database forked from.
+func (provider MysqlSharedProvider) PerformFork(ctx context.Context, dbInstance *DbInstance, from *DbInstance, backup string, Owner string) (*DbInstance, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	fromSettings, err := provider.tenantSettings(from.Plan, from.Name)
+	if err != nil {
+		return nil, err
+	}
+	source, err := sql.Open("mysql", fromSettings.GetMasterUriAsDsn())
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	var charset, collation string
+	if err = source.QueryRowContext(ctx, "select default_character_set_name, default_collation_name from information_schema.schemata where schema_name = ?", from.Name).Scan(&charset, &collation); err != nil {
+		return nil, errors.New("Cannot find the database to fork from: " + err.Error())
+	}
+
+	var dump io.ReadCloser
+	if backup == "" {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(dumpMysqlDatabase(ctx, fromSettings.GetMasterUriWithDbAsDsn(from.Name), from.Name, writer, func(int64) {}, nil))
+		}()
+		dump = reader
+	} else {
+		Id, err := forkLogicalBackup(from, backup)
+		if err != nil {
+			return nil, err
+		}
+		if dump, err = openLogicalBackup(ctx, from, Id); err != nil {
+			return nil, err
+		}
+	}
+	// closing the pipe stops the dump if the load gives up before reading all of it.
+	defer dump.Close()
+
+	db, err := sql.Open("mysql", settings.GetMasterUriAsDsn())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "DROP DATABASE " + quoteMysqlName(dbInstance.Name)); err != nil {
+		return nil, err
+	}
+	// grants on a database outlive it, so the owner keeps their access.
+	if _, err := db.ExecContext(ctx, "CREATE DATABASE " + quoteMysqlName(dbInstance.Name) + " CHARACTER SET " + charset + " COLLATE " + collation); err != nil {
+		return nil, err
+	}
+	owner, err := url.Parse(settings.MasterUri)
+	if err != nil {
+		return nil, err
+	}
+	owner.User = url.UserPassword(dbInstance.Username, dbInstance.Password)
+	ownerDsn := MysqlSharedProviderPrivatePlanSettings{MasterUri: owner.String()}.GetMasterUriWithDbAsDsn(dbInstance.Name)
+	if err = loadMysqlDump(ctx, ownerDsn, dump); err != nil {
+		return nil, errors.New("Failed to load the database forked from: " + err.Error())
+	}
+
+	forked, err := provider.GetInstance(ctx, dbInstance.Name, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	forked.Id = dbInstance.Id
+	forked.Username = dbInstance.Username
+	forked.Password = dbInstance.Password
+	return forked, nil
 }
 
-func (provider MysqlSharedProvider) Restart(dbInstance *DbInstance) error {
-	return errors.New("This feature is not available on this plan.")
+func (provider MysqlSharedProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	return ErrFeatureNotAvailable
 }
 
-func (provider MysqlSharedProvider) ListLogs(dbInstance *DbInstance) ([]DatabaseLogs, error) {
+func (provider MysqlSharedProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
 	return []DatabaseLogs{},
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider MysqlSharedProvider) GetLogs(dbInstance *DbInstance, path string) (string, error) {
+func (provider MysqlSharedProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
 	return "",
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider MysqlSharedProvider) CreateReadOnlyUser(dbInstance *DbInstance) (DatabaseUrlSpec, error) {
-	var settings MysqlSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+func (provider MysqlSharedProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
 		return DatabaseUrlSpec{}, err
 	}
-	return CreateMysqlReadOnlyRole(dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name))
+	return CreateMysqlReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name))
 }
 
-func (provider MysqlSharedProvider) DeleteReadOnlyUser(dbInstance *DbInstance, role string) error {
-	var settings MysqlSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+func (provider MysqlSharedProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
 		return err
 	}
-	return DeleteMysqlReadOnlyRole(dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name), role)
+	return DeleteMysqlReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name), role)
 }
 
-func (provider MysqlSharedProvider) RotatePasswordReadOnlyUser(dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
-	var settings MysqlSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+func (provider MysqlSharedProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
 		return DatabaseUrlSpec{}, err
 	}
-	return RotateMysqlReadOnlyRole(dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name), role)
+	return RotateMysqlReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDbAsDsn(dbInstance.Name), role)
 }
 
 // Technically the create role functions are used by any provider that implements mysql but we'll place
 // them here, but be aware they're not specific to this provider.
-func CreateMysqlReadOnlyRole(dbInstance *DbInstance, databaseUri string) (DatabaseUrlSpec, error) {
+func CreateMysqlReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string) (DatabaseUrlSpec, error) {
+	return createMysqlReadOnlyRole(ctx, dbInstance, databaseUri, "rdo1" + strings.ToLower(RandomString(7)), RandomString(10))
+}
+
+// Creates a read only role with the credentials given, this is used to bring existing roles along when a database moves.
+func createMysqlReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string, username string, password string) (DatabaseUrlSpec, error) {
 	if dbInstance.Engine != "mysql" {
 		return DatabaseUrlSpec{}, errors.New("I do not know how to do this on anything other than mysql.")
 	}
 
-	username := "rdo1" + strings.ToLower(RandomString(7))
-	password := RandomString(10)
-
 	db, err := sql.Open("mysql", databaseUri)
 
 	if err != nil {
@@ -303,10 +736,10 @@ func CreateMysqlReadOnlyRole(dbInstance *DbInstance, databaseUri string) (Databa
 	}
 	defer db.Close()
 
-	if _, err = db.Exec("create user '" + username + "'@'%' identified by '" + password + "'"); err != nil {
+	if _, err = db.ExecContext(ctx, "create user '" + username + "'@'%' identified by '" + password + "'"); err != nil {
 		return DatabaseUrlSpec{}, errors.New("Failed to reduce connection limit when deprovisioning: " + dbInstance.Name + " error: " + err.Error())
 	}
-	if _, err = db.Exec("grant select on " + dbInstance.Name + ".* to '" + username + "'"); err != nil {
+	if _, err = db.ExecContext(ctx, "grant select on " + dbInstance.Name + ".* to '" + username + "'"); err != nil {
 		return DatabaseUrlSpec{}, errors.New("Failed to reduce connection limit when deprovisioning: " + dbInstance.Name + " error: " + err.Error())
 	}
 	return DatabaseUrlSpec{
@@ -317,17 +750,17 @@ func CreateMysqlReadOnlyRole(dbInstance *DbInstance, databaseUri string) (Databa
 	}, nil
 }
 
-func RotateMysqlReadOnlyRole(dbInstance *DbInstance, databaseUri string, role string) (DatabaseUrlSpec, error) {
+func RotateMysqlReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string, role string) (DatabaseUrlSpec, error) {
 	db, err := sql.Open("mysql", databaseUri)
 	if err != nil {
 		return DatabaseUrlSpec{}, err
 	}
 	defer db.Close()
 	password := RandomString(10)
-	if _, err = db.Exec("UPDATE mysql.user SET authentication_string = PASSWORD('" + password + "') WHERE User = '" + role + "'"); err != nil {
+	if _, err = db.ExecContext(ctx, "UPDATE mysql.user SET authentication_string = PASSWORD('" + password + "') WHERE User = '" + role + "'"); err != nil {
 		return DatabaseUrlSpec{}, err
 	}
-	if _, err = db.Exec("flush privileges"); err != nil {
+	if _, err = db.ExecContext(ctx, "flush privileges"); err != nil {
 		return DatabaseUrlSpec{}, err
 	}
 	return DatabaseUrlSpec{
@@ -337,17 +770,17 @@ func RotateMysqlReadOnlyRole(dbInstance *DbInstance, databaseUri string, role st
 	}, nil
 }
 
-func DeleteMysqlReadOnlyRole(dbInstance *DbInstance, databaseUri string, role string) error {
+func DeleteMysqlReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string, role string) error {
 	db, err := sql.Open("mysql", databaseUri)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	if _, err = db.Exec("REVOKE all privileges, grant option from " + role); err != nil {
+	if _, err = db.ExecContext(ctx, "REVOKE all privileges, grant option from " + role); err != nil {
 		return errors.New("Failed to revoke access from master user to shared tenant user: " + dbInstance.Name + " error: " + err.Error())
 	}
-	if _, err = db.Exec("DROP USER " + role); err != nil {
+	if _, err = db.ExecContext(ctx, "DROP USER " + role); err != nil {
 		return errors.New("Failed to remove user: " + dbInstance.Name + " error: " + err.Error())
 	}
 
@@ -1,20 +1,28 @@
 package broker
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"github.com/golang/glog"
 	_ "github.com/lib/pq"
+	"io"
 	"net/url"
+	"os"
+	"os/exec"
 	"strings"
 )
 
 // provider=shared-postgres in database
 // These values come out of the plans table provider_private_details column.
 type PostgresSharedProviderPrivatePlanSettings struct {
-	MasterUri     string `json:"master_uri"`
-	Engine        string `json:"engine"`
-	EngineVersion string `json:"engine_version"`
+	MasterUri      string       `json:"master_uri"`
+	Engine         string       `json:"engine"`
+	EngineVersion  string       `json:"engine_version"`
+	Hosts          []SharedHost `json:"hosts"`           /* Databases are placed on one of these, if set */
+	Placement      string       `json:"placement"`       /* Can be 'tenants', 'size', 'free_disk' */
+	BackupLocation string       `json:"backup_location"` /* Where dumps are kept, a file:// or s3:// url */
 }
 
 func (psppps PostgresSharedProviderPrivatePlanSettings) MasterHost() string {
@@ -45,15 +53,66 @@ type PostgresSharedProvider struct {
 	namePrefix string
 }
 
+func init() {
+	RegisterProvider(PostgresShared, func(namePrefix string) (Provider, error) {
+		provider, err := NewPostgresSharedProvider(namePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}, PostgresSharedProviderPrivatePlanSettings{})
+}
+
 func NewPostgresSharedProvider(namePrefix string) (PostgresSharedProvider, error) {
 	return PostgresSharedProvider{
 		namePrefix: namePrefix,
 	}, nil
 }
 
-func (provider PostgresSharedProvider) GetInstance(name string, plan *ProviderPlan) (*DbInstance, error) {
+// The settings for an existing database, the master uri is swapped for the host the database was placed on.
+func (provider PostgresSharedProvider) tenantSettings(plan *ProviderPlan, name string) (PostgresSharedProviderPrivatePlanSettings, error) {
 	var settings PostgresSharedProviderPrivatePlanSettings
 	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return settings, err
+	}
+	uri, err := sharedTenantUri(name, settings.MasterUri, settings.Hosts)
+	if err != nil {
+		return settings, err
+	}
+	settings.MasterUri = uri
+	return settings, nil
+}
+
+// The bytes used by every database on the host the master uri points to.
+func postgresHostSize(ctx context.Context, masterUri string) (int64, error) {
+	db, err := sql.Open("postgres", masterUri)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var size int64
+	err = db.QueryRowContext(ctx, "select coalesce(sum(pg_database_size(datname)), 0)::bigint from pg_database where has_database_privilege(datname, 'CONNECT')").Scan(&size)
+	return size, err
+}
+
+func (provider PostgresSharedProvider) Close() error {
+	return nil
+}
+
+func (provider PostgresSharedProvider) Capabilities(plan *ProviderPlan) ProviderCapabilities {
+	var settings PostgresSharedProviderPrivatePlanSettings
+	if plan == nil {
+		return ProviderCapabilities{RolesCapability}
+	}
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil || settings.BackupLocation == "" {
+		return ProviderCapabilities{RolesCapability}
+	}
+	return ProviderCapabilities{RolesCapability, BackupsCapability, RestoreCapability}
+}
+
+func (provider PostgresSharedProvider) GetInstance(ctx context.Context, name string, plan *ProviderPlan) (*DbInstance, error) {
+	settings, err := provider.tenantSettings(plan, name)
+	if err != nil {
 		return nil, err
 	}
 
@@ -73,59 +132,82 @@ func (provider PostgresSharedProvider) GetInstance(name string, plan *ProviderPl
 	}, nil
 }
 
-func (provider PostgresSharedProvider) PerformPostProvision(db *DbInstance) (*DbInstance, error) {
+func (provider PostgresSharedProvider) PerformPostProvision(ctx context.Context, db *DbInstance) (*DbInstance, error) {
 	return db, nil
 }
 
-func (provider PostgresSharedProvider) Provision(Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
-	var settings PostgresSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
-		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
-	}
-
-	db_name := strings.ToLower(provider.namePrefix + RandomString(8))
-	username := strings.ToLower("u" + RandomString(8))
-	password := RandomString(16)
+// Creates the owner and database on the host the settings master uri points to.
+func (provider PostgresSharedProvider) createTenant(ctx context.Context, settings PostgresSharedProviderPrivatePlanSettings, db_name string, username string, password string) error {
 	db, err := sql.Open("postgres", settings.MasterUri)
 	if err != nil {
-		return nil, errors.New("Cannot provision shared database (connection failure): " + err.Error())
+		return errors.New("Cannot provision shared database (connection failure): " + err.Error())
 	}
 	defer db.Close()
 
-	if _, err = db.Exec("CREATE USER " + username + " WITH PASSWORD '" + password + "' NOINHERIT"); err != nil {
-		return nil, errors.New("Failed to create user with password: " + err.Error())
+	if _, err = db.ExecContext(ctx, "CREATE USER " + username + " WITH PASSWORD '" + password + "' NOINHERIT"); err != nil {
+		return errors.New("Failed to create user with password: " + err.Error())
 	}
-	if _, err = db.Exec("GRANT " + username + " TO CURRENT_USER"); err != nil {
-		return nil, errors.New("Failed to grant access to master user on shared tenant " + err.Error())
+	if _, err = db.ExecContext(ctx, "GRANT " + username + " TO CURRENT_USER"); err != nil {
+		return errors.New("Failed to grant access to master user on shared tenant " + err.Error())
 	}
-	if _, err = db.Exec("CREATE DATABASE " + db_name + " OWNER " + username); err != nil {
-		return nil, errors.New("Failed to create database with owner on shared tenant " + err.Error())
+	if _, err = db.ExecContext(ctx, "CREATE DATABASE " + db_name + " OWNER " + username); err != nil {
+		return errors.New("Failed to create database with owner on shared tenant " + err.Error())
 	}
 
-	// add postgres extensions
-	udb, err := sql.Open("postgres", settings.GetMasterUriWithDb(db_name))
+	return createPostgresExtensions(ctx, settings.GetMasterUriWithDb(db_name))
+}
+
+// Adds the extensions every shared database comes with.
+func createPostgresExtensions(ctx context.Context, databaseUri string) error {
+	udb, err := sql.Open("postgres", databaseUri)
 	if err != nil {
-		return nil, errors.New("Cannot connect to new provisioned db: " + err.Error())
+		return errors.New("Cannot connect to new provisioned db: " + err.Error())
 	}
 	defer udb.Close()
 
-	if _, err = udb.Exec("CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA public"); err != nil {
-		return nil, errors.New("Cannot create extension postgres_fdw on new db: " + err.Error())
+	if _, err = udb.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA public"); err != nil {
+		return errors.New("Cannot create extension postgres_fdw on new db: " + err.Error())
 	}
-	if _, err = udb.Exec("CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public"); err != nil {
-		return nil, errors.New("Cannot create extension pgcrypto on new db: " + err.Error())
+	if _, err = udb.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public"); err != nil {
+		return errors.New("Cannot create extension pgcrypto on new db: " + err.Error())
 	}
-	if _, err = udb.Exec("CREATE EXTENSION IF NOT EXISTS tablefunc WITH SCHEMA public"); err != nil {
-		return nil, errors.New("Cannot create extension tablefunc on new db: " + err.Error())
+	if _, err = udb.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS tablefunc WITH SCHEMA public"); err != nil {
+		return errors.New("Cannot create extension tablefunc on new db: " + err.Error())
 	}
-	if _, err = udb.Exec("CREATE EXTENSION IF NOT EXISTS hstore WITH SCHEMA public"); err != nil {
-		return nil, errors.New("Cannot create extension hstore on new db: " + err.Error())
+	if _, err = udb.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS hstore WITH SCHEMA public"); err != nil {
+		return errors.New("Cannot create extension hstore on new db: " + err.Error())
 	}
-	if _, err = udb.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\" WITH SCHEMA public"); err != nil {
-		return nil, errors.New("Cannot create extension uuid-ossp on new db: " + err.Error())
+	if _, err = udb.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\" WITH SCHEMA public"); err != nil {
+		return errors.New("Cannot create extension uuid-ossp on new db: " + err.Error())
+	}
+	if _, err = udb.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS \"citext\" WITH SCHEMA public"); err != nil {
+		return errors.New("Cannot create extension citext on new db: " + err.Error())
+	}
+	return nil
+}
+
+func (provider PostgresSharedProvider) Provision(ctx context.Context, Id string, plan *ProviderPlan, Owner string) (*DbInstance, error) {
+	var settings PostgresSharedProviderPrivatePlanSettings
+	if err := json.Unmarshal([]byte(plan.providerPrivateDetails), &settings); err != nil {
+		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
 	}
-	if _, err = udb.Exec("CREATE EXTENSION IF NOT EXISTS \"citext\" WITH SCHEMA public"); err != nil {
-		return nil, errors.New("Cannot create extension citext on new db: " + err.Error())
+	uri, err := placeSharedTenant(settings.MasterUri, settings.Hosts, settings.Placement, func(uri string) (int64, error) {
+		return postgresHostSize(ctx, uri)
+	})
+	if err != nil {
+		return nil, errors.New("Cannot find a host for the shared database: " + err.Error())
+	}
+	settings.MasterUri = uri
+
+	db_name := strings.ToLower(provider.namePrefix + RandomString(8))
+	username := strings.ToLower("u" + RandomString(8))
+	password := RandomString(16)
+	if err = provider.createTenant(ctx, settings, db_name, username, password); err != nil {
+		return nil, err
+	}
+	if err = recordSharedTenant(db_name, settings.MasterUri); err != nil {
+		glog.Errorf("ERROR: Orphaned Database! The host of %s could not be recorded: %s\n", db_name, err.Error())
+		return nil, errors.New("Failed to record the host of the shared database: " + err.Error())
 	}
 	return &DbInstance{
 		Id:            Id,
@@ -143,13 +225,32 @@ func (provider PostgresSharedProvider) Provision(Id string, plan *ProviderPlan,
 	}, nil
 }
 
-// TODO: take snapshot somehow.
-func (provider PostgresSharedProvider) Deprovision(dbInstance *DbInstance, takeSnapshot bool) error {
-	var settings PostgresSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+// A final backup is taken first when asked for and the plan has a backup location.
+func (provider PostgresSharedProvider) Deprovision(ctx context.Context, dbInstance *DbInstance, takeSnapshot bool) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if takeSnapshot && settings.BackupLocation != "" {
+		backup, err := addLogicalBackup(dbInstance, settings.BackupLocation)
+		if err != nil {
+			return errors.New("Failed to record the final backup of: " + dbInstance.Name + " error: " + err.Error())
+		}
+		if err = provider.PerformBackup(ctx, dbInstance, backup.Id); err != nil {
+			return errors.New("Failed to take the final backup of: " + dbInstance.Name + " error: " + err.Error())
+		}
+	}
+	if err = provider.dropTenant(ctx, settings, dbInstance); err != nil {
 		return err
 	}
+	if err = forgetSharedTenant(dbInstance.Name); err != nil {
+		return errors.New("Failed to remove the host record of: " + dbInstance.Name + " error: "+ err.Error())
+	}
+	return nil
+}
 
+// Removes the database, its owner and read only users from the host the settings master uri points to.
+func (provider PostgresSharedProvider) dropTenant(ctx context.Context, settings PostgresSharedProviderPrivatePlanSettings, dbInstance *DbInstance) error {
 	db, err := sql.Open("postgres", settings.MasterUri)
 	if err != nil {
 		return errors.New("Cannot deprovision shared database (connection failure): " + err.Error())
@@ -157,143 +258,434 @@ func (provider PostgresSharedProvider) Deprovision(dbInstance *DbInstance, takeS
 	defer db.Close()
 
 
-	// Get a list of all read only users
-	rows, err := db.Query(ApplyParamsToStatement(`
-		select 
-			groups.rolname as "group", 
-			members.rolname as "member" 
-		from pg_auth_members 
-			join pg_roles groups on pg_auth_members.roleid = groups.oid 
-			join pg_roles members on pg_auth_members.member = members.oid
-		where groups.rolname = '$1'
-	`, dbInstance.Name + "_readonly_users"))
+	roles, err := postgresReadOnlyRoles(ctx, db, dbInstance.Name)
 	if err != nil {
-		return errors.New("Failed to query read only users in role: " + err.Error())
+		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var group, role string
-		if err := rows.Scan(&group, &role); err != nil {
-			return errors.New("Failed to scan read only users in role: " + err.Error())
-		}
-		if err = DeletePostgresReadOnlyRole(dbInstance, settings.GetMasterUriWithDb(dbInstance.Name), role); err != nil {
+	for _, role := range roles {
+		if err = DeletePostgresReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDb(dbInstance.Name), role); err != nil {
 			return errors.New("Failed to remove read only user while deprovisioning database: " + dbInstance.Name + " error: " + err.Error())
 		}
 	}
-	if err := rows.Err(); err != nil {
-		return errors.New("Failed to deprovision database while trying to fetch read only user results: " + dbInstance.Name + " error: "+ err.Error())
-	}
-	if _, err = db.Exec("ALTER DATABASE " + dbInstance.Name + " OWNER TO CURRENT_USER"); err != nil {
+	if _, err = db.ExecContext(ctx, "ALTER DATABASE " + dbInstance.Name + " OWNER TO CURRENT_USER"); err != nil {
 		return errors.New("Failed to set owner to master account for: " + dbInstance.Name + " error: "+ err.Error())
 	}
-	if _, err = db.Exec("ALTER DATABASE " + dbInstance.Name + " CONNECTION LIMIT 0"); err != nil {
+	if _, err = db.ExecContext(ctx, "ALTER DATABASE " + dbInstance.Name + " CONNECTION LIMIT 0"); err != nil {
 		return errors.New("Failed to reduce connection limit when deprovisioning: " + dbInstance.Name + " error: "+ err.Error())
 	}
-	if _, err = db.Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = '" + dbInstance.Name + "' AND pid <> pg_backend_pid()"); err != nil {
+	if _, err = db.ExecContext(ctx, "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = '" + dbInstance.Name + "' AND pid <> pg_backend_pid()"); err != nil {
 		return errors.New("Failed to terminate backends when deprovisioning: " + dbInstance.Name + " error: "+ err.Error())
 	}
-	if _, err = db.Exec("REVOKE " + dbInstance.Username + " FROM CURRENT_USER"); err != nil {
+	if _, err = db.ExecContext(ctx, "REVOKE " + dbInstance.Username + " FROM CURRENT_USER"); err != nil {
 		return errors.New("Failed to revoke access from master user to shared tenant user: " + dbInstance.Name + " error: "+ err.Error())
 	}
-	if _, err = db.Exec("DROP DATABASE " + dbInstance.Name); err != nil {
+	if _, err = db.ExecContext(ctx, "DROP DATABASE " + dbInstance.Name); err != nil {
 		return errors.New("Failed to drop database shared tenant: " + dbInstance.Name + " error: "+ err.Error())
 	}
-	if _, err = db.Exec("DROP USER " + dbInstance.Username); err != nil {
+	if _, err = db.ExecContext(ctx, "DROP USER " + dbInstance.Username); err != nil {
 		return errors.New("Failed to remove user: " + dbInstance.Name + " error: "+ err.Error())
 	}
 	return nil
 }
 
-func (provider PostgresSharedProvider) Modify(dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
+// The read only users of a database, these are the members of its read only group.
+func postgresReadOnlyRoles(ctx context.Context, db *sql.DB, name string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, ApplyParamsToStatement(`
+		select 
+			groups.rolname as "group", 
+			members.rolname as "member" 
+		from pg_auth_members 
+			join pg_roles groups on pg_auth_members.roleid = groups.oid 
+			join pg_roles members on pg_auth_members.member = members.oid
+		where groups.rolname = '$1'
+	`, name + "_readonly_users"))
+	if err != nil {
+		return nil, errors.New("Failed to query read only users in role: " + err.Error())
+	}
+	defer rows.Close()
+	roles := make([]string, 0)
+	for rows.Next() {
+		var group, role string
+		if err := rows.Scan(&group, &role); err != nil {
+			return nil, errors.New("Failed to scan read only users in role: " + err.Error())
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("Failed to fetch read only user results: " + name + " error: "+ err.Error())
+	}
+	return roles, nil
+}
+
+// Plan changes are done by MoveTenant, as it needs the read only roles from storage.
+func (provider PostgresSharedProvider) Modify(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan) (*DbInstance, error) {
 	return nil,
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider PostgresSharedProvider) Tag(dbInstance *DbInstance, Name string, Value string) error {
+// Moves a database to the host it belongs on under the new plan, if it isn't already there. The data is
+// copied with migratePostgres while logins to the database are disabled.
+func (provider PostgresSharedProvider) MoveTenant(ctx context.Context, dbInstance *DbInstance, plan *ProviderPlan, roles []DatabaseUrlSpec, progress func(string)) (*DbInstance, error) {
+	from, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	var to PostgresSharedProviderPrivatePlanSettings
+	if err = json.Unmarshal([]byte(plan.providerPrivateDetails), &to); err != nil {
+		return nil, errors.New("Cannot unmarshal private details: " + err.Error())
+	}
+	if to.MasterUri, err = moveSharedTenantTo(from.MasterUri, to.MasterUri, to.Hosts, to.Placement, func(uri string) (int64, error) {
+		return postgresHostSize(ctx, uri)
+	}); err != nil {
+		return nil, errors.New("Cannot find a host for the shared database: " + err.Error())
+	}
+
+	if to.MasterHost() != from.MasterHost() {
+		setLogin := func(login string) error {
+			db, err := sql.Open("postgres", from.MasterUri)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			if _, err = db.ExecContext(ctx, "ALTER ROLE " + dbInstance.Username + " " + login); err != nil {
+				return err
+			}
+			for _, role := range roles {
+				if _, err = db.ExecContext(ctx, "ALTER ROLE " + role.Username + " " + login); err != nil {
+					return err
+				}
+			}
+			if login == "NOLOGIN" {
+				if _, err = db.ExecContext(ctx, "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = '" + dbInstance.Name + "' AND pid <> pg_backend_pid()"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		move := sharedTenantMove{
+			name: dbInstance.Name,
+			from: from.MasterHost(),
+			to:   to.MasterUri,
+			create: func() error {
+				return provider.createTenant(ctx, to, dbInstance.Name, dbInstance.Username, dbInstance.Password)
+			},
+			pause: func() error {
+				return setLogin("NOLOGIN")
+			},
+			resume: func() error {
+				return setLogin("LOGIN")
+			},
+			copy: func() error {
+				// loaded by the owner on the new host so everything copied belongs to them.
+				toUri, err := url.Parse(to.GetMasterUriWithDb(dbInstance.Name))
+				if err != nil {
+					return err
+				}
+				toUri.User = url.UserPassword(dbInstance.Username, dbInstance.Password)
+				_, err = migratePostgres(ctx, from.GetMasterUriWithDb(dbInstance.Name), toUri.String(), true, progress)
+				return err
+			},
+			roles: func() error {
+				moved := *dbInstance
+				moved.Endpoint = to.MasterHost() + "/" + dbInstance.Name
+				moved.Plan = plan
+				for _, role := range roles {
+					if _, err := createPostgresReadOnlyRole(ctx, &moved, to.GetMasterUriWithDb(dbInstance.Name), role.Username, role.Password); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			remove: func() error {
+				return provider.dropTenant(ctx, to, dbInstance)
+			},
+			drop: func() error {
+				return provider.dropTenant(ctx, from, dbInstance)
+			},
+		}
+		if err = move.run(progress); err != nil {
+			return nil, err
+		}
+	} else if err = recordSharedTenant(dbInstance.Name, to.MasterUri); err != nil {
+		return nil, errors.New("Failed to record the host of the shared database: " + err.Error())
+	}
+
+	moved, err := provider.GetInstance(ctx, dbInstance.Name, plan)
+	if err != nil {
+		return nil, err
+	}
+	moved.Id = dbInstance.Id
+	moved.Username = dbInstance.Username
+	moved.Password = dbInstance.Password
+	return moved, nil
+}
+
+func (provider PostgresSharedProvider) Tag(ctx context.Context, dbInstance *DbInstance, Name string, Value string) error {
 	// do nothing
 	return nil
 }
 
-func (provider PostgresSharedProvider) Untag(dbInstance *DbInstance, Name string) error {
+func (provider PostgresSharedProvider) Untag(ctx context.Context, dbInstance *DbInstance, Name string) error {
 	// do nothing
 	return nil
 }
 
-func (provider PostgresSharedProvider) GetBackup(dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
-	return DatabaseBackupSpec{},
-		errors.New("This feature is not available on this plan.")
+func (provider PostgresSharedProvider) GetBackup(ctx context.Context, dbInstance *DbInstance, Id string) (DatabaseBackupSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	if settings.BackupLocation == "" {
+		return DatabaseBackupSpec{}, ErrFeatureNotAvailable
+	}
+	backup, err := getLogicalBackup(dbInstance, Id)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return backup.Spec(), nil
 }
 
-func (provider PostgresSharedProvider) CreateReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
+func (provider PostgresSharedProvider) CreateReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
 	return nil,
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider PostgresSharedProvider) GetReadReplica(dbInstance *DbInstance) (*DbInstance, error) {
+func (provider PostgresSharedProvider) GetReadReplica(ctx context.Context, dbInstance *DbInstance) (*DbInstance, error) {
 	return nil,
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider PostgresSharedProvider) DeleteReadReplica(dbInstance *DbInstance) error {
-	return errors.New("This feature is not available on this plan.")
+func (provider PostgresSharedProvider) DeleteReadReplica(ctx context.Context, dbInstance *DbInstance) error {
+	return ErrFeatureNotAvailable
 }
 
-func (provider PostgresSharedProvider) ListBackups(dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
-	return []DatabaseBackupSpec{},
-		errors.New("This feature is not available on this plan.")
+func (provider PostgresSharedProvider) ListBackups(ctx context.Context, dbInstance *DbInstance) ([]DatabaseBackupSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return []DatabaseBackupSpec{}, err
+	}
+	if settings.BackupLocation == "" {
+		return []DatabaseBackupSpec{}, ErrFeatureNotAvailable
+	}
+	return listLogicalBackups(dbInstance)
 }
 
-func (provider PostgresSharedProvider) CreateBackup(dbInstance *DbInstance) (DatabaseBackupSpec, error) {
-	return DatabaseBackupSpec{},
-		errors.New("This feature is not available on this plan.")
+// Only records the backup, the dump is taken by PerformBackup in the worker.
+func (provider PostgresSharedProvider) CreateBackup(ctx context.Context, dbInstance *DbInstance) (DatabaseBackupSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	if settings.BackupLocation == "" {
+		return DatabaseBackupSpec{}, ErrFeatureNotAvailable
+	}
+	backup, err := addLogicalBackup(dbInstance, settings.BackupLocation)
+	if err != nil {
+		return DatabaseBackupSpec{}, err
+	}
+	return backup.Spec(), nil
 }
 
-func (provider PostgresSharedProvider) RestoreBackup(dbInstance *DbInstance, Id string) error {
-	return errors.New("This feature is not available on this plan.")
+// Dumps the database in pg_dump's custom format into the backup store.
+func (provider PostgresSharedProvider) PerformBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	return takeLogicalBackup(ctx, dbInstance, Id, func(out io.Writer, progress func(int64)) error {
+		uri, password := withoutPassword(settings.GetMasterUriWithDb(dbInstance.Name))
+		dump := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--no-owner", "--no-privileges", "--no-comments", "--dbname=" + uri)
+		dump.Env = append(os.Environ(), "PGPASSWORD=" + password)
+		return runCommand(dump, nil, out)
+	})
 }
 
-func (provider PostgresSharedProvider) Restart(dbInstance *DbInstance) error {
-	return errors.New("This feature is not available on this plan.")
+// Restores into a new database which then replaces the existing one, so a failed restore leaves the
+// database as it was. Read only users are given access to the restored database.
+func (provider PostgresSharedProvider) RestoreBackup(ctx context.Context, dbInstance *DbInstance, Id string) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return err
+	}
+	if settings.BackupLocation == "" {
+		return ErrFeatureNotAvailable
+	}
+	dump, err := openLogicalBackup(ctx, dbInstance, Id)
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+
+	db, err := sql.Open("postgres", settings.MasterUri)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	restoreName := dbInstance.Name + "_restore"
+	replacedName := dbInstance.Name + "_replaced"
+	if _, err = db.ExecContext(ctx, "DROP DATABASE IF EXISTS " + restoreName); err != nil {
+		return errors.New("Failed to remove an earlier restore of: " + dbInstance.Name + " error: " + err.Error())
+	}
+	if _, err = db.ExecContext(ctx, "CREATE DATABASE " + restoreName + " OWNER " + dbInstance.Username); err != nil {
+		return errors.New("Failed to create database to restore into: " + err.Error())
+	}
+	cleanup := func(err error) error {
+		if _, derr := db.ExecContext(ctx, "DROP DATABASE IF EXISTS " + restoreName); derr != nil {
+			glog.Errorf("ERROR: Orphaned Database! Unable to remove %s after a failed restore: %s\n", restoreName, derr.Error())
+		}
+		return err
+	}
+	if err = createPostgresExtensions(ctx, settings.GetMasterUriWithDb(restoreName)); err != nil {
+		return cleanup(err)
+	}
+	uri, password := withoutPassword(settings.GetMasterUriWithDb(restoreName))
+	restore := exec.CommandContext(ctx, "pg_restore", "--no-owner", "--no-privileges", "--role=" + dbInstance.Username, "--exit-on-error", "--single-transaction", "--dbname=" + uri)
+	restore.Env = append(os.Environ(), "PGPASSWORD=" + password)
+	if err = runCommand(restore, dump, nil); err != nil {
+		return cleanup(err)
+	}
+
+	roles, err := postgresReadOnlyRoles(ctx, db, dbInstance.Name)
+	if err != nil {
+		return cleanup(err)
+	}
+	if _, err = db.ExecContext(ctx, "ALTER DATABASE " + dbInstance.Name + " CONNECTION LIMIT 0"); err != nil {
+		return cleanup(errors.New("Failed to reduce connection limit when restoring: " + err.Error()))
+	}
+	if _, err = db.ExecContext(ctx, "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = '" + dbInstance.Name + "' AND pid <> pg_backend_pid()"); err != nil {
+		db.ExecContext(ctx, "ALTER DATABASE " + dbInstance.Name + " CONNECTION LIMIT -1")
+		return cleanup(errors.New("Failed to terminate backends when restoring: " + err.Error()))
+	}
+	if _, err = db.ExecContext(ctx, "ALTER DATABASE " + dbInstance.Name + " RENAME TO " + replacedName); err != nil {
+		db.ExecContext(ctx, "ALTER DATABASE " + dbInstance.Name + " CONNECTION LIMIT -1")
+		return cleanup(errors.New("Failed to move the database aside when restoring: " + err.Error()))
+	}
+	if _, err = db.ExecContext(ctx, "ALTER DATABASE " + restoreName + " RENAME TO " + dbInstance.Name); err != nil {
+		db.ExecContext(ctx, "ALTER DATABASE " + replacedName + " RENAME TO " + dbInstance.Name)
+		db.ExecContext(ctx, "ALTER DATABASE " + dbInstance.Name + " CONNECTION LIMIT -1")
+		return cleanup(errors.New("Failed to replace the database with the restore: " + err.Error()))
+	}
+	for _, role := range roles {
+		if err = grantPostgresReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDb(dbInstance.Name), role); err != nil {
+			glog.Errorf("ERROR: Unable to give read only user %s access to the restored database %s: %s\n", role, dbInstance.Name, err.Error())
+		}
+	}
+	if _, err = db.ExecContext(ctx, "DROP DATABASE " + replacedName); err != nil {
+		glog.Errorf("ERROR: Orphaned Database! Unable to remove %s after restoring %s: %s\n", replacedName, dbInstance.Name, err.Error())
+	}
+	return nil
 }
 
-func (provider PostgresSharedProvider) ListLogs(dbInstance *DbInstance) ([]DatabaseLogs, error) {
+// Forks are new databases on a host of the plan, the worker copies the database (or its backup) into the
+// fork with pg_restore so anything in it belongs to the fork's owner.
+func (provider PostgresSharedProvider) Fork(ctx context.Context, Id string, plan *ProviderPlan, Owner string, from *DbInstance, backup string) (*DbInstance, error) {
+	if backup != "" {
+		if _, err := forkLogicalBackup(from, backup); err != nil {
+			return nil, err
+		}
+	}
+	dbInstance, err := provider.Provision(ctx, Id, plan, Owner)
+	if err != nil {
+		return nil, err
+	}
+	// the fork is empty until the worker has copied into it.
+	dbInstance.Status = "creating"
+	dbInstance.Ready = false
+	return dbInstance, nil
+}
+
+// The restore is a single transaction, so a fork that fails to copy is left empty and can be tried again.
+func (provider PostgresSharedProvider) PerformFork(ctx context.Context, dbInstance *DbInstance, from *DbInstance, backup string, Owner string) (*DbInstance, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
+		return nil, err
+	}
+	uri, password := withoutPassword(settings.GetMasterUriWithDb(dbInstance.Name))
+	restore := exec.CommandContext(ctx, "pg_restore", "--no-owner", "--no-privileges", "--role=" + dbInstance.Username, "--exit-on-error", "--single-transaction", "--dbname=" + uri)
+	restore.Env = append(os.Environ(), "PGPASSWORD=" + password)
+	if backup == "" {
+		fromSettings, err := provider.tenantSettings(from.Plan, from.Name)
+		if err != nil {
+			return nil, err
+		}
+		fromUri, fromPassword := withoutPassword(fromSettings.GetMasterUriWithDb(from.Name))
+		dump := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--no-owner", "--no-privileges", "--no-comments", "--dbname=" + fromUri)
+		dump.Env = append(os.Environ(), "PGPASSWORD=" + fromPassword)
+		if err = pipeCommands(ctx, dump, restore); err != nil {
+			return nil, err
+		}
+	} else {
+		Id, err := forkLogicalBackup(from, backup)
+		if err != nil {
+			return nil, err
+		}
+		dump, err := openLogicalBackup(ctx, from, Id)
+		if err != nil {
+			return nil, err
+		}
+		defer dump.Close()
+		if err = runCommand(restore, dump, nil); err != nil {
+			return nil, err
+		}
+	}
+	forked, err := provider.GetInstance(ctx, dbInstance.Name, dbInstance.Plan)
+	if err != nil {
+		return nil, err
+	}
+	forked.Id = dbInstance.Id
+	forked.Username = dbInstance.Username
+	forked.Password = dbInstance.Password
+	return forked, nil
+}
+
+func (provider PostgresSharedProvider) Restart(ctx context.Context, dbInstance *DbInstance) error {
+	return ErrFeatureNotAvailable
+}
+
+func (provider PostgresSharedProvider) ListLogs(ctx context.Context, dbInstance *DbInstance) ([]DatabaseLogs, error) {
 	return []DatabaseLogs{},
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider PostgresSharedProvider) GetLogs(dbInstance *DbInstance, path string) (string, error) {
+func (provider PostgresSharedProvider) GetLogs(ctx context.Context, dbInstance *DbInstance, path string) (string, error) {
 	return "",
-		errors.New("This feature is not available on this plan.")
+		ErrFeatureNotAvailable
 }
 
-func (provider PostgresSharedProvider) CreateReadOnlyUser(dbInstance *DbInstance) (DatabaseUrlSpec, error) {
-	var settings PostgresSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+func (provider PostgresSharedProvider) CreateReadOnlyUser(ctx context.Context, dbInstance *DbInstance) (DatabaseUrlSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
 		return DatabaseUrlSpec{}, err
 	}
-	return CreatePostgresReadOnlyRole(dbInstance, settings.GetMasterUriWithDb(dbInstance.Name))
+	return CreatePostgresReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDb(dbInstance.Name))
 }
 
-func (provider PostgresSharedProvider) DeleteReadOnlyUser(dbInstance *DbInstance, role string) error {
-	var settings PostgresSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+func (provider PostgresSharedProvider) DeleteReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) error {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
 		return err
 	}
-	return DeletePostgresReadOnlyRole(dbInstance, settings.GetMasterUriWithDb(dbInstance.Name), role)
+	return DeletePostgresReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDb(dbInstance.Name), role)
 }
 
-func (provider PostgresSharedProvider) RotatePasswordReadOnlyUser(dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
-	var settings PostgresSharedProviderPrivatePlanSettings
-	if err := json.Unmarshal([]byte(dbInstance.Plan.providerPrivateDetails), &settings); err != nil {
+func (provider PostgresSharedProvider) RotatePasswordReadOnlyUser(ctx context.Context, dbInstance *DbInstance, role string) (DatabaseUrlSpec, error) {
+	settings, err := provider.tenantSettings(dbInstance.Plan, dbInstance.Name)
+	if err != nil {
 		return DatabaseUrlSpec{}, err
 	}
-	return RotatePostgresReadOnlyRole(dbInstance, settings.GetMasterUriWithDb(dbInstance.Name), role)
+	return RotatePostgresReadOnlyRole(ctx, dbInstance, settings.GetMasterUriWithDb(dbInstance.Name), role)
 }
 
 
 // Technically the create role functions are used by any provider that implements postgres but we'll place
 // them here, but be aware they're not specific to this provider.
-func CreatePostgresReadOnlyRole(dbInstance *DbInstance, databaseUri string) (DatabaseUrlSpec, error) {
+func CreatePostgresReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string) (DatabaseUrlSpec, error) {
+	return createPostgresReadOnlyRole(ctx, dbInstance, databaseUri, "rdo1" + strings.ToLower(RandomString(7)), RandomString(10))
+}
+
+// Creates a read only role with the credentials given, this is used to bring existing roles along when a database moves.
+func createPostgresReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string, username string, password string) (DatabaseUrlSpec, error) {
 	if dbInstance.Engine != "postgres" {
 		return DatabaseUrlSpec{}, errors.New("I do not know how to do this on anything other than postgres.")
 	}
@@ -311,17 +703,38 @@ func CreatePostgresReadOnlyRole(dbInstance *DbInstance, databaseUri string) (Dat
 		$$;
 	`
 	
-	if _, err := db.Exec(ApplyParamsToStatement(group_statement, readOnlyUserGroup)); err != nil {
+	if _, err := db.ExecContext(ctx, ApplyParamsToStatement(group_statement, readOnlyUserGroup)); err != nil {
+		return DatabaseUrlSpec{}, err
+	}
+
+	if err != nil {
+		return DatabaseUrlSpec{}, err
+	}
+	defer db.Close()
+
+	if _, err = db.ExecContext(ctx, "create user " + username + " with login encrypted password '" + password + "'"); err != nil {
 		return DatabaseUrlSpec{}, err
 	}
+	if err = grantPostgresReadOnlyRole(ctx, dbInstance, databaseUri, username); err != nil {
+		return DatabaseUrlSpec{}, err
+	}
+	return DatabaseUrlSpec{
+		Username: username,
+		Password: password,
+		Endpoint: dbInstance.Endpoint,
+		Plan:     dbInstance.Plan.ID,
+	}, nil
+}
 
+// Gives an existing read only user access to everything in the database, this is also used to give them
+// access again after the database is restored.
+func grantPostgresReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string, role string) error {
 	statement := `
 	do $do$
 	declare sch text;
 	begin
-	  create user $1 with login encrypted password '$2';
-	  grant connect on database $3 to $1;
-	  grant $5 to $1;
+	  grant connect on database $2 to $1;
+	  grant $4 to $1;
 	  
 	  for sch in select nspname from pg_namespace where nspname not like 'pg_toast%' and nspname not like 'pg_temp%' and nspname != 'information_schema' and nspname != 'pg_catalog'
 	  loop
@@ -329,42 +742,30 @@ func CreatePostgresReadOnlyRole(dbInstance *DbInstance, databaseUri string) (Dat
 		  execute format($$ revoke create on schema %I from $1 $$, sch);
 		  execute format($$ grant select on all tables in schema %I to $1 $$, sch);
 		  execute format($$ grant usage, select on all sequences in schema %I to $1 $$, sch);
-		  execute format($$ alter default privileges for user $4 in schema %I grant select on tables to $1 $$, sch);
-		  execute format($$ alter default privileges for user $4 in schema %I grant select on sequences to $1 $$, sch);
+		  execute format($$ alter default privileges for user $3 in schema %I grant select on tables to $1 $$, sch);
+		  execute format($$ alter default privileges for user $3 in schema %I grant select on sequences to $1 $$, sch);
 	  end loop;
 	end 
 	$do$;
 	`
-
-	app_username := dbInstance.Username
+	db, err := sql.Open("postgres", databaseUri)
 	if err != nil {
-		return DatabaseUrlSpec{}, err
+		return err
 	}
 	defer db.Close()
 
-	username := "rdo1" + strings.ToLower(RandomString(7))
-	password := RandomString(10)
-
-	_, err = db.Exec(ApplyParamsToStatement(statement, username, password, dbInstance.Name, app_username, readOnlyUserGroup))
-	if err != nil {
-		return DatabaseUrlSpec{}, err
-	}
-	return DatabaseUrlSpec{
-		Username: username,
-		Password: password,
-		Endpoint: dbInstance.Endpoint,
-		Plan:     dbInstance.Plan.ID,
-	}, nil
+	_, err = db.ExecContext(ctx, ApplyParamsToStatement(statement, role, dbInstance.Name, dbInstance.Username, dbInstance.Name + "_readonly_users"))
+	return err
 }
 
-func RotatePostgresReadOnlyRole(dbInstance *DbInstance, databaseUri string, role string) (DatabaseUrlSpec, error) {
+func RotatePostgresReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string, role string) (DatabaseUrlSpec, error) {
 	db, err := sql.Open("postgres", databaseUri)
 	if err != nil {
 		return DatabaseUrlSpec{}, err
 	}
 	defer db.Close()
 	password := RandomString(10)
-	if _, err = db.Exec("alter user " + role + " WITH PASSWORD '" + password + "'"); err != nil {
+	if _, err = db.ExecContext(ctx, "alter user " + role + " WITH PASSWORD '" + password + "'"); err != nil {
 		return DatabaseUrlSpec{}, err
 	}
 	return DatabaseUrlSpec{
@@ -374,7 +775,7 @@ func RotatePostgresReadOnlyRole(dbInstance *DbInstance, databaseUri string, role
 	}, nil
 }
 
-func DeletePostgresReadOnlyRole(dbInstance *DbInstance, databaseUri string, role string) error {
+func DeletePostgresReadOnlyRole(ctx context.Context, dbInstance *DbInstance, databaseUri string, role string) error {
 	statement := `
 	do $do$
 	declare sch text;
@@ -399,7 +800,7 @@ func DeletePostgresReadOnlyRole(dbInstance *DbInstance, databaseUri string, role
 	}
 	defer db.Close()
 
-	_, err = db.Exec(ApplyParamsToStatement(statement, role, dbInstance.Name,  dbInstance.Username))
+	_, err = db.ExecContext(ctx, ApplyParamsToStatement(statement, role, dbInstance.Name,  dbInstance.Username))
 	if err != nil {
 		return err
 	}
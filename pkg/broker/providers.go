@@ -1,36 +1,100 @@
 package broker
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"github.com/golang/glog"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"reflect"
+	"sync"
 )
 
 type Providers string
 
 const (
-	AWSInstance    Providers = "aws-instance"
-	AWSCluster     Providers = "aws-cluster"
-	GCloudInstance Providers = "gcloud-instance"
-	PostgresShared Providers = "postgres-shared"
-	MysqlShared    Providers = "mysql-shared"
-	Unknown        Providers = "unknown"
+	AWSInstance        Providers = "aws-instance"
+	AWSCluster         Providers = "aws-cluster"
+	GCloudInstance     Providers = "gcloud-instance"
+	PostgresShared     Providers = "postgres-shared"
+	MysqlShared        Providers = "mysql-shared"
+	MssqlShared        Providers = "mssql-shared"
+	CockroachShared    Providers = "cockroach-shared"
+	Memory             Providers = "memory"
+	KubernetesPostgres Providers = "kubernetes-postgres"
+	AzureFlexible      Providers = "azure-flexible"
+	Unknown            Providers = "unknown"
 )
 
+// A ProviderFactory creates a new provider, the name prefix is used by the provider to namespace
+// the databases it creates.
+type ProviderFactory func(namePrefix string) (Provider, error)
+
+// A ProviderRegistration describes a provider that plans can use by name, the schema is a
+// value of the type the plans provider_private_details json is unmarshalled into.
+type ProviderRegistration struct {
+	Name    Providers
+	Factory ProviderFactory
+	Schema  interface{}
+}
+
+var providerRegistry = struct {
+	sync.RWMutex
+	providers map[Providers]ProviderRegistration
+	instances map[string]Provider
+}{providers: make(map[Providers]ProviderRegistration), instances: make(map[string]Provider)}
+
+// RegisterProvider makes a provider available to plans, providers (including ones outside of this
+// package) should call this from an init function. Registering the same name twice panics.
+func RegisterProvider(name Providers, factory ProviderFactory, schema interface{}) {
+	providerRegistry.Lock()
+	defer providerRegistry.Unlock()
+	if name == "" || name == Unknown {
+		panic("broker: RegisterProvider provider name is invalid")
+	}
+	if factory == nil {
+		panic("broker: RegisterProvider factory is nil for " + string(name))
+	}
+	if _, dup := providerRegistry.providers[name]; dup {
+		panic("broker: RegisterProvider called twice for provider " + string(name))
+	}
+	providerRegistry.providers[name] = ProviderRegistration{Name: name, Factory: factory, Schema: schema}
+}
+
+func GetProviderRegistration(name Providers) (ProviderRegistration, bool) {
+	providerRegistry.RLock()
+	defer providerRegistry.RUnlock()
+	registration, ok := providerRegistry.providers[name]
+	return registration, ok
+}
+
 func GetProvidersFromString(str string) Providers {
-	if str == "aws-instance" {
-		return AWSInstance
-	} else if str == "aws-cluster" {
-		return AWSCluster
-	}  else if str == "gcloud-instance" {
-		return GCloudInstance
-	} else if str == "postgres-shared" {
-		return PostgresShared
-	} else if str == "mysql-shared" {
-		return MysqlShared
+	if _, ok := GetProviderRegistration(Providers(str)); ok {
+		return Providers(str)
 	}
 	return Unknown
 }
 
+// ValidateProviderPrivateDetails ensures the provider_private_details of a plan match the schema
+// the provider registered, unknown fields or the wrong types are rejected.
+func ValidateProviderPrivateDetails(name Providers, details string) error {
+	registration, ok := GetProviderRegistration(name)
+	if !ok {
+		return errors.New("Unable to find provider " + string(name) + ".")
+	}
+	if registration.Schema == nil {
+		return nil
+	}
+	settings := reflect.New(reflect.TypeOf(registration.Schema)).Interface()
+	decoder := json.NewDecoder(bytes.NewReader([]byte(details)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(settings); err != nil {
+		return errors.New("The provider private details for " + string(name) + " are invalid: " + err.Error())
+	}
+	return nil
+}
+
 type ProviderPlan struct {
 	basePlan               osb.Plan  `json:"-"` /* NEVER allow this to be serialized into a JSON call as it may accidently send sensitive info to callbacks */
 	Provider               Providers `json:"provider"`
@@ -39,41 +103,114 @@ type ProviderPlan struct {
 	Scheme                 string    `json:"scheme"`
 }
 
+// Capability is a single optional feature a provider may (or may not) support, such as backups or logs.
+type Capability string
+
+const (
+	BackupsCapability  Capability = "backups"
+	RestoreCapability  Capability = "restore"
+	RolesCapability    Capability = "roles"
+	LogsCapability     Capability = "logs"
+	RestartCapability  Capability = "restart"
+	ReplicasCapability Capability = "replicas"
+	TagsCapability     Capability = "tags"
+	VersionsCapability Capability = "versions"
+	// restoring to a point in time, rather than to a backup.
+	PointInTimeCapability Capability = "point-in-time"
+	// scaling the readers of a cluster behind its reader endpoint.
+	ReadersCapability Capability = "readers"
+	// keeping a copy of databases in another region to promote if their region is lost.
+	DisasterRecoveryCapability Capability = "disaster-recovery"
+	// binding with users that log in with short lived iam tokens rather than the password.
+	IAMAuthCapability Capability = "iam-auth"
+)
+
+// ProviderCapabilities is the set of optional features a provider supports for a plan,
+// anything not in this set will return ErrFeatureNotAvailable from the provider.
+type ProviderCapabilities []Capability
+
+func (capabilities ProviderCapabilities) Has(capability Capability) bool {
+	if capability == "" {
+		return true
+	}
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrFeatureNotAvailable = errors.New("This feature is not available on this plan.")
+
 type Provider interface {
-	GetInstance(string, *ProviderPlan) (*DbInstance, error)
-	Provision(string, *ProviderPlan, string) (*DbInstance, error)
-	Deprovision(*DbInstance, bool) error
-	Modify(*DbInstance, *ProviderPlan) (*DbInstance, error)
-	Tag(*DbInstance, string, string) error
-	Untag(*DbInstance, string) error
-	GetBackup(*DbInstance, string) (DatabaseBackupSpec, error)
-	ListBackups(*DbInstance) ([]DatabaseBackupSpec, error)
-	CreateBackup(*DbInstance) (DatabaseBackupSpec, error)
-	RestoreBackup(*DbInstance, string) error
-	Restart(*DbInstance) error
-	ListLogs(*DbInstance) ([]DatabaseLogs, error)
-	GetLogs(*DbInstance, string) (string, error)
-	CreateReadOnlyUser(*DbInstance) (DatabaseUrlSpec, error)
-	DeleteReadOnlyUser(*DbInstance, string) error
-	RotatePasswordReadOnlyUser(*DbInstance, string) (DatabaseUrlSpec, error)
-	CreateReadReplica(*DbInstance) (*DbInstance, error)
-	GetReadReplica(*DbInstance) (*DbInstance, error)
-	DeleteReadReplica(*DbInstance) error
-	PerformPostProvision(*DbInstance) (*DbInstance, error)
+	Close() error
+	Capabilities(*ProviderPlan) ProviderCapabilities
+	GetInstance(context.Context, string, *ProviderPlan) (*DbInstance, error)
+	Provision(context.Context, string, *ProviderPlan, string) (*DbInstance, error)
+	Deprovision(context.Context, *DbInstance, bool) error
+	Modify(context.Context, *DbInstance, *ProviderPlan) (*DbInstance, error)
+	Tag(context.Context, *DbInstance, string, string) error
+	Untag(context.Context, *DbInstance, string) error
+	GetBackup(context.Context, *DbInstance, string) (DatabaseBackupSpec, error)
+	ListBackups(context.Context, *DbInstance) ([]DatabaseBackupSpec, error)
+	CreateBackup(context.Context, *DbInstance) (DatabaseBackupSpec, error)
+	RestoreBackup(context.Context, *DbInstance, string) error
+	Restart(context.Context, *DbInstance) error
+	ListLogs(context.Context, *DbInstance) ([]DatabaseLogs, error)
+	GetLogs(context.Context, *DbInstance, string) (string, error)
+	CreateReadOnlyUser(context.Context, *DbInstance) (DatabaseUrlSpec, error)
+	DeleteReadOnlyUser(context.Context, *DbInstance, string) error
+	RotatePasswordReadOnlyUser(context.Context, *DbInstance, string) (DatabaseUrlSpec, error)
+	CreateReadReplica(context.Context, *DbInstance) (*DbInstance, error)
+	GetReadReplica(context.Context, *DbInstance) (*DbInstance, error)
+	DeleteReadReplica(context.Context, *DbInstance) error
+	PerformPostProvision(context.Context, *DbInstance) (*DbInstance, error)
 }
 
+// GetProviderByPlan returns the provider for the plan, providers are created once per provider and
+// name prefix then shared by every caller until CloseProviders is called.
 func GetProviderByPlan(namePrefix string, plan *ProviderPlan) (Provider, error) {
-	if plan.Provider == AWSInstance {
-		return NewAWSInstanceProvider(namePrefix)
-	} else if plan.Provider == AWSCluster {
-		return NewAWSClusteredProvider(namePrefix)
-	} else if plan.Provider == GCloudInstance {
-		return NewGCloudInstanceProvider(namePrefix)
-	} else if plan.Provider == MysqlShared {
-		return NewMysqlSharedProvider(namePrefix)
-	} else if plan.Provider == PostgresShared {
-		return NewPostgresSharedProvider(namePrefix)
-	} else {
+	key := string(plan.Provider) + "|" + namePrefix
+	providerRegistry.RLock()
+	registration, ok := providerRegistry.providers[plan.Provider]
+	provider, created := providerRegistry.instances[key]
+	providerRegistry.RUnlock()
+	if !ok {
 		return nil, errors.New("Unable to find provider for plan.")
 	}
+	if created {
+		return provider, nil
+	}
+	// providers can be slow to create, so they're created without holding the lock.
+	provider, err := registration.Factory(namePrefix)
+	if err != nil {
+		return nil, err
+	}
+	providerRegistry.Lock()
+	defer providerRegistry.Unlock()
+	if existing, ok := providerRegistry.instances[key]; ok {
+		// another caller created it first, theirs is kept.
+		if err := provider.Close(); err != nil {
+			glog.Errorf("Unable to close provider %s: %s\n", key, err.Error())
+		}
+		return existing, nil
+	}
+	providerRegistry.instances[key] = provider
+	return provider, nil
+}
+
+// CloseProviders closes every provider created by GetProviderByPlan, this should be called on shutdown.
+func CloseProviders() error {
+	providerRegistry.Lock()
+	defer providerRegistry.Unlock()
+	var lastErr error = nil
+	for key, provider := range providerRegistry.instances {
+		if err := provider.Close(); err != nil {
+			glog.Errorf("Unable to close provider %s: %s\n", key, err.Error())
+			lastErr = err
+		}
+		delete(providerRegistry.instances, key)
+	}
+	return lastErr
 }
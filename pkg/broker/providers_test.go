@@ -0,0 +1,116 @@
+package broker
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+)
+
+type testRegistryProvider struct {
+	Provider
+	namePrefix string
+	closed     *bool
+}
+
+func (provider testRegistryProvider) Close() error {
+	*provider.closed = true
+	return nil
+}
+
+type testRegistryProviderSettings struct {
+	Uri string `json:"uri"`
+}
+
+// Removes a provider registered by a test, so the test can register it again when run more than once.
+func unregisterProvider(name Providers) {
+	providerRegistry.Lock()
+	defer providerRegistry.Unlock()
+	delete(providerRegistry.providers, name)
+}
+
+func TestProviderRegistry(t *testing.T) {
+	var testProvider Providers = "test-registry"
+	created := 0
+	var lock sync.Mutex
+	RegisterProvider(testProvider, func(namePrefix string) (Provider, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		created++
+		return testRegistryProvider{namePrefix: namePrefix, closed: new(bool)}, nil
+	}, testRegistryProviderSettings{})
+	defer unregisterProvider(testProvider)
+
+	Convey("Given a provider registered from outside of the broker", t, func() {
+		Convey("Ensure plans can resolve the provider by name.", func() {
+			So(GetProvidersFromString("test-registry"), ShouldEqual, testProvider)
+			provider, err := GetProviderByPlan("foo", &ProviderPlan{Provider: GetProvidersFromString("test-registry")})
+			So(err, ShouldBeNil)
+			So(provider.(testRegistryProvider).namePrefix, ShouldEqual, "foo")
+		})
+		Convey("Ensure providers are shared until they are closed.", func() {
+			CloseProviders()
+			created = 0
+			plan := &ProviderPlan{Provider: testProvider}
+			first, err := GetProviderByPlan("foo", plan)
+			So(err, ShouldBeNil)
+			second, err := GetProviderByPlan("foo", plan)
+			So(err, ShouldBeNil)
+			So(created, ShouldEqual, 1)
+			So(first.(testRegistryProvider).closed, ShouldEqual, second.(testRegistryProvider).closed)
+			_, err = GetProviderByPlan("bar", plan)
+			So(err, ShouldBeNil)
+			So(created, ShouldEqual, 2)
+			So(CloseProviders(), ShouldBeNil)
+			So(*first.(testRegistryProvider).closed, ShouldEqual, true)
+			_, err = GetProviderByPlan("foo", plan)
+			So(err, ShouldBeNil)
+			So(created, ShouldEqual, 3)
+		})
+		Convey("Ensure providers created at the same time are only kept once.", func() {
+			plan := &ProviderPlan{Provider: testProvider}
+			providers := make([]Provider, 8)
+			var wg sync.WaitGroup
+			for i := range providers {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					providers[i], _ = GetProviderByPlan("race", plan)
+				}(i)
+			}
+			wg.Wait()
+			for _, provider := range providers {
+				So(provider.(testRegistryProvider).closed, ShouldEqual, providers[0].(testRegistryProvider).closed)
+			}
+			So(*providers[0].(testRegistryProvider).closed, ShouldEqual, false)
+			So(CloseProviders(), ShouldBeNil)
+		})
+		Convey("Ensure unregistered providers are unknown.", func() {
+			So(GetProvidersFromString("does-not-exist"), ShouldEqual, Unknown)
+			_, err := GetProviderByPlan("foo", &ProviderPlan{Provider: Unknown})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Ensure provider private details are validated against the schema.", func() {
+			So(ValidateProviderPrivateDetails(testProvider, `{"uri":"postgres://localhost/foo"}`), ShouldBeNil)
+			So(ValidateProviderPrivateDetails(testProvider, `{"url":"postgres://localhost/foo"}`), ShouldNotBeNil)
+			So(ValidateProviderPrivateDetails(testProvider, `{"uri":5}`), ShouldNotBeNil)
+			So(ValidateProviderPrivateDetails("does-not-exist", `{}`), ShouldNotBeNil)
+		})
+		Convey("Ensure a provider cannot be registered twice.", func() {
+			So(func() {
+				RegisterProvider(testProvider, func(namePrefix string) (Provider, error) { return nil, nil }, nil)
+			}, ShouldPanic)
+		})
+	})
+
+	Convey("Given the built in providers", t, func() {
+		Convey("Ensure they are all registered.", func() {
+			for _, name := range []Providers{AWSInstance, AWSCluster, GCloudInstance, PostgresShared, MysqlShared, MssqlShared, CockroachShared, Memory, KubernetesPostgres, AzureFlexible} {
+				So(GetProvidersFromString(string(name)), ShouldEqual, name)
+			}
+		})
+		Convey("Ensure the default shared plans are valid.", func() {
+			So(ValidateProviderPrivateDetails(PostgresShared, `{"master_uri":"postgres://localhost/foo", "engine":"postgres", "engine_version":"10.4"}`), ShouldBeNil)
+			So(ValidateProviderPrivateDetails(MysqlShared, `{"master_uri":"mysql://localhost/foo", "engine":"mysql", "engine_version":"5.7"}`), ShouldBeNil)
+		})
+	})
+}
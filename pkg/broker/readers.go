@@ -0,0 +1,63 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// Aurora clusters can have up to 15 readers.
+const maxReaders = 15
+
+// A ReaderScaler is a provider whose databases are clusters with any number of readers behind one
+// reader endpoint. The readers are reported as the replica of the database, the endpoint of the
+// replica is the reader endpoint rather than any one reader, so readers can come and go without
+// changing the bindings of apps.
+type ReaderScaler interface {
+	// The number of readers the database has, and whether they're scaled automatically.
+	Readers(context.Context, *DbInstance) (int64, bool, error)
+	ScaleReaders(context.Context, *DbInstance, int64) error
+}
+
+func validateReaderCount(count int64) error {
+	if count < 0 || count > maxReaders {
+		return errors.New("The number of readers must be between 0 and " + strconv.Itoa(maxReaders) + ".")
+	}
+	return nil
+}
+
+// Keeps the replica recorded for a database in step with its readers, a replica is recorded while
+// the database has readers or they're scaled automatically. Returns the replica, or nil if there's
+// none. Providers that aren't reader scalers are left as they are.
+func syncReaders(ctx context.Context, storage Storage, provider Provider, dbInstance *DbInstance) (*DbInstance, error) {
+	scaler, ok := provider.(ReaderScaler)
+	if !ok {
+		return nil, nil
+	}
+	count, automatic, err := scaler.Readers(ctx, dbInstance)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := storage.HasReplicas(dbInstance)
+	if err != nil {
+		return nil, err
+	}
+	replica, err := provider.GetReadReplica(ctx, dbInstance)
+	if err != nil {
+		return nil, err
+	}
+	replica.Id = dbInstance.Id
+	replica.Username = dbInstance.Username
+	replica.Password = dbInstance.Password
+
+	if count == 0 && !automatic {
+		if amount > 0 {
+			return nil, storage.DeleteReplica(replica)
+		}
+		return nil, nil
+	}
+	if amount > 0 {
+		return replica, storage.UpdateReplica(replica)
+	}
+	return replica, storage.AddReplica(replica)
+}
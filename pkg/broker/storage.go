@@ -200,6 +200,7 @@ begin
         retries int not null default 0,
         metadata text not null default '',
         result text not null default '',
+        progress text not null default '',
         created timestamp with time zone not null default now(),
         updated timestamp with time zone not null default now(),
         started timestamp with time zone,
@@ -225,9 +226,57 @@ begin
         alter table plans alter column provider TYPE varchar(1024) using provider::varchar(1024);
     end if;
 
+    if not exists (SELECT NULL 
+              FROM INFORMATION_SCHEMA.COLUMNS
+             WHERE table_name = 'tasks'
+              AND column_name = 'progress'
+              and table_schema = 'public') then
+        alter table tasks add column progress text not null default '';
+    end if;
+
     drop trigger if exists tasks_updated on tasks;
     create trigger tasks_updated before update on tasks for each row execute procedure mark_updated_column();
 
+    create table if not exists shared_tenants
+    (
+        name varchar(200) not null primary key,
+        host varchar(1024) not null,
+        created timestamp with time zone not null default now(),
+        updated timestamp with time zone not null default now(),
+        deleted bool not null default false
+    );
+    drop trigger if exists shared_tenants_updated on shared_tenants;
+    create trigger shared_tenants_updated before update on shared_tenants for each row execute procedure mark_updated_column();
+
+    create table if not exists logical_backups
+    (
+        backup varchar(200) not null primary key,
+        database varchar(200) not null,
+        location varchar(1024) not null,
+        path varchar(1024) not null,
+        status varchar(200) not null default 'creating',
+        progress bigint not null default 0,
+        size bigint not null default 0,
+        created timestamp with time zone not null default now(),
+        updated timestamp with time zone not null default now(),
+        deleted bool not null default false
+    );
+    drop trigger if exists logical_backups_updated on logical_backups;
+    create trigger logical_backups_updated before update on logical_backups for each row execute procedure mark_updated_column();
+
+    create table if not exists iam_bindings
+    (
+        database varchar(1024) references databases("id") not null,
+        binding varchar(1024) not null,
+        username varchar(128) not null,
+        created timestamp with time zone not null default now(),
+        updated timestamp with time zone not null default now(),
+        deleted bool not null default false,
+        primary key(database, binding)
+    );
+    drop trigger if exists iam_bindings_updated on iam_bindings;
+    create trigger iam_bindings_updated before update on iam_bindings for each row execute procedure mark_updated_column();
+
     -- populate some default services (aws postgres)
     if (select count(*) from services) = 0 then
         insert into services 
@@ -285,16 +334,15 @@ $$
 `
 
 func cancelOnInterrupt(ctx context.Context, db *sql.DB) {
-	term := make(chan os.Signal)
+	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(term)
 
-	for {
-		select {
-		case <-term:
-			db.Close()
-		case <-ctx.Done():
-			db.Close()
-		}
+	select {
+	case <-term:
+		db.Close()
+	case <-ctx.Done():
+		db.Close()
 	}
 }
 
@@ -312,7 +360,11 @@ type Storage interface {
 	UpdateRole(*DbInstance, string, string) (DatabaseUrlSpec, error)
 	HasRole(*DbInstance, string) (int64, error)
 	DeleteRole(*DbInstance, string) error
+	GetIAMBinding(*DbInstance, string) (string, error)
+	AddIAMBinding(*DbInstance, string, string) error
+	DeleteIAMBinding(*DbInstance, string) error
 	GetInstance(string) (*DbEntry, error)
+	GetInstancesByPlan(string) ([]DbEntry, error)
 	AddInstance(*DbInstance) error
 	DeleteInstance(*DbInstance) error
 	UpdateInstance(*DbInstance, string) error
@@ -326,8 +378,15 @@ type Storage interface {
 	NukeInstance(string) error
 	WarnOnUnfinishedTasks()
 	IsRestoring(string) (bool, error)
+	IsForking(string) (bool, error)
+	IsSyncingRecovery(string) (bool, error)
+	IsPromotingRecovery(string) (bool, error)
 	IsUpgrading(string) (bool, error)
+	SetUpgradeProgress(string, string) error
+	GetUpgradeProgress(string) (string, error)
 	ValidateInstanceID(id string) error
+	SharedTenantStore
+	LogicalBackupStore
 }
 
 type PostgresStorage struct {
@@ -567,12 +626,49 @@ func (b *PostgresStorage) IsUpgrading(dbId string) (bool, error) {
 	return count > 0, err
 }
 
+// Records how far along a running plan or provider change is.
+func (b *PostgresStorage) SetUpgradeProgress(dbId string, progress string) error {
+	_, err := b.db.Exec("update tasks set progress = $2 where status = 'started' and (action = 'change-providers' OR action = 'change-plans') and deleted = false and database = $1", dbId, progress)
+	return err
+}
+
+// Returns how far along a running plan or provider change is, or an empty string if none is running.
+func (b *PostgresStorage) GetUpgradeProgress(dbId string) (string, error) {
+	var progress string
+	err := b.db.QueryRow("select progress from tasks where status = 'started' and (action = 'change-providers' OR action = 'change-plans') and deleted = false and database = $1 order by started desc limit 1", dbId).Scan(&progress)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", nil
+	}
+	return progress, err
+}
+
 func (b *PostgresStorage) IsRestoring(dbId string) (bool, error) {
 	var count int64
 	err := b.db.QueryRow("select count(*) from tasks where ( status = 'started' or status = 'pending' ) and action = 'restore-database' and deleted = false and database = $1", dbId).Scan(&count)
 	return count > 0, err
 }
 
+// Whether a database is still being forked from another database.
+func (b *PostgresStorage) IsForking(dbId string) (bool, error) {
+	var count int64
+	err := b.db.QueryRow("select count(*) from tasks where ( status = 'started' or status = 'pending' ) and action = 'fork-database' and deleted = false and database = $1", dbId).Scan(&count)
+	return count > 0, err
+}
+
+// Whether a sync of the disaster recovery copy of a database is waiting or running.
+func (b *PostgresStorage) IsSyncingRecovery(dbId string) (bool, error) {
+	var count int64
+	err := b.db.QueryRow("select count(*) from tasks where ( status = 'started' or status = 'pending' ) and action = 'sync-disaster-recovery' and deleted = false and database = $1", dbId).Scan(&count)
+	return count > 0, err
+}
+
+// Whether the disaster recovery copy of a database is being promoted.
+func (b *PostgresStorage) IsPromotingRecovery(dbId string) (bool, error) {
+	var count int64
+	err := b.db.QueryRow("select count(*) from tasks where ( status = 'started' or status = 'pending' ) and action = 'promote-disaster-recovery' and deleted = false and database = $1", dbId).Scan(&count)
+	return count > 0, err
+}
+
 func (b *PostgresStorage) HasRole(dbInstance *DbInstance, username string) (int64, error) {
 	var count int64
 	err := b.db.QueryRow("select count(*) from roles where database = $1 and username = $2 and deleted = false", dbInstance.Id, username).Scan(&count)
@@ -584,6 +680,85 @@ func (b *PostgresStorage) DeleteRole(dbInstance *DbInstance, username string) er
 	return err
 }
 
+// Returns the user an iam binding logs in as, bindings that use the password have no rows.
+func (b *PostgresStorage) GetIAMBinding(dbInstance *DbInstance, bindingId string) (string, error) {
+	var username string
+	err := b.db.QueryRow("select username from iam_bindings where database = $1 and binding = $2 and deleted = false", dbInstance.Id, bindingId).Scan(&username)
+	return username, err
+}
+
+func (b *PostgresStorage) AddIAMBinding(dbInstance *DbInstance, bindingId string, username string) error {
+	_, err := b.db.Exec("insert into iam_bindings (database, binding, username) values ($1, $2, $3) on conflict (database, binding) do update set username = $3, deleted = false", dbInstance.Id, bindingId, username)
+	return err
+}
+
+func (b *PostgresStorage) DeleteIAMBinding(dbInstance *DbInstance, bindingId string) error {
+	_, err := b.db.Exec("update iam_bindings set deleted = true where database = $1 and binding = $2", dbInstance.Id, bindingId)
+	return err
+}
+
+// Returns the key of the host a shared database was placed on, or an empty string if it was never recorded.
+func (b *PostgresStorage) GetSharedTenantHost(name string) (string, error) {
+	var host string
+	err := b.db.QueryRow("select host from shared_tenants where name = $1 and deleted = false", name).Scan(&host)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return "", nil
+	}
+	return host, err
+}
+
+func (b *PostgresStorage) AddSharedTenant(name string, host string) error {
+	_, err := b.db.Exec("insert into shared_tenants (name, host) values ($1, $2) on conflict (name) do update set host = $2, deleted = false", name, host)
+	return err
+}
+
+func (b *PostgresStorage) DeleteSharedTenant(name string) error {
+	_, err := b.db.Exec("update shared_tenants set deleted = true where name = $1", name)
+	return err
+}
+
+func (b *PostgresStorage) CountSharedTenants(host string) (int64, error) {
+	var count int64
+	err := b.db.QueryRow("select count(*) from shared_tenants where host = $1 and deleted = false", host).Scan(&count)
+	return count, err
+}
+
+func (b *PostgresStorage) AddLogicalBackup(backup LogicalBackup) error {
+	_, err := b.db.Exec("insert into logical_backups (backup, database, location, path, status, progress, size, created) values ($1, $2, $3, $4, $5, $6, $7, $8)", backup.Id, backup.Database, backup.Location, backup.Path, backup.Status, backup.Progress, backup.Size, backup.Created)
+	return err
+}
+
+func (b *PostgresStorage) UpdateLogicalBackup(backup LogicalBackup) error {
+	_, err := b.db.Exec("update logical_backups set status = $2, progress = $3, size = $4 where backup = $1", backup.Id, backup.Status, backup.Progress, backup.Size)
+	return err
+}
+
+func (b *PostgresStorage) GetLogicalBackup(database string, Id string) (LogicalBackup, error) {
+	var backup LogicalBackup
+	err := b.db.QueryRow("select backup, database, location, path, status, progress, size, created from logical_backups where database = $1 and backup = $2 and deleted = false", database, Id).Scan(&backup.Id, &backup.Database, &backup.Location, &backup.Path, &backup.Status, &backup.Progress, &backup.Size, &backup.Created)
+	if err != nil && err.Error() == "sql: no rows in result set" {
+		return LogicalBackup{}, errors.New("Not found")
+	}
+	return backup, err
+}
+
+func (b *PostgresStorage) ListLogicalBackups(database string) ([]LogicalBackup, error) {
+	rows, err := b.db.Query("select backup, database, location, path, status, progress, size, created from logical_backups where database = $1 and deleted = false order by created desc", database)
+	if err != nil {
+		return []LogicalBackup{}, err
+	}
+	defer rows.Close()
+	backups := make([]LogicalBackup, 0)
+	for rows.Next() {
+		var backup LogicalBackup
+		if err := rows.Scan(&backup.Id, &backup.Database, &backup.Location, &backup.Path, &backup.Status, &backup.Progress, &backup.Size, &backup.Created); err != nil {
+			return []LogicalBackup{}, err
+		}
+		backups = append(backups, backup)
+	}
+	return backups, rows.Err()
+}
+
 func (b *PostgresStorage) GetUnclaimedInstance(PlanId string, InstanceId string) (*DbEntry, error) {
 	tx, err := b.db.Begin()
 	if err != nil {
@@ -742,6 +917,24 @@ func (b *PostgresStorage) GetInstance(Id string) (*DbEntry, error) {
 	return &entry, nil
 }
 
+// The claimed databases on a plan, preprovisioned databases are left out.
+func (b *PostgresStorage) GetInstancesByPlan(PlanId string) ([]DbEntry, error) {
+	rows, err := b.db.Query("select id, name, plan, claimed, status, username, password, endpoint from databases where plan = $1 and claimed = true and deleted = false", PlanId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]DbEntry, 0)
+	for rows.Next() {
+		var entry DbEntry
+		if err := rows.Scan(&entry.Id, &entry.Name, &entry.PlanId, &entry.Claimed, &entry.Status, &entry.Username, &entry.Password, &entry.Endpoint); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
 func (b *PostgresStorage) AddTask(Id string, action TaskAction, metadata string) (string, error) {
 	var task_id string
 	return task_id, b.db.QueryRow("insert into tasks (task, database, action, metadata) values (uuid_generate_v4(), $1, $2, $3) returning task", Id, action, metadata).Scan(&task_id)
@@ -801,7 +994,62 @@ func InitStorage(ctx context.Context, o Options) (*PostgresStorage, error) {
 
 	go cancelOnInterrupt(ctx, db)
 
-	return &PostgresStorage{
+	storage := PostgresStorage{
 		db: db,
-	}, nil
+	}
+	storage.warnOnInvalidPlans()
+	storage.recordLegacySharedTenants()
+	return &storage, nil
+}
+
+// Shared databases created before hosts were recorded live on their plans master uri, they're recorded
+// there so they're counted when placing new databases. Databases already recorded are left as they are.
+func (b *PostgresStorage) recordLegacySharedTenants() {
+	rows, err := b.db.Query(`
+		select databases.name, plans.provider_private_details::text
+		from databases join plans on databases.plan = plans.plan
+		where plans.provider in ($1, $2) and databases.deleted = false`, string(PostgresShared), string(MysqlShared))
+	if err != nil {
+		glog.Errorf("Unable to record the hosts of shared databases: %s\n", err.Error())
+		return
+	}
+	tenants := make(map[string]string)
+	for rows.Next() {
+		var name, providerPrivateDetails string
+		if err := rows.Scan(&name, &providerPrivateDetails); err != nil {
+			glog.Errorf("Unable to record the hosts of shared databases, scan failed: %s\n", err.Error())
+			rows.Close()
+			return
+		}
+		if host := legacySharedTenantHost(providerPrivateDetails); host != "" {
+			tenants[name] = host
+		}
+	}
+	rows.Close()
+	for name, host := range tenants {
+		if _, err := b.db.Exec("insert into shared_tenants (name, host) values ($1, $2) on conflict (name) do nothing", name, host); err != nil {
+			glog.Errorf("Unable to record the host of shared database %s: %s\n", name, err.Error())
+		}
+	}
+}
+
+// Plans can be added to the database by hand, so make some noise early if they reference
+// providers that are not registered or have provider private details the provider cant use.
+func (b *PostgresStorage) warnOnInvalidPlans() {
+	rows, err := b.db.Query("select plan, provider, provider_private_details::text from plans where deleted = false")
+	if err != nil {
+		glog.Errorf("Unable to validate plans: %s\n", err.Error())
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var planId, provider, providerPrivateDetails string
+		if err := rows.Scan(&planId, &provider, &providerPrivateDetails); err != nil {
+			glog.Errorf("Unable to validate plans, scan failed: %s\n", err.Error())
+			return
+		}
+		if err := ValidateProviderPrivateDetails(Providers(provider), os.ExpandEnv(providerPrivateDetails)); err != nil {
+			glog.Errorf("WARNING: Plan %s will not work: %s\n", planId, err.Error())
+		}
+	}
 }
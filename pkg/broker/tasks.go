@@ -11,8 +11,6 @@ import (
 	"github.com/golang/glog"
 	"net/http"
 	"os"
-	"os/exec"
-	"strings"
 	"time"
 )
 
@@ -29,6 +27,12 @@ const (
 	RestoreDbTask                        TaskAction = "restore-database"
 	PerformPostProvisionTask             TaskAction = "perform-post-provision"
 	ResyncReplicasFromProviderTask       TaskAction = "resync-replicas-from-provider-task"
+	BackupDbTask                         TaskAction = "backup-database"
+	UpgradeVersionTask                   TaskAction = "upgrade-version"
+	RestoreToTimeTask                    TaskAction = "restore-to-time"
+	ForkDbTask                           TaskAction = "fork-database"
+	SyncRecoveryTask                     TaskAction = "sync-disaster-recovery"
+	PromoteRecoveryTask                  TaskAction = "promote-disaster-recovery"
 )
 
 type Task struct {
@@ -49,17 +53,38 @@ type WebhookTaskMetadata struct {
 }
 
 type ChangeProvidersTaskMetadata struct {
-	Plan string `json:"plan"`
+	Plan       string                 `json:"plan"`
+	Switchover *ReplicationSwitchover `json:"switchover,omitempty"`
 }
 
 type ChangePlansTaskMetadata struct {
-	Plan string `json:"plan"`
+	Plan       string                 `json:"plan"`
+	Switchover *ReplicationSwitchover `json:"switchover,omitempty"`
 }
 
 type RestoreDbTaskMetadata struct {
 	Backup string `json:"backup"`
 }
 
+type BackupDbTaskMetadata struct {
+	Backup string `json:"backup"`
+}
+
+type UpgradeVersionTaskMetadata struct {
+	Version string `json:"version"`
+	Window  string `json:"window,omitempty"`
+}
+
+type RestoreToTimeTaskMetadata struct {
+	Time time.Time `json:"time"`
+}
+
+type ForkDbTaskMetadata struct {
+	From   string `json:"from"`
+	Backup string `json:"backup,omitempty"`
+	Owner  string `json:"owner"`
+}
+
 func FinishedTask(storage Storage, taskId string, retries int64, result string, status string) {
 	var t = time.Now()
 	err := storage.UpdateTask(taskId, &status, &retries, nil, &result, nil, &t)
@@ -77,6 +102,7 @@ func UpdateTaskStatus(storage Storage, taskId string, retries int64, result stri
 
 func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, storage Storage, wait int64) {
 	t := time.NewTicker(time.Second * time.Duration(wait))
+	defer t.Stop()
 	dbEntries, err := storage.StartProvisioningTasks()
 	if err != nil {
 		glog.Errorf("Get pending tasks failed: %s\n", err.Error())
@@ -98,7 +124,9 @@ func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, sto
 			continue
 		}
 
-		dbInstance, err := provider.Provision(entry.Id, plan, "preprovisioned")
+		provisionCtx, cancel := WithOperationTimeout(ctx, ProvisionOperation)
+		dbInstance, err := provider.Provision(provisionCtx, entry.Id, plan, "preprovisioned")
+		cancel()
 		if err != nil {
 			glog.Errorf("Error provisioning database (%s): %s\n", plan.ID, err.Error())
 			storage.NukeInstance(entry.Id)
@@ -108,7 +136,10 @@ func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, sto
 		if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
 			glog.Errorf("Error inserting record into provisioned table: %s\n", err.Error())
 
-			if err = provider.Deprovision(dbInstance, false); err != nil {
+			deprovisionCtx, cancel := WithOperationTimeout(ctx, string(DeleteTask))
+			err = provider.Deprovision(deprovisionCtx, dbInstance, false)
+			cancel()
+			if err != nil {
 				glog.Errorf("Error cleaning up (deprovision failed) after insert record failed but provision succeeded (Database Id:%s Name: %s) %s\n", dbInstance.Id, dbInstance.Name, err.Error())
 				if _, err = storage.AddTask(dbInstance.Id, DeleteTask, dbInstance.Name); err != nil {
 					glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", dbInstance.Name, err.Error())
@@ -122,32 +153,62 @@ func RunPreprovisionTasks(ctx context.Context, o Options, namePrefix string, sto
 			}
 		}
 		glog.Infof("Finished preprovisioning database: %s with plan: %s\n", entry.Id, entry.PlanId)
-		<-t.C
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
 	}
 }
 
 func TickTocPreprovisionTasks(ctx context.Context, o Options, namePrefix string, storage Storage) {
 	next_check := time.NewTicker(time.Second * 60 * 5)
+	defer next_check.Stop()
 	for {
 		RunPreprovisionTasks(ctx, o, namePrefix, storage, 60)
-		<-next_check.C
+		select {
+		case <-ctx.Done():
+			return
+		case <-next_check.C:
+		}
 	}
 }
 
-func RestoreBackup(storage Storage, dbInstance *DbInstance, namePrefix string, backup string) error {
+func RestoreBackup(ctx context.Context, storage Storage, dbInstance *DbInstance, namePrefix string, backup string) error {
 	provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
 	if err != nil {
 		glog.Errorf("Unable to restore backup, cannot find provider (GetProviderByPlan failed): %s\n", err.Error())
 		return err
 	}
-	if err = provider.RestoreBackup(dbInstance, backup); err != nil {
+	if restorer, ok := provider.(ReplacingRestorer); ok {
+		restored, err := restorer.RestoreBackupInto(ctx, dbInstance, backup)
+		if err != nil {
+			glog.Errorf("Unable to restore backup: %s\n", err.Error())
+			return err
+		}
+		if err = storage.UpdateInstance(restored, restored.Plan.ID); err != nil {
+			glog.Errorf("ERROR: Cannot update instance in database after restoring %s from %s (restored into: %s) %s\n", dbInstance.Name, backup, restored.Name, err.Error())
+			// a delete task can't be added as it would find the database by the id it shares with dbInstance.
+			if derr := provider.Deprovision(ctx, restored, false); derr != nil {
+				glog.Errorf("Error: Unable to clean up after error, WE HAVE AN ORPHAN! (%s): %s\n", restored.Name, derr.Error())
+			}
+			return err
+		}
+		if restored.Name != dbInstance.Name {
+			if err = provider.Deprovision(ctx, dbInstance, false); err != nil {
+				glog.Errorf("ERROR: Orphaned Database! Unable to remove %s after restoring it into %s: %s\n", dbInstance.Name, restored.Name, err.Error())
+			}
+		}
+		return nil
+	}
+	if err = provider.RestoreBackup(ctx, dbInstance, backup); err != nil {
 		glog.Errorf("Unable to restore backup: %s\n", err.Error())
 		return err
 	}
 	return nil
 }
 
-func UpgradeWithinProviders(storage Storage, fromDb *DbInstance, toPlanId string, namePrefix string) (string, error) {
+func UpgradeWithinProviders(ctx context.Context, storage Storage, fromDb *DbInstance, toPlanId string, namePrefix string) (string, error) {
 	toPlan, err := storage.GetPlanByID(toPlanId)
 	if err != nil {
 		return "", err
@@ -163,10 +224,32 @@ func UpgradeWithinProviders(storage Storage, fromDb *DbInstance, toPlanId string
 		return "", errors.New("Unable to upgrade, different providers were passed in on both plans")
 	}
 
+	var dbInstance *DbInstance
+	if mover, ok := fromProvider.(TenantMover); ok {
+		roles, err := storage.ListRoles(fromDb)
+		if err != nil {
+			return "", err
+		}
+		dbInstance, err = mover.MoveTenant(ctx, fromDb, toPlan, roles, func(progress string) {
+			if err := storage.SetUpgradeProgress(fromDb.Id, progress); err != nil {
+				glog.Errorf("Unable to record the progress of changing plans for %s: %s\n", fromDb.Name, err.Error())
+			}
+		})
+		if err != nil {
+			return "", err
+		}
+		dbInstance.Id = fromDb.Id
+		if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
+			glog.Errorf("ERROR: Cannot update instance in database after moving %s (to plan: %s) %s\n", dbInstance.Name, dbInstance.Plan.ID, err.Error())
+			return "", err
+		}
+		return "", nil
+	}
+
 	// This could take a very long time.
-	dbInstance, err := fromProvider.Modify(fromDb, toPlan)
+	dbInstance, err = fromProvider.Modify(ctx, fromDb, toPlan)
 	if err != nil && err.Error() == "This feature is not available on this plan." {
-		return UpgradeAcrossProviders(storage, fromDb, toPlanId, namePrefix)
+		return UpgradeAcrossProviders(ctx, storage, fromDb, toPlanId, namePrefix)
 	}
 	if err != nil {
 		return "", err
@@ -176,6 +259,13 @@ func UpgradeWithinProviders(storage Storage, fromDb *DbInstance, toPlanId string
 		glog.Errorf("ERROR: Cannot update instance in database after upgrade change %s (to plan: %s) %s\n", dbInstance.Name, dbInstance.Plan.ID, err.Error())
 		return "", err
 	}
+	if replica, err := syncReaders(ctx, storage, fromProvider, dbInstance); err != nil {
+		glog.Errorf("Error: Unable to record the readers after upgrade change %s (to plan: %s) %s\n", dbInstance.Name, dbInstance.Plan.ID, err.Error())
+	} else if replica != nil && !IsAvailable(replica.Status) {
+		if _, err = storage.AddTask(dbInstance.Id, ResyncReplicasFromProviderTask, ""); err != nil {
+			glog.Errorf("Error: Unable to schedule resync of replica from provider! (%s): %s\n", replica.Name, err.Error())
+		}
+	}
 
 	if !IsAvailable(dbInstance.Status) {
 		if _, err = storage.AddTask(dbInstance.Id, ResyncFromProviderTask, ""); err != nil {
@@ -185,86 +275,103 @@ func UpgradeWithinProviders(storage Storage, fromDb *DbInstance, toPlanId string
 	return "", err
 }
 
-func UpgradeAcrossProviders(storage Storage, fromDb *DbInstance, toPlanId string, namePrefix string) (string, error) {
-	toPlan, err := storage.GetPlanByID(toPlanId)
+// Provisions the database a database is moving to when it changes plans and waits for it to be available,
+// the new database is given the id of the database it replaces.
+func provisionUpgradeTarget(ctx context.Context, storage Storage, toProvider Provider, fromDb *DbInstance, toPlan *ProviderPlan) (*DbInstance, error) {
+	origToDb, err := toProvider.Provision(ctx, fromDb.Id, toPlan, "")
 	if err != nil {
-		return "", err
-	}
-	toProvider, err := GetProviderByPlan(namePrefix, toPlan)
-	if err != nil {
-		return "", err
-	}
-	fromProvider, err := GetProviderByPlan(namePrefix, fromDb.Plan)
-	if err != nil {
-		return "", err
-	}
-	if toPlanId == fromDb.Plan.ID {
-		return "", errors.New("Cannot upgrade to the same plan")
-	}
-	if fromDb.Engine != "postgres" {
-		return "", errors.New("Can only upgrade across providers on postgres")
-	}
-
-	origToDb, err := toProvider.Provision(fromDb.Id, toPlan, "")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	var toDb *DbInstance = nil
 	t := time.NewTicker(time.Second * 30)
+	defer t.Stop()
 	for i := 0; i < 60; i++ {
-		toDb, err = toProvider.GetInstance(origToDb.Name, origToDb.Plan)
+		toDb, err = toProvider.GetInstance(ctx, origToDb.Name, origToDb.Plan)
 		if err != nil {
 			glog.Errorf("Unable to get instance of db %s because %s\n", origToDb.Name, err.Error())
-			if err = toProvider.Deprovision(origToDb, false); err != nil {
+			if err = toProvider.Deprovision(ctx, origToDb, false); err != nil {
 				glog.Errorf("Unable to clean up after error, for %s database! %s\n", origToDb.Name, err.Error())
 				if _, err = storage.AddTask(origToDb.Id, DeleteTask, origToDb.Name); err != nil {
 					glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", origToDb.Name, err.Error())
 				}
 			}
-			return "", errors.New("The database instance could not be obtained.")
+			return nil, errors.New("The database instance could not be obtained.")
 		}
 		if i == 59 {
-			if err = toProvider.Deprovision(origToDb, false); err != nil {
+			if err = toProvider.Deprovision(ctx, origToDb, false); err != nil {
 				glog.Errorf("Unable to clean up after error, for %s database! %s\n", origToDb.Name, err.Error())
 				if _, err = storage.AddTask(origToDb.Id, DeleteTask, origToDb.Name); err != nil {
 					glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", origToDb.Name, err.Error())
 				}
 			}
-			return "", errors.New("The database provisioning never finished.")
+			return nil, errors.New("The database provisioning never finished.")
 		}
 		if IsAvailable(toDb.Status) {
 			break
 		}
-		<-t.C
+		select {
+		case <-ctx.Done():
+			// The context is finished so we can't deprovision it ourselves, leave it to a task.
+			if _, err = storage.AddTask(origToDb.Id, DeleteTask, origToDb.Name); err != nil {
+				glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", origToDb.Name, err.Error())
+			}
+			return nil, ctx.Err()
+		case <-t.C:
+		}
 	}
 	if toDb == nil {
-		return "", errors.New("The database provisioning never finished, toDb was nil.")
+		return nil, errors.New("The database provisioning never finished, toDb was nil.")
 	}
 
 	toDb.Id = fromDb.Id
 	toDb.Username = origToDb.Username
 	toDb.Password = origToDb.Password
+	return toDb, nil
+}
 
-	v := strings.Split(fromDb.Endpoint, ":")
-	var extras = " "
+func UpgradeAcrossProviders(ctx context.Context, storage Storage, fromDb *DbInstance, toPlanId string, namePrefix string) (string, error) {
+	toPlan, err := storage.GetPlanByID(toPlanId)
+	if err != nil {
+		return "", err
+	}
+	toProvider, err := GetProviderByPlan(namePrefix, toPlan)
+	if err != nil {
+		return "", err
+	}
+	fromProvider, err := GetProviderByPlan(namePrefix, fromDb.Plan)
+	if err != nil {
+		return "", err
+	}
+	if toPlanId == fromDb.Plan.ID {
+		return "", errors.New("Cannot upgrade to the same plan")
+	}
+	if migrationEngine(fromDb.Engine) == "" {
+		return "", errors.New("Can only upgrade across providers on postgres or mysql")
+	}
 
-	if len(v) == 2 {
-		u := strings.Split(v[1], "/")
-		extras = extras + "-p " + u[0]
+	toDb, err := provisionUpgradeTarget(ctx, storage, toProvider, fromDb, toPlan)
+	if err != nil {
+		return "", err
 	}
-	targetUrl := toDb.Scheme + "://" + toDb.Username + ":" + toDb.Password + "@" + toDb.Endpoint
 
-	cmd := exec.Command("sh", "-c", "set -o pipefail ; PGPASSWORD=\""+fromDb.Password+"\" pg_dump -xOc -d "+fromDb.Name+" -h "+v[0]+extras+" -U "+fromDb.Username+" | psql "+targetUrl)
-	var out bytes.Buffer
-	cmd.Stderr = &out
-	if err = cmd.Run(); err != nil {
+	out, err := migrateDatabase(ctx, fromDb, toDb, func(progress string) {
+		if err := storage.SetUpgradeProgress(fromDb.Id, progress); err != nil {
+			glog.Errorf("Unable to record the progress of changing providers for %s: %s\n", fromDb.Name, err.Error())
+		}
+	})
+	if err != nil {
+		glog.Errorf("Cannot copy %s to %s during provider change: %s\n", fromDb.Name, toDb.Name, err.Error())
+		// a delete task can't be added as it would find the database by the id it shares with fromDb.
+		if derr := toProvider.Deprovision(ctx, toDb, false); derr != nil {
+			glog.Errorf("Error: Unable to clean up after error, WE HAVE AN ORPHAN! (%s): %s\n", toDb.Name, derr.Error())
+		}
 		return "", err
 	}
 
 	if err = storage.UpdateInstance(toDb, toDb.Plan.ID); err != nil {
 		glog.Errorf("Cannot update instance in database after provider change %s (to plan: %s) %s\n", toDb.Name, toDb.Plan.ID, err.Error())
-		if err = toProvider.Deprovision(toDb, false); err != nil {
+		if err = toProvider.Deprovision(ctx, toDb, false); err != nil {
 			glog.Errorf("Cannot deprovision database after failure in recording provider change %s %s\n", toDb.Name, err.Error())
 			if _, err = storage.AddTask(toDb.Id, DeleteTask, toDb.Name); err != nil {
 				glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! (%s): %s\n", toDb.Name, err.Error())
@@ -273,348 +380,749 @@ func UpgradeAcrossProviders(storage Storage, fromDb *DbInstance, toPlanId string
 		return "", err
 	}
 
-	if err = fromProvider.Deprovision(fromDb, true); err != nil {
+	if err = fromProvider.Deprovision(ctx, fromDb, true); err != nil {
 		glog.Errorf("Cannot deprovision existing database during provider change %s %s\n", fromDb.Name, err.Error())
 		// Do not add this as a task, since the instance id stayed the same and was upgrade it fromDb.Id now
 		// represents the samething as toDb. We can only write out to lthe logs and hope someone picks this up.
 		glog.Errorf("Error: Unable to add task to delete instance, WE HAVE AN ORPHAN! Name: %s, Plan Id: %s, Error: %s\n", fromDb.Name, fromDb.Plan.ID, err.Error())
 	}
 
-	return out.String(), nil
+	return out, nil
 }
 
-func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage Storage) error {
+// Changes the plan (and possibly provider) of a postgres database by replicating it into a new database
+// on the plan, see replicateDatabase.
+func UpgradeByReplication(ctx context.Context, storage Storage, fromDb *DbInstance, toPlanId string, namePrefix string, switchover ReplicationSwitchover) (string, error) {
+	toPlan, err := storage.GetPlanByID(toPlanId)
+	if err != nil {
+		return "", err
+	}
+	toProvider, err := GetProviderByPlan(namePrefix, toPlan)
+	if err != nil {
+		return "", err
+	}
+	fromProvider, err := GetProviderByPlan(namePrefix, fromDb.Plan)
+	if err != nil {
+		return "", err
+	}
+	if toPlanId == fromDb.Plan.ID {
+		return "", errors.New("Cannot upgrade to the same plan")
+	}
+	if migrationEngine(fromDb.Engine) != "postgres" {
+		return "", errors.New("Can only switch over with replication on postgres")
+	}
+	toDb, err := provisionUpgradeTarget(ctx, storage, toProvider, fromDb, toPlan)
+	if err != nil {
+		return "", err
+	}
 
-	t := time.NewTicker(time.Second * 60)
-	for {
-		<-t.C
-		storage.WarnOnUnfinishedTasks()
+	progress := func(progress string) {
+		if err := storage.SetUpgradeProgress(fromDb.Id, progress); err != nil {
+			glog.Errorf("Unable to record the progress of changing plans for %s: %s\n", fromDb.Name, err.Error())
+		}
+	}
+	out, err := replicateDatabase(ctx, fromDb, toDb, switchover, progress, func() error {
+		return storage.UpdateInstance(toDb, toDb.Plan.ID)
+	})
+	if err == errCannotSubscribe {
+		// nothing has been copied yet, so the database is copied as it would be without a switchover.
+		glog.Infof("Cannot switch %s over to %s with replication, copying it instead: %s\n", fromDb.Name, toDb.Name, err.Error())
+		if out, err = migrateDatabase(ctx, fromDb, toDb, progress); err == nil {
+			err = storage.UpdateInstance(toDb, toDb.Plan.ID)
+		}
+	}
+	if err != nil {
+		glog.Errorf("Cannot switch %s over to %s: %s\n", fromDb.Name, toDb.Name, err.Error())
+		// a delete task can't be added as it would find the database by the id it shares with fromDb.
+		if derr := toProvider.Deprovision(ctx, toDb, false); derr != nil {
+			glog.Errorf("Error: Unable to clean up after error, WE HAVE AN ORPHAN! (%s): %s\n", toDb.Name, derr.Error())
+		}
+		return "", err
+	}
 
-		task, err := storage.PopPendingTask()
-		if err != nil && err.Error() != "sql: no rows in result set" {
-			glog.Errorf("Getting a pending task failed: %s\n", err.Error())
-			return err
-		} else if err != nil && err.Error() == "sql: no rows in result set" {
-			// Nothing to do...
-			continue
+	if err = fromProvider.Deprovision(ctx, fromDb, true); err != nil {
+		glog.Errorf("Error: Unable to deprovision the database switched over from, WE HAVE AN ORPHAN! Name: %s, Plan Id: %s, Error: %s\n", fromDb.Name, fromDb.Plan.ID, err.Error())
+	}
+	return out, nil
+}
+
+// Upgrades the engine of a database to the version without changing its plan.
+func UpgradeEngineVersion(ctx context.Context, storage Storage, fromDb *DbInstance, version string, namePrefix string) (string, error) {
+	provider, err := GetProviderByPlan(namePrefix, fromDb.Plan)
+	if err != nil {
+		return "", err
+	}
+	upgrader, ok := provider.(VersionUpgrader)
+	if !ok {
+		return "", ErrFeatureNotAvailable
+	}
+	// A retry may find the upgrade already done.
+	if fromDb.EngineVersion == version {
+		return "", nil
+	}
+	if err = validateUpgradeVersion(ctx, upgrader, fromDb, version); err != nil {
+		return "", err
+	}
+	from := fromDb.EngineVersion
+	dbInstance, err := upgrader.UpgradeEngineVersion(ctx, fromDb, version)
+	if err != nil {
+		return "", err
+	}
+	if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
+		glog.Errorf("ERROR: Cannot update instance in database after upgrading %s (to version: %s) %s\n", dbInstance.Name, version, err.Error())
+		return "", err
+	}
+	return "Upgraded " + dbInstance.Engine + " from " + from + " to " + dbInstance.EngineVersion + ".", nil
+}
+
+// Restores a database to a point in time, if the provider restored it into a new database the old one
+// is removed once the new one is recorded.
+func RestoreToTime(ctx context.Context, storage Storage, fromDb *DbInstance, at time.Time, namePrefix string) (string, error) {
+	provider, err := GetProviderByPlan(namePrefix, fromDb.Plan)
+	if err != nil {
+		return "", err
+	}
+	restorer, ok := provider.(PointInTimeRestorer)
+	if !ok {
+		return "", ErrFeatureNotAvailable
+	}
+	if err = validateRestoreTime(ctx, restorer, fromDb, at); err != nil {
+		return "", err
+	}
+	dbInstance, err := restorer.RestoreToTime(ctx, fromDb, at)
+	if err != nil {
+		return "", err
+	}
+	if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
+		glog.Errorf("ERROR: Cannot update instance in database after restoring %s to %s (restored into: %s) %s\n", fromDb.Name, at.Format(time.RFC3339), dbInstance.Name, err.Error())
+		return "", err
+	}
+	if dbInstance.Name != fromDb.Name {
+		if err = provider.Deprovision(ctx, fromDb, false); err != nil {
+			glog.Errorf("ERROR: Orphaned Database! Unable to remove %s after restoring it into %s: %s\n", fromDb.Name, dbInstance.Name, err.Error())
 		}
+	}
+	return "Restored " + fromDb.Name + " to " + at.UTC().Format(time.RFC3339) + ".", nil
+}
 
-		glog.Infof("Started task: %s\n", task.Id)
+// Finishes forking a database once it's available, the data is copied in (or the copy is given its own
+// credentials) by the provider.
+func ForkDatabase(ctx context.Context, storage Storage, dbInstance *DbInstance, fromDb *DbInstance, backup string, owner string, namePrefix string) (string, error) {
+	provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
+	if err != nil {
+		return "", err
+	}
+	forker, ok := provider.(Forker)
+	if !ok {
+		return "", ErrFeatureNotAvailable
+	}
+	forked, err := forker.PerformFork(ctx, dbInstance, fromDb, backup, owner)
+	if err != nil {
+		return "", err
+	}
+	if err = storage.UpdateInstance(forked, forked.Plan.ID); err != nil {
+		glog.Errorf("ERROR: Cannot update instance in database after forking %s into %s %s\n", fromDb.Name, forked.Name, err.Error())
+		return "", err
+	}
+	replica, err := syncReaders(ctx, storage, provider, forked)
+	if err != nil {
+		return "", err
+	}
+	if replica != nil && !IsAvailable(replica.Status) {
+		if _, err = storage.AddTask(forked.Id, ResyncReplicasFromProviderTask, ""); err != nil {
+			glog.Errorf("Error: Unable to schedule resync of replica from provider! (%s): %s\n", replica.Name, err.Error())
+		}
+	}
+	if backup != "" {
+		return "Forked " + fromDb.Name + " (backup " + backup + ") into " + forked.Name + ".", nil
+	}
+	return "Forked " + fromDb.Name + " into " + forked.Name + ".", nil
+}
 
-		if task.Action == DeleteTask {
-			glog.Infof("Delete and deprovision database for task: %s\n", task.Id)
+// Brings the disaster recovery copy of a database up to date.
+func SyncDisasterRecovery(ctx context.Context, storage Storage, dbInstance *DbInstance, namePrefix string) (string, error) {
+	provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
+	if err != nil {
+		return "", err
+	}
+	recoverer, ok := provider.(DisasterRecoverer)
+	if !ok || !provider.Capabilities(dbInstance.Plan).Has(DisasterRecoveryCapability) {
+		return "", ErrFeatureNotAvailable
+	}
+	return recoverer.SyncRecovery(ctx, dbInstance)
+}
 
-			if task.Retries >= 10 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to delete database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
-			}
+// Promotes the disaster recovery copy of a database in place of the database, the promoted database
+// is recorded so bindings point to it. The replica of the database is in the same place as the
+// database that was lost, so it's removed.
+func PromoteDisasterRecovery(ctx context.Context, storage Storage, dbInstance *DbInstance, namePrefix string) (string, error) {
+	provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
+	if err != nil {
+		return "", err
+	}
+	recoverer, ok := provider.(DisasterRecoverer)
+	if !ok || !provider.Capabilities(dbInstance.Plan).Has(DisasterRecoveryCapability) {
+		return "", ErrFeatureNotAvailable
+	}
+	promoted, err := recoverer.PromoteRecovery(ctx, dbInstance)
+	if err != nil {
+		return "", err
+	}
+	if err = storage.UpdateInstance(promoted, promoted.Plan.ID); err != nil {
+		glog.Errorf("ERROR: Cannot update instance in database after promoting %s into %s %s\n", dbInstance.Name, promoted.Name, err.Error())
+		return "", err
+	}
+	amount, err := storage.HasReplicas(dbInstance)
+	if err != nil {
+		return "", err
+	}
+	if amount > 0 {
+		if err = storage.DeleteReplica(&DbInstance{Id: dbInstance.Id, Name: dbInstance.Name + "-ro"}); err != nil {
+			return "", err
+		}
+	}
+	return "Promoted the disaster recovery copy of " + dbInstance.Name + " as " + promoted.Name + ".", nil
+}
 
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
+func runWorkerTask(ctx context.Context, namePrefix string, storage Storage, task *Task) {
+	if task.Action == DeleteTask {
+		glog.Infof("Delete and deprovision database for task: %s\n", task.Id)
 
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
-			}
-			provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get provider: "+err.Error(), "pending")
-				continue
-			}
-			replicas, err := storage.HasReplicas(dbInstance)
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to check for replicas: "+err.Error(), "pending")
-				continue
-			}
-			if replicas > 0 {
-				if err = provider.DeleteReadReplica(dbInstance); err != nil {
-					UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to remove replicas: "+err.Error(), "pending")
-					continue
-				}
-			}
-			if err = provider.Deprovision(dbInstance, true); err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to deprovision: "+err.Error(), "pending")
-				continue
-			}
-			if err = storage.DeleteInstance(dbInstance); err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to delete: "+err.Error(), "pending")
-				continue
-			}
-			FinishedTask(storage, task.Id, task.Retries, "", "finished")
-		} else if task.Action == ResyncFromProviderTask {
-			glog.Infof("Resyncing from provider for task: %s\n", task.Id)
-			if task.Retries >= 60 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
-			}
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
-			if err != nil {
-				glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
-			}
-			dbEntry, err := storage.GetInstance(task.DatabaseId)
-			if err != nil {
-				glog.Infof("Failed to get database instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get DbEntry: "+err.Error(), "pending")
-				continue
-			}
-			if dbInstance.Status != dbEntry.Status {
-				if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
-					UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: "+err.Error(), "pending")
-					continue
-				}
-			} else {
-				glog.Infof("Status did not change at provider for task: %s\n", task.Id)
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check", "pending")
-				continue
-			}
+		if task.Retries >= 10 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to delete database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
 
-			FinishedTask(storage, task.Id, task.Retries, "", "finished")
-		} else if task.Action == ResyncFromProviderUntilAvailableTask {
-			glog.Infof("Resyncing from provider until available for task: %s\n", task.Id)
-			if task.Retries >= 60 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
-			}
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
-			if err != nil {
-				glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
-			}
-			if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: "+err.Error(), "pending")
-				continue
-			}
-			if !IsAvailable(dbInstance.Status) {
-				glog.Infof("Status did not change at provider for task: %s\n", task.Id)
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check ("+dbInstance.Status+")", "pending")
-				continue
-			}
-			FinishedTask(storage, task.Id, task.Retries, "", "finished")
-		} else if task.Action == ResyncReplicasFromProviderTask {
-			glog.Infof("Resyncing from provider until available for replica: %s\n", task.Id)
-			if task.Retries >= 60 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for replica "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
-			}
-			dbInstance, err := GetReplicaById(namePrefix, storage, task.DatabaseId)
-			if err != nil {
-				glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
-			}
-			if err = storage.UpdateReplica(dbInstance); err != nil {
-				glog.Infof("Failed to update replica in database for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot update replica: "+err.Error(), "pending")
-				continue
-			}
-			if !IsAvailable(dbInstance.Status) {
-				glog.Infof("Status did not change at provider for task: %s\n", task.Id)
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check ("+dbInstance.Status+")", "pending")
-				continue
-			}
-			FinishedTask(storage, task.Id, task.Retries, "", "finished")
-		} else if task.Action == PerformPostProvisionTask {
-			glog.Infof("Resyncing from provider until available (for perform post provision) for task: %s\n", task.Id)
-			if task.Retries >= 60 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
-			}
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
-			if err != nil {
-				glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get provider: "+err.Error(), "pending")
+			return
+		}
+		replicas, err := storage.HasReplicas(dbInstance)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to check for replicas: "+err.Error(), "pending")
+			return
+		}
+		if replicas > 0 {
+			if err = provider.DeleteReadReplica(ctx, dbInstance); err != nil {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to remove replicas: "+err.Error(), "pending")
+				return
 			}
+		}
+		if err = provider.Deprovision(ctx, dbInstance, true); err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to deprovision: "+err.Error(), "pending")
+			return
+		}
+		if err = storage.DeleteInstance(dbInstance); err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to delete: "+err.Error(), "pending")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, "", "finished")
+	} else if task.Action == ResyncFromProviderTask {
+		glog.Infof("Resyncing from provider for task: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		dbEntry, err := storage.GetInstance(task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get database instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get DbEntry: "+err.Error(), "pending")
+			return
+		}
+		if dbInstance.Status != dbEntry.Status {
 			if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
 				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: "+err.Error(), "pending")
-				continue
-			}
-			if !IsAvailable(dbInstance.Status) {
-				glog.Infof("Status did not change at provider for task: %s\n", task.Id)
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check ("+dbInstance.Status+")", "pending")
-				continue
+				return
 			}
+		} else {
+			glog.Infof("Status did not change at provider for task: %s\n", task.Id)
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check", "pending")
+			return
+		}
 
-			provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get provider: "+err.Error(), "pending")
-				continue
-			}
+		FinishedTask(storage, task.Id, task.Retries, "", "finished")
+	} else if task.Action == ResyncFromProviderUntilAvailableTask {
+		glog.Infof("Resyncing from provider until available for task: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: "+err.Error(), "pending")
+			return
+		}
+		if !IsAvailable(dbInstance.Status) {
+			glog.Infof("Status did not change at provider for task: %s\n", task.Id)
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check ("+dbInstance.Status+")", "pending")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, "", "finished")
+	} else if task.Action == ResyncReplicasFromProviderTask {
+		glog.Infof("Resyncing from provider until available for replica: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for replica "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		dbInstance, err := GetReplicaById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		if err = storage.UpdateReplica(dbInstance); err != nil {
+			glog.Infof("Failed to update replica in database for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot update replica: "+err.Error(), "pending")
+			return
+		}
+		if !IsAvailable(dbInstance.Status) {
+			glog.Infof("Status did not change at provider for task: %s\n", task.Id)
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check ("+dbInstance.Status+")", "pending")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, "", "finished")
+	} else if task.Action == PerformPostProvisionTask {
+		glog.Infof("Resyncing from provider until available (for perform post provision) for task: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		if err = storage.UpdateInstance(dbInstance, dbInstance.Plan.ID); err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: "+err.Error(), "pending")
+			return
+		}
+		if !IsAvailable(dbInstance.Status) {
+			glog.Infof("Status did not change at provider for task: %s\n", task.Id)
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check ("+dbInstance.Status+")", "pending")
+			return
+		}
 
-			newDbInstance, err := provider.PerformPostProvision(dbInstance)
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: "+err.Error(), "pending")
-				continue
-			}
+		provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get provider: "+err.Error(), "pending")
+			return
+		}
 
-			if err = storage.UpdateInstance(newDbInstance, newDbInstance.Plan.ID); err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance after post provision: "+err.Error(), "pending")
-				continue
-			}
+		newDbInstance, err := provider.PerformPostProvision(ctx, dbInstance)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance: "+err.Error(), "pending")
+			return
+		}
 
-			FinishedTask(storage, task.Id, task.Retries, "", "finished")
-		} else if task.Action == NotifyCreateServiceWebhookTask {
+		if err = storage.UpdateInstance(newDbInstance, newDbInstance.Plan.ID); err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to update instance after post provision: "+err.Error(), "pending")
+			return
+		}
 
-			if task.Retries >= 60 {
-				FinishedTask(storage, task.Id, task.Retries, "Unable to deliver webhook: "+task.Result, "failed")
-				continue
+		replica, err := syncReaders(ctx, storage, provider, newDbInstance)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to record readers after post provision: "+err.Error(), "pending")
+			return
+		}
+		if replica != nil && !IsAvailable(replica.Status) {
+			if _, err = storage.AddTask(newDbInstance.Id, ResyncReplicasFromProviderTask, ""); err != nil {
+				glog.Errorf("Error: Unable to schedule resync of replica from provider! (%s): %s\n", replica.Name, err.Error())
 			}
+		}
 
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
-			}
-			if !IsAvailable(dbInstance.Status) {
-				glog.Infof("Status did not change at provider for task: %s\n", task.Id)
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check", "pending")
-				continue
-			}
+		FinishedTask(storage, task.Id, task.Retries, "", "finished")
+	} else if task.Action == NotifyCreateServiceWebhookTask {
 
-			byteData, err := json.Marshal(map[string]interface{}{"state": "succeeded", "description": "available"})
-			// seems like this would be more useful, but whatevs: byteData, err := json.Marshal(dbInstance)
+		if task.Retries >= 60 {
+			FinishedTask(storage, task.Id, task.Retries, "Unable to deliver webhook: "+task.Result, "failed")
+			return
+		}
 
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot marshal dbInstance to json: "+err.Error(), "pending")
-				continue
-			}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		if !IsAvailable(dbInstance.Status) {
+			glog.Infof("Status did not change at provider for task: %s\n", task.Id)
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check", "pending")
+			return
+		}
 
-			var taskMetaData WebhookTaskMetadata
-			err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
-			if err != nil {
-				glog.Infof("Cannot unmarshal task metadata to callback on create service: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to callback on create service: "+err.Error(), "pending")
-				continue
-			}
+		byteData, err := json.Marshal(map[string]interface{}{"state": "succeeded", "description": "available"})
+		// seems like this would be more useful, but whatevs: byteData, err := json.Marshal(dbInstance)
 
-			h := hmac.New(sha256.New, []byte(taskMetaData.Secret))
-			h.Write(byteData)
-			sha := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot marshal dbInstance to json: "+err.Error(), "pending")
+			return
+		}
 
-			client := &http.Client{}
-			req, err := http.NewRequest("POST", taskMetaData.Url, bytes.NewReader(byteData))
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to create http post request: "+err.Error(), "pending")
-				continue
-			}
-			req.Header.Add("content-type", "application/json")
-			req.Header.Add("x-osb-signature", sha)
-			resp, err := client.Do(req)
-			if err != nil {
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to send http post operation: "+err.Error(), "pending")
-				continue
-			}
-			resp.Body.Close() // ignore it, we dont want to hear it.
+		var taskMetaData WebhookTaskMetadata
+		err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
+		if err != nil {
+			glog.Infof("Cannot unmarshal task metadata to callback on create service: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to callback on create service: "+err.Error(), "pending")
+			return
+		}
 
-			if os.Getenv("RETRY_WEBHOOKS") != "" {
-				if resp.StatusCode < 200 || resp.StatusCode > 399 {
-					UpdateTaskStatus(storage, task.Id, task.Retries+1, "Got invalid http status code from hook: "+resp.Status, "pending")
-					continue
-				}
-				FinishedTask(storage, task.Id, task.Retries, resp.Status, "finished")
-			} else {
-				if resp.StatusCode < 200 || resp.StatusCode > 399 {
-					UpdateTaskStatus(storage, task.Id, task.Retries+1, "Got invalid http status code from hook: "+resp.Status, "failed")
-				} else {
-					FinishedTask(storage, task.Id, task.Retries, resp.Status, "finished")
-				}
-			}
-		} else if task.Action == ChangePlansTask {
-			glog.Infof("Changing plans for database: %s\n", task.Id)
-			if task.Retries >= 60 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to change plans for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
-			}
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
-			if err != nil {
-				glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
-			}
-			var taskMetaData ChangePlansTaskMetadata
-			err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
-			if err != nil {
-				glog.Infof("Cannot unmarshal task metadata to change providers: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot unmarshal task metadata to change providers: "+err.Error(), "pending")
-				continue
-			}
-			output, err := UpgradeWithinProviders(storage, dbInstance, taskMetaData.Plan, namePrefix)
-			if err != nil {
-				glog.Infof("Cannot change plans for: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot change plans: "+err.Error(), "pending")
-				continue
-			}
+		h := hmac.New(sha256.New, []byte(taskMetaData.Secret))
+		h.Write(byteData)
+		sha := base64.StdEncoding.EncodeToString(h.Sum(nil))
 
-			FinishedTask(storage, task.Id, task.Retries, output, "finished")
-		} else if task.Action == RestoreDbTask {
-			glog.Infof("Restoring database for: %s\n", task.Id)
-			if task.Retries >= 60 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to restore database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
+		client := &http.Client{}
+		req, err := http.NewRequest("POST", taskMetaData.Url, bytes.NewReader(byteData))
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to create http post request: "+err.Error(), "pending")
+			return
+		}
+		req = req.WithContext(ctx)
+		req.Header.Add("content-type", "application/json")
+		req.Header.Add("x-osb-signature", sha)
+		resp, err := client.Do(req)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Failed to send http post operation: "+err.Error(), "pending")
+			return
+		}
+		resp.Body.Close() // ignore it, we dont want to hear it.
+
+		if os.Getenv("RETRY_WEBHOOKS") != "" {
+			if resp.StatusCode < 200 || resp.StatusCode > 399 {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Got invalid http status code from hook: "+resp.Status, "pending")
+				return
 			}
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
-			if err != nil {
-				glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
+			FinishedTask(storage, task.Id, task.Retries, resp.Status, "finished")
+		} else {
+			if resp.StatusCode < 200 || resp.StatusCode > 399 {
+				UpdateTaskStatus(storage, task.Id, task.Retries+1, "Got invalid http status code from hook: "+resp.Status, "failed")
+			} else {
+				FinishedTask(storage, task.Id, task.Retries, resp.Status, "finished")
 			}
-			var taskMetaData RestoreDbTaskMetadata
-			err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
+		}
+	} else if task.Action == ChangePlansTask {
+		glog.Infof("Changing plans for database: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to change plans for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		var taskMetaData ChangePlansTaskMetadata
+		err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
+		if err != nil {
+			glog.Infof("Cannot unmarshal task metadata to change providers: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot unmarshal task metadata to change providers: "+err.Error(), "pending")
+			return
+		}
+		if taskMetaData.Switchover != nil {
+			// a switchover that didn't catch up won't do any better by retrying.
+			output, err := UpgradeByReplication(ctx, storage, dbInstance, taskMetaData.Plan, namePrefix, *taskMetaData.Switchover)
 			if err != nil {
-				glog.Infof("Cannot unmarshal task metadata to restore databases: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to restore databases: "+err.Error(), "pending")
-				continue
-			}
-			if err = RestoreBackup(storage, dbInstance, namePrefix, taskMetaData.Backup); err != nil {
-				glog.Infof("Cannot restore backups for: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot restore backup: "+err.Error(), "pending")
-				continue
+				glog.Infof("Cannot switch over plans for: %s, %s\n", task.Id, err.Error())
+				FinishedTask(storage, task.Id, task.Retries, "Cannot switch over plans: "+err.Error(), "failed")
+				return
 			}
+			FinishedTask(storage, task.Id, task.Retries, output, "finished")
+			return
+		}
+		output, err := UpgradeWithinProviders(ctx, storage, dbInstance, taskMetaData.Plan, namePrefix)
+		if err != nil {
+			glog.Infof("Cannot change plans for: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot change plans: "+err.Error(), "pending")
+			return
+		}
 
-			FinishedTask(storage, task.Id, task.Retries, "", "finished")
-		} else if task.Action == ChangeProvidersTask {
-			glog.Infof("Changing providers for database: %s\n", task.Id)
-			if task.Retries >= 60 {
-				glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
-				FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
-				continue
-			}
-			dbInstance, err := GetInstanceById(namePrefix, storage, task.DatabaseId)
+		FinishedTask(storage, task.Id, task.Retries, output, "finished")
+	} else if task.Action == RestoreDbTask {
+		glog.Infof("Restoring database for: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to restore database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		var taskMetaData RestoreDbTaskMetadata
+		err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
+		if err != nil {
+			glog.Infof("Cannot unmarshal task metadata to restore databases: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to restore databases: "+err.Error(), "pending")
+			return
+		}
+		if err = RestoreBackup(ctx, storage, dbInstance, namePrefix, taskMetaData.Backup); err != nil {
+			glog.Infof("Cannot restore backups for: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot restore backup: "+err.Error(), "pending")
+			return
+		}
+
+		FinishedTask(storage, task.Id, task.Retries, "", "finished")
+	} else if task.Action == BackupDbTask {
+		glog.Infof("Taking backup of database for: %s\n", task.Id)
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		var taskMetaData BackupDbTaskMetadata
+		if err = json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil {
+			glog.Infof("Cannot unmarshal task metadata to backup databases: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to backup databases: "+err.Error(), "failed")
+			return
+		}
+		provider, err := GetProviderByPlan(namePrefix, dbInstance.Plan)
+		if err != nil {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get provider: "+err.Error(), "pending")
+			return
+		}
+		backuper, ok := provider.(LogicalBackuper)
+		if !ok {
+			FinishedTask(storage, task.Id, task.Retries, "The provider does not take logical backups.", "failed")
+			return
+		}
+		// the backup is marked failed by the provider, a new one has to be requested rather than retrying.
+		if err = backuper.PerformBackup(ctx, dbInstance, taskMetaData.Backup); err != nil {
+			glog.Errorf("Cannot take backup for: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot take backup: "+err.Error(), "failed")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, "", "finished")
+	} else if task.Action == UpgradeVersionTask {
+		glog.Infof("Upgrading version of database for: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to upgrade the version of database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		var taskMetaData UpgradeVersionTaskMetadata
+		if err := json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil {
+			glog.Infof("Cannot unmarshal task metadata to upgrade versions: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to upgrade versions: "+err.Error(), "failed")
+			return
+		}
+		if taskMetaData.Window != "" {
+			window, err := parseMaintenanceWindow(taskMetaData.Window)
 			if err != nil {
-				glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
-				continue
+				FinishedTask(storage, task.Id, task.Retries, err.Error(), "failed")
+				return
 			}
-			var taskMetaData ChangeProvidersTaskMetadata
-			err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
-			if err != nil {
-				glog.Infof("Cannot unmarshal task metadata to change providers: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to change providers: "+err.Error(), "pending")
-				continue
+			// Put back until the window opens, this doesn't count as a retry.
+			if !window.Contains(time.Now()) {
+				UpdateTaskStatus(storage, task.Id, task.Retries, "Waiting for the maintenance window "+taskMetaData.Window, "pending")
+				return
 			}
-			output, err := UpgradeAcrossProviders(storage, dbInstance, taskMetaData.Plan, namePrefix)
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		output, err := UpgradeEngineVersion(ctx, storage, dbInstance, taskMetaData.Version, namePrefix)
+		if err != nil {
+			glog.Infof("Cannot upgrade version for: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot upgrade version: "+err.Error(), "pending")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, output, "finished")
+	} else if task.Action == RestoreToTimeTask {
+		glog.Infof("Restoring database to a point in time for: %s\n", task.Id)
+		var taskMetaData RestoreToTimeTaskMetadata
+		if err := json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil {
+			glog.Infof("Cannot unmarshal task metadata to restore to a point in time: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to restore to a point in time: "+err.Error(), "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		// the time to restore to slips out of reach as logs expire, a new restore has to be requested rather than retrying.
+		output, err := RestoreToTime(ctx, storage, dbInstance, taskMetaData.Time, namePrefix)
+		if err != nil {
+			glog.Errorf("Cannot restore to a point in time for: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot restore to a point in time: "+err.Error(), "failed")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, output, "finished")
+	} else if task.Action == ForkDbTask {
+		glog.Infof("Forking database for: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to fork into database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		var taskMetaData ForkDbTaskMetadata
+		if err := json.Unmarshal([]byte(task.Metadata), &taskMetaData); err != nil {
+			glog.Infof("Cannot unmarshal task metadata to fork databases: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to fork databases: "+err.Error(), "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		if !IsAvailable(dbInstance.Status) {
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "No change in status since last check ("+dbInstance.Status+")", "pending")
+			return
+		}
+		fromDb, err := GetInstanceById(ctx, namePrefix, storage, taskMetaData.From)
+		if err != nil {
+			glog.Infof("Failed to get the database to fork from for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get the database to fork from: "+err.Error(), "pending")
+			return
+		}
+		output, err := ForkDatabase(ctx, storage, dbInstance, fromDb, taskMetaData.Backup, taskMetaData.Owner, namePrefix)
+		if err != nil {
+			glog.Infof("Cannot fork database for: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot fork database: "+err.Error(), "pending")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, output, "finished")
+	} else if task.Action == SyncRecoveryTask {
+		glog.Infof("Syncing disaster recovery copy of database for: %s\n", task.Id)
+		// syncs are scheduled again every interval, so a failed sync is not retried.
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "failed")
+			return
+		}
+		output, err := SyncDisasterRecovery(ctx, storage, dbInstance, namePrefix)
+		if err != nil {
+			glog.Errorf("Cannot sync disaster recovery copy for: %s, %s\n", task.Id, err.Error())
+			FinishedTask(storage, task.Id, task.Retries, "Cannot sync disaster recovery copy: "+err.Error(), "failed")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, output, "finished")
+	} else if task.Action == PromoteRecoveryTask {
+		glog.Infof("Promoting disaster recovery copy of database for: %s\n", task.Id)
+		if task.Retries >= 10 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to promote the disaster recovery copy of database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		// the database may be in a region that's down, so the provider isn't asked for it.
+		dbInstance, err := GetRecordedInstanceById(storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get database instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		output, err := PromoteDisasterRecovery(ctx, storage, dbInstance, namePrefix)
+		if err != nil {
+			glog.Infof("Cannot promote disaster recovery copy for: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries+1, "Cannot promote disaster recovery copy: "+err.Error(), "pending")
+			return
+		}
+		FinishedTask(storage, task.Id, task.Retries, output, "finished")
+	} else if task.Action == ChangeProvidersTask {
+		glog.Infof("Changing providers for database: %s\n", task.Id)
+		if task.Retries >= 60 {
+			glog.Infof("Retry limit was reached for task: %s %d\n", task.Id, task.Retries)
+			FinishedTask(storage, task.Id, task.Retries, "Unable to resync information from provider for database "+task.DatabaseId+" as it failed multiple times ("+task.Result+")", "failed")
+			return
+		}
+		dbInstance, err := GetInstanceById(ctx, namePrefix, storage, task.DatabaseId)
+		if err != nil {
+			glog.Infof("Failed to get provider instance for task: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot get dbInstance: "+err.Error(), "pending")
+			return
+		}
+		var taskMetaData ChangeProvidersTaskMetadata
+		err = json.Unmarshal([]byte(task.Metadata), &taskMetaData)
+		if err != nil {
+			glog.Infof("Cannot unmarshal task metadata to change providers: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot unmarshal task metadata to change providers: "+err.Error(), "pending")
+			return
+		}
+		if taskMetaData.Switchover != nil {
+			output, err := UpgradeByReplication(ctx, storage, dbInstance, taskMetaData.Plan, namePrefix, *taskMetaData.Switchover)
 			if err != nil {
-				glog.Infof("Cannot switch providers: %s, %s\n", task.Id, err.Error())
-				UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot switch providers: "+err.Error(), "pending")
-				continue
+				glog.Infof("Cannot switch over providers: %s, %s\n", task.Id, err.Error())
+				FinishedTask(storage, task.Id, task.Retries, "Cannot switch over providers: "+err.Error(), "failed")
+				return
 			}
-
 			FinishedTask(storage, task.Id, task.Retries, output, "finished")
+			return
+		}
+		output, err := UpgradeAcrossProviders(ctx, storage, dbInstance, taskMetaData.Plan, namePrefix)
+		if err != nil {
+			glog.Infof("Cannot switch providers: %s, %s\n", task.Id, err.Error())
+			UpdateTaskStatus(storage, task.Id, task.Retries, "Cannot switch providers: "+err.Error(), "pending")
+			return
+		}
+
+		FinishedTask(storage, task.Id, task.Retries, output, "finished")
+	}
+	// TODO: create binding NotifyCreateBindingWebhookTask
+}
+
+func RunWorkerTasks(ctx context.Context, o Options, namePrefix string, storage Storage) error {
+
+	t := time.NewTicker(time.Second * 60)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
 		}
-		// TODO: create binding NotifyCreateBindingWebhookTask
+		storage.WarnOnUnfinishedTasks()
+
+		task, err := storage.PopPendingTask()
+		if err != nil && err.Error() != "sql: no rows in result set" {
+			glog.Errorf("Getting a pending task failed: %s\n", err.Error())
+			return err
+		} else if err != nil && err.Error() == "sql: no rows in result set" {
+			// Nothing to do...
+			continue
+		}
+
+		glog.Infof("Started task: %s\n", task.Id)
+
+		taskCtx, cancel := WithOperationTimeout(ctx, string(task.Action))
+		runWorkerTask(taskCtx, namePrefix, storage, task)
+		cancel()
 
 		glog.Infof("Finished task: %s\n", task.Id)
 	}
-	return nil
 }
 
 func RunBackgroundTasks(ctx context.Context, o Options) error {
@@ -623,6 +1131,15 @@ func RunBackgroundTasks(ctx context.Context, o Options) error {
 		return err
 	}
 
+	defer CloseProviders()
+
+	return RunBackgroundTasksWithStorage(ctx, o, namePrefix, storage)
+}
+
+// RunBackgroundTasksWithStorage runs the background tasks with storage from InitFromOptions, providers
+// are left open for the caller to close.
+func RunBackgroundTasksWithStorage(ctx context.Context, o Options, namePrefix string, storage Storage) error {
 	go TickTocPreprovisionTasks(ctx, o, namePrefix, storage)
+	go TickTocDisasterRecoveryTasks(ctx, namePrefix, storage)
 	return RunWorkerTasks(ctx, o, namePrefix, storage)
 }
@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Every call to a provider gets a deadline so a hung call (such as an RDS waiter that never
+// finishes) cannot hold up a request or a worker forever. Operations are the HTTP request,
+// preprovisioning and each task action. The deadline can be changed by setting the operation
+// name upper cased, with dashes as underscores and a _TIMEOUT suffix in the environment to a
+// go duration, e.g. RESTORE_DATABASE_TIMEOUT=12h or REQUEST_TIMEOUT=5m.
+const (
+	RequestOperation   string = "request"
+	ProvisionOperation string = "provision"
+)
+
+var defaultOperationTimeouts = map[string]time.Duration{
+	RequestOperation:                             time.Minute * 2,
+	ProvisionOperation:                           time.Minute * 10,
+	string(DeleteTask):                           time.Minute * 30,
+	string(ResyncFromProviderTask):               time.Minute * 5,
+	string(ResyncFromProviderUntilAvailableTask): time.Minute * 5,
+	string(ResyncReplicasFromProviderTask):       time.Minute * 5,
+	string(NotifyCreateServiceWebhookTask):       time.Minute,
+	string(NotifyCreateBindingWebhookTask):       time.Minute,
+	string(PerformPostProvisionTask):             time.Minute * 30,
+	string(ChangePlansTask):                      time.Hour * 6,
+	string(ChangeProvidersTask):                  time.Hour * 12,
+	string(RestoreDbTask):                        time.Hour * 6,
+	string(BackupDbTask):                         time.Hour * 6,
+	string(UpgradeVersionTask):                   time.Hour * 6,
+	string(RestoreToTimeTask):                    time.Hour * 6,
+	string(ForkDbTask):                           time.Hour * 6,
+	string(SyncRecoveryTask):                     time.Hour,
+	string(PromoteRecoveryTask):                  time.Hour * 6,
+}
+
+func OperationTimeout(operation string) time.Duration {
+	env := strings.ToUpper(strings.Replace(operation, "-", "_", -1)) + "_TIMEOUT"
+	if value := os.Getenv(env); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		glog.Errorf("WARNING: The timeout %s=%s is not a valid duration, using the default.\n", env, value)
+	}
+	if timeout, ok := defaultOperationTimeouts[operation]; ok {
+		return timeout
+	}
+	return defaultOperationTimeouts[RequestOperation]
+}
+
+func WithOperationTimeout(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, OperationTimeout(operation))
+}
+
+// sleepWithContext waits for the duration, it returns early with the context's
+// error if the context is cancelled or reaches its deadline first.
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	t := time.NewTimer(duration)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,35 @@
+package broker
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOperationTimeouts(t *testing.T) {
+	Convey("Given operations that call out to providers", t, func() {
+		Convey("Ensure each operation has a sensible default.", func() {
+			So(OperationTimeout(RequestOperation), ShouldEqual, time.Minute*2)
+			So(OperationTimeout(string(RestoreDbTask)), ShouldEqual, time.Hour*6)
+			So(OperationTimeout("does-not-exist"), ShouldEqual, OperationTimeout(RequestOperation))
+		})
+		Convey("Ensure the timeout can be changed from the environment.", func() {
+			os.Setenv("RESTORE_DATABASE_TIMEOUT", "12h")
+			defer os.Unsetenv("RESTORE_DATABASE_TIMEOUT")
+			So(OperationTimeout(string(RestoreDbTask)), ShouldEqual, time.Hour*12)
+		})
+		Convey("Ensure an invalid timeout in the environment falls back to the default.", func() {
+			os.Setenv("REQUEST_TIMEOUT", "soon")
+			defer os.Unsetenv("REQUEST_TIMEOUT")
+			So(OperationTimeout(RequestOperation), ShouldEqual, time.Minute*2)
+		})
+		Convey("Ensure waiting stops when the context is cancelled.", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			So(sleepWithContext(ctx, time.Hour), ShouldEqual, context.Canceled)
+			So(sleepWithContext(context.Background(), time.Millisecond), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A VersionUpgrader is a provider that can upgrade the engine of a database without changing its plan.
+// The upgrade targets are the versions the provider can upgrade the database to from its current version.
+type VersionUpgrader interface {
+	UpgradeTargets(context.Context, *DbInstance) ([]string, error)
+	UpgradeEngineVersion(context.Context, *DbInstance, string) (*DbInstance, error)
+}
+
+// Ensures the version is one the provider can upgrade the database to.
+func validateUpgradeVersion(ctx context.Context, upgrader VersionUpgrader, dbInstance *DbInstance, version string) error {
+	if version == dbInstance.EngineVersion {
+		return errors.New("The database is already on version " + version + ".")
+	}
+	targets, err := upgrader.UpgradeTargets(ctx, dbInstance)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if target == version {
+			return nil
+		}
+	}
+	if len(targets) == 0 {
+		return errors.New("There are no versions " + dbInstance.Engine + " " + dbInstance.EngineVersion + " can be upgraded to.")
+	}
+	return errors.New("The version " + version + " is not one " + dbInstance.Engine + " " + dbInstance.EngineVersion + " can be upgraded to, it can be upgraded to " + strings.Join(targets, ", ") + ".")
+}
+
+var maintenanceWindowDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// A weekly window, in UTC, that maintenance can be started in. It's written the same way as the
+// maintenance windows of RDS, e.g. "sun:05:00-sun:07:30", windows can go past the end of the week.
+type maintenanceWindow struct {
+	start int // minutes since the start of the week
+	end   int
+}
+
+func parseMaintenanceWindow(window string) (maintenanceWindow, error) {
+	times := strings.Split(strings.ToLower(strings.TrimSpace(window)), "-")
+	if len(times) != 2 {
+		return maintenanceWindow{}, errors.New("The maintenance window " + window + " must be in the format ddd:hh:mm-ddd:hh:mm.")
+	}
+	start, err := parseWeekMinute(times[0])
+	if err != nil {
+		return maintenanceWindow{}, errors.New("The maintenance window " + window + " must be in the format ddd:hh:mm-ddd:hh:mm.")
+	}
+	end, err := parseWeekMinute(times[1])
+	if err != nil || start == end {
+		return maintenanceWindow{}, errors.New("The maintenance window " + window + " must be in the format ddd:hh:mm-ddd:hh:mm.")
+	}
+	return maintenanceWindow{start: start, end: end}, nil
+}
+
+func parseWeekMinute(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, errors.New("Invalid time")
+	}
+	day := -1
+	for i, name := range maintenanceWindowDays {
+		if parts[0] == name {
+			day = i
+		}
+	}
+	hour, err := strconv.Atoi(parts[1])
+	if err != nil || day < 0 || hour < 0 || hour > 23 {
+		return 0, errors.New("Invalid time")
+	}
+	minute, err := strconv.Atoi(parts[2])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, errors.New("Invalid time")
+	}
+	return day*24*60 + hour*60 + minute, nil
+}
+
+func (window maintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	minute := int(t.Weekday())*24*60 + t.Hour()*60 + t.Minute()
+	if window.start < window.end {
+		return minute >= window.start && minute < window.end
+	}
+	return minute >= window.start || minute < window.end
+}
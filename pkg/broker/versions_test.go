@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestUpgradeVersion(t *testing.T) {
+	ctx := context.Background()
+	Convey("Given a maintenance window", t, func() {
+		Convey("Ensure only times inside the window are in it.", func() {
+			window, err := parseMaintenanceWindow("sun:05:00-sun:06:30")
+			So(err, ShouldBeNil)
+			// 2019-01-06 was a sunday.
+			So(window.Contains(time.Date(2019, 1, 6, 5, 0, 0, 0, time.UTC)), ShouldEqual, true)
+			So(window.Contains(time.Date(2019, 1, 6, 6, 29, 0, 0, time.UTC)), ShouldEqual, true)
+			So(window.Contains(time.Date(2019, 1, 6, 6, 30, 0, 0, time.UTC)), ShouldEqual, false)
+			So(window.Contains(time.Date(2019, 1, 7, 5, 30, 0, 0, time.UTC)), ShouldEqual, false)
+		})
+
+		Convey("Ensure windows can go past the end of the week.", func() {
+			window, err := parseMaintenanceWindow("Sat:23:00-Sun:01:00")
+			So(err, ShouldBeNil)
+			So(window.Contains(time.Date(2019, 1, 5, 23, 30, 0, 0, time.UTC)), ShouldEqual, true)
+			So(window.Contains(time.Date(2019, 1, 6, 0, 30, 0, 0, time.UTC)), ShouldEqual, true)
+			So(window.Contains(time.Date(2019, 1, 6, 1, 30, 0, 0, time.UTC)), ShouldEqual, false)
+		})
+
+		Convey("Ensure invalid windows are rejected.", func() {
+			for _, window := range []string{"", "sun:05:00", "sun:25:00-sun:26:00", "xyz:05:00-sun:06:00", "sun:05:00-sun:05:00", "sun:05-sun:06"} {
+				_, err := parseMaintenanceWindow(window)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("Given an aws instance on an older version", t, func() {
+		awssvc := newFakeRDS()
+		awssvc.upgradeTargets["9.6.1"] = []string{"9.6.6", "10.1"}
+		awssvc.parameterGroups = []*rds.DBParameterGroup{
+			{DBParameterGroupName: aws.String("default.postgres9.6"), DBParameterGroupFamily: aws.String("postgres9.6")},
+			{DBParameterGroupName: aws.String("custom.postgres9.6"), DBParameterGroupFamily: aws.String("postgres9.6")},
+		}
+		provider := newFakeAWSInstanceProvider(awssvc)
+		plan := &ProviderPlan{
+			ID:                     "aws-test-plan",
+			Provider:               AWSInstance,
+			Scheme:                 "postgres",
+			providerPrivateDetails: `{"DBInstanceClass":"db.t2.micro","Engine":"postgres","EngineVersion":"9.6.1","AllocatedStorage":5,"DBParameterGroupName":"custom.postgres9.6"}`,
+		}
+		dbInstance, err := provider.Provision(ctx, "instance-id", plan, "owner")
+		So(err, ShouldBeNil)
+		dbInstance.Plan = plan
+		dbInstance.Status = "available"
+
+		Convey("Ensure only versions rds reports as targets are accepted.", func() {
+			So(validateUpgradeVersion(ctx, provider, dbInstance, "9.6.6"), ShouldBeNil)
+			So(validateUpgradeVersion(ctx, provider, dbInstance, "10.1"), ShouldBeNil)
+			err := validateUpgradeVersion(ctx, provider, dbInstance, "11.1")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "9.6.6, 10.1")
+			So(validateUpgradeVersion(ctx, provider, dbInstance, "9.6.1"), ShouldNotBeNil)
+		})
+
+		Convey("Ensure a minor version upgrade keeps the parameter group of the plan.", func() {
+			upgraded, err := provider.UpgradeEngineVersion(ctx, dbInstance, "9.6.6")
+			So(err, ShouldBeNil)
+			So(upgraded.EngineVersion, ShouldEqual, "9.6.6")
+			So(upgraded.Plan.ID, ShouldEqual, plan.ID)
+			So(*awssvc.instances[dbInstance.Name].EngineVersion, ShouldEqual, "9.6.6")
+			So(*awssvc.instances[dbInstance.Name].DBParameterGroups[0].DBParameterGroupName, ShouldEqual, "custom.postgres9.6")
+		})
+	})
+}